@@ -1,30 +1,116 @@
+// Dict looks words up in dictionaries from acme.
+//
+// Usage:
+//
+//	Dict [-d dict] [-s server] [-f file]... [-m strategy]
+//	Dict -f file... -l addr
+//
+// Dict opens a window named /dict/ listing the dictionaries.
+// Looking at a word (with the right button) in that window, or in
+// a definition window, opens a window with each definition found.
+//
+// By default Dict asks the DICT server named by -s. The -f option,
+// which may be repeated, reads local dictionaries instead, so that
+// Dict works offline: a dictd .index file, whose definitions are in
+// the .dict or .dict.dz file alongside; a StarDict .ifo file, with
+// its .idx and .dict or .dict.dz files; or a directory holding such
+// files.
+//
+// The -d option restricts lookups to one dictionary. By default Dict
+// uses the first dictionary defining the word.
+//
+// With local dictionaries, a word without a definition is matched
+// against the headwords using the -m strategy: prefix, for words
+// starting with it, or lev, for words within one edit of it, the
+// default. The matches are listed in a window, ready to be looked at.
+//
+// With -l, Dict opens no window but serves the local dictionaries
+// with the DICT protocol on the TCP address addr, such as
+// localhost:2628, for Dict -s and other DICT clients.
 package main // import "plramos.win/9fans/acme/Dict"
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"os"
+	"strings"
 
-	"plramos.win/9fans/acme"
 	"golang.org/x/net/dict"
+	"plramos.win/9fans/acme"
 )
 
 var dictx = flag.String("d", "", "dictionary")
 var server = flag.String("s", "dict.org:dict", "server")
+var strat = flag.String("m", ".", "matching `strategy` for words without a definition")
+var listen = flag.String("l", "", "serve the local dictionaries on `addr`")
+var files fileList
 var lookc = make(chan string)
-var d *dict.Client
+var d source
 var dicts []dict.Dict
 
+// A source looks words up, on a DICT server or in a library
+// of local dictionaries.
+type source interface {
+	Dicts() ([]dict.Dict, error)
+	Define(dict, word string) ([]*dict.Defn, error)
+}
+
+// A fileList is a list of file names, set by a repeated flag.
+type fileList []string
+
+func (l *fileList) String() string     { return strings.Join(*l, " ") }
+func (l *fileList) Set(s string) error { *l = append(*l, s); return nil }
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: Dict [-d dict] [-s server] [-f file]... [-m strategy]\n")
+	fmt.Fprintf(os.Stderr, "       Dict -f file... -l addr\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
 func main() {
+	flag.Var(&files, "f", "read local dictionary `file`")
+	flag.Usage = usage
 	flag.Parse()
+	if flag.NArg() != 0 || *listen != "" && len(files) == 0 {
+		usage()
+	}
+	if *dictx == "" {
+		*dictx = "!"
+	}
+
+	var lib *library
+	if len(files) > 0 {
+		var err error
+		lib, err = openLibrary(files)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *listen != "" {
+		l, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal(serve(l, lib))
+	}
+
 	w, err := acme.New()
 	if err != nil {
 		log.Fatal(err)
 	}
 	w.Name("/dict/")
-	d, err = dict.Dial("tcp", *server)
-	if err != nil {
-		w.Write("body", []byte(err.Error()))
-		return
+	if lib != nil {
+		d = lib
+	} else {
+		c, err := dict.Dial("tcp", *server)
+		if err != nil {
+			w.Write("body", []byte(err.Error()))
+			return
+		}
+		d = c
 	}
 	w.Ctl("clean")
 	go func() {
@@ -43,16 +129,46 @@ func main() {
 }
 
 func lookup(word string) {
-	defs, err := d.Define("!", word)
+	defs, err := d.Define(*dictx, word)
 	if err != nil {
 		log.Print(err)
 		return
 	}
+	if len(defs) == 0 {
+		if lib, ok := d.(*library); ok {
+			matches(lib, word)
+		}
+		return
+	}
 	for _, def := range defs {
 		go wordwin(def)
 	}
 }
 
+// matches opens a window listing the headwords matching word.
+func matches(lib *library, word string) {
+	defs, err := lib.Match(*dictx, *strat, word)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if len(defs) == 0 {
+		return
+	}
+	w, err := acme.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	w.Name("/dict/match/%s", word)
+	for _, def := range defs {
+		w.Fprintf("body", "%s\t%s\n", def.Word, def.Dict.Name)
+	}
+	w.Ctl("clean")
+	for word := range events(w) {
+		go lookup(word)
+	}
+}
+
 func wordwin(def *dict.Defn) {
 	w, err := acme.New()
 	if err != nil {
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// A dictzip gives random access to a file compressed by dictzip:
+// a gzip file whose deflate stream is flushed every chunk bytes of
+// input, with the compressed size of each chunk recorded in the
+// RA subfield of the gzip header.
+type dictzip struct {
+	f      *os.File
+	chunk  int64   // uncompressed bytes per chunk
+	starts []int64 // file offset of each compressed chunk, and of the end
+
+	mu    sync.Mutex
+	last  int    // index of the chunk in buf, or -1
+	buf   []byte // uncompressed chunk
+	size  int64  // uncompressed size
+	plain []byte // whole file, for gzip files without an RA field
+}
+
+// openDictzip opens the compressed file name.
+// A plain gzip file is decompressed into memory.
+func openDictzip(name string) (*dictzip, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	z := &dictzip{f: f, last: -1}
+	if err := z.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return z, nil
+}
+
+var errNotGzip = errors.New("not a gzip file")
+
+func (z *dictzip) readHeader() error {
+	var h [10]byte
+	if _, err := z.f.ReadAt(h[:], 0); err != nil {
+		return errNotGzip
+	}
+	if h[0] != 0x1f || h[1] != 0x8b || h[2] != 8 {
+		return errNotGzip
+	}
+	flags := h[3]
+	off := int64(10)
+	var ra []byte
+	if flags&0x04 != 0 { // FEXTRA
+		var n [2]byte
+		if _, err := z.f.ReadAt(n[:], off); err != nil {
+			return err
+		}
+		extra := make([]byte, binary.LittleEndian.Uint16(n[:]))
+		if _, err := z.f.ReadAt(extra, off+2); err != nil {
+			return err
+		}
+		off += 2 + int64(len(extra))
+		for len(extra) >= 4 {
+			id, n := extra[:2], int(binary.LittleEndian.Uint16(extra[2:4]))
+			if 4+n > len(extra) {
+				break
+			}
+			if id[0] == 'R' && id[1] == 'A' {
+				ra = extra[4 : 4+n]
+			}
+			extra = extra[4+n:]
+		}
+	}
+	for _, bit := range []byte{0x08, 0x10} { // FNAME, FCOMMENT
+		if flags&bit == 0 {
+			continue
+		}
+		for b := [1]byte{1}; b[0] != 0; off++ {
+			if _, err := z.f.ReadAt(b[:], off); err != nil {
+				return err
+			}
+		}
+	}
+	if flags&0x02 != 0 { // FHCRC
+		off += 2
+	}
+
+	if len(ra) < 6 || binary.LittleEndian.Uint16(ra) != 1 {
+		return z.readPlain()
+	}
+	z.chunk = int64(binary.LittleEndian.Uint16(ra[2:]))
+	count := int(binary.LittleEndian.Uint16(ra[4:]))
+	if len(ra) < 6+2*count || z.chunk == 0 {
+		return errors.New("bad dictzip header")
+	}
+	z.starts = make([]int64, count+1)
+	z.starts[0] = off
+	for i := 0; i < count; i++ {
+		z.starts[i+1] = z.starts[i] + int64(binary.LittleEndian.Uint16(ra[6+2*i:]))
+	}
+	// The gzip trailer holds the uncompressed size, modulo 2³².
+	fi, err := z.f.Stat()
+	if err != nil {
+		return err
+	}
+	var t [4]byte
+	if _, err := z.f.ReadAt(t[:], fi.Size()-4); err != nil {
+		return err
+	}
+	z.size = int64(binary.LittleEndian.Uint32(t[:]))
+	if max := int64(count) * z.chunk; z.size < max {
+		z.size += (max - z.size) &^ (1<<32 - 1)
+	}
+	return nil
+}
+
+func (z *dictzip) readPlain() error {
+	r, err := gzip.NewReader(io.NewSectionReader(z.f, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	z.plain, err = io.ReadAll(r)
+	z.size = int64(len(z.plain))
+	return err
+}
+
+// ReadAt reads uncompressed data.
+func (z *dictzip) ReadAt(p []byte, off int64) (int, error) {
+	if z.plain != nil {
+		if off >= int64(len(z.plain)) {
+			return 0, io.EOF
+		}
+		n := copy(p, z.plain[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if off >= z.size {
+			return n, io.EOF
+		}
+		i := int(off / z.chunk)
+		if err := z.load(i); err != nil {
+			return n, err
+		}
+		j := off - int64(i)*z.chunk
+		if j >= int64(len(z.buf)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		m := copy(p[n:], z.buf[j:])
+		n += m
+		off += int64(m)
+	}
+	return n, nil
+}
+
+// load decompresses chunk i into z.buf.
+func (z *dictzip) load(i int) error {
+	if i == z.last {
+		return nil
+	}
+	if i+1 >= len(z.starts) {
+		return io.EOF
+	}
+	comp := make([]byte, z.starts[i+1]-z.starts[i])
+	if _, err := z.f.ReadAt(comp, z.starts[i]); err != nil {
+		return err
+	}
+	// Each chunk ends with a flush, not a final block,
+	// so reading stops with io.ErrUnexpectedEOF.
+	buf, err := io.ReadAll(flate.NewReader(bytes.NewReader(comp)))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	z.buf, z.last = buf, i
+	return nil
+}
+
+func (z *dictzip) Close() error {
+	return z.f.Close()
+}
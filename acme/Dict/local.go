@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/dict"
+)
+
+var (
+	errNoDB    = errors.New("invalid database")
+	errNoStrat = errors.New("invalid strategy")
+)
+
+// Matching strategies, as a DICT server lists them.
+var strategies = []dict.Dict{
+	{Name: "exact", Desc: "Match headwords exactly"},
+	{Name: "prefix", Desc: "Match prefixes"},
+	{Name: "lev", Desc: "Match headwords within Levenshtein distance one"},
+}
+
+// defaultStrat is the strategy used for MATCH with strategy ".".
+const defaultStrat = "lev"
+
+// A library is a set of dictionaries read from local files.
+// It looks words up as a DICT server would.
+type library struct {
+	dicts []*localDict
+}
+
+// A localDict is one dictionary, in dictd or StarDict format.
+type localDict struct {
+	dict.Dict
+	entries []entry     // sorted by key
+	data    io.ReaderAt // uncompressed definitions
+	star    bool        // StarDict format
+	types   string      // for StarDict, the sametypesequence, if any
+}
+
+// An entry is a headword and where its definition is.
+type entry struct {
+	key  string // folded headword, for lookup
+	word string
+	off  int64
+	size int64
+}
+
+// fold returns the key under which word is looked up.
+func fold(word string) string {
+	return strings.ToLower(strings.TrimSpace(word))
+}
+
+// openLibrary opens the dictionaries named by paths. A path may name
+// a dictd .index or .dict(.dz) file, a StarDict .ifo file, or a
+// directory, which is searched for .index and .ifo files.
+func openLibrary(paths []string) (*library, error) {
+	lib := new(library)
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{p}
+		if fi.IsDir() {
+			files, err = filepath.Glob(filepath.Join(p, "*.index"))
+			if err != nil {
+				return nil, err
+			}
+			ifos, _ := filepath.Glob(filepath.Join(p, "*.ifo"))
+			files = append(files, ifos...)
+		}
+		for _, file := range files {
+			var d *localDict
+			if strings.HasSuffix(file, ".ifo") {
+				d, err = openStarDict(strings.TrimSuffix(file, ".ifo"))
+			} else {
+				base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(file, ".dz"), ".dict"), ".index")
+				d, err = openDictd(base)
+			}
+			if err != nil {
+				return nil, err
+			}
+			lib.dicts = append(lib.dicts, d)
+		}
+	}
+	if len(lib.dicts) == 0 {
+		return nil, errors.New("no dictionaries found")
+	}
+	return lib, nil
+}
+
+// openData opens the definitions file base.dict or base.dict.dz.
+func openData(base string) (io.ReaderAt, error) {
+	f, err := os.Open(base + ".dict")
+	if err == nil {
+		return f, nil
+	}
+	z, err1 := openDictzip(base + ".dict.dz")
+	if err1 != nil {
+		if errors.Is(err1, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, err1
+	}
+	return z, nil
+}
+
+// openDictd opens the dictd dictionary in base.index and base.dict(.dz).
+// Each index line holds a headword and the offset and length of its
+// definition in base 64.
+func openDictd(base string) (*localDict, error) {
+	f, err := os.Open(base + ".index")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := &localDict{Dict: dict.Dict{Name: filepath.Base(base), Desc: filepath.Base(base)}}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Split(sc.Text(), "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s.index:%d: malformed entry", base, n)
+		}
+		off, err1 := b64(fields[1])
+		size, err2 := b64(fields[2])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s.index:%d: malformed offset", base, n)
+		}
+		d.entries = append(d.entries, entry{fold(fields[0]), fields[0], off, size})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if d.data, err = openData(base); err != nil {
+		return nil, err
+	}
+	d.sort()
+
+	// dictfmt stores the description as a pseudo-entry,
+	// whose text may start with the headword.
+	for _, key := range []string{"00databaseshort", "00-database-short"} {
+		e, ok := d.find(key)
+		if !ok {
+			continue
+		}
+		text, err := d.text(e)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(text), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && fold(line) != "00databaseshort" && fold(line) != "00-database-short" {
+				d.Desc = line
+				break
+			}
+		}
+		break
+	}
+	return d, nil
+}
+
+const b64digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// b64 decodes a number written in dictd's base 64.
+func b64(s string) (int64, error) {
+	if s == "" {
+		return 0, strconv.ErrSyntax
+	}
+	var n int64
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(b64digits, s[i])
+		if d < 0 {
+			return 0, strconv.ErrSyntax
+		}
+		n = n<<6 | int64(d)
+	}
+	return n, nil
+}
+
+// openStarDict opens the StarDict dictionary in base.ifo, base.idx
+// (or base.idx.gz) and base.dict(.dz).
+func openStarDict(base string) (*localDict, error) {
+	ifo, err := os.ReadFile(base + ".ifo")
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(ifo), "\n")
+	if !strings.HasPrefix(lines[0], "StarDict's dict ifo file") {
+		return nil, fmt.Errorf("%s.ifo: not a StarDict file", base)
+	}
+	d := &localDict{Dict: dict.Dict{Name: filepath.Base(base), Desc: filepath.Base(base)}, star: true}
+	offBits := 32
+	for _, line := range lines[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "bookname":
+			d.Desc = val
+		case "sametypesequence":
+			d.types = val
+		case "idxoffsetbits":
+			if val == "64" {
+				offBits = 64
+			}
+		}
+	}
+
+	idx, err := os.ReadFile(base + ".idx")
+	if errors.Is(err, os.ErrNotExist) {
+		idx, err = readGzip(base + ".idx.gz")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for len(idx) > 0 {
+		i := bytes.IndexByte(idx, 0)
+		if i < 0 || len(idx) < i+1+offBits/8+4 {
+			return nil, fmt.Errorf("%s.idx: truncated", base)
+		}
+		word := string(idx[:i])
+		idx = idx[i+1:]
+		var off int64
+		if offBits == 64 {
+			off, idx = int64(binary.BigEndian.Uint64(idx)), idx[8:]
+		} else {
+			off, idx = int64(binary.BigEndian.Uint32(idx)), idx[4:]
+		}
+		size := int64(binary.BigEndian.Uint32(idx))
+		idx = idx[4:]
+		d.entries = append(d.entries, entry{fold(word), word, off, size})
+	}
+	if d.data, err = openData(base); err != nil {
+		return nil, err
+	}
+	d.sort()
+	return d, nil
+}
+
+func readGzip(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (d *localDict) sort() {
+	sort.SliceStable(d.entries, func(i, j int) bool {
+		return d.entries[i].key < d.entries[j].key
+	})
+}
+
+// find returns the first entry with the given key.
+func (d *localDict) find(key string) (entry, bool) {
+	i := sort.Search(len(d.entries), func(i int) bool { return d.entries[i].key >= key })
+	if i < len(d.entries) && d.entries[i].key == key {
+		return d.entries[i], true
+	}
+	return entry{}, false
+}
+
+// text returns the definition of e.
+func (d *localDict) text(e entry) ([]byte, error) {
+	buf := make([]byte, e.size)
+	if _, err := d.data.ReadAt(buf, e.off); err != nil && !(err == io.EOF && len(buf) == 0) {
+		return nil, err
+	}
+	if d.star {
+		return starText(buf, d.types), nil
+	}
+	return buf, nil
+}
+
+// starText returns the text fields of a StarDict definition.
+// With a sametypesequence, types lists the fields' types and the
+// last field runs to the end; otherwise each field starts with its
+// type. Lower-case types are text ended by NUL; upper-case ones,
+// such as pictures and sounds, start with their size and are left out.
+func starText(data []byte, types string) []byte {
+	same := types != ""
+	var out []byte
+	for len(data) > 0 {
+		var t byte
+		last := false
+		if same {
+			if types == "" {
+				break
+			}
+			t, types = types[0], types[1:]
+			last = types == ""
+		} else {
+			t, data = data[0], data[1:]
+		}
+		var field []byte
+		switch {
+		case last:
+			field, data = data, nil
+		case 'a' <= t && t <= 'z':
+			i := bytes.IndexByte(data, 0)
+			if i < 0 {
+				field, data = data, nil
+				break
+			}
+			field, data = data[:i], data[i+1:]
+		default:
+			if len(data) < 4 {
+				return out
+			}
+			n := 4 + int(binary.BigEndian.Uint32(data))
+			if n > len(data) {
+				n = len(data)
+			}
+			field, data = data[4:n], data[n:]
+		}
+		if t < 'a' || t > 'z' {
+			continue
+		}
+		if t == 'g' || t == 'h' || t == 'x' {
+			field = stripTags(field)
+		}
+		out = append(out, bytes.TrimRight(field, "\n")...)
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// stripTags removes markup from a Pango, HTML or XDXF field.
+func stripTags(b []byte) []byte {
+	var out []byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '<')
+		if i < 0 {
+			out = append(out, b...)
+			break
+		}
+		out = append(out, b[:i]...)
+		j := bytes.IndexByte(b[i:], '>')
+		if j < 0 {
+			break
+		}
+		if tag := strings.ToLower(string(b[i+1 : i+j])); tag == "br" || tag == "br/" || tag == "br /" {
+			out = append(out, '\n')
+		}
+		b = b[i+j+1:]
+	}
+	return out
+}
+
+// Dicts returns the dictionaries in the library.
+func (lib *library) Dicts() ([]dict.Dict, error) {
+	var list []dict.Dict
+	for _, d := range lib.dicts {
+		list = append(list, d.Dict)
+	}
+	return list, nil
+}
+
+// lookup returns the dictionaries named by db:
+// one by name, or all of them for "*" and "!".
+func (lib *library) lookup(db string) ([]*localDict, error) {
+	if db == "*" || db == "!" {
+		return lib.dicts, nil
+	}
+	for _, d := range lib.dicts {
+		if d.Name == db {
+			return []*localDict{d}, nil
+		}
+	}
+	return nil, errNoDB
+}
+
+// Define returns the definitions of word in db, as a DICT server's
+// DEFINE command does: db names a dictionary, "*" means all of them
+// and "!" all of them in turn until one has a definition.
+func (lib *library) Define(db, word string) ([]*dict.Defn, error) {
+	dicts, err := lib.lookup(db)
+	if err != nil {
+		return nil, err
+	}
+	key := fold(word)
+	var defs []*dict.Defn
+	for _, d := range dicts {
+		i := sort.Search(len(d.entries), func(i int) bool { return d.entries[i].key >= key })
+		for ; i < len(d.entries) && d.entries[i].key == key; i++ {
+			e := d.entries[i]
+			text, err := d.text(e)
+			if err != nil {
+				return nil, err
+			}
+			defs = append(defs, &dict.Defn{Dict: d.Dict, Word: e.word, Text: text})
+		}
+		if db == "!" && len(defs) > 0 {
+			break
+		}
+	}
+	return defs, nil
+}
+
+// Match returns the headwords in db matching word under the
+// strategy strat, one of those listed in strategies or "." for
+// the default, as a DICT server's MATCH command does. The
+// definitions returned have no Text.
+func (lib *library) Match(db, strat, word string) ([]*dict.Defn, error) {
+	dicts, err := lib.lookup(db)
+	if err != nil {
+		return nil, err
+	}
+	if strat == "." {
+		strat = defaultStrat
+	}
+	key := fold(word)
+	var match func(string) bool
+	switch strat {
+	case "exact":
+		match = func(k string) bool { return k == key }
+	case "prefix":
+		match = func(k string) bool { return strings.HasPrefix(k, key) }
+	case "lev":
+		match = func(k string) bool { return lev1(k, key) }
+	default:
+		return nil, errNoStrat
+	}
+	var defs []*dict.Defn
+	for _, d := range dicts {
+		i := 0
+		if strat != "lev" {
+			i = sort.Search(len(d.entries), func(i int) bool { return d.entries[i].key >= key })
+		}
+		last := ""
+		for ; i < len(d.entries); i++ {
+			e := d.entries[i]
+			if !match(e.key) {
+				if strat != "lev" {
+					break
+				}
+				continue
+			}
+			if e.word != last && !strings.HasPrefix(e.key, "00") {
+				defs = append(defs, &dict.Defn{Dict: d.Dict, Word: e.word})
+				last = e.word
+			}
+		}
+		if db == "!" && len(defs) > 0 {
+			break
+		}
+	}
+	return defs, nil
+}
+
+// lev1 reports whether a and b are within Levenshtein distance one:
+// equal, or differing by one inserted, deleted or replaced rune.
+func lev1(a, b string) bool {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	if len(a)-len(b) > utf8.UTFMax {
+		return false
+	}
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if ra != rb {
+			return a[na:] == b[nb:] || a[na:] == b || a == b[nb:]
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return utf8.RuneCountInString(a)+utf8.RuneCountInString(b) <= 1
+}
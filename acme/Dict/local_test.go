@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dict"
+)
+
+var testWords = []struct{ word, text string }{
+	{"00databaseshort", "00databaseshort\n     Test Dictionary\n"},
+	{"apple", "apple\n  A fruit.\n"},
+	{"apply", "apply\n  To put to use.\n"},
+	{"Banana", "banana\n  A yellow fruit.\n"},
+	{"cherry", "cherry\n  A small red fruit.\n.dotted line\n"},
+}
+
+// writeDictd writes the test words as the dictd dictionary base.
+func writeDictd(t *testing.T, base string) []byte {
+	var data bytes.Buffer
+	var index strings.Builder
+	enc := func(n int) string {
+		s := ""
+		for {
+			s = string(b64digits[n%64]) + s
+			if n /= 64; n == 0 {
+				return s
+			}
+		}
+	}
+	for _, w := range testWords {
+		index.WriteString(w.word + "\t" + enc(data.Len()) + "\t" + enc(len(w.text)) + "\n")
+		data.WriteString(w.text)
+	}
+	if err := os.WriteFile(base+".index", []byte(index.String()), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return data.Bytes()
+}
+
+// writeDictzip compresses data as dictzip does, in chunks of n bytes.
+func writeDictzip(t *testing.T, name string, data []byte, n int) {
+	var chunks [][]byte
+	for i := 0; i < len(data); i += n {
+		var b bytes.Buffer
+		fw, _ := flate.NewWriter(&b, flate.BestCompression)
+		end := i + n
+		if end > len(data) {
+			end = len(data)
+		}
+		fw.Write(data[i:end])
+		if i+n < len(data) {
+			fw.Flush()
+		} else {
+			fw.Close()
+		}
+		chunks = append(chunks, b.Bytes())
+	}
+	ra := []byte{'R', 'A', 0, 0, 1, 0}
+	ra = binary.LittleEndian.AppendUint16(ra, uint16(n))
+	ra = binary.LittleEndian.AppendUint16(ra, uint16(len(chunks)))
+	for _, c := range chunks {
+		ra = binary.LittleEndian.AppendUint16(ra, uint16(len(c)))
+	}
+	binary.LittleEndian.PutUint16(ra[2:], uint16(len(ra)-4))
+
+	out := []byte{0x1f, 0x8b, 8, 0x04, 0, 0, 0, 0, 2, 3}
+	out = binary.LittleEndian.AppendUint16(out, uint16(len(ra)))
+	out = append(out, ra...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(data))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	if err := os.WriteFile(name, out, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeStarDict writes the test words as the StarDict dictionary base.
+func writeStarDict(t *testing.T, base string) {
+	var data, idx bytes.Buffer
+	for _, w := range testWords[1:] {
+		idx.WriteString(w.word + "\x00")
+		idx.Write(binary.BigEndian.AppendUint32(nil, uint32(data.Len())))
+		text := "<b>" + strings.TrimSpace(w.text) + "</b>"
+		idx.Write(binary.BigEndian.AppendUint32(nil, uint32(len(text))))
+		data.WriteString(text)
+	}
+	ifo := "StarDict's dict ifo file\nversion=2.4.2\nbookname=Star Test\nwordcount=4\nsametypesequence=h\n"
+	for name, b := range map[string][]byte{".ifo": []byte(ifo), ".idx": idx.Bytes(), ".dict": data.Bytes()} {
+		if err := os.WriteFile(base+name, b, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testLibrary(t *testing.T) *library {
+	dir := t.TempDir()
+	data := writeDictd(t, filepath.Join(dir, "plain"))
+	if err := os.WriteFile(filepath.Join(dir, "plain.dict"), data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	writeDictd(t, filepath.Join(dir, "zipped"))
+	writeDictzip(t, filepath.Join(dir, "zipped.dict.dz"), data, 16)
+	writeStarDict(t, filepath.Join(dir, "star"))
+
+	lib, err := openLibrary([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+func TestLibrary(t *testing.T) {
+	lib := testLibrary(t)
+	dicts, _ := lib.Dicts()
+	want := []dict.Dict{
+		{Name: "plain", Desc: "Test Dictionary"},
+		{Name: "zipped", Desc: "Test Dictionary"},
+		{Name: "star", Desc: "Star Test"},
+	}
+	if !reflect.DeepEqual(dicts, want) {
+		t.Errorf("Dicts = %v, want %v", dicts, want)
+	}
+
+	for _, db := range []string{"plain", "zipped"} {
+		for _, w := range testWords[1:] {
+			defs, err := lib.Define(db, strings.ToUpper(w.word))
+			if err != nil || len(defs) != 1 || string(defs[0].Text) != w.text || defs[0].Word != w.word {
+				t.Errorf("Define(%s, %s) = %v, %v", db, w.word, defs, err)
+			}
+		}
+	}
+	defs, err := lib.Define("star", "cherry")
+	if err != nil || len(defs) != 1 || string(defs[0].Text) != "cherry\n  A small red fruit.\n.dotted line\n" {
+		t.Errorf("Define(star, cherry) = %v, %v", defs, err)
+	}
+	if defs, _ := lib.Define("*", "apple"); len(defs) != 3 {
+		t.Errorf("Define(*, apple) found %d definitions, want 3", len(defs))
+	}
+	if defs, _ := lib.Define("!", "apple"); len(defs) != 1 || defs[0].Dict.Name != "plain" {
+		t.Errorf("Define(!, apple) = %v", defs)
+	}
+	if _, err := lib.Define("none", "apple"); err != errNoDB {
+		t.Errorf("Define(none, apple) error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		strat, word string
+		want        []string
+	}{
+		{"prefix", "app", []string{"apple", "apply"}},
+		{"prefix", "b", []string{"Banana"}},
+		{"lev", "aple", []string{"apple"}},
+		{"lev", "applx", []string{"apple", "apply"}},
+		{".", "chery", []string{"cherry"}},
+		{"exact", "app", nil},
+	} {
+		defs, err := lib.Match("plain", tt.strat, tt.word)
+		var got []string
+		for _, d := range defs {
+			got = append(got, d.Word)
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Match(%s, %s) = %v, %v, want %v", tt.strat, tt.word, got, err, tt.want)
+		}
+	}
+	if _, err := lib.Match("plain", "soundex", "x"); err != errNoStrat {
+		t.Errorf("Match with unknown strategy: error = %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	lib := testLibrary(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serve(l, lib)
+
+	c, err := dict.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	dicts, err := c.Dicts()
+	if err != nil || len(dicts) != 3 || dicts[2] != (dict.Dict{Name: "star", Desc: "Star Test"}) {
+		t.Errorf("Dicts = %v, %v", dicts, err)
+	}
+	defs, err := c.Define("zipped", "cherry")
+	if err != nil || len(defs) != 1 || string(defs[0].Text) != "cherry\n  A small red fruit.\n.dotted line\n" {
+		t.Errorf("Define(zipped, cherry) = %v, %v", defs, err)
+	}
+	if _, err := c.Define("zipped", "durian"); err == nil || !strings.Contains(err.Error(), "552") {
+		t.Errorf("Define(zipped, durian) error = %v, want 552", err)
+	}
+	if _, err := c.Define("none", "apple"); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("Define(none, apple) error = %v, want 550", err)
+	}
+	if defs, err := c.Define("*", "two words"); err == nil || defs != nil {
+		t.Errorf("Define(*, two words) = %v, %v", defs, err)
+	}
+}
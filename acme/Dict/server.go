@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"golang.org/x/net/dict"
+)
+
+// serve answers DICT protocol (RFC 2229) requests from lib on l,
+// so that other clients, such as Dict -s, can use local dictionaries.
+// It implements the commands that lookups need: DEFINE, MATCH,
+// SHOW DB, SHOW STRAT, CLIENT, HELP and QUIT.
+func serve(l net.Listener, lib *library) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveConn(c, lib)
+	}
+}
+
+const serverHelp = `DEFINE database word         look up word in database
+MATCH database strategy word  match word in database using strategy
+SHOW DB                       list all accessible databases
+SHOW STRAT                    list available matching strategies
+CLIENT info                   identify client to server
+HELP                          display this help information
+QUIT                          terminate connection`
+
+func serveConn(c net.Conn, lib *library) {
+	defer c.Close()
+	t := textproto.NewConn(c)
+	host, _ := os.Hostname()
+	t.PrintfLine("220 %s Dict local dictionary server <> <%d@%s>", host, os.Getpid(), host)
+	for {
+		line, err := t.ReadLine()
+		if err != nil {
+			return
+		}
+		args, err := splitCmd(line)
+		if err != nil || len(args) == 0 {
+			t.PrintfLine("501 syntax error, illegal parameters")
+			continue
+		}
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "DEFINE" && len(args) == 3:
+			defs, err := lib.Define(args[1], args[2])
+			if reply(t, err, len(defs), "552 no match") {
+				continue
+			}
+			t.PrintfLine("150 %d definitions retrieved", len(defs))
+			for _, d := range defs {
+				t.PrintfLine("151 %s %s %s", quote(d.Word), d.Dict.Name, quote(d.Dict.Desc))
+				writeText(t, string(d.Text))
+			}
+			t.PrintfLine("250 ok")
+		case cmd == "MATCH" && len(args) == 4:
+			defs, err := lib.Match(args[1], args[2], args[3])
+			if reply(t, err, len(defs), "552 no match") {
+				continue
+			}
+			t.PrintfLine("152 %d matches found", len(defs))
+			var b strings.Builder
+			for _, d := range defs {
+				fmt.Fprintf(&b, "%s %s\n", d.Dict.Name, quote(d.Word))
+			}
+			writeText(t, b.String())
+			t.PrintfLine("250 ok")
+		case cmd == "SHOW" && len(args) == 2:
+			var list []dict.Dict
+			var code int
+			switch strings.ToUpper(args[1]) {
+			case "DB", "DATABASES":
+				list, _ = lib.Dicts()
+				code = 110
+				if len(list) == 0 {
+					t.PrintfLine("554 no databases present")
+					continue
+				}
+			case "STRAT", "STRATEGIES":
+				list, code = strategies, 111
+			default:
+				t.PrintfLine("501 syntax error, illegal parameters")
+				continue
+			}
+			t.PrintfLine("%d %d items present", code, len(list))
+			var b strings.Builder
+			for _, d := range list {
+				fmt.Fprintf(&b, "%s %s\n", d.Name, quote(d.Desc))
+			}
+			writeText(t, b.String())
+			t.PrintfLine("250 ok")
+		case cmd == "CLIENT":
+			t.PrintfLine("250 ok")
+		case cmd == "HELP":
+			t.PrintfLine("113 help text follows")
+			writeText(t, serverHelp)
+			t.PrintfLine("250 ok")
+		case cmd == "QUIT":
+			t.PrintfLine("221 bye")
+			return
+		case cmd == "DEFINE" || cmd == "MATCH" || cmd == "SHOW":
+			t.PrintfLine("501 syntax error, illegal parameters")
+		default:
+			t.PrintfLine("500 unknown command")
+		}
+	}
+}
+
+// reply sends the status for a failed or empty lookup,
+// reporting whether it did.
+func reply(t *textproto.Conn, err error, n int, none string) bool {
+	switch {
+	case errors.Is(err, errNoDB):
+		t.PrintfLine("550 invalid database, use \"SHOW DB\" for list of databases")
+	case errors.Is(err, errNoStrat):
+		t.PrintfLine("551 invalid strategy, use \"SHOW STRAT\" for a list of strategies")
+	case err != nil:
+		log.Print(err)
+		t.PrintfLine("420 server temporarily unavailable")
+	case n == 0:
+		t.PrintfLine("%s", none)
+	default:
+		return false
+	}
+	return true
+}
+
+// writeText writes s as a dot-terminated text block.
+func writeText(t *textproto.Conn, s string) {
+	w := t.DotWriter()
+	w.Write([]byte(s))
+	w.Close()
+}
+
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// splitCmd splits a command line into words, which may be
+// quoted with single or double quotes and use backslash escapes.
+func splitCmd(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+		var b strings.Builder
+		var q byte
+		i := 0
+	Scan:
+		for ; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == '\\' && i+1 < len(line):
+				i++
+				b.WriteByte(line[i])
+			case q != 0 && c == q:
+				q = 0
+			case q != 0:
+				b.WriteByte(c)
+			case c == '"' || c == '\'':
+				q = c
+			case c == ' ' || c == '\t':
+				break Scan
+			default:
+				b.WriteByte(c)
+			}
+		}
+		if q != 0 {
+			return nil, errors.New("unterminated quote")
+		}
+		args = append(args, b.String())
+		line = line[i:]
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// addrRE matches what may be a file:line address in command output:
+// a file name, at the start of the output line or after a space or
+// opening bracket, followed by a colon and a line number.
+var addrRE = regexp.MustCompile(`(^|[\s(\[])([^\s:()\[\]"'=]+):([0-9]+)`)
+
+// maxIndex limits how many files a resolver indexes.
+const maxIndex = 100000
+
+// A resolver rewrites the file:line addresses in command output
+// to use absolute file names, so that clicking on them in the
+// +watch window opens the right file wherever the command printed
+// them relative to. A relative name is taken relative to dir or,
+// failing that, as the end of the name of a single file in the tree
+// under dir, as when go test prints a name relative to its package.
+// Names that match no file are left alone.
+type resolver struct {
+	filter *filter
+	index  map[string][]string // base name → files under dir; nil until needed
+}
+
+// rewrite returns line with its addresses made absolute.
+func (r *resolver) rewrite(line string) string {
+	return addrRE.ReplaceAllStringFunc(line, func(m string) string {
+		sub := addrRE.FindStringSubmatch(m)
+		lead, name, num := sub[1], sub[2], sub[3]
+		if abs := r.resolve(name); abs != "" {
+			return lead + abs + ":" + num
+		}
+		return m
+	})
+}
+
+// resolve returns the absolute name of the file called name,
+// or "" if there is no such file or no single such file.
+func (r *resolver) resolve(name string) string {
+	if filepath.IsAbs(name) {
+		return ""
+	}
+	dir := r.filter.dir
+	if full := filepath.Join(dir, name); isFile(full) {
+		return full
+	}
+	if strings.HasPrefix(name, ".") {
+		// ./x or ../x names only make sense relative to dir.
+		return ""
+	}
+	if r.index == nil {
+		r.buildIndex()
+	}
+	var found string
+	for _, full := range r.index[filepath.Base(name)] {
+		if strings.HasSuffix(full, "/"+name) {
+			if found != "" {
+				return ""
+			}
+			found = full
+		}
+	}
+	return found
+}
+
+func (r *resolver) buildIndex() {
+	r.index = make(map[string][]string)
+	n := 0
+	filepath.WalkDir(r.filter.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			rel, _ := filepath.Rel(r.filter.dir, name)
+			if name != r.filter.dir && (strings.HasPrefix(d.Name(), ".") || r.filter.exclude.match(rel)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if n++; n > maxIndex {
+			return filepath.SkipAll
+		}
+		base := d.Name()
+		r.index[base] = append(r.index[base], name)
+		return nil
+	})
+}
+
+func isFile(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "pkg/b_test.go", "pkg/c.go", "other/c.go", ".git/d.go"} {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &resolver{filter: &filter{dir: dir}}
+	for _, tt := range []struct{ in, out string }{
+		{"./a.go:3:4: undefined: x", dir + "/a.go:3:4: undefined: x"},
+		{"pkg/c.go:1: bad", dir + "/pkg/c.go:1: bad"},
+		{"    b_test.go:12: got 1", "    " + dir + "/pkg/b_test.go:12: got 1"},
+		{"  --> pkg/c.go:2:5", "  --> " + dir + "/pkg/c.go:2:5"},
+		{"(a.go:7)", "(" + dir + "/a.go:7)"},
+		{"c.go:1: ambiguous", "c.go:1: ambiguous"},
+		{"d.go:1: hidden", "d.go:1: hidden"},
+		{"/abs/e.go:1: absolute", "/abs/e.go:1: absolute"},
+		{"at 12:30:45", "at 12:30:45"},
+		{"a.go: no line", "a.go: no line"},
+	} {
+		if got := r.rewrite(tt.in); got != tt.out {
+			t.Errorf("rewrite(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f := &filter{dir: "/src", include: globList{"*.go", "cmd/*"}, exclude: globList{"testdata", "gen/*.go"}}
+	for _, tt := range []struct {
+		name      string
+		flat, rec bool
+	}{
+		{"/src/a.go", true, true},
+		{"/src/a.txt", false, false},
+		{"/src/cmd/x", false, true},
+		{"/src/p/a.go", false, true},
+		{"/src/p/testdata/a.go", false, false},
+		{"/src/gen/a.go", false, false},
+		{"/src/+watch", false, false},
+		{"/other/a.go", false, false},
+	} {
+		f.recursive = false
+		if got := f.wants(tt.name); got != tt.flat {
+			t.Errorf("wants(%s) = %v, want %v", tt.name, got, tt.flat)
+		}
+		f.recursive = true
+		if got := f.wants(tt.name); got != tt.rec {
+			t.Errorf("wants(%s) with -r = %v, want %v", tt.name, got, tt.rec)
+		}
+	}
+}
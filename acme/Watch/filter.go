@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// A globList is a list of file name patterns, set by a repeated flag.
+type globList []string
+
+func (l *globList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *globList) Set(s string) error {
+	if _, err := filepath.Match(s, ""); err != nil {
+		return err
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// match reports whether a pattern in l matches the file name, given
+// relative to the watched directory. A pattern without a slash
+// matches the base name or any directory along the way, so that
+// -x .git excludes everything under .git; one with a slash matches
+// the relative name, or a directory leading to it.
+func (l globList) match(rel string) bool {
+	elems := strings.Split(rel, "/")
+	for _, pat := range l {
+		if !strings.Contains(pat, "/") {
+			for _, e := range elems {
+				if ok, _ := filepath.Match(pat, e); ok {
+					return true
+				}
+			}
+			continue
+		}
+		for i := range elems {
+			if ok, _ := filepath.Match(pat, strings.Join(elems[:i+1], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A filter decides which changed files cause the command to run.
+type filter struct {
+	dir       string
+	recursive bool
+	include   globList
+	exclude   globList
+}
+
+// rel returns name relative to the watched directory,
+// or false if name is outside what is being watched.
+func (f *filter) rel(name string) (string, bool) {
+	rel, err := filepath.Rel(f.dir, name)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	if !f.recursive && strings.Contains(rel, "/") {
+		return "", false
+	}
+	return rel, true
+}
+
+// wants reports whether a change to the file name should
+// cause the command to run.
+func (f *filter) wants(name string) bool {
+	rel, ok := f.rel(name)
+	if !ok || strings.HasSuffix(rel, "+watch") || f.exclude.match(rel) {
+		return false
+	}
+	return len(f.include) == 0 || f.include.match(rel)
+}
+
+// skipDir reports whether the directory name should not be watched
+// for changes made outside acme: it is excluded, or hidden.
+func (f *filter) skipDir(name string) bool {
+	rel, ok := f.rel(name)
+	if !ok {
+		return name != f.dir
+	}
+	return f.exclude.match(rel) || strings.HasPrefix(filepath.Base(rel), ".")
+}
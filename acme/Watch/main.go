@@ -6,13 +6,37 @@
 //
 // Usage:
 //
-//	Watch cmd [args...]
+//	Watch [-r] [-w] [-i glob]... [-x glob]... [-d delay] cmd [args...]
 //
 // Watch opens a new acme window named for the current directory
 // with a suffix of /+watch. The window shows the execution of the given
 // command. Each time any file in that directory is Put from within acme,
 // Watch reexecutes the command and updates the window.
 //
+// The -r option watches the files in all subdirectories as well.
+// The -w option also watches for files written, created, removed
+// or renamed by other programs; it is only supported on Linux, and
+// it skips directories whose names begin with a dot.
+//
+// The -i and -x options, which may be repeated, restrict the files
+// watched to those matching an include pattern, if any are given,
+// and not matching an exclude pattern. A pattern without a slash
+// matches a file's base name or any directory along its path, so that
+// -x testdata ignores everything under testdata directories; one with
+// a slash matches the path relative to the current directory.
+// Use -x to ignore files written by the command itself, which would
+// otherwise run it again.
+//
+// Changes arriving together are collected: the command runs once
+// no more have arrived for the -d delay, 200ms by default.
+//
+// In the command output, file:line addresses with relative file names
+// are rewritten to use absolute ones, so that they open the right file
+// when clicked with the right button. A name is taken relative to the
+// current directory or, failing that, as the end of the name of a
+// single file in the tree below it, as when go test prints names
+// relative to each package.
+//
 // The command and arguments are joined by spaces and passed to rc(1)
 // to be interpreted as a shell command line.
 //
@@ -37,7 +61,6 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -51,10 +74,24 @@ import (
 var args []string
 var win *acme.Win
 var needrun = make(chan bool, 1)
-var recursive = flag.Bool("r", false, "watch all subdirectories recursively")
+var changed = make(chan bool, 1)
+
+var (
+	recursive = flag.Bool("r", false, "watch all subdirectories recursively")
+	outside   = flag.Bool("w", false, "watch for changes made outside acme")
+	delay     = flag.Duration("d", 200*time.Millisecond, "run the command `delay` after the last change")
+	include   globList
+	exclude   globList
+)
+
+func init() {
+	flag.Var(&include, "i", "watch only files matching `glob`")
+	flag.Var(&exclude, "x", "ignore files matching `glob`")
+}
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: Watch [-r] cmd args...\n")
+	fmt.Fprintf(os.Stderr, "usage: Watch [-r] [-w] [-i glob]... [-x glob]... [-d delay] cmd args...\n")
+	flag.PrintDefaults()
 	os.Exit(2)
 }
 
@@ -65,6 +102,9 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 	args = flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
 
 	var err error
 	win, err = acme.New()
@@ -74,71 +114,120 @@ func main() {
 	pwd, _ := os.Getwd()
 	pwdSlash := strings.TrimSuffix(pwd, "/") + "/"
 	win.Name(pwdSlash + "+watch")
+	win.SetErrorPrefix(pwdSlash)
 	win.Ctl("clean")
 	win.Ctl("dumpdir " + pwd)
-	win.Fprintf("tag", "Get Kill Quit ")
+	d, err := acme.NewDispatcher(win, commands{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	d.Describe("Get", "run the commands again")
+	d.Describe("Kill", "stop the commands")
+	d.Describe("Quit", "send the commands SIGQUIT")
+	d.SetTag()
 	win.Fprintf("body", "%% %s\n", strings.Join(args, " "))
 
+	f := &filter{dir: pwd, recursive: *recursive, include: include, exclude: exclude}
 	needrun <- true
-	go events()
-	go runner(pwd)
+	go events(d)
+	go runner(f)
+	go debounce()
 
-	r, err := acme.Log()
+	if *outside {
+		err := notify(f, func(name string) {
+			if f.wants(name) {
+				trigger()
+			}
+		})
+		if err != nil {
+			win.Fprintf("body", "(%v)\n", err)
+		}
+	}
+
+	wt, err := acme.Watch("")
 	if err != nil {
 		log.Fatal(err)
 	}
-	for {
-		ev, err := r.Read()
-		if err != nil {
-			log.Fatal(err)
+	for ev := range wt.Events() {
+		if ev.Op == acme.WatchPut && f.wants(ev.Name) {
+			trigger()
 		}
-		if ev.Op == "put" && (path.Dir(ev.Name) == pwd || *recursive && strings.HasPrefix(ev.Name, pwdSlash)) {
+	}
+	log.Fatal(wt.Err())
+}
+
+// trigger notes a change to a watched file.
+func trigger() {
+	select {
+	case changed <- true:
+	default:
+	}
+}
+
+// debounce asks for the command to run once changes
+// have stopped arriving for the -d delay.
+func debounce() {
+	for range changed {
+		t := time.NewTimer(*delay)
+		for waiting := true; waiting; {
 			select {
-			case needrun <- true:
-			default:
+			case <-changed:
+				t.Reset(*delay)
+			case <-t.C:
+				waiting = false
 			}
-			// slow down any runaway loops
-			time.Sleep(100 * time.Millisecond)
 		}
+		select {
+		case needrun <- true:
+		default:
+		}
+		// slow down any runaway loops
+		time.Sleep(100 * time.Millisecond)
 	}
 }
 
-func events() {
-	for e := range win.EventChan() {
-		switch e.C2 {
-		case 'x', 'X': // execute
-			if string(e.Text) == "Get" {
-				select {
-				case needrun <- true:
-				default:
-				}
-				continue
-			}
-			if string(e.Text) == "Kill" {
-				run.Lock()
-				cmd := run.cmd
-				run.kill = true
-				run.Unlock()
-				if cmd != nil {
-					kill(cmd)
-				}
-				continue
-			}
-			if string(e.Text) == "Quit" {
-				run.Lock()
-				cmd := run.cmd
-				run.Unlock()
-				if cmd != nil {
-					quit(cmd)
-				}
-				continue
-			}
-			if string(e.Text) == "Del" {
-				win.Ctl("delete")
-			}
-		}
-		win.WriteEvent(e)
+// commands are the commands of the +watch window.
+type commands struct{}
+
+func (commands) ExecGet() {
+	select {
+	case needrun <- true:
+	default:
+	}
+}
+
+func (commands) ExecKill() {
+	run.Lock()
+	cmd := run.cmd
+	run.kill = true
+	run.Unlock()
+	if cmd != nil {
+		kill(cmd)
+	}
+}
+
+func (commands) ExecQuit() {
+	run.Lock()
+	cmd := run.cmd
+	run.Unlock()
+	if cmd != nil {
+		quit(cmd)
+	}
+}
+
+func (commands) Execute(cmd string) bool {
+	if cmd == "Del" {
+		win.Ctl("delete")
 	}
+	return false
+}
+
+func (commands) Look(arg string) bool {
+	return false
+}
+
+func events(d *acme.Dispatcher) {
+	d.Loop()
 	os.Exit(0)
 }
 
@@ -149,7 +238,7 @@ var run struct {
 	kill bool
 }
 
-func runner(dir string) {
+func runner(f *filter) {
 	for range needrun {
 		run.Lock()
 		run.id++
@@ -164,7 +253,7 @@ func runner(dir string) {
 		lastcmd = nil
 
 		runSetup(id)
-		go runBackground(id, dir)
+		go runBackground(id, f)
 	}
 }
 
@@ -178,12 +267,11 @@ func runSetup(id int) {
 	var dumpcmd string
 	if len(matches) == 0 {
 		// reset window
-		win.Addr(",")
-		win.Write("data", nil)
-		win.Write("body", []byte(fmt.Sprintf("%% %s\n", strings.Join(args, " "))))
+		win.ApplyEdits([]acme.Edit{{Q0: 0, Q1: utf8.RuneCount(data), Text: []byte(fmt.Sprintf("%% %s\n", strings.Join(args, " ")))}})
 
 		dumpcmd = strings.Join(args, " ")
 	} else {
+		var edits []acme.Edit
 		end, endByte := utf8.RuneCount(data), len(data)
 		for i := len(matches) - 1; i >= 0; i-- {
 			m := matches[i]
@@ -193,8 +281,7 @@ func runSetup(id int) {
 				log.Fatal("bad runes")
 			}
 			if mEnd < end {
-				win.Addr("#%d,#%d", mEnd, end)
-				win.Write("data", nil)
+				edits = append(edits, acme.Edit{Q0: mEnd, Q1: end})
 			}
 			end, endByte = mStart, m[0]
 
@@ -204,21 +291,45 @@ func runSetup(id int) {
 			}
 		}
 		if end > 0 {
-			win.Addr(",#%d", end)
-			win.Write("data", nil)
+			edits = append(edits, acme.Edit{Q0: 0, Q1: end})
 		}
+		win.ApplyEdits(edits)
 	}
 	win.Addr("#0")
 
-	prefix := "dump Watch"
-	if *recursive {
-		prefix += " -r"
+	win.Ctl("dump %s %s", dumpFlags(), dumpcmd)
+}
+
+// dumpFlags returns the Watch command line, without the command
+// to run, for acme's Dump to recreate the window.
+func dumpFlags() string {
+	s := "Watch"
+	flag.Visit(func(f *flag.Flag) {
+		switch v := f.Value.(type) {
+		case *globList:
+			for _, pat := range *v {
+				s += " -" + f.Name + " " + quote(pat)
+			}
+		default:
+			if b, ok := v.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				s += " -" + f.Name
+			} else {
+				s += " -" + f.Name + " " + quote(v.String())
+			}
+		}
+	})
+	return s
+}
+
+// quote quotes s for rc if it needs it.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'`^$#;&|<>(){}=*?[]\\") {
+		return s
 	}
-	cmd := fmt.Sprintf("%s %s", prefix, dumpcmd)
-	win.Ctl(cmd)
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
 }
 
-func runBackground(id int, dir string) {
+func runBackground(id int, f *filter) {
 	buf := make([]byte, 4096)
 	run.Lock()
 	for {
@@ -271,7 +382,7 @@ func runBackground(id int, dir string) {
 		}
 
 		cmd := exec.Command(rc, "-c", string(line))
-		cmd.Dir = dir
+		cmd.Dir = f.dir
 		r, w, err := os.Pipe()
 		if err != nil {
 			log.Fatal(err)
@@ -296,7 +407,17 @@ func runBackground(id int, dir string) {
 		run.cmd = cmd
 		run.Unlock()
 
-		bol := true
+		// Write the output a line at a time, making addresses absolute.
+		// Insert leading space in front of % at start of line
+		// to avoid introducing new commands.
+		res := &resolver{filter: f}
+		var partial []byte
+		writeLine := func(line []byte) {
+			if line[0] == '%' {
+				win.Write("data", []byte(" "))
+			}
+			win.Write("data", line)
+		}
 		for {
 			n, err := r.Read(buf)
 			if err != nil {
@@ -304,26 +425,15 @@ func runBackground(id int, dir string) {
 			}
 			run.Lock()
 			if id == run.id && n > 0 {
-				// Insert leading space in front of % at start of line
-				// to avoid introducing new commands.
-				p := buf[:n]
-				if bol && p[0] == '%' {
-					win.Write("data", []byte(" "))
-				}
+				partial = append(partial, buf[:n]...)
 				for {
-					// invariant: len(p) > 0.
-					// invariant: not at beginning of line in acme window output
-					// (either not at beginning of line in actual output, or leading space printed).
-					i := bytes.Index(p, []byte("\n%"))
+					i := bytes.IndexByte(partial, '\n')
 					if i < 0 {
 						break
 					}
-					win.Write("data", p[:i+1])
-					win.Write("data", []byte(" "))
-					p = p[i+1:]
+					writeLine([]byte(res.rewrite(string(partial[:i+1]))))
+					partial = partial[i+1:]
 				}
-				win.Write("data", p)
-				bol = p[len(p)-1] == '\n'
 			}
 			run.Unlock()
 		}
@@ -331,7 +441,8 @@ func runBackground(id int, dir string) {
 		run.Lock()
 		if id == run.id {
 			// If output was missing final newline, print trailing backslash and add newline.
-			if !bol {
+			if len(partial) > 0 {
+				writeLine(partial)
 				win.Fprintf("data", "\\\n")
 			}
 			if err != nil {
@@ -0,0 +1,86 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const notifyMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ONLYDIR
+
+// notify watches the file system under f.dir with inotify, calling
+// changed with the name of each file written, created, removed or
+// renamed, including by programs other than acme. It watches the
+// subdirectories too if f.recursive is set, adding new ones as they
+// appear, except for those f.skipDir rejects.
+func notify(f *filter, changed func(name string)) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	dirs := make(map[int]string)
+	add := func(root string) {
+		filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if f.skipDir(name) {
+				return filepath.SkipDir
+			}
+			wd, err := unix.InotifyAddWatch(fd, name, notifyMask)
+			if err != nil {
+				log.Printf("watch %s: %v", name, err)
+				return filepath.SkipDir
+			}
+			dirs[wd] = name
+			if !f.recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}
+	add(f.dir)
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := unix.Read(fd, buf)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Printf("inotify: %v", err)
+				return
+			}
+			for p := buf[:n]; len(p) >= unix.SizeofInotifyEvent; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&p[0]))
+				raw := p[unix.SizeofInotifyEvent : unix.SizeofInotifyEvent+int(ev.Len)]
+				p = p[unix.SizeofInotifyEvent+int(ev.Len):]
+				if ev.Mask&unix.IN_IGNORED != 0 {
+					delete(dirs, int(ev.Wd))
+					continue
+				}
+				dir, ok := dirs[int(ev.Wd)]
+				if !ok {
+					continue
+				}
+				name := filepath.Join(dir, string(bytes.TrimRight(raw, "\x00")))
+				if ev.Mask&unix.IN_ISDIR != 0 {
+					if f.recursive && ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+						add(name)
+					}
+					continue
+				}
+				changed(name)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	dir := t.TempDir()
+	c := make(chan string, 10)
+	f := &filter{dir: dir, recursive: true}
+	if err := notify(f, func(name string) { c <- name }); err != nil {
+		t.Fatal(err)
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case name := <-c:
+			if name != want {
+				t.Errorf("changed %s, want %s", name, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change reported for %s", want)
+		}
+	}
+
+	a := filepath.Join(dir, "a.go")
+	os.WriteFile(a, []byte("x"), 0666)
+	expect(a) // create
+	expect(a) // close after write
+
+	// New directories are watched too.
+	sub := filepath.Join(dir, "sub")
+	os.Mkdir(sub, 0777)
+	time.Sleep(100 * time.Millisecond) // let the watch be added
+	b := filepath.Join(sub, "b.go")
+	os.WriteFile(b, []byte("x"), 0666)
+	expect(b)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// notify would watch the file system for changes made outside acme,
+// but only Linux's inotify is supported.
+func notify(f *filter, changed func(name string)) error {
+	return errors.New("watching for changes outside acme is only supported on Linux")
+}
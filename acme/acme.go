@@ -56,6 +56,15 @@ var fsys *client.Fsys
 var fsysErr error
 var fsysOnce sync.Once
 
+// SetFsys makes the package use fs as the acme file system
+// instead of mounting the acme service, for instance to run
+// against an acmetest server in tests.
+func SetFsys(fs *client.Fsys) {
+	fsysOnce.Do(func() {})
+	fsys = fs
+	fsysErr = nil
+}
+
 // AutoExit sets whether to call os.Exit the next time the last managed acme window is deleted.
 // If there are no acme windows at the time of the call, the exit does not happen until one
 // is created and then deleted.
@@ -120,6 +129,11 @@ type WinInfo struct {
 
 	// History is only non-nil if the version of acme supports it.
 	History *WinHistoryInfo
+
+	// Encoding holds the encoding of the window's file,
+	// such as utf-8 or latin1+crlf. Like History, it is only
+	// set by Win.Info, if the version of acme supports it.
+	Encoding string
 }
 
 type WinSizeInfo struct {
@@ -156,7 +170,11 @@ func (r *LogReader) Read() (LogEvent, error) {
 	if err != nil {
 		return LogEvent{}, err
 	}
-	f := strings.SplitN(string(r.buf[:n]), " ", 3)
+	return parseLogEvent(r.buf[:n])
+}
+
+func parseLogEvent(b []byte) (LogEvent, error) {
+	f := strings.SplitN(string(b), " ", 3)
 	if len(f) != 3 {
 		return LogEvent{}, fmt.Errorf("malformed log event")
 	}
@@ -262,6 +280,8 @@ func Open(id int, ctl *client.Fid) (*Win, error) {
 	w := new(Win)
 	w.id = id
 	w.ctl = ctl
+	windowsMu.Lock()
+	defer windowsMu.Unlock()
 	w.next = nil
 	w.prev = last
 	if last != nil {
@@ -460,9 +480,15 @@ func (w *Win) Info() (WinInfo, error) {
 	}
 	if rest != "" {
 		info.History = new(WinHistoryInfo)
-		if _, err := splitFields(rest, &info.History.CanUndo, &info.History.CanRedo); err != nil {
+		rest, err = splitFields(rest, &info.History.CanUndo, &info.History.CanRedo)
+		if err != nil {
 			return WinInfo{}, fmt.Errorf("invalid history info in %q: %v", line, err)
 		}
+		if rest != "" {
+			if _, err := splitFields(rest, &info.Encoding); err != nil {
+				return WinInfo{}, fmt.Errorf("invalid encoding in %q: %v", line, err)
+			}
+		}
 	}
 	return info, nil
 }
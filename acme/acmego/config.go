@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A rule says how to format the files whose names match a pattern.
+type rule struct {
+	pattern string        // glob for the base name, or for the path if it has a slash
+	dir     string        // directory that a pattern with a slash is relative to
+	mode    string        // "arg", "stdin" or "inplace"
+	imports bool          // apply only changes to the Go import block
+	timeout time.Duration // 0 for the -t default
+	cmd     []string      // formatter command; nil to leave files alone
+	src     string        // file:line where the rule is defined
+}
+
+// Built-in rules, used after any from the configuration files.
+const (
+	importRules = `*.go apply=imports goimports`
+	formatRules = `
+		*.go	goimports
+		*.rs	rustfmt --emit stdout
+		*.py	yapf
+		*.sql	sqlfmt -
+	`
+)
+
+// parseRules parses the rules in a configuration file.
+// Each non-blank line not starting with # holds a rule:
+//
+//	pattern [option=value]... command [arg]...
+//
+// Fields are separated by spaces and tabs and may be quoted with
+// single quotes as in rc, with a doubled quote standing for one.
+// Patterns with a slash are relative to dir; dir "" leaves them as is.
+// Bad lines are skipped and reported, each error prefixed by file:line.
+func parseRules(file, dir string, data []byte) ([]*rule, error) {
+	var rules []*rule
+	var errs []error
+	for i, line := range strings.Split(string(data), "\n") {
+		src := fmt.Sprintf("%s:%d", file, i+1)
+		f, err := fields(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", src, err))
+			continue
+		}
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		r, err := parseRule(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", src, err))
+			continue
+		}
+		r.src = src
+		if strings.Contains(r.pattern, "/") && dir != "" && !filepath.IsAbs(r.pattern) {
+			r.dir = dir
+		}
+		rules = append(rules, r)
+	}
+	return rules, errors.Join(errs...)
+}
+
+func parseRule(f []string) (*rule, error) {
+	r := &rule{pattern: f[0], mode: "arg"}
+	if _, err := filepath.Match(r.pattern, ""); err != nil {
+		return nil, fmt.Errorf("bad pattern %q", r.pattern)
+	}
+	f = f[1:]
+	for ; len(f) > 0; f = f[1:] {
+		key, val, ok := strings.Cut(f[0], "=")
+		if !ok {
+			break
+		}
+		switch key {
+		case "mode":
+			if val != "arg" && val != "stdin" && val != "inplace" {
+				return nil, fmt.Errorf("unknown mode %q", val)
+			}
+			r.mode = val
+		case "apply":
+			if val != "all" && val != "imports" {
+				return nil, fmt.Errorf("unknown apply %q", val)
+			}
+			r.imports = val == "imports"
+		case "timeout":
+			d, err := time.ParseDuration(val)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("bad timeout %q", val)
+			}
+			r.timeout = d
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	switch {
+	case len(f) == 0:
+		return nil, fmt.Errorf("missing command")
+	case len(f) == 1 && f[0] == "-":
+		// no formatter
+	default:
+		r.cmd = f
+	}
+	return r, nil
+}
+
+// fields splits line into fields, handling rc-style quotes.
+func fields(line string) ([]string, error) {
+	var f []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" {
+			return f, nil
+		}
+		var b strings.Builder
+		for line != "" && !strings.ContainsRune(" \t\r", rune(line[0])) {
+			if line[0] != '\'' {
+				b.WriteByte(line[0])
+				line = line[1:]
+				continue
+			}
+			line = line[1:]
+			for {
+				i := strings.IndexByte(line, '\'')
+				if i < 0 {
+					return nil, fmt.Errorf("unterminated quote")
+				}
+				b.WriteString(line[:i])
+				line = line[i+1:]
+				if !strings.HasPrefix(line, "'") {
+					break
+				}
+				b.WriteByte('\'')
+				line = line[1:]
+			}
+		}
+		f = append(f, b.String())
+	}
+}
+
+// match reports whether r applies to the file name.
+func (r *rule) match(name string) bool {
+	if !strings.Contains(r.pattern, "/") {
+		ok, _ := filepath.Match(r.pattern, filepath.Base(name))
+		return ok
+	}
+	if r.dir != "" {
+		rel, err := filepath.Rel(r.dir, name)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return false
+		}
+		name = rel
+	}
+	ok, _ := filepath.Match(r.pattern, name)
+	return ok
+}
+
+// A config finds the rule for a file.
+type config struct {
+	global  string  // global configuration file, or ""
+	builtin []*rule // rules used when no configured rule matches
+}
+
+// lookup returns the rule for name: the first matching rule in the
+// .acmego files in name's directory and each directory above it,
+// nearest first, then in the global file, then among the built-in
+// rules. It returns nil if no rule matches or the rule says to leave
+// the file alone. Errors in configuration files are returned
+// alongside, so that they can be reported without stopping the
+// search.
+func (c *config) lookup(name string) (*rule, error) {
+	var files []string
+	for dir := filepath.Dir(name); ; {
+		files = append(files, filepath.Join(dir, ".acmego"))
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	if c.global != "" {
+		files = append(files, c.global)
+	}
+
+	var errs []error
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		dir := filepath.Dir(file)
+		if file == c.global {
+			dir = ""
+		}
+		rules, err := parseRules(file, dir, data)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if r := find(rules, name); r != nil {
+			return r.use(), errors.Join(errs...)
+		}
+	}
+	return find(c.builtin, name).use(), errors.Join(errs...)
+}
+
+func find(rules []*rule, name string) *rule {
+	for _, r := range rules {
+		if r.match(name) {
+			return r
+		}
+	}
+	return nil
+}
+
+// use returns r, or nil if r says to leave files alone.
+func (r *rule) use() *rule {
+	if r == nil || r.cmd == nil {
+		return nil
+	}
+	return r
+}
+
+// args returns the formatter arguments for name, with $file replaced
+// by name. In the arg and inplace modes, name is appended if no
+// argument mentions $file.
+func (r *rule) args(name string) []string {
+	args := make([]string, len(r.cmd)-1)
+	found := false
+	for i, a := range r.cmd[1:] {
+		if strings.Contains(a, "$file") {
+			found = true
+			a = strings.ReplaceAll(a, "$file", name)
+		}
+		args[i] = a
+	}
+	if !found && r.mode != "stdin" {
+		args = append(args, name)
+	}
+	return args
+}
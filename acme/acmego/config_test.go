@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/acme/acmetest"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules("cfg", "/src", []byte(`
+# comment
+*.go	goimports
+*.ts mode=inplace timeout=30s prettier --write
+gen/*	-
+*.x	mode=stdin sh -c 'it''s $file'
+*.y	mode=bad fmt
+*.z	timeout=1s
+[	fmt
+*.q	'unterminated
+`))
+	want := []*rule{
+		{pattern: "*.go", mode: "arg", cmd: []string{"goimports"}, src: "cfg:3"},
+		{pattern: "*.ts", mode: "inplace", timeout: 30 * time.Second, cmd: []string{"prettier", "--write"}, src: "cfg:4"},
+		{pattern: "gen/*", dir: "/src", mode: "arg", src: "cfg:5"},
+		{pattern: "*.x", mode: "stdin", cmd: []string{"sh", "-c", "it's $file"}, src: "cfg:6"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		for _, r := range rules {
+			t.Logf("%+v", r)
+		}
+		t.Errorf("wrong rules")
+	}
+	for _, e := range []string{
+		`cfg:7: unknown mode "bad"`,
+		"cfg:8: missing command",
+		`cfg:9: bad pattern "["`,
+		"cfg:10: unterminated quote",
+	} {
+		if err == nil || !strings.Contains(err.Error(), e) {
+			t.Errorf("error %v does not mention %q", err, e)
+		}
+	}
+
+	if got := rules[3].args("/src/a.x"); !reflect.DeepEqual(got, []string{"-c", "it's /src/a.x"}) {
+		t.Errorf("args = %q", got)
+	}
+	if got := rules[1].args("/src/a.ts"); !reflect.DeepEqual(got, []string{"--write", "/src/a.ts"}) {
+		t.Errorf("args = %q", got)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	global := filepath.Join(root, "global")
+	write(global, "*.py black -q\n*.rs rustfmt\n")
+	write(filepath.Join(root, ".acmego"), "*.py yapf\ngen/* -\n")
+	write(filepath.Join(sub, ".acmego"), "*.py autopep8\nbad\n")
+	builtin, _ := parseRules("builtin", "", []byte(formatRules))
+	c := &config{global: global, builtin: builtin}
+
+	for _, tt := range []struct {
+		name string
+		cmd  string // "" for no rule
+	}{
+		{filepath.Join(sub, "a.py"), "autopep8"},
+		{filepath.Join(root, "a.py"), "yapf"},
+		{filepath.Join(sub, "a.rs"), "rustfmt"},
+		{filepath.Join(sub, "a.go"), "goimports"},
+		{filepath.Join(root, "gen/a.go"), ""},
+		{filepath.Join(sub, "gen/a.go"), "goimports"},
+		{filepath.Join(sub, "a.c"), ""},
+	} {
+		r, err := c.lookup(tt.name)
+		cmd := ""
+		if r != nil {
+			cmd = r.cmd[0]
+		}
+		if cmd != tt.cmd {
+			t.Errorf("lookup(%s) = %q, want %q", tt.name, cmd, tt.cmd)
+		}
+		if inSub := strings.HasPrefix(tt.name, sub+"/"); inSub != (err != nil) {
+			t.Errorf("lookup(%s) error = %v", tt.name, err)
+		}
+	}
+}
+
+func TestErrorText(t *testing.T) {
+	out := "<standard input>:3:5: expected ';'\nsub/b.go:1: bad\n/abs/c.go:2: bad\nnote: no address\n"
+	want := "/src/a.go:3:5: expected ';'\n/src/sub/b.go:1: bad\n/abs/c.go:2: bad\nnote: no address\n"
+	if got := errorText("/src/a.go", "stdin", []byte(out)); got != want {
+		t.Errorf("errorText:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReformat(t *testing.T) {
+	a := acmetest.New()
+	fsys, err := a.Mount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.SetFsys(fsys)
+
+	name := filepath.Join(t.TempDir(), "a.txt")
+	text := "one\ntwo\n"
+	if err := os.WriteFile(name, []byte(text), 0666); err != nil {
+		t.Fatal(err)
+	}
+	w := a.NewWindow(name, text)
+
+	rules, err := parseRules("cfg", "", []byte(`
+*.txt mode=stdin tr a-z A-Z
+*.bad mode=stdin sh -c 'echo "<stdin>:2: oops" >&2; exit 1'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reformat(w.ID(), name, rules[0])
+	if got := w.Body(); got != "ONE\nTWO\n" {
+		t.Errorf("body = %q after reformat", got)
+	}
+
+	reformat(w.ID(), name, &rule{mode: "stdin", cmd: rules[1].cmd, src: "cfg:3"})
+	var errs string
+	for _, w := range a.Windows() {
+		if w.Name() == filepath.Dir(name)+"/+Errors" {
+			errs = w.Body()
+		}
+	}
+	if !strings.Contains(errs, name+":2: oops\n") {
+		t.Errorf("+Errors = %q", errs)
+	}
+}
@@ -6,7 +6,7 @@
 //
 // Usage:
 //
-//	acmego [-f]
+//	acmego [-f] [-c config] [-t timeout]
 //
 // Each time a .go file is written, acmego checks whether the
 // import block needs adjustment. If so, it makes the changes
@@ -22,67 +22,105 @@
 //
 //	.rs - rustfmt
 //	.py - yapf
-//	.templ - templ fmt
+//	.sql - sqlfmt
+//
+// Further formatters are configured in the file named by -c,
+// by default $HOME/lib/acmego, and in files named .acmego in the
+// directory of the file being written and the directories above it.
+// Rules in nearer files take precedence, then those in the -c file,
+// then the built-in ones above. Each line holds a rule:
+//
+//	pattern [option=value]... command [arg]...
+//
+// The pattern matches the file's base name or, if it contains a
+// slash, its path relative to the directory of the .acmego file.
+// Fields may be quoted with single quotes as in rc. Lines starting
+// with # are comments. The options are:
+//
+//	mode=arg      run command with the file name as its last
+//	              argument and read the result from its output
+//	              (the default)
+//	mode=stdin    pipe the file to command and read the result
+//	              from its output
+//	mode=inplace  run command with the file name, letting it
+//	              rewrite the file, and read the file back
+//	apply=imports apply only changes to the Go import block
+//	timeout=d     stop command after duration d, such as 5s,
+//	              instead of after the -t timeout
+//
+// An argument containing $file has it replaced by the file name,
+// which is then not appended. A command of - leaves matching files
+// alone. For example:
+//
+//	*.templ	mode=stdin templ fmt
+//	*.ts	mode=inplace timeout=30s prettier --write
+//	gen/*	-
+//
+// The formatter runs in the directory of the file.
+// Its error output, and errors in the configuration files,
+// are shown in the +Errors window, with positions rewritten
+// to file:line addresses.
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"plramos.win/9fans/acme"
 )
 
-var gofmt = flag.Bool("f", false, "format the entire file after Put")
-
-var formatters = map[string][]string{
-	".go": {"goimports"},
-}
-
-// Non-Go formatters (only loaded with -f option).
-var otherFormatters = map[string][]string{
-	".rs":  {"rustfmt", "--emit", "stdout"},
-	".py":  {"yapf"},
-	".sql": {"sqlfmt", "-"},
-}
+var (
+	gofmt   = flag.Bool("f", false, "format the entire file after Put")
+	cfgFile = flag.String("c", filepath.Join(os.Getenv("HOME"), "lib/acmego"), "read formatter rules from `file`")
+	timeout = flag.Duration("t", 10*time.Second, "stop formatters after `duration`")
+)
 
 func main() {
 	flag.Parse()
+	builtin := importRules
 	if *gofmt {
-		for suffix, formatter := range otherFormatters {
-			formatters[suffix] = formatter
-		}
+		builtin = formatRules
 	}
-	l, err := acme.Log()
+	rules, err := parseRules("builtin", "", []byte(builtin))
 	if err != nil {
 		log.Fatal(err)
 	}
+	for _, r := range rules {
+		r.src = ""
+	}
+	cfg := &config{global: *cfgFile, builtin: rules}
 
-	for {
-		event, err := l.Read()
-		if err != nil {
-			log.Fatal(err)
-		}
-		if event.Name == "" || event.Op != "put" {
+	wt, err := acme.Watch("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for event := range wt.Events() {
+		if event.Name == "" || event.Op != acme.WatchPut {
 			continue
 		}
-		for suffix, formatter := range formatters {
-			if strings.HasSuffix(event.Name, suffix) {
-				reformat(event.ID, event.Name, formatter)
-				break
-			}
+		r, err := cfg.lookup(event.Name)
+		if err != nil {
+			acme.Err(event.Name, err.Error())
+		}
+		if r != nil {
+			reformat(event.ID, event.Name, r)
 		}
 	}
+	log.Fatal(wt.Err())
 }
 
-func reformat(id int, name string, formatter []string) {
+func reformat(id int, name string, r *rule) {
 	w, err := acme.Open(id, nil)
 	if err != nil {
 		log.Print(err)
@@ -90,25 +128,24 @@ func reformat(id int, name string, formatter []string) {
 	}
 	defer w.CloseFiles()
 
-	old, err := ioutil.ReadFile(name)
+	old, err := os.ReadFile(name)
 	if err != nil {
 		// log.Print(err)
 		return
 	}
 
-	exe, err := exec.LookPath(formatter[0])
+	exe, err := exec.LookPath(r.cmd[0])
 	if err != nil {
-		// Formatter not installed.
+		// Formatter not installed. Only complain if it was asked for.
+		if r.src != "" {
+			acme.Errf(name, "%s: %s: %v", r.src, r.cmd[0], err)
+		}
 		return
 	}
 
-	new, err := exec.Command(exe, append(formatter[1:], name)...).CombinedOutput()
+	new, err := run(exe, name, old, r)
 	if err != nil {
-		if strings.Contains(string(new), "fatal error") {
-			fmt.Fprintf(os.Stderr, "goimports %s: %v\n%s", name, err, new)
-		} else {
-			fmt.Fprintf(os.Stderr, "%s", new)
-		}
+		acme.Err(name, err.Error())
 		return
 	}
 
@@ -116,7 +153,7 @@ func reformat(id int, name string, formatter []string) {
 		return
 	}
 
-	if !*gofmt {
+	if r.imports {
 		oldTop, err := readImports(bytes.NewReader(old), true)
 		if err != nil {
 			// log.Print(err)
@@ -135,21 +172,6 @@ func reformat(id int, name string, formatter []string) {
 		return
 	}
 
-	f, err := ioutil.TempFile("", "acmego")
-	if err != nil {
-		log.Print(err)
-		return
-	}
-	if _, err := f.Write(new); err != nil {
-		log.Print(err)
-		return
-	}
-	tmp := f.Name()
-	f.Close()
-	defer os.Remove(tmp)
-
-	diff, _ := exec.Command("9", "diff", name, tmp).CombinedOutput()
-
 	latest, err := w.ReadAll("body")
 	if err != nil {
 		log.Print(err)
@@ -159,103 +181,69 @@ func reformat(id int, name string, formatter []string) {
 		log.Printf("skipped update to %s: window modified since Put\n", name)
 		return
 	}
-
-	w.Write("ctl", []byte("mark"))
-	w.Write("ctl", []byte("nomark"))
-	diffLines := strings.Split(string(diff), "\n")
-	for i := len(diffLines) - 1; i >= 0; i-- {
-		line := diffLines[i]
-		if line == "" {
-			continue
-		}
-		if line[0] == '<' || line[0] == '-' || line[0] == '>' {
-			continue
-		}
-		j := 0
-		for j < len(line) && line[j] != 'a' && line[j] != 'c' && line[j] != 'd' {
-			j++
-		}
-		if j >= len(line) {
-			log.Printf("cannot parse diff line: %q", line)
-			break
-		}
-		oldStart, oldEnd := parseSpan(line[:j])
-		newStart, newEnd := parseSpan(line[j+1:])
-		if newStart == 0 || (oldStart == 0 && line[j] != 'a') {
-			continue
-		}
-		switch line[j] {
-		case 'a':
-			err := w.Addr("%d+#0", oldStart)
-			if err != nil {
-				log.Print(err)
-				break
-			}
-			w.Write("data", findLines(new, newStart, newEnd))
-		case 'c':
-			err := w.Addr("%d,%d", oldStart, oldEnd)
-			if err != nil {
-				log.Print(err)
-				break
-			}
-			w.Write("data", findLines(new, newStart, newEnd))
-		case 'd':
-			err := w.Addr("%d,%d", oldStart, oldEnd)
-			if err != nil {
-				log.Print(err)
-				break
-			}
-			w.Write("data", nil)
-		}
-	}
-	if !bytes.HasSuffix(old, nlBytes) && bytes.HasSuffix(new, nlBytes) {
-		// plan9port diff doesn't report a difference if there's a mismatch in the
-		// final newline, so add one if needed.
-		if err := w.Addr("$"); err != nil {
-			log.Print(err)
-			return
-		}
-		w.Write("data", nlBytes)
+	if err := w.ApplyEdits(acme.LineEdits(old, new)); err != nil {
+		log.Print(err)
 	}
 }
 
-var nlBytes = []byte("\n")
+// run runs the formatter exe as r says on the file name,
+// whose content is old, and returns the formatted content.
+func run(exe, name string, old []byte, r *rule) ([]byte, error) {
+	d := r.timeout
+	if d == 0 {
+		d = *timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
 
-func parseSpan(text string) (start, end int) {
-	i := strings.Index(text, ",")
-	if i < 0 {
-		n, err := strconv.Atoi(text)
-		if err != nil {
-			log.Printf("cannot parse span %q", text)
-			return 0, 0
-		}
-		return n, n
+	cmd := exec.CommandContext(ctx, exe, r.args(name)...)
+	cmd.Dir = filepath.Dir(name)
+	if r.mode == "stdin" {
+		cmd.Stdin = bytes.NewReader(old)
 	}
-	start, err1 := strconv.Atoi(text[:i])
-	end, err2 := strconv.Atoi(text[i+1:])
-	if err1 != nil || err2 != nil {
-		log.Printf("cannot parse span %q", text)
-		return 0, 0
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v", d)
 	}
-	return start, end
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %v\n%s", name, r.cmd[0], err, errorText(name, r.mode, stderr.Bytes()))
+	}
+	if r.mode == "inplace" {
+		return os.ReadFile(name)
+	}
+	return stdout.Bytes(), nil
 }
 
-func findLines(text []byte, start, end int) []byte {
-	i := 0
+// Names that formatters use for their standard input in messages.
+var stdinNames = map[string]bool{
+	"<standard input>": true,
+	"<stdin>":          true,
+	"stdin":            true,
+	"-":                true,
+}
 
-	start--
-	for ; i < len(text) && start > 0; i++ {
-		if text[i] == '\n' {
-			start--
-			end--
-		}
-	}
-	startByte := i
-	for ; i < len(text) && end > 0; i++ {
-		if text[i] == '\n' {
-			end--
+// errorText rewrites a formatter's error output so that the
+// positions it mentions are addresses acme can open: names
+// standing for standard input become name, and relative names
+// are made relative to name's directory.
+func errorText(name, mode string, out []byte) string {
+	var b strings.Builder
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if file, rest, ok := strings.Cut(line, ":"); ok && rest != "" && '0' <= rest[0] && rest[0] <= '9' {
+			switch {
+			case mode == "stdin" && stdinNames[file]:
+				line = name + ":" + rest
+			case file != "" && !strings.ContainsAny(file, " \t") && !filepath.IsAbs(file):
+				line = filepath.Join(filepath.Dir(name), file) + ":" + rest
+			}
 		}
+		b.WriteString(line)
+		b.WriteString("\n")
 	}
-	endByte := i
-	return text[startByte:endByte]
+	return b.String()
 }
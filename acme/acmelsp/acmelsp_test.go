@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/acme/acmetest"
+	"plramos.win/9fans/plumb"
+)
+
+func TestText(t *testing.T) {
+	// 😀 is one rune but two UTF-16 code units.
+	tx := newText([]byte("a😀b\nc"), true)
+	for _, tt := range []struct {
+		q int
+		p position
+	}{
+		{0, position{0, 0}},
+		{2, position{0, 3}},
+		{3, position{0, 4}},
+		{4, position{1, 0}},
+		{5, position{1, 1}},
+	} {
+		if p := tx.position(tt.q); p != tt.p {
+			t.Errorf("position(%d) = %v, want %v", tt.q, p, tt.p)
+		}
+		if q := tx.offset(tt.p); q != tt.q {
+			t.Errorf("offset(%v) = %d, want %d", tt.p, q, tt.q)
+		}
+	}
+	if line, col := tx.column(position{0, 3}); line != 1 || col != 3 {
+		t.Errorf("column = %d:%d, want 1:3", line, col)
+	}
+	got := tx.apply([]textEdit{
+		{lspRange{position{0, 1}, position{0, 3}}, "-"},
+		{lspRange{position{1, 1}, position{1, 1}}, "d"},
+		{lspRange{position{1, 1}, position{1, 1}}, "e"},
+	})
+	if string(got) != "a-b\ncde" {
+		t.Errorf("apply = %q", got)
+	}
+}
+
+const testBody = "package x\n\nfunc  f() {}\n\nfunc g() { f() }\n"
+
+// A fakeServer is a language server that knows
+// just enough about testBody to answer requests.
+type fakeServer struct {
+	conn *rpcConn
+
+	mu      sync.Mutex
+	text    map[string]string
+	methods []string
+	pos     position // position of the last request
+}
+
+func (s *fakeServer) handle(method string, params json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods = append(s.methods, method)
+	var p struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		Position       position `json:"position"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	json.Unmarshal(params, &p)
+	uri := p.TextDocument.URI
+	at := func(line, char int) lspRange {
+		return lspRange{position{line, char}, position{line, char + 1}}
+	}
+	switch method {
+	case "initialize":
+		return map[string]interface{}{"capabilities": map[string]interface{}{"textDocumentSync": 1}}, nil
+	case "textDocument/didOpen":
+		s.text[uri] = p.TextDocument.Text
+		go s.conn.Notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         uri,
+			Diagnostics: []diagnostic{{Range: at(4, 11), Severity: 1, Source: "fake", Message: "bad f"}},
+		})
+	case "textDocument/didChange":
+		s.text[uri] = p.ContentChanges[0].Text
+	case "textDocument/definition":
+		s.pos = p.Position
+		return location{uri, at(2, 6)}, nil
+	case "textDocument/references":
+		return []location{{uri, at(2, 6)}, {uri, at(4, 11)}}, nil
+	case "textDocument/hover":
+		return map[string]interface{}{"contents": map[string]string{"kind": "plaintext", "value": "func f()"}}, nil
+	case "textDocument/formatting":
+		return []textEdit{{lspRange{position{2, 4}, position{2, 6}}, " "}}, nil
+	case "textDocument/rename":
+		other := fileURI(filepath.Join(filepath.Dir(uriFile(uri)), "other.go"))
+		return workspaceEdit{Changes: map[string][]textEdit{
+			uri:   {{at(2, 5), "h"}, {at(4, 11), "h"}},
+			other: {{at(2, 8), "h"}},
+		}}, nil
+	case "shutdown":
+		return nil, nil
+	}
+	return nil, nil
+}
+
+func (s *fakeServer) called(method string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBridge(t *testing.T) {
+	a := acmetest.New()
+	fsys, err := a.Mount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.SetFsys(fsys)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module x\n"), 0666)
+	other := filepath.Join(dir, "other.go")
+	os.WriteFile(other, []byte("package x\n\nvar _ = f\n"), 0666)
+
+	s := &fakeServer{text: make(map[string]string)}
+	var plumbed []*plumb.Message
+	var mu sync.Mutex
+	b := newBridge(defaultServers)
+	b.delay = 10 * time.Millisecond
+	b.start = func(cfg *serverConfig, root string) (io.ReadWriteCloser, error) {
+		if cfg.command[0] != "gopls" || root != dir {
+			t.Errorf("start %v in %s, want gopls in %s", cfg.command, root, dir)
+		}
+		c1, c2 := net.Pipe()
+		s.conn = newConn(c2, s.handle)
+		return c1, nil
+	}
+	b.plumb = func(m *plumb.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		plumbed = append(plumbed, m)
+		return nil
+	}
+	r, err := acme.Log()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go b.run(r)
+
+	file := filepath.Join(dir, "x.go")
+	fw := a.NewWindow(file, testBody)
+	// Poll, since some conditions depend on the fake server's state.
+	wait := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	wait("attach", func() bool { return strings.Contains(fw.Tag(), "Def Refs Hover Rename Fmt Diag") })
+	uri := fileURI(file)
+	s.mu.Lock()
+	if s.text[uri] != testBody {
+		t.Errorf("server text after open = %q", s.text[uri])
+	}
+	s.mu.Unlock()
+
+	lsp := func() string {
+		for _, w := range a.Windows() {
+			if w.Name() == filepath.Join(dir, "+lsp") {
+				return w.Body()
+			}
+		}
+		return ""
+	}
+
+	// Def plumbs the definition, asking about the start of dot.
+	fw.Select(36, 37)
+	fw.Exec("Def")
+	wait("plumb", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(plumbed) == 1
+	})
+	if m := plumbed[0]; string(m.Data) != file || m.Dst != "edit" || m.LookupAttr("addr") != "2+#6" {
+		t.Errorf("plumbed %s %s addr=%s", m.Dst, m.Data, m.LookupAttr("addr"))
+	}
+	if s.pos != (position{4, 11}) {
+		t.Errorf("definition asked at %v, want 4:11", s.pos)
+	}
+
+	fw.Exec("Refs")
+	want := "x.go:3:7\tfunc  f() {}\nx.go:5:12\tfunc g() { f() }\n"
+	wait("Refs", func() bool { return lsp() == want })
+
+	fw.Exec("Hover")
+	wait("Hover", func() bool { return lsp() == "func f()\n" })
+
+	fw.Exec("Diag")
+	wait("Diag", func() bool { return lsp() == "x.go:5:12: error: bad f (fake)\n" })
+
+	fw.Exec("Fmt")
+	formatted := strings.Replace(testBody, "func  f", "func f", 1)
+	wait("Fmt", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return fw.Body() == formatted && s.text[uri] == formatted
+	})
+
+	fw.Exec("Rename h")
+	renamed := strings.ReplaceAll(formatted, "f()", "h()")
+	wait("Rename", func() bool { return fw.Body() == renamed })
+	wait("Rename output", func() bool { return lsp() == "other.go:1:1\nx.go:1:1\n" })
+	if data, _ := os.ReadFile(other); string(data) != "package x\n\nvar _ = h\n" {
+		t.Errorf("other.go = %q", data)
+	}
+	if fw.Errors() != "" {
+		t.Errorf("errors: %s", fw.Errors())
+	}
+
+	// Typing reaches the server after a pause.
+	fw.Type(0, "// x\n")
+	wait("didChange", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.text[uri] == "// x\n"+renamed
+	})
+
+	// Unhandled commands go back to acme.
+	fw.Exec("Other")
+	wait("returned event", func() bool { return len(fw.Returned()) == 1 })
+
+	fw.Delete()
+	wait("shutdown", func() bool { return s.called("textDocument/didClose") && s.called("exit") })
+}
@@ -0,0 +1,155 @@
+// Acmelsp connects acme windows to language servers.
+//
+// Usage:
+//
+//	acmelsp [-s suffixes=command]...
+//
+// Acmelsp watches acme for windows holding source files that
+// a language server handles, starting one server per workspace:
+// the nearest directory above the file that holds a marker such
+// as go.mod or .git. It keeps the server's copy of each document
+// in step with the window body, and adds these commands to the
+// window's tag:
+//
+//	Def     plumb the definition of the identifier at dot
+//	Refs    list the references to the identifier at dot
+//	Hover   show the documentation for the identifier at dot
+//	Rename  rename the identifier at dot to the argument,
+//	        given after the command or as a chorded argument
+//	Fmt     format the body, as a single undoable change
+//	Diag    list the server's diagnostics for the file
+//
+// Lists are written to a window named +lsp in the workspace
+// root, one file:line:col address per line, ready to be
+// opened with the right button or sent to the plumber.
+//
+// The -s option adds a server for files with the given
+// comma-separated suffixes, overriding the defaults:
+//
+//	.go                    gopls
+//	.rs                    rust-analyzer
+//	.py                    pylsp
+//	.c .h .cc .cpp .hpp    clangd
+//	.ts .tsx .js .jsx      typescript-language-server --stdio
+//
+// For example:
+//
+//	acmelsp -s '.zig=zls'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plumb"
+)
+
+var servers serverFlag
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: acmelsp [-s suffixes=command]...\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("acmelsp: ")
+	flag.Var(&servers, "s", "use `suffixes=command` as the language server for files with those suffixes")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 0 {
+		usage()
+	}
+
+	b := newBridge(append(servers, defaultServers...))
+	r, err := acme.Log()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(b.run(r))
+}
+
+// A bridge connects acme windows to language servers.
+type bridge struct {
+	configs []*serverConfig
+	start   func(cfg *serverConfig, root string) (io.ReadWriteCloser, error)
+	plumb   func(m *plumb.Message) error
+	delay   time.Duration // pause in typing before sending changes
+	timeout time.Duration // for requests to servers
+
+	cmdMu sync.Mutex // serializes commands, which may lock several windows
+
+	mu         sync.Mutex
+	workspaces map[string]*workspace // by root and command
+	windows    map[int]*window       // by acme window id
+}
+
+func newBridge(configs []*serverConfig) *bridge {
+	return &bridge{
+		configs:    configs,
+		start:      startServer,
+		plumb:      sendPlumb,
+		delay:      200 * time.Millisecond,
+		timeout:    30 * time.Second,
+		workspaces: make(map[string]*workspace),
+		windows:    make(map[int]*window),
+	}
+}
+
+// run attaches to the existing windows and then
+// follows the acme log until it fails.
+func (b *bridge) run(r *acme.LogReader) error {
+	wins, err := acme.Windows()
+	if err != nil {
+		return err
+	}
+	for _, info := range wins {
+		b.attach(info.ID, info.Name)
+	}
+	for {
+		ev, err := r.Read()
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		w := b.windows[ev.ID]
+		b.mu.Unlock()
+		switch ev.Op {
+		case "new", "focus":
+			if w == nil {
+				b.attach(ev.ID, ev.Name)
+			}
+		case "get":
+			if w != nil {
+				w.reload()
+			} else {
+				b.attach(ev.ID, ev.Name)
+			}
+		case "put":
+			if w != nil {
+				w.saved()
+			} else {
+				b.attach(ev.ID, ev.Name)
+			}
+		case "del":
+			b.detach(ev.ID)
+		}
+	}
+}
+
+func sendPlumb(m *plumb.Message) error {
+	fid, err := plumb.Open("send", plan9.OWRITE)
+	if err != nil {
+		return err
+	}
+	defer fid.Close()
+	return m.Send(fid)
+}
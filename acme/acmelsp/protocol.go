@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// The subset of the Language Server Protocol that acmelsp uses.
+// See https://microsoft.github.io/language-server-protocol/.
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+// A locationLink is the richer form of location
+// that servers may return for definitions.
+type locationLink struct {
+	TargetURI            string   `json:"targetUri"`
+	TargetSelectionRange lspRange `json:"targetSelectionRange"`
+}
+
+type textEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type versionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+}
+
+type textDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type textDocumentEdit struct {
+	TextDocument versionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []textEdit                      `json:"edits"`
+}
+
+type workspaceEdit struct {
+	Changes         map[string][]textEdit `json:"changes,omitempty"`
+	DocumentChanges []textDocumentEdit    `json:"documentChanges,omitempty"`
+}
+
+// byURI returns the text edits of e grouped by document.
+// File operations in DocumentChanges are ignored.
+func (e *workspaceEdit) byURI() map[string][]textEdit {
+	m := make(map[string][]textEdit)
+	for uri, edits := range e.Changes {
+		m[uri] = append(m[uri], edits...)
+	}
+	for _, dc := range e.DocumentChanges {
+		if dc.TextDocument.URI != "" {
+			m[dc.TextDocument.URI] = append(m[dc.TextDocument.URI], dc.Edits...)
+		}
+	}
+	return m
+}
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity,omitempty"`
+	Source   string   `json:"source,omitempty"`
+	Message  string   `json:"message"`
+}
+
+var severities = []string{1: "error", 2: "warning", 3: "info", 4: "hint"}
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type serverCapabilities struct {
+	PositionEncoding string `json:"positionEncoding,omitempty"`
+}
+
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities"`
+}
+
+// clientCapabilities is what acmelsp tells servers it supports.
+var clientCapabilities = map[string]interface{}{
+	"general": map[string]interface{}{
+		"positionEncodings": []string{"utf-32", "utf-16"},
+	},
+	"textDocument": map[string]interface{}{
+		"synchronization":    map[string]interface{}{"didSave": true},
+		"hover":              map[string]interface{}{"contentFormat": []string{"plaintext"}},
+		"definition":         map[string]interface{}{"linkSupport": true},
+		"references":         map[string]interface{}{},
+		"rename":             map[string]interface{}{},
+		"formatting":         map[string]interface{}{},
+		"publishDiagnostics": map[string]interface{}{},
+	},
+	"workspace": map[string]interface{}{
+		"applyEdit":        true,
+		"workspaceEdit":    map[string]interface{}{"documentChanges": true},
+		"workspaceFolders": true,
+		"configuration":    true,
+	},
+}
+
+// decodeLocations decodes the result of a definition or
+// references request: null, a location, or a list of
+// locations or location links.
+func decodeLocations(raw json.RawMessage) ([]location, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] != '[' {
+		raw = append(append(json.RawMessage("["), raw...), ']')
+	}
+	var list []struct {
+		location
+		locationLink
+	}
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	var locs []location
+	for _, l := range list {
+		if l.URI == "" && l.TargetURI != "" {
+			l.location = location{URI: l.TargetURI, Range: l.TargetSelectionRange}
+		}
+		locs = append(locs, l.location)
+	}
+	return locs, nil
+}
+
+// decodeHover returns the text of a hover result, whose contents
+// may be markup, a marked string, or a list of marked strings.
+func decodeHover(raw json.RawMessage) string {
+	var h struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if json.Unmarshal(raw, &h) != nil || len(h.Contents) == 0 {
+		return ""
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(h.Contents, &list) != nil {
+		list = []json.RawMessage{h.Contents}
+	}
+	var parts []string
+	for _, c := range list {
+		var s string
+		if json.Unmarshal(c, &s) == nil {
+			parts = append(parts, s)
+			continue
+		}
+		var v struct {
+			Value string `json:"value"`
+		}
+		if json.Unmarshal(c, &v) == nil {
+			parts = append(parts, v.Value)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// fileURI returns the file URI for the absolute path name.
+func fileURI(name string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(name)}
+	return u.String()
+}
+
+// uriFile returns the path named by a file URI.
+func uriFile(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// A message is a JSON-RPC 2.0 request, notification or response.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+// An rpcError is the error object of a JSON-RPC response.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// Error codes defined by JSON-RPC and LSP.
+const (
+	codeMethodNotFound   = -32601
+	codeInternalError    = -32603
+	codeRequestCancelled = -32800
+)
+
+var errClosed = errors.New("connection closed")
+
+// A handler handles a request or notification from the other side.
+// For notifications, the result is ignored.
+type handler func(method string, params json.RawMessage) (interface{}, error)
+
+// An rpcConn is a JSON-RPC 2.0 connection using the
+// Content-Length framing of the Language Server Protocol.
+// Both sides may send requests.
+type rpcConn struct {
+	rw      io.ReadWriteCloser
+	handler handler
+
+	wmu sync.Mutex // serializes writes
+
+	mu      sync.Mutex
+	seq     int64
+	pending map[int64]chan *message
+	err     error // set once the read loop stops
+
+	done chan struct{}
+}
+
+// newConn returns a connection on rw that passes incoming
+// requests and notifications to h. Notifications are handled
+// in order; each request is handled in its own goroutine.
+func newConn(rw io.ReadWriteCloser, h handler) *rpcConn {
+	c := &rpcConn{
+		rw:      rw,
+		handler: h,
+		pending: make(map[int64]chan *message),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Close closes the connection and fails any outstanding calls.
+func (c *rpcConn) Close() error {
+	err := c.rw.Close()
+	<-c.done
+	return err
+}
+
+// Call sends a request and waits for its response,
+// which it decodes into result unless result is nil.
+// If ctx is cancelled first, Call tells the other side
+// with $/cancelRequest and returns ctx.Err().
+func (c *rpcConn) Call(ctx context.Context, method string, params, result interface{}) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.seq++
+	id := c.seq
+	ch := make(chan *message, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	raw := json.RawMessage(strconv.FormatInt(id, 10))
+	if err := c.send(&message{ID: &raw, Method: method}, params); err != nil {
+		c.forget(id)
+		return err
+	}
+	select {
+	case m := <-ch:
+		if m == nil {
+			return c.err
+		}
+		if m.Error != nil {
+			return fmt.Errorf("%s: %w", method, m.Error)
+		}
+		if result == nil || len(m.Result) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(m.Result, result); err != nil {
+			return fmt.Errorf("%s: decoding result: %v", method, err)
+		}
+		return nil
+	case <-ctx.Done():
+		c.forget(id)
+		c.Notify("$/cancelRequest", map[string]int64{"id": id})
+		return ctx.Err()
+	}
+}
+
+// Notify sends a notification.
+func (c *rpcConn) Notify(method string, params interface{}) error {
+	return c.send(&message{Method: method}, params)
+}
+
+func (c *rpcConn) forget(id int64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *rpcConn) send(m *message, params interface{}) error {
+	m.JSONRPC = "2.0"
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		m.Params = p
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := fmt.Fprintf(c.rw, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *rpcConn) reply(id *json.RawMessage, result interface{}, err error) {
+	m := &message{ID: id}
+	if err != nil {
+		e, ok := err.(*rpcError)
+		if !ok {
+			e = &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+		m.Error = e
+	} else {
+		r, err := json.Marshal(result)
+		if err != nil {
+			m.Error = &rpcError{Code: codeInternalError, Message: err.Error()}
+		} else {
+			m.Result = r
+		}
+	}
+	c.send(m, nil)
+}
+
+func (c *rpcConn) readLoop() {
+	defer close(c.done)
+	r := textproto.NewReader(bufio.NewReader(c.rw))
+	var err error
+	for {
+		var m *message
+		m, err = readMessage(r)
+		if err != nil {
+			break
+		}
+		switch {
+		case m.Method == "" && m.ID != nil:
+			id, err := strconv.ParseInt(string(*m.ID), 10, 64)
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			ch := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- m
+			}
+		case m.ID != nil:
+			go func() {
+				result, err := c.handler(m.Method, m.Params)
+				c.reply(m.ID, result, err)
+			}()
+		default:
+			c.handler(m.Method, m.Params)
+		}
+	}
+
+	if err == io.EOF {
+		err = errClosed
+	}
+	c.mu.Lock()
+	c.err = err
+	for id, ch := range c.pending {
+		delete(c.pending, id)
+		close(ch)
+	}
+	c.mu.Unlock()
+}
+
+func readMessage(r *textproto.Reader) (*message, error) {
+	h, err := r.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || errors.Is(err, io.ErrClosedPipe) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad Content-Length %q", h.Get("Content-Length"))
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.R, data); err != nil {
+		return nil, err
+	}
+	m := new(message)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("bad message: %v", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"plramos.win/9fans/acme"
+)
+
+// A text is the content of a document as last sent to the server.
+// It converts between acme's rune offsets and LSP positions,
+// whose characters count UTF-16 code units unless the server
+// agreed to count runes.
+type text struct {
+	r     []rune
+	lines []int // rune offset of the start of each line
+	utf16 bool
+}
+
+func newText(data []byte, utf16 bool) *text {
+	t := &text{r: []rune(string(data)), lines: []int{0}, utf16: utf16}
+	for i, c := range t.r {
+		if c == '\n' {
+			t.lines = append(t.lines, i+1)
+		}
+	}
+	return t
+}
+
+func (t *text) String() string {
+	return string(t.r)
+}
+
+// position returns the LSP position of rune offset q.
+func (t *text) position(q int) position {
+	q = max(0, min(q, len(t.r)))
+	line := sort.Search(len(t.lines), func(i int) bool { return t.lines[i] > q }) - 1
+	char := q - t.lines[line]
+	if t.utf16 {
+		char = 0
+		for _, c := range t.r[t.lines[line]:q] {
+			char += utf16Len(c)
+		}
+	}
+	return position{Line: line, Character: char}
+}
+
+// offset returns the rune offset of p, clamped to its line.
+func (t *text) offset(p position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(t.lines) {
+		return len(t.r)
+	}
+	q := t.lines[p.Line]
+	for n := 0; q < len(t.r) && t.r[q] != '\n' && n < p.Character; q++ {
+		if t.utf16 {
+			n += utf16Len(t.r[q])
+		} else {
+			n++
+		}
+	}
+	return q
+}
+
+// column returns the 1-based line and rune column of p,
+// as used in file:line:col addresses.
+func (t *text) column(p position) (line, col int) {
+	q := t.offset(p)
+	line = sort.Search(len(t.lines), func(i int) bool { return t.lines[i] > q }) - 1
+	return line + 1, q - t.lines[line] + 1
+}
+
+// edits converts LSP text edits to acme edits.
+func (t *text) edits(tes []textEdit) []acme.Edit {
+	var edits []acme.Edit
+	for _, e := range tes {
+		edits = append(edits, acme.Edit{
+			Q0:   t.offset(e.Range.Start),
+			Q1:   t.offset(e.Range.End),
+			Text: []byte(e.NewText),
+		})
+	}
+	return edits
+}
+
+// apply returns the result of applying tes to t.
+func (t *text) apply(tes []textEdit) []byte {
+	edits := t.edits(tes)
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Q0 < edits[j].Q0 })
+	var out []byte
+	q := 0
+	for _, e := range edits {
+		out = append(out, string(t.r[q:max(q, e.Q0)])...)
+		out = append(out, e.Text...)
+		q = max(q, e.Q1)
+	}
+	return append(out, string(t.r[q:])...)
+}
+
+func utf16Len(c rune) int {
+	if c >= 0x10000 && c <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
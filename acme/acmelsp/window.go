@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/plumb"
+)
+
+// commands are the tag commands acmelsp handles.
+var commands = []string{"Def", "Refs", "Hover", "Rename", "Fmt", "Diag"}
+
+// A window is an acme window whose file is open in a language server.
+type window struct {
+	b    *bridge
+	ws   *workspace
+	id   int
+	file string
+	uri  string
+	win  *acme.Win
+
+	cur atomic.Pointer[text] // the text the server has
+
+	mu      sync.Mutex // guards win, except its event file, and the fields below
+	version int
+	dirty   bool
+	closed  bool
+	timer   *time.Timer
+}
+
+// attach starts managing the window with the given id
+// if a language server handles its file.
+func (b *bridge) attach(id int, name string) {
+	if name == "" || !filepath.IsAbs(name) || strings.HasSuffix(name, "/") || strings.Contains(name, "/+") {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.windows[id] != nil {
+		return
+	}
+	ws, err := b.workspaceLocked(name)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if ws == nil {
+		return
+	}
+	win, err := acme.Open(id, nil)
+	if err != nil {
+		return
+	}
+	w := &window{b: b, ws: ws, id: id, file: name, uri: fileURI(name), win: win}
+	body, err := win.ReadAll("body")
+	if err == nil {
+		err = win.OpenEvent()
+	}
+	if err == nil {
+		w.version = 1
+		w.cur.Store(newText(body, ws.utf16))
+		err = ws.conn.Notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": textDocumentItem{URI: w.uri, LanguageID: languageID(name), Version: w.version, Text: string(body)},
+		})
+	}
+	if err != nil {
+		log.Printf("%s: %v", name, err)
+		win.CloseFiles()
+		if ws.nwin == 0 {
+			// Don't leave an unused server running.
+			ws.nwin++
+			ws.releaseLocked()
+		}
+		return
+	}
+	ws.nwin++
+	b.windows[id] = w
+	if tag, err := win.ReadAll("tag"); err == nil && !strings.Contains(string(tag), " "+commands[0]+" ") {
+		win.Fprintf("tag", " %s ", strings.Join(commands, " "))
+	}
+	go w.loop()
+}
+
+// detach stops managing the window with the given id.
+func (b *bridge) detach(id int) {
+	b.mu.Lock()
+	w := b.windows[id]
+	delete(b.windows, id)
+	b.mu.Unlock()
+	if w == nil {
+		return
+	}
+
+	// A window's lock comes before b.mu.
+	w.mu.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	w.ws.conn.Notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": textDocumentIdentifier{w.uri},
+	})
+	b.mu.Lock()
+	w.ws.releaseLocked()
+	b.mu.Unlock()
+}
+
+// window returns the managed window showing the document uri.
+func (b *bridge) window(uri string) *window {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, w := range b.windows {
+		if w.uri == uri {
+			return w
+		}
+	}
+	return nil
+}
+
+// textOf returns the text of the document uri, from its window
+// if it has one or else from disk. It returns nil if the
+// document cannot be read.
+func (b *bridge) textOf(uri string, utf16 bool) *text {
+	if w := b.window(uri); w != nil {
+		return w.cur.Load()
+	}
+	data, err := os.ReadFile(uriFile(uri))
+	if err != nil {
+		return nil
+	}
+	return newText(data, utf16)
+}
+
+// loop handles the window's events until it is deleted.
+func (w *window) loop() {
+	for e := range w.win.EventChan() {
+		switch e.C2 {
+		case 'I', 'D':
+			w.changed()
+		case 'x', 'X':
+			cmd := strings.TrimSpace(string(e.Text))
+			if e.Flag&8 != 0 {
+				cmd += " " + strings.TrimSpace(string(e.Arg))
+			}
+			if !w.execute(cmd) {
+				w.win.WriteEvent(e)
+			}
+		case 'l', 'L':
+			w.win.WriteEvent(e)
+		}
+	}
+	w.b.detach(w.id)
+}
+
+// changed notes that the body has changed,
+// telling the server after a pause in typing.
+func (w *window) changed() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirty = true
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.b.delay, w.sync)
+	} else {
+		w.timer.Reset(w.b.delay)
+	}
+}
+
+func (w *window) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.syncLocked(); err != nil {
+		log.Printf("%s: %v", w.file, err)
+	}
+}
+
+// syncLocked sends the body to the server if it has changed.
+func (w *window) syncLocked() error {
+	if !w.dirty || w.closed {
+		return nil
+	}
+	body, err := w.win.ReadAll("body")
+	if err != nil {
+		return err
+	}
+	w.dirty = false
+	w.version++
+	w.cur.Store(newText(body, w.ws.utf16))
+	return w.ws.conn.Notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   versionedTextDocumentIdentifier{w.uri, w.version},
+		"contentChanges": []map[string]string{{"text": string(body)}},
+	})
+}
+
+// saved tells the server that the file has been written.
+func (w *window) saved() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirty = true
+	if err := w.syncLocked(); err != nil {
+		log.Printf("%s: %v", w.file, err)
+		return
+	}
+	w.ws.conn.Notify("textDocument/didSave", map[string]interface{}{
+		"textDocument": textDocumentIdentifier{w.uri},
+	})
+}
+
+// reload notes that the body was reread from disk.
+func (w *window) reload() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirty = true
+	if err := w.syncLocked(); err != nil {
+		log.Printf("%s: %v", w.file, err)
+	}
+}
+
+// execute runs cmd if it is one of the acmelsp commands.
+func (w *window) execute(cmd string) bool {
+	verb, arg, _ := strings.Cut(cmd, " ")
+	arg = strings.TrimSpace(arg)
+	var f func(ctx context.Context, arg string) error
+	switch verb {
+	default:
+		return false
+	case "Def":
+		f = w.def
+	case "Refs":
+		f = w.refs
+	case "Hover":
+		f = w.hover
+	case "Rename":
+		f = w.rename
+	case "Fmt":
+		f = w.format
+	case "Diag":
+		f = w.diag
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), w.b.timeout)
+	defer cancel()
+	w.b.cmdMu.Lock()
+	defer w.b.cmdMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.syncLocked()
+	if err == nil {
+		err = f(ctx, arg)
+	}
+	if err != nil {
+		w.win.Errf("%s: %v", verb, err)
+	}
+	return true
+}
+
+// dotParams returns the parameters for a request
+// about the start of the window's dot.
+func (w *window) dotParams() (textDocumentPositionParams, error) {
+	if err := w.win.SetAddrToDot(); err != nil {
+		return textDocumentPositionParams{}, err
+	}
+	q0, _, err := w.win.ReadAddr()
+	if err != nil {
+		return textDocumentPositionParams{}, err
+	}
+	return textDocumentPositionParams{
+		TextDocument: textDocumentIdentifier{w.uri},
+		Position:     w.cur.Load().position(q0),
+	}, nil
+}
+
+func (w *window) def(ctx context.Context, arg string) error {
+	p, err := w.dotParams()
+	if err != nil {
+		return err
+	}
+	var raw json.RawMessage
+	if err := w.ws.conn.Call(ctx, "textDocument/definition", p, &raw); err != nil {
+		return err
+	}
+	locs, err := decodeLocations(raw)
+	if err != nil {
+		return err
+	}
+	switch len(locs) {
+	case 0:
+		return errors.New("no definition found")
+	case 1:
+		file := uriFile(locs[0].URI)
+		line, col := locs[0].Range.Start.Line+1, locs[0].Range.Start.Character+1
+		if t := w.b.textOf(locs[0].URI, w.ws.utf16); t != nil {
+			line, col = t.column(locs[0].Range.Start)
+		}
+		m := &plumb.Message{
+			Src:  "acmelsp",
+			Dst:  "edit",
+			Dir:  w.ws.root,
+			Type: "text",
+			Attr: &plumb.Attribute{Name: "addr", Value: fmt.Sprintf("%d+#%d", line-1, col-1)},
+			Data: []byte(file),
+		}
+		if w.b.plumb(m) == nil {
+			return nil
+		}
+	}
+	return w.ws.show(w.listLocations(locs))
+}
+
+func (w *window) refs(ctx context.Context, arg string) error {
+	p, err := w.dotParams()
+	if err != nil {
+		return err
+	}
+	params := struct {
+		textDocumentPositionParams
+		Context struct {
+			IncludeDeclaration bool `json:"includeDeclaration"`
+		} `json:"context"`
+	}{textDocumentPositionParams: p}
+	params.Context.IncludeDeclaration = true
+	var raw json.RawMessage
+	if err := w.ws.conn.Call(ctx, "textDocument/references", params, &raw); err != nil {
+		return err
+	}
+	locs, err := decodeLocations(raw)
+	if err != nil {
+		return err
+	}
+	if len(locs) == 0 {
+		return errors.New("no references found")
+	}
+	return w.ws.show(w.listLocations(locs))
+}
+
+// listLocations formats locs one per line as file:line:col
+// followed by the text of the line.
+func (w *window) listLocations(locs []location) string {
+	var buf strings.Builder
+	for _, loc := range locs {
+		buf.WriteString(w.ws.address(loc))
+		if t := w.b.textOf(loc.URI, w.ws.utf16); t != nil && loc.Range.Start.Line < len(t.lines) {
+			q0 := t.lines[loc.Range.Start.Line]
+			q1 := len(t.r)
+			if loc.Range.Start.Line+1 < len(t.lines) {
+				q1 = t.lines[loc.Range.Start.Line+1] - 1
+			}
+			fmt.Fprintf(&buf, "\t%s", strings.TrimSpace(string(t.r[q0:q1])))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func (w *window) hover(ctx context.Context, arg string) error {
+	p, err := w.dotParams()
+	if err != nil {
+		return err
+	}
+	var raw json.RawMessage
+	if err := w.ws.conn.Call(ctx, "textDocument/hover", p, &raw); err != nil {
+		return err
+	}
+	text := decodeHover(raw)
+	if text == "" {
+		return errors.New("no information")
+	}
+	return w.ws.show(text)
+}
+
+func (w *window) rename(ctx context.Context, arg string) error {
+	if arg == "" {
+		return errors.New("usage: Rename newname")
+	}
+	p, err := w.dotParams()
+	if err != nil {
+		return err
+	}
+	params := struct {
+		textDocumentPositionParams
+		NewName string `json:"newName"`
+	}{p, arg}
+	var edit workspaceEdit
+	if err := w.ws.conn.Call(ctx, "textDocument/rename", params, &edit); err != nil {
+		return err
+	}
+	files, err := w.ws.applyEdit(&edit, w)
+	if err != nil {
+		return err
+	}
+	var buf strings.Builder
+	for _, file := range files {
+		fmt.Fprintf(&buf, "%s\n", w.ws.address(location{URI: fileURI(file)}))
+	}
+	return w.ws.show(buf.String())
+}
+
+func (w *window) format(ctx context.Context, arg string) error {
+	params := map[string]interface{}{
+		"textDocument": textDocumentIdentifier{w.uri},
+		"options":      map[string]interface{}{"tabSize": 8, "insertSpaces": false},
+	}
+	var edits []textEdit
+	if err := w.ws.conn.Call(ctx, "textDocument/formatting", params, &edits); err != nil {
+		return err
+	}
+	return w.applyLocked(edits)
+}
+
+func (w *window) diag(ctx context.Context, arg string) error {
+	var buf strings.Builder
+	for _, d := range w.ws.diagnostics(w.uri) {
+		fmt.Fprintf(&buf, "%s: ", w.ws.address(location{w.uri, d.Range}))
+		if d.Severity > 0 && d.Severity < len(severities) {
+			fmt.Fprintf(&buf, "%s: ", severities[d.Severity])
+		}
+		buf.WriteString(strings.ReplaceAll(d.Message, "\n", " "))
+		if d.Source != "" {
+			fmt.Fprintf(&buf, " (%s)", d.Source)
+		}
+		buf.WriteString("\n")
+	}
+	if buf.Len() == 0 {
+		buf.WriteString("no diagnostics\n")
+	}
+	return w.ws.show(buf.String())
+}
+
+// applyLocked applies LSP text edits to the body
+// and tells the server the result.
+func (w *window) applyLocked(edits []textEdit) error {
+	if len(edits) == 0 {
+		return nil
+	}
+	if err := w.win.ApplyEdits(w.cur.Load().edits(edits)); err != nil {
+		return err
+	}
+	w.dirty = true
+	return w.syncLocked()
+}
+
+// applyEdit applies a workspace edit: to the window body for
+// documents open in acme, otherwise to the file on disk.
+// The caller may hold locked's lock. It returns the files changed.
+func (ws *workspace) applyEdit(edit *workspaceEdit, locked *window) ([]string, error) {
+	var files []string
+	for uri, edits := range edit.byURI() {
+		file := uriFile(uri)
+		if w := ws.b.window(uri); w != nil {
+			if w != locked {
+				w.mu.Lock()
+			}
+			err := w.applyLocked(edits)
+			if w != locked {
+				w.mu.Unlock()
+			}
+			if err != nil {
+				return files, fmt.Errorf("%s: %v", file, err)
+			}
+		} else {
+			if err := applyFile(file, edits, ws.utf16); err != nil {
+				return files, err
+			}
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// applyFile applies edits to the named file on disk.
+func applyFile(file string, edits []textEdit, utf16 bool) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, newText(data, utf16).apply(edits), fi.Mode()&os.ModePerm)
+}
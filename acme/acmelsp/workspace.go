@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"plramos.win/9fans/acme"
+)
+
+// A serverConfig says which language server handles which files.
+type serverConfig struct {
+	suffixes []string
+	command  []string
+	markers  []string // files whose directory is the workspace root
+}
+
+var defaultServers = []*serverConfig{
+	{[]string{".go"}, []string{"gopls"}, []string{"go.work", "go.mod", ".git"}},
+	{[]string{".rs"}, []string{"rust-analyzer"}, []string{"Cargo.toml", ".git"}},
+	{[]string{".py"}, []string{"pylsp"}, []string{"pyproject.toml", "setup.py", ".git"}},
+	{[]string{".c", ".h", ".cc", ".cpp", ".hpp"}, []string{"clangd"}, []string{"compile_commands.json", ".git"}},
+	{[]string{".ts", ".tsx", ".js", ".jsx"}, []string{"typescript-language-server", "--stdio"}, []string{"package.json", ".git"}},
+}
+
+// parseServer parses a -s flag value of the form
+// "suffix[,suffix...]=command [args...]".
+func parseServer(s string) (*serverConfig, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return nil, fmt.Errorf("bad server %q: want suffixes=command", s)
+	}
+	cfg := &serverConfig{
+		suffixes: strings.Split(s[:i], ","),
+		command:  strings.Fields(s[i+1:]),
+		markers:  []string{".git"},
+	}
+	if len(cfg.command) == 0 {
+		return nil, fmt.Errorf("bad server %q: no command", s)
+	}
+	for _, suffix := range cfg.suffixes {
+		if !strings.HasPrefix(suffix, ".") {
+			return nil, fmt.Errorf("bad server %q: suffix %q does not begin with a dot", s, suffix)
+		}
+	}
+	// Keep the root markers of a default server for the same files.
+	for _, d := range defaultServers {
+		if d.suffixes[0] == cfg.suffixes[0] {
+			cfg.markers = d.markers
+		}
+	}
+	return cfg, nil
+}
+
+// serverFlag is a flag.Value collecting -s options.
+type serverFlag []*serverConfig
+
+func (f *serverFlag) String() string {
+	var list []string
+	for _, cfg := range *f {
+		list = append(list, strings.Join(cfg.suffixes, ",")+"="+strings.Join(cfg.command, " "))
+	}
+	return strings.Join(list, " ")
+}
+
+func (f *serverFlag) Set(s string) error {
+	cfg, err := parseServer(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, cfg)
+	return nil
+}
+
+var languageIDs = map[string]string{
+	".c":   "c",
+	".cc":  "cpp",
+	".cpp": "cpp",
+	".go":  "go",
+	".h":   "c",
+	".hpp": "cpp",
+	".js":  "javascript",
+	".jsx": "javascriptreact",
+	".py":  "python",
+	".rs":  "rust",
+	".ts":  "typescript",
+	".tsx": "typescriptreact",
+}
+
+func languageID(name string) string {
+	ext := filepath.Ext(name)
+	if id, ok := languageIDs[ext]; ok {
+		return id
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+// findRoot returns the nearest directory above file
+// containing one of the markers, or else file's directory.
+func findRoot(file string, markers []string) string {
+	dir := filepath.Dir(file)
+	for d := dir; ; {
+		for _, m := range markers {
+			if _, err := os.Stat(filepath.Join(d, m)); err == nil {
+				return d
+			}
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// startServer runs the server's command in root,
+// talking to it over its standard input and output.
+func startServer(cfg *serverConfig, root string) (io.ReadWriteCloser, error) {
+	cmd := exec.Command(cfg.command[0], cfg.command[1:]...)
+	cmd.Dir = root
+	cmd.Stderr = os.Stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdConn{r, w, cmd}, nil
+}
+
+type cmdConn struct {
+	io.ReadCloser
+	w   io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (c *cmdConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+// Close closes the server's input and waits for it to exit,
+// killing it if it takes too long.
+func (c *cmdConn) Close() error {
+	c.w.Close()
+	t := time.AfterFunc(5*time.Second, func() { c.cmd.Process.Kill() })
+	defer t.Stop()
+	return c.cmd.Wait()
+}
+
+// A workspace is a running language server for one root directory.
+type workspace struct {
+	b     *bridge
+	cfg   *serverConfig
+	root  string
+	conn  *rpcConn
+	utf16 bool
+	nwin  int // windows using the workspace, guarded by b.mu
+
+	mu    sync.Mutex
+	diags map[string][]diagnostic // by URI
+}
+
+// workspaceLocked returns the workspace for file, starting its server
+// if needed. It returns nil if no server handles file.
+// b.mu must be held.
+func (b *bridge) workspaceLocked(file string) (*workspace, error) {
+	var cfg *serverConfig
+Search:
+	for _, c := range b.configs {
+		for _, suffix := range c.suffixes {
+			if strings.HasSuffix(file, suffix) {
+				cfg = c
+				break Search
+			}
+		}
+	}
+	if cfg == nil {
+		return nil, nil
+	}
+	root := findRoot(file, cfg.markers)
+	key := root + "\x00" + strings.Join(cfg.command, " ")
+	if ws := b.workspaces[key]; ws != nil {
+		return ws, nil
+	}
+
+	rw, err := b.start(cfg, root)
+	if err != nil {
+		return nil, fmt.Errorf("starting %s: %v", cfg.command[0], err)
+	}
+	ws := &workspace{b: b, cfg: cfg, root: root, diags: make(map[string][]diagnostic)}
+	ws.conn = newConn(rw, ws.handle)
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	var res initializeResult
+	err = ws.conn.Call(ctx, "initialize", map[string]interface{}{
+		"processId":        os.Getpid(),
+		"clientInfo":       map[string]string{"name": "acmelsp"},
+		"rootUri":          fileURI(root),
+		"workspaceFolders": []map[string]string{{"uri": fileURI(root), "name": filepath.Base(root)}},
+		"capabilities":     clientCapabilities,
+	}, &res)
+	if err == nil {
+		err = ws.conn.Notify("initialized", struct{}{})
+	}
+	if err != nil {
+		ws.conn.Close()
+		return nil, fmt.Errorf("initializing %s: %v", cfg.command[0], err)
+	}
+	ws.utf16 = res.Capabilities.PositionEncoding != "utf-32"
+	b.workspaces[key] = ws
+	return ws, nil
+}
+
+// releaseLocked drops a window's use of ws,
+// shutting the server down after the last one.
+// b.mu must be held.
+func (ws *workspace) releaseLocked() {
+	ws.nwin--
+	if ws.nwin > 0 {
+		return
+	}
+	for key, w := range ws.b.workspaces {
+		if w == ws {
+			delete(ws.b.workspaces, key)
+		}
+	}
+	go ws.shutdown()
+}
+
+func (ws *workspace) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), ws.b.timeout)
+	defer cancel()
+	ws.conn.Call(ctx, "shutdown", nil, nil)
+	ws.conn.Notify("exit", nil)
+	ws.conn.Close()
+}
+
+// handle handles requests and notifications from the server.
+func (ws *workspace) handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "textDocument/publishDiagnostics":
+		var p publishDiagnosticsParams
+		if err := json.Unmarshal(params, &p); err == nil {
+			ws.mu.Lock()
+			ws.diags[p.URI] = p.Diagnostics
+			ws.mu.Unlock()
+		}
+		return nil, nil
+	case "window/showMessage":
+		var p struct {
+			Type    int    `json:"type"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(params, &p); err == nil && p.Type <= 2 {
+			log.Printf("%s: %s", ws.cfg.command[0], p.Message)
+		}
+		return nil, nil
+	case "workspace/configuration":
+		var p struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(params, &p)
+		return make([]interface{}, len(p.Items)), nil
+	case "workspace/applyEdit":
+		var p struct {
+			Edit workspaceEdit `json:"edit"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		_, err := ws.applyEdit(&p.Edit, nil)
+		return map[string]interface{}{"applied": err == nil}, nil
+	case "window/workDoneProgress/create", "client/registerCapability", "client/unregisterCapability",
+		"window/showMessageRequest", "workspace/workspaceFolders":
+		return nil, nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not supported: " + method}
+}
+
+// diagnostics returns the diagnostics for the document uri.
+func (ws *workspace) diagnostics(uri string) []diagnostic {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	d := append([]diagnostic(nil), ws.diags[uri]...)
+	sort.SliceStable(d, func(i, j int) bool {
+		pi, pj := d[i].Range.Start, d[j].Range.Start
+		return pi.Line < pj.Line || pi.Line == pj.Line && pi.Character < pj.Character
+	})
+	return d
+}
+
+// address returns the file:line:col address of the location,
+// relative to the workspace root when possible.
+func (ws *workspace) address(loc location) string {
+	file := uriFile(loc.URI)
+	t := ws.b.textOf(loc.URI, ws.utf16)
+	var line, col int
+	if t != nil {
+		line, col = t.column(loc.Range.Start)
+	} else {
+		line, col = loc.Range.Start.Line+1, loc.Range.Start.Character+1
+	}
+	if rel, err := filepath.Rel(ws.root, file); err == nil && !strings.HasPrefix(rel, "..") {
+		file = rel
+	}
+	return fmt.Sprintf("%s:%d:%d", file, line, col)
+}
+
+// show replaces the contents of the workspace's +lsp window with text,
+// creating the window if needed.
+func (ws *workspace) show(text string) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	name := filepath.Join(ws.root, "+lsp")
+	w := acme.Show(name)
+	if w == nil {
+		var err error
+		if w, err = acme.New(); err != nil {
+			return err
+		}
+		if err := w.Name("%s", name); err != nil {
+			return err
+		}
+	}
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	w.Clear()
+	w.Write("body", []byte(text))
+	w.Clean()
+	w.Select(acme.CharAddr(0))
+	return w.Show()
+}
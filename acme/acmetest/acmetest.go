@@ -0,0 +1,823 @@
+// Package acmetest provides an in-memory acme file server
+// for testing programs that use package acme.
+//
+// An Acme serves the files described in acme(4): index, log, new,
+// and for each window a directory holding addr, body, ctl, data,
+// errors, event, tag and xdata. Tests play the user's part: they
+// create windows, inject events such as executing a command or
+// typing, and inspect the resulting window state.
+//
+//	a := acmetest.New()
+//	fsys, err := a.Mount()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	acme.SetFsys(fsys)
+//	w := a.NewWindow("/tmp/x.go", "package x\n")
+//	go runHelper()
+//	w.Exec("Fmt")
+//	a.Wait(time.Second, func() bool { return w.Body() == "package x\n" })
+//
+// The server keeps no display, so it ignores ctl messages that
+// only affect appearance, but it records every ctl message for
+// inspection. The get and put messages read and write the named
+// file on disk, as acme does.
+package acmetest // import "plramos.win/9fans/acme/acmetest"
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plan9/client"
+	"plramos.win/9fans/plan9/srv"
+)
+
+const (
+	owner     = "acme"
+	font      = "/lib/font/bit/lucsans/euro.8.font"
+	width     = 800
+	tabWidth  = 4
+	eventSize = 256 // longest event text acme sends
+)
+
+// Errors, spelled as acme spells them.
+var (
+	errDel         = srv.Error("deleted window")
+	errBadCtl      = srv.Error("ill-formed control message")
+	errBadAddr     = srv.Error("bad address syntax")
+	errAddrRange   = srv.Error("address out of range")
+	errBadEvent    = srv.Error("bad event syntax")
+	errDirty       = srv.Error("file dirty")
+	errInterrupted = srv.Error("interrupted")
+)
+
+var winFiles = []struct {
+	name string
+	perm plan9.Perm
+}{
+	{"addr", 0600},
+	{"body", plan9.DMAPPEND | 0600},
+	{"ctl", 0600},
+	{"data", 0600},
+	{"errors", 0200},
+	{"event", 0600},
+	{"tag", plan9.DMAPPEND | 0600},
+	{"xdata", 0600},
+}
+
+// An Acme is an in-memory acme file server.
+type Acme struct {
+	srv  *srv.Srv
+	tree *srv.Tree
+
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced on every change
+	wins    map[int]*Window
+	nextID  int
+	logs    map[*srv.Fid][]string // unread log entries of each open log file
+}
+
+// A file is the Aux of every plain file in the tree.
+type file struct {
+	w    *Window // nil for index, log and the files in new
+	name string
+}
+
+// New returns an Acme with no windows.
+func New() *Acme {
+	a := &Acme{
+		changed: make(chan struct{}),
+		wins:    make(map[int]*Window),
+		nextID:  1,
+		logs:    make(map[*srv.Fid][]string),
+	}
+	a.tree = srv.NewTree(owner, owner, 0500)
+	a.tree.Root.Create("index", owner, 0400, &file{name: "index"})
+	a.tree.Root.Create("log", owner, 0400, &file{name: "log"})
+	dir, _ := a.tree.Root.Create("new", owner, plan9.DMDIR|0500, nil)
+	for _, f := range winFiles {
+		dir.Create(f.name, owner, f.perm, &file{name: f.name})
+	}
+	a.srv = &srv.Srv{
+		Tree:       a.tree,
+		Open:       a.open,
+		Read:       a.read,
+		Write:      a.write,
+		Destroyfid: a.destroyfid,
+	}
+	return a
+}
+
+// Mount connects to a over an in-memory pipe and returns
+// the attached file system, ready for acme.SetFsys.
+func (a *Acme) Mount() (*client.Fsys, error) {
+	c1, c2 := net.Pipe()
+	go func() {
+		a.srv.ServeConn(c1)
+		c1.Close()
+	}()
+	conn, err := client.NewConn(c2)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := conn.Attach(nil, owner, "")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.Release()
+	return fsys, nil
+}
+
+// Serve serves a to connections accepted on l, such as a unix
+// socket named acme in a test's $NAMESPACE directory, so that
+// separate programs can be tested against it.
+func (a *Acme) Serve(l net.Listener) error {
+	return a.srv.Serve(l)
+}
+
+// Wait calls cond, with no locks held, until it returns true,
+// calling it again each time the state of a changes.
+// It reports whether cond returned true before timeout elapsed.
+func (a *Acme) Wait(timeout time.Duration, cond func() bool) bool {
+	deadline := time.After(timeout)
+	for {
+		a.mu.Lock()
+		ch := a.changed
+		a.mu.Unlock()
+		if cond() {
+			return true
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return cond()
+		}
+	}
+}
+
+// changedLocked wakes blocked readers and Wait.
+func (a *Acme) changedLocked() {
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// NewWindow creates a window named name holding body,
+// as if the user had opened it. The window is clean.
+func (a *Acme) NewWindow(name, body string) *Window {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w := a.newWindowLocked()
+	w.name = name
+	w.body = []rune(body)
+	w.isdir = strings.HasSuffix(name, "/")
+	a.logLocked(w, "new")
+	return w
+}
+
+// Window returns the window with the given id, or nil.
+func (a *Acme) Window(id int) *Window {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.wins[id]
+}
+
+// Windows returns the open windows in order of id.
+func (a *Acme) Windows() []*Window {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.windowsLocked()
+}
+
+func (a *Acme) windowsLocked() []*Window {
+	var ws []*Window
+	for _, w := range a.wins {
+		ws = append(ws, w)
+	}
+	sort.Slice(ws, func(i, j int) bool { return ws[i].id < ws[j].id })
+	return ws
+}
+
+func (a *Acme) newWindowLocked() *Window {
+	w := &Window{a: a, id: a.nextID}
+	a.nextID++
+	w.dir, _ = a.tree.Root.Create(strconv.Itoa(w.id), owner, plan9.DMDIR|0500, nil)
+	for _, f := range winFiles {
+		w.dir.Create(f.name, owner, f.perm, &file{w: w, name: f.name})
+	}
+	a.wins[w.id] = w
+	a.changedLocked()
+	return w
+}
+
+func (a *Acme) deleteLocked(w *Window) {
+	if w.deleted {
+		return
+	}
+	w.deleted = true
+	w.events = nil
+	for _, f := range w.dir.Children() {
+		f.Remove()
+	}
+	w.dir.Remove()
+	delete(a.wins, w.id)
+	a.logLocked(w, "del")
+}
+
+// logLocked reports op on w to the readers of the log file.
+func (a *Acme) logLocked(w *Window, op string) {
+	for fid, q := range a.logs {
+		a.logs[fid] = append(q, fmt.Sprintf("%d %s %s\n", w.id, op, w.name))
+	}
+	a.changedLocked()
+}
+
+func (a *Acme) indexLocked() string {
+	var b strings.Builder
+	for _, w := range a.windowsLocked() {
+		tag := w.tagLocked()
+		fmt.Fprintf(&b, "%11d %11d %11d %11d %11d %s\n",
+			w.id, utf8.RuneCountInString(tag), len(w.body), bool2int(w.isdir), bool2int(w.dirty), tag)
+	}
+	return b.String()
+}
+
+func (a *Acme) open(r *srv.Req) {
+	f, _ := r.Fid.File.Aux.(*file)
+	if f == nil {
+		r.Respond(nil)
+		return
+	}
+	a.mu.Lock()
+	var err error
+	switch {
+	case f.name == "log":
+		a.logs[r.Fid] = []string{}
+	case f.name == "index":
+	case f.w == nil:
+		// Opening a file in new creates a window.
+		w := a.newWindowLocked()
+		a.logLocked(w, "new")
+		nf := w.dir.Walk(f.name)
+		r.Fid.File = nf
+		r.Ofcall.Qid = nf.Stat().Qid
+		f = nf.Aux.(*file)
+	}
+	if w := f.w; w != nil {
+		if w.deleted {
+			err = errDel
+		} else if f.name == "event" {
+			w.nevent++
+		}
+	}
+	a.mu.Unlock()
+	r.Respond(err)
+}
+
+func (a *Acme) destroyfid(fid *srv.Fid) {
+	f, _ := fid.File.Aux.(*file)
+	if f == nil || !fid.IsOpen() {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.logs, fid)
+	if w := f.w; w != nil && f.name == "event" {
+		if w.nevent--; w.nevent == 0 {
+			w.events = nil
+		}
+	}
+}
+
+func (a *Acme) read(r *srv.Req) {
+	f := r.Fid.File.Aux.(*file)
+	for {
+		a.mu.Lock()
+		wait, err := a.readLocked(r, f)
+		ch := a.changed
+		a.mu.Unlock()
+		if !wait {
+			r.Respond(err)
+			return
+		}
+		select {
+		case <-ch:
+		case <-r.Context().Done():
+			r.Respond(errInterrupted)
+			return
+		}
+	}
+}
+
+// readLocked fills in the reply to r, a read of f.
+// It returns wait=true if the read must block.
+func (a *Acme) readLocked(r *srv.Req, f *file) (wait bool, err error) {
+	switch f.name {
+	case "index":
+		r.ReadString(a.indexLocked())
+		return false, nil
+	case "log":
+		q := a.logs[r.Fid]
+		if len(q) == 0 {
+			return true, nil
+		}
+		r.Ofcall.Data = []byte(q[0])
+		a.logs[r.Fid] = q[1:]
+		return false, nil
+	}
+	w := f.w
+	if w.deleted {
+		return false, errDel
+	}
+	switch f.name {
+	case "addr":
+		r.ReadString(fmt.Sprintf("%11d %11d ", w.addr[0], w.addr[1]))
+	case "body":
+		r.ReadString(string(w.body))
+	case "tag":
+		r.ReadString(w.tagLocked())
+	case "ctl":
+		r.ReadString(w.ctlLocked())
+	case "data", "xdata":
+		end := len(w.body)
+		if f.name == "xdata" {
+			end = w.addr[1]
+		}
+		var b []byte
+		q := w.addr[0]
+		for ; q < end && len(b)+utf8.RuneLen(w.body[q]) <= int(r.Ifcall.Count); q++ {
+			b = utf8.AppendRune(b, w.body[q])
+		}
+		w.addr[0] = q
+		r.Ofcall.Data = b
+	case "event":
+		if len(w.events) == 0 {
+			return true, nil
+		}
+		var b []byte
+		for len(w.events) > 0 && (len(b) == 0 || len(b)+len(w.events[0]) <= int(r.Ifcall.Count)) {
+			b = append(b, w.events[0]...)
+			w.events = w.events[1:]
+		}
+		r.Ofcall.Data = b
+	default:
+		return false, srv.Eperm
+	}
+	return false, nil
+}
+
+func (a *Acme) write(r *srv.Req) {
+	f := r.Fid.File.Aux.(*file)
+	a.mu.Lock()
+	var err error
+	if f.w == nil || f.w.deleted {
+		err = errDel
+	} else {
+		err = f.w.writeLocked(f.name, string(r.Ifcall.Data))
+	}
+	a.changedLocked()
+	a.mu.Unlock()
+	if err == nil {
+		r.Ofcall.Count = uint32(len(r.Ifcall.Data))
+	}
+	r.Respond(err)
+}
+
+func bool2int(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// A Window is a window in an Acme.
+// Its methods act as the user would, or report the window's state.
+type Window struct {
+	a   *Acme
+	id  int
+	dir *srv.File
+
+	// guarded by a.mu
+	name     string
+	body     []rune
+	tag      string // the part of the tag after the | Look
+	isdir    bool
+	dirty    bool
+	deleted  bool
+	addr     [2]int
+	dot      [2]int
+	nevent   int      // open event files
+	events   []string // unread events
+	ctls     []string
+	errors   strings.Builder
+	returned []acme.Event
+}
+
+func (w *Window) tagLocked() string {
+	return w.name + " Del Snarf | Look " + w.tag
+}
+
+func (w *Window) ctlLocked() string {
+	tag := w.tagLocked()
+	return fmt.Sprintf("%11d %11d %11d %11d %11d %11d %s %11d ",
+		w.id, utf8.RuneCountInString(tag), len(w.body), bool2int(w.isdir), bool2int(w.dirty),
+		width, font, tabWidth)
+}
+
+// ID returns the window's id.
+func (w *Window) ID() int { return w.id }
+
+// Name returns the window's file name.
+func (w *Window) Name() string {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return w.name
+}
+
+// Body returns the text of the window's body.
+func (w *Window) Body() string {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return string(w.body)
+}
+
+// SetBody replaces the body with s, as when the file is reloaded,
+// and marks the window clean. It sends no events.
+func (w *Window) SetBody(s string) {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	w.body = []rune(s)
+	w.dirty = false
+	w.addr, w.dot = [2]int{}, [2]int{}
+	w.a.changedLocked()
+}
+
+// Tag returns the window's whole tag.
+func (w *Window) Tag() string {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return w.tagLocked()
+}
+
+// Dirty reports whether the window has unsaved changes.
+func (w *Window) Dirty() bool {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return w.dirty
+}
+
+// Deleted reports whether the window has been deleted.
+func (w *Window) Deleted() bool {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return w.deleted
+}
+
+// Addr returns the window's address, as set through the addr file.
+func (w *Window) Addr() (q0, q1 int) {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return w.addr[0], w.addr[1]
+}
+
+// Dot returns the window's selection.
+func (w *Window) Dot() (q0, q1 int) {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return w.dot[0], w.dot[1]
+}
+
+// Select sets the selection to the runes q0 through q1.
+func (w *Window) Select(q0, q1 int) {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	w.dot = [2]int{min(q0, len(w.body)), min(q1, len(w.body))}
+	w.a.changedLocked()
+}
+
+// Ctls returns the control messages written to the window, in order.
+func (w *Window) Ctls() []string {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return append([]string(nil), w.ctls...)
+}
+
+// Errors returns the text written to the window's errors file.
+func (w *Window) Errors() string {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return w.errors.String()
+}
+
+// Returned returns the events that acme handled itself:
+// those written back to the event file, and those sent
+// while no program had the event file open.
+func (w *Window) Returned() []acme.Event {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	return append([]acme.Event(nil), w.returned...)
+}
+
+// Focus reports a focus event in the log, as when the mouse enters the window.
+func (w *Window) Focus() {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	w.a.logLocked(w, "focus")
+}
+
+// Delete deletes the window, as the Delete command does.
+func (w *Window) Delete() {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	w.a.deleteLocked(w)
+}
+
+// Type inserts text in the body at q0 as if typed at the keyboard.
+func (w *Window) Type(q0 int, text string) {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	w.insertLocked('K', min(q0, len(w.body)), text)
+	w.a.changedLocked()
+}
+
+// Exec executes cmd with the middle button, as if the user
+// had typed it in the tag (if it is not there already) and clicked it.
+func (w *Window) Exec(cmd string) {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	q0, q1 := w.tagTextLocked(cmd)
+	w.sendLocked(acme.Event{C1: 'M', C2: 'x', Q0: q0, Q1: q1, Text: []byte(cmd)})
+}
+
+// Look looks for text with the right button: in the body
+// if it appears there, or else in the tag as with Exec.
+func (w *Window) Look(text string) {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	if i := strings.Index(string(w.body), text); i >= 0 {
+		q0 := utf8.RuneCountInString(string(w.body)[:i])
+		q1 := q0 + utf8.RuneCountInString(text)
+		w.sendLocked(acme.Event{C1: 'M', C2: 'L', Q0: q0, Q1: q1, Text: []byte(text)})
+		return
+	}
+	q0, q1 := w.tagTextLocked(text)
+	w.sendLocked(acme.Event{C1: 'M', C2: 'l', Q0: q0, Q1: q1, Text: []byte(text)})
+}
+
+// SendEvent sends e to the program reading the window's event file.
+// If e.Flag has the 8 bit set, e.Arg and e.Loc follow as the
+// chorded argument. If no program has the event file open,
+// acme handles e itself.
+func (w *Window) SendEvent(e acme.Event) {
+	w.a.mu.Lock()
+	defer w.a.mu.Unlock()
+	w.sendLocked(e)
+}
+
+func (w *Window) sendLocked(e acme.Event) {
+	if w.nevent == 0 {
+		w.returnLocked(e.C1, e.C2, e.Q0, e.Q1)
+		return
+	}
+	w.eventLocked(e.C1, e.C2, e.Q0, e.Q1, e.Flag, string(e.Text))
+	if e.Flag&8 != 0 {
+		w.eventLocked(e.C1, e.C2, 0, 0, 0, string(e.Arg))
+		w.eventLocked(e.C1, e.C2, 0, 0, 0, string(e.Loc))
+	}
+	w.a.changedLocked()
+}
+
+// tagTextLocked returns the rune range of text in the tag,
+// appending it to the tag if it is not there.
+func (w *Window) tagTextLocked(text string) (q0, q1 int) {
+	tag := w.tagLocked()
+	i := strings.Index(tag, text)
+	if i < 0 {
+		if w.tag != "" && !strings.HasSuffix(w.tag, " ") {
+			w.tag += " "
+		}
+		w.tag += text
+		tag = w.tagLocked()
+		i = len(tag) - len(text)
+	}
+	q0 = utf8.RuneCountInString(tag[:i])
+	return q0, q0 + utf8.RuneCountInString(text)
+}
+
+// eventLocked queues an event for the event file, if it is open.
+func (w *Window) eventLocked(c1, c2 rune, q0, q1, flag int, text string) {
+	if w.nevent == 0 {
+		return
+	}
+	nr := utf8.RuneCountInString(text)
+	if nr > eventSize {
+		text, nr = "", 0
+	}
+	w.events = append(w.events, fmt.Sprintf("%c%c%d %d %d %d %s\n", c1, c2, q0, q1, flag, nr, text))
+}
+
+func (w *Window) insertLocked(origin rune, q int, text string) {
+	r := []rune(text)
+	body := make([]rune, 0, len(w.body)+len(r))
+	body = append(body, w.body[:q]...)
+	body = append(body, r...)
+	w.body = append(body, w.body[q:]...)
+	for _, p := range []*int{&w.dot[0], &w.dot[1]} {
+		if *p >= q {
+			*p += len(r)
+		}
+	}
+	w.dirty = true
+	w.eventLocked(origin, 'I', q, q+len(r), 0, text)
+}
+
+func (w *Window) cutLocked(origin rune, q0, q1 int) {
+	w.body = append(w.body[:q0], w.body[q1:]...)
+	for _, p := range []*int{&w.dot[0], &w.dot[1]} {
+		if *p >= q1 {
+			*p -= q1 - q0
+		} else if *p > q0 {
+			*p = q0
+		}
+	}
+	w.dirty = true
+	w.eventLocked(origin, 'D', q0, q1, 0, "")
+}
+
+func (w *Window) writeLocked(name, text string) error {
+	switch name {
+	case "addr":
+		q, err := evalAddr(w.body, text, w.addr)
+		if err != nil {
+			return err
+		}
+		w.addr = q
+	case "body":
+		w.insertLocked('E', len(w.body), text)
+	case "data", "xdata":
+		q0, q1 := w.addr[0], w.addr[1]
+		if q1 > len(w.body) || q0 > q1 {
+			return errAddrRange
+		}
+		if q1 > q0 {
+			w.cutLocked('F', q0, q1)
+		}
+		w.insertLocked('F', q0, text)
+		w.addr[0] = q0 + utf8.RuneCountInString(text)
+		w.addr[1] = w.addr[0]
+	case "tag":
+		q := utf8.RuneCountInString(w.tagLocked())
+		w.tag += text
+		w.eventLocked('E', 'i', q, q+utf8.RuneCountInString(text), 0, text)
+	case "errors":
+		w.errors.WriteString(text)
+	case "ctl":
+		for _, line := range strings.Split(text, "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			w.ctls = append(w.ctls, line)
+			if err := w.ctlLocked1(line); err != nil {
+				return err
+			}
+			if w.deleted {
+				break
+			}
+		}
+	case "event":
+		return w.eventWriteLocked(text)
+	default:
+		return srv.Eperm
+	}
+	return nil
+}
+
+func (w *Window) ctlLocked1(line string) error {
+	cmd, arg, _ := strings.Cut(line, " ")
+	switch cmd {
+	case "clean":
+		w.dirty = false
+	case "dirty":
+		w.dirty = true
+	case "name":
+		if arg == "" {
+			return errBadCtl
+		}
+		w.name = arg
+		w.isdir = strings.HasSuffix(arg, "/")
+	case "delete":
+		w.a.deleteLocked(w)
+	case "del":
+		if w.dirty {
+			return errDirty
+		}
+		w.a.deleteLocked(w)
+	case "get":
+		return w.getLocked()
+	case "put":
+		return w.putLocked()
+	case "dot=addr":
+		w.dot = w.addr
+	case "addr=dot":
+		w.addr = w.dot
+	case "cleartag":
+		w.tag = ""
+	case "show", "lock", "unlock", "indent", "noindent", "mark", "nomark",
+		"menu", "nomenu", "limit=addr", "font", "dump", "dumpdir", "color", "nocolor":
+		// No display, so nothing to do.
+	case "encoding":
+		// Files are always read and written as UTF-8.
+	default:
+		return errBadCtl
+	}
+	return nil
+}
+
+func (w *Window) getLocked() error {
+	data, err := os.ReadFile(w.name)
+	if err != nil {
+		return srv.Error(err.Error())
+	}
+	w.body = []rune(string(data))
+	w.dirty = false
+	w.addr, w.dot = [2]int{}, [2]int{}
+	w.a.logLocked(w, "get")
+	return nil
+}
+
+func (w *Window) putLocked() error {
+	if err := os.WriteFile(w.name, []byte(string(w.body)), 0666); err != nil {
+		return srv.Error(err.Error())
+	}
+	w.dirty = false
+	w.a.logLocked(w, "put")
+	return nil
+}
+
+// eventWriteLocked handles events written back to the event file.
+func (w *Window) eventWriteLocked(text string) error {
+	for text != "" {
+		line, rest, ok := strings.Cut(text, "\n")
+		if !ok || len(line) < 2 {
+			return errBadEvent
+		}
+		text = rest
+		c1, c2 := rune(line[0]), rune(line[1])
+		f := strings.Fields(line[2:])
+		if len(f) != 2 {
+			return errBadEvent
+		}
+		q0, err0 := strconv.Atoi(f[0])
+		q1, err1 := strconv.Atoi(f[1])
+		if err0 != nil || err1 != nil {
+			return errBadEvent
+		}
+		if err := w.returnLocked(c1, c2, q0, q1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returnLocked has acme handle an event itself. It records the event
+// and carries out the built-in commands Del, Delete, Get and Put.
+func (w *Window) returnLocked(c1, c2 rune, q0, q1 int) error {
+	text := w.body
+	if 'a' <= c2 && c2 <= 'z' {
+		text = []rune(w.tagLocked())
+	}
+	if q0 < 0 || q0 > q1 || q1 > len(text) {
+		return errBadEvent
+	}
+	s := string(text[q0:q1])
+	w.returned = append(w.returned, acme.Event{
+		C1: c1, C2: c2, Q0: q0, Q1: q1, OrigQ0: q0, OrigQ1: q1,
+		Nb: len(s), Nr: q1 - q0, Text: []byte(s),
+	})
+	w.a.changedLocked()
+	if c2 != 'x' && c2 != 'X' {
+		return nil
+	}
+	switch strings.TrimSpace(s) {
+	case "Del":
+		if !w.dirty {
+			w.a.deleteLocked(w)
+		}
+	case "Delete":
+		w.a.deleteLocked(w)
+	case "Get":
+		return w.getLocked()
+	case "Put":
+		return w.putLocked()
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package acmetest_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/acme/acmetest"
+)
+
+func start(t *testing.T) *acmetest.Acme {
+	a := acmetest.New()
+	fsys, err := a.Mount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.SetFsys(fsys)
+	return a
+}
+
+func TestWindow(t *testing.T) {
+	a := start(t)
+	lr, err := acme.Log()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close()
+
+	w, err := acme.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Name("/tmp/hello"); err != nil {
+		t.Fatal(err)
+	}
+	w.Write("body", []byte("hello\nworld\n"))
+	fw := a.Window(w.ID())
+	if fw == nil || fw.Name() != "/tmp/hello" || fw.Body() != "hello\nworld\n" || !fw.Dirty() {
+		t.Fatalf("window state wrong after writes")
+	}
+
+	// Replace the second line through addr and data.
+	if err := w.Addr("2"); err != nil {
+		t.Fatal(err)
+	}
+	if q0, q1, err := w.ReadAddr(); err != nil || q0 != 6 || q1 != 12 {
+		t.Errorf("ReadAddr = %d, %d, %v, want 6, 12", q0, q1, err)
+	}
+	if data, err := w.ReadAll("xdata"); err != nil || string(data) != "world\n" {
+		t.Errorf("xdata = %q, %v", data, err)
+	}
+	w.Addr("/wor/,/ld/")
+	w.Write("data", []byte("WORLD"))
+	if got := fw.Body(); got != "hello\nWORLD\n" {
+		t.Errorf("body = %q", got)
+	}
+	if err := w.Addr("/nomatch/"); err == nil {
+		t.Errorf("Addr(/nomatch/) succeeded")
+	}
+
+	w.Ctl("clean")
+	info, err := w.Info()
+	if err != nil || info.ID != w.ID() || info.BodyLen != 12 || info.IsModified {
+		t.Errorf("Info = %+v, %v", info, err)
+	}
+	wins, err := acme.Windows()
+	if err != nil || len(wins) != 1 || wins[0].Name != "/tmp/hello" {
+		t.Errorf("Windows = %+v, %v", wins, err)
+	}
+	if err := w.Ctl("bogus"); err == nil {
+		t.Errorf("bad ctl message accepted")
+	}
+
+	w.Del(true)
+	if !fw.Deleted() {
+		t.Errorf("window not deleted")
+	}
+	for _, want := range []string{"new", "del"} {
+		e, err := lr.Read()
+		if err != nil || e.Op != want || e.ID != w.ID() {
+			t.Errorf("log = %+v, %v, want %s", e, err, want)
+		}
+	}
+}
+
+type handler struct {
+	w *acme.Win
+}
+
+func (h *handler) Execute(cmd string) bool {
+	if cmd == "Hi" {
+		h.w.Fprintf("body", "hi\n")
+		return true
+	}
+	return false
+}
+
+func (h *handler) Look(arg string) bool { return false }
+
+func TestEvents(t *testing.T) {
+	a := start(t)
+	fw := a.NewWindow("/tmp/ev", "text\n")
+	w, err := acme.Open(fw.ID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.OpenEvent(); err != nil {
+		t.Fatal(err)
+	}
+	go w.EventLoop(&handler{w: w})
+
+	fw.Exec("Hi")
+	if !a.Wait(5*time.Second, func() bool { return fw.Body() == "text\nhi\n" }) {
+		t.Fatalf("Hi not executed; body = %q", fw.Body())
+	}
+
+	// Unhandled events go back to acme.
+	fw.Look("text")
+	fw.Exec("Other")
+	if !a.Wait(5*time.Second, func() bool { return len(fw.Returned()) == 2 }) {
+		t.Fatalf("returned events = %+v", fw.Returned())
+	}
+	ret := fw.Returned()
+	if string(ret[0].Text) != "text" || ret[0].C2 != 'L' || string(ret[1].Text) != "Other" || ret[1].C2 != 'x' {
+		t.Errorf("returned events = %+v", ret)
+	}
+	if !strings.Contains(fw.Tag(), "Other") {
+		t.Errorf("tag = %q, want Other", fw.Tag())
+	}
+
+	fw.Exec("Delete")
+	if !a.Wait(5*time.Second, fw.Deleted) {
+		t.Errorf("Delete did not delete window")
+	}
+}
@@ -0,0 +1,152 @@
+package acmetest
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// evalAddr evaluates the acme address s in text, with dot as
+// the current address, and returns the selected range in runes.
+// It understands the common forms: #n, n (a line), 0, $, .,
+// /regexp/, ?regexp?, and pairs of those joined by , or ;.
+// As in acme, an empty left side of a pair means 0
+// and an empty right side means $.
+func evalAddr(text []rune, s string, dot [2]int) (q [2]int, err error) {
+	s = strings.TrimSpace(s)
+	// Find the , or ; outside any regexp.
+	i := -1
+	for j := 0; j < len(s) && i < 0; j++ {
+		c := s[j]
+		if c == '/' || c == '?' {
+			k := strings.IndexByte(s[j+1:], c)
+			if k < 0 {
+				break
+			}
+			j += k + 1
+			continue
+		}
+		if c == ',' || c == ';' {
+			i = j
+		}
+	}
+	if i < 0 {
+		return simpleAddr(text, s, dot)
+	}
+	left, right := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	l := [2]int{0, 0}
+	if left != "" {
+		if l, err = simpleAddr(text, left, dot); err != nil {
+			return q, err
+		}
+	}
+	if s[i] == ';' {
+		dot = l
+	}
+	r := [2]int{len(text), len(text)}
+	if right != "" {
+		if r, err = simpleAddr(text, right, dot); err != nil {
+			return q, err
+		}
+	}
+	if l[0] > r[1] {
+		return q, errAddrRange
+	}
+	return [2]int{l[0], r[1]}, nil
+}
+
+func simpleAddr(text []rune, s string, dot [2]int) ([2]int, error) {
+	switch {
+	case s == "":
+		return dot, nil
+	case s == ".":
+		return dot, nil
+	case s == "$":
+		return [2]int{len(text), len(text)}, nil
+	case s[0] == '#':
+		n, err := strconv.Atoi(s[1:])
+		if err != nil {
+			return dot, errBadAddr
+		}
+		if n < 0 || n > len(text) {
+			return dot, errAddrRange
+		}
+		return [2]int{n, n}, nil
+	case s[0] >= '0' && s[0] <= '9':
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return dot, errBadAddr
+		}
+		return lineAddr(text, n)
+	case s[0] == '/' || s[0] == '?':
+		re := strings.TrimSuffix(s[1:], s[:1])
+		return regexpAddr(text, re, s[0] == '?', dot)
+	}
+	return dot, errBadAddr
+}
+
+// lineAddr returns the range of line n, counting from 1.
+// Line 0 is the empty string at the start of text.
+func lineAddr(text []rune, n int) ([2]int, error) {
+	if n == 0 {
+		return [2]int{0, 0}, nil
+	}
+	q0 := 0
+	for line := 1; line < n; line++ {
+		i := indexRune(text[q0:], '\n')
+		if i < 0 {
+			return [2]int{}, errAddrRange
+		}
+		q0 += i + 1
+	}
+	if q0 == len(text) && n > 1 {
+		return [2]int{q0, q0}, nil
+	}
+	q1 := len(text)
+	if i := indexRune(text[q0:], '\n'); i >= 0 {
+		q1 = q0 + i + 1
+	}
+	return [2]int{q0, q1}, nil
+}
+
+// regexpAddr searches for re forward from the end of dot,
+// or backward from its start, wrapping around.
+func regexpAddr(text []rune, re string, backward bool, dot [2]int) ([2]int, error) {
+	rx, err := regexp.Compile("(?m)" + re)
+	if err != nil {
+		return dot, errBadAddr
+	}
+	s := string(text)
+	locs := rx.FindAllStringIndex(s, -1)
+	if len(locs) == 0 {
+		return dot, errAddrRange // as acme answers
+	}
+	toRunes := func(loc []int) [2]int {
+		q0 := utf8.RuneCountInString(s[:loc[0]])
+		return [2]int{q0, q0 + utf8.RuneCountInString(s[loc[0]:loc[1]])}
+	}
+	if backward {
+		for i := len(locs) - 1; i >= 0; i-- {
+			if q := toRunes(locs[i]); q[1] <= dot[0] {
+				return q, nil
+			}
+		}
+		return toRunes(locs[len(locs)-1]), nil
+	}
+	for _, loc := range locs {
+		if q := toRunes(loc); q[0] >= dot[1] {
+			return q, nil
+		}
+	}
+	return toRunes(locs[0]), nil
+}
+
+func indexRune(r []rune, c rune) int {
+	for i, x := range r {
+		if x == c {
+			return i
+		}
+	}
+	return -1
+}
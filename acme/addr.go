@@ -0,0 +1,253 @@
+package acme
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An Address is an address in acme's address language,
+// checked as it is built, for use with Win.SetAddr and Win.Select.
+//
+// The zero Address is the empty address, which acme takes as dot.
+// Addresses are built from the constructors below and combined with
+// methods, so that LineAddr(3).To(End()) is the address "3,$".
+// An Address built from invalid parts carries the first error,
+// reported by Err and by SetAddr.
+type Address struct {
+	s        string
+	compound bool // contains , or ;
+	err      error
+}
+
+// An AddrError reports an invalid address.
+type AddrError struct {
+	Addr   string // the address
+	Offset int    // byte offset of the problem in Addr, or -1 if acme rejected it
+	Err    error  // ErrBadAddr, ErrAddrRange (also for a regexp with no match) or a regexp error
+}
+
+func (e *AddrError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("acme: address %q: %v", e.Addr, e.Err)
+	}
+	return fmt.Sprintf("acme: address %q: offset %d: %v", e.Addr, e.Offset, e.Err)
+}
+
+func (e *AddrError) Unwrap() error {
+	return e.Err
+}
+
+// String returns the address in acme's syntax.
+func (a Address) String() string {
+	return a.s
+}
+
+// Err returns the error, if any, made while building a.
+func (a Address) Err() error {
+	return a.err
+}
+
+func badAddr(s string, off int, err error) Address {
+	return Address{s: s, err: &AddrError{Addr: s, Offset: off, Err: err}}
+}
+
+// CharAddr returns the address #q: the empty string after the first q runes.
+func CharAddr(q int) Address {
+	s := "#" + strconv.Itoa(q)
+	if q < 0 {
+		return badAddr(s, 1, ErrAddrRange)
+	}
+	return Address{s: s}
+}
+
+// LineAddr returns the address of line n, counting from 1.
+// Line 0 is the empty string at the start of the body.
+func LineAddr(n int) Address {
+	s := strconv.Itoa(n)
+	if n < 0 {
+		return badAddr(s, 0, ErrAddrRange)
+	}
+	return Address{s: s}
+}
+
+// RegexpAddr returns the address /re/: the next match of re after dot.
+func RegexpAddr(re string) Address {
+	return regexpAddr('/', re)
+}
+
+// BackRegexpAddr returns the address ?re?: the previous match of re before dot.
+func BackRegexpAddr(re string) Address {
+	return regexpAddr('?', re)
+}
+
+func regexpAddr(delim byte, re string) Address {
+	s := string(delim) + strings.ReplaceAll(re, string(delim), `\`+string(delim)) + string(delim)
+	if strings.Contains(re, "\n") {
+		return badAddr(s, 1+strings.Index(re, "\n"), ErrBadAddr)
+	}
+	if _, err := regexp.Compile(re); err != nil {
+		return badAddr(s, 1, err)
+	}
+	return Address{s: s}
+}
+
+// Dot returns the address ., the current selection.
+func Dot() Address {
+	return Address{s: "."}
+}
+
+// End returns the address $, the empty string at the end of the body.
+func End() Address {
+	return Address{s: "$"}
+}
+
+// All returns the address 0,$ covering the whole body.
+func All() Address {
+	return LineAddr(0).To(End())
+}
+
+// To returns the address a,b: from the start of a to the end of b.
+func (a Address) To(b Address) Address {
+	return a.join(",", b)
+}
+
+// Then returns the address a;b: like a,b but with
+// dot set to a while b is evaluated.
+func (a Address) Then(b Address) Address {
+	return a.join(";", b)
+}
+
+// Plus returns the address a+b: b evaluated forward from the end of a,
+// as in .+1 for the line after dot or .+#3 for three runes after it.
+func (a Address) Plus(b Address) Address {
+	return a.join("+", b)
+}
+
+// Minus returns the address a-b: b evaluated backward from the start of a.
+func (a Address) Minus(b Address) Address {
+	return a.join("-", b)
+}
+
+func (a Address) join(op string, b Address) Address {
+	s := a.s + op + b.s
+	switch {
+	case a.err != nil:
+		return Address{s: s, err: a.err}
+	case b.err != nil:
+		return Address{s: s, err: b.err}
+	case a.compound:
+		// Acme's address language has no parentheses,
+		// and a,b,c means a,(b,c).
+		return badAddr(s, len(a.s), ErrBadAddr)
+	case (op == "+" || op == "-") && b.compound:
+		return badAddr(s, len(a.s)+1, ErrBadAddr)
+	}
+	return Address{s: s, compound: a.compound || b.compound || op == "," || op == ";"}
+}
+
+// ParseAddress checks that s is a valid address and returns it.
+// The error, if any, is an *AddrError.
+func ParseAddress(s string) (Address, error) {
+	p := &addrParser{s: s}
+	compound, err := p.compound()
+	if err == nil && p.i < len(s) {
+		err = ErrBadAddr
+	}
+	if err != nil {
+		a := badAddr(s, p.i, err)
+		return a, a.err
+	}
+	return Address{s: s, compound: compound}, nil
+}
+
+// An addrParser parses an address as acme does:
+//
+//	compound = simple [ (',' | ';') compound ]
+//	simple   = [ term ] { ('+' | '-') [ term ] | term }
+//	term     = '#' number | number | '/' regexp ['/'] | '?' regexp ['?'] | '.' | '$'
+//
+// Either side of , or ; may be empty, and so may the operand
+// of + or -, which then means one line.
+type addrParser struct {
+	s string
+	i int
+}
+
+func (p *addrParser) compound() (bool, error) {
+	if err := p.simple(); err != nil {
+		return false, err
+	}
+	if p.i < len(p.s) && (p.s[p.i] == ',' || p.s[p.i] == ';') {
+		p.i++
+		_, err := p.compound()
+		return true, err
+	}
+	return false, nil
+}
+
+func (p *addrParser) simple() error {
+	for p.i < len(p.s) {
+		switch c := p.s[p.i]; c {
+		case ',', ';':
+			return nil
+		case '+', '-':
+			p.i++
+		case ' ', '\t':
+			p.i++
+		default:
+			if err := p.term(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *addrParser) term() error {
+	switch c := p.s[p.i]; {
+	case c == '.' || c == '$':
+		p.i++
+	case c == '#':
+		p.i++
+		if p.number() == 0 {
+			return ErrBadAddr
+		}
+	case c >= '0' && c <= '9':
+		p.number()
+	case c == '/' || c == '?':
+		p.i++
+		start := p.i
+		var re strings.Builder
+		for p.i < len(p.s) && p.s[p.i] != c {
+			if p.s[p.i] == '\n' {
+				return ErrBadAddr
+			}
+			if p.s[p.i] == '\\' && p.i+1 < len(p.s) && p.s[p.i+1] == c {
+				p.i++
+			}
+			re.WriteByte(p.s[p.i])
+			p.i++
+		}
+		if _, err := regexp.Compile(re.String()); err != nil {
+			p.i = start
+			return err
+		}
+		if p.i < len(p.s) {
+			p.i++ // closing delimiter
+		}
+	default:
+		return ErrBadAddr
+	}
+	return nil
+}
+
+// number skips digits and returns how many there were.
+func (p *addrParser) number() int {
+	start := p.i
+	for p.i < len(p.s) && '0' <= p.s[p.i] && p.s[p.i] <= '9' {
+		p.i++
+	}
+	return p.i - start
+}
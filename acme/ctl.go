@@ -0,0 +1,196 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by acme, for use with errors.Is.
+var (
+	ErrBadCtl    = errors.New("ill-formed control message")
+	ErrBadAddr   = errors.New("bad address syntax")
+	ErrAddrRange = errors.New("address out of range")
+	ErrDirty     = errors.New("file dirty")
+	ErrDeleted   = errors.New("deleted window")
+)
+
+var acmeErrors = []error{ErrBadCtl, ErrBadAddr, ErrAddrRange, ErrDirty, ErrDeleted}
+
+// acmeError returns the Err variable matching the
+// error acme replied with, or err itself.
+func acmeError(err error) error {
+	for _, e := range acmeErrors {
+		if err.Error() == e.Error() {
+			return e
+		}
+	}
+	return err
+}
+
+// A CtlError reports a failed control message.
+type CtlError struct {
+	ID  int    // window id
+	Msg string // control message, without the newline
+	Err error  // one of the Err variables, or the error acme returned
+}
+
+func (e *CtlError) Error() string {
+	return fmt.Sprintf("acme: window %d: %s: %v", e.ID, e.Msg, e.Err)
+}
+
+func (e *CtlError) Unwrap() error {
+	return e.Err
+}
+
+// ctlMsg writes the control message msg to the window's ctl file.
+func (w *Win) ctlMsg(msg string) error {
+	if err := w.Ctl("%s", msg); err != nil {
+		return &CtlError{w.id, msg, acmeError(err)}
+	}
+	return nil
+}
+
+// ctlArg writes the control message verb followed by arg, which must
+// be non-empty and, as acme requires, free of newlines and NULs.
+func (w *Win) ctlArg(verb, arg string) error {
+	msg := verb + " " + arg
+	if arg == "" || strings.ContainsAny(arg, "\n\x00") {
+		return &CtlError{w.id, msg, ErrBadCtl}
+	}
+	return w.ctlMsg(msg)
+}
+
+// Clean marks the window clean, as after a Put.
+func (w *Win) Clean() error { return w.ctlMsg("clean") }
+
+// Dirty marks the window dirty, as if its body had been changed.
+func (w *Win) Dirty() error { return w.ctlMsg("dirty") }
+
+// Show scrolls the window so that dot is visible.
+func (w *Win) Show() error { return w.ctlMsg("show") }
+
+// SetName sets the window's file name.
+// Acme rejects names containing spaces or control characters.
+func (w *Win) SetName(name string) error {
+	for _, c := range name {
+		if c <= ' ' {
+			return &CtlError{w.id, "name " + name, fmt.Errorf("bad character in file name")}
+		}
+	}
+	if err := w.ctlArg("name", name); err != nil {
+		return err
+	}
+	w.name = name
+	return nil
+}
+
+// SetFont sets the window's font, as the Font command does.
+func (w *Win) SetFont(font string) error { return w.ctlArg("font", font) }
+
+// SetColor overrides one of the window's colors, named by key
+// as in acme's theme files, such as tag.back or body.text,
+// with color, such as #ffdddd or red. Helpers use it to
+// set special windows apart.
+func (w *Win) SetColor(key, color string) error {
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return &CtlError{w.id, "color " + key + " " + color, ErrBadCtl}
+	}
+	return w.ctlArg("color", key+" "+color)
+}
+
+// ResetColors removes the window's color overrides,
+// so that it is drawn in acme's theme.
+func (w *Win) ResetColors() error { return w.ctlMsg("nocolor") }
+
+// SetEncoding sets the encoding in which acme reads and writes
+// the window's file, as the Enc command does: a charset such as
+// utf-8, utf-16le or latin1, the options bom, crlf or lf, or both,
+// as in latin1+crlf.
+func (w *Win) SetEncoding(enc string) error { return w.ctlArg("encoding", enc) }
+
+// SetDump sets the command that Dump records to recreate the window.
+func (w *Win) SetDump(cmd string) error { return w.ctlArg("dump", cmd) }
+
+// SetDumpDir sets the directory in which the dump command runs.
+func (w *Win) SetDumpDir(dir string) error { return w.ctlArg("dumpdir", dir) }
+
+// SetDotToAddr sets dot, the selection, to the address last set.
+func (w *Win) SetDotToAddr() error { return w.ctlMsg("dot=addr") }
+
+// SetAddrToDot sets the address to dot.
+func (w *Win) SetAddrToDot() error { return w.ctlMsg("addr=dot") }
+
+// SetLimitToAddr limits later address searches to the address last set.
+func (w *Win) SetLimitToAddr() error { return w.ctlMsg("limit=addr") }
+
+// Mark starts a new undo group: changes before it
+// and after it are undone separately.
+func (w *Win) Mark() error { return w.ctlMsg("mark") }
+
+// NoMark stops acme from starting an undo group at each change,
+// so that the following changes undo together, until the next Mark.
+func (w *Win) NoMark() error { return w.ctlMsg("nomark") }
+
+// SetMenu sets whether the tag shows Undo, Redo and Put
+// automatically as the window's state changes.
+func (w *Win) SetMenu(on bool) error {
+	if on {
+		return w.ctlMsg("menu")
+	}
+	return w.ctlMsg("nomenu")
+}
+
+// SetIndent sets whether typing a newline copies
+// the previous line's indentation.
+func (w *Win) SetIndent(on bool) error {
+	if on {
+		return w.ctlMsg("indent")
+	}
+	return w.ctlMsg("noindent")
+}
+
+// ClearTag removes everything after the vertical bar in the tag.
+func (w *Win) ClearTag() error { return w.ctlMsg("cleartag") }
+
+// Lock takes exclusive use of the window until Unlock.
+// Acme holds the lock for the ctl file, so Lock and Unlock
+// must be used through the same Win.
+func (w *Win) Lock() error { return w.ctlMsg("lock") }
+
+// Unlock releases the exclusive use taken by Lock.
+func (w *Win) Unlock() error { return w.ctlMsg("unlock") }
+
+// Get reloads the body from the window's file, as Get does.
+func (w *Win) Get() error { return w.ctlMsg("get") }
+
+// Put writes the body to the window's file, as Put does.
+func (w *Win) Put() error { return w.ctlMsg("put") }
+
+// Delete deletes the window. Unless sure is set,
+// it fails with ErrDirty if the window is dirty.
+func (w *Win) Delete(sure bool) error {
+	if sure {
+		return w.ctlMsg("delete")
+	}
+	return w.ctlMsg("del")
+}
+
+// SetAddr sets the window's address to a, checking a first.
+func (w *Win) SetAddr(a Address) error {
+	if a.err != nil {
+		return a.err
+	}
+	if err := w.Addr("%s", a.s); err != nil {
+		return &AddrError{Addr: a.s, Offset: -1, Err: acmeError(err)}
+	}
+	return nil
+}
+
+// Select sets both the window's address and dot to a.
+func (w *Win) Select(a Address) error {
+	if err := w.SetAddr(a); err != nil {
+		return err
+	}
+	return w.SetDotToAddr()
+}
@@ -0,0 +1,131 @@
+package acme_test
+
+import (
+	"errors"
+	"testing"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/acme/acmetest"
+)
+
+func TestParseAddress(t *testing.T) {
+	for _, s := range []string{
+		"", ".", "$", "0", "12", "#0", "#12", ",", "0,$", ",$", "3,",
+		"/x/", "/x", `/a\/b/`, "?x?", "/a/,/b/", "/a/;/b/", ".+1", ".-#3",
+		"3+", "/x/-", "#3,#5", "1,2,3", "3/x/",
+	} {
+		if _, err := acme.ParseAddress(s); err != nil {
+			t.Errorf("ParseAddress(%q): %v", s, err)
+		}
+	}
+	for _, tt := range []struct {
+		s   string
+		off int
+	}{
+		{"#", 1},
+		{"#x", 1},
+		{"x", 0},
+		{"1,x", 2},
+		{"/a(/", 1},
+		{"3 %", 2},
+	} {
+		_, err := acme.ParseAddress(tt.s)
+		var ae *acme.AddrError
+		if !errors.As(err, &ae) || ae.Offset != tt.off || ae.Addr != tt.s {
+			t.Errorf("ParseAddress(%q) = %v, want error at offset %d", tt.s, err, tt.off)
+		}
+	}
+}
+
+func TestAddressBuilder(t *testing.T) {
+	for _, tt := range []struct {
+		a    acme.Address
+		want string
+	}{
+		{acme.All(), "0,$"},
+		{acme.LineAddr(3).To(acme.End()), "3,$"},
+		{acme.CharAddr(2).To(acme.CharAddr(5)), "#2,#5"},
+		{acme.Dot().Plus(acme.LineAddr(1)), ".+1"},
+		{acme.RegexpAddr("a/b").Then(acme.BackRegexpAddr("c?")), `/a\/b/;?c\??`},
+		{acme.LineAddr(1).To(acme.LineAddr(2).To(acme.LineAddr(3))), "1,2,3"},
+	} {
+		if tt.a.Err() != nil || tt.a.String() != tt.want {
+			t.Errorf("address = %q, %v; want %q", tt.a, tt.a.Err(), tt.want)
+			continue
+		}
+		if _, err := acme.ParseAddress(tt.a.String()); err != nil {
+			t.Errorf("ParseAddress(%q): %v", tt.a, err)
+		}
+	}
+	for _, a := range []acme.Address{
+		acme.CharAddr(-1),
+		acme.LineAddr(-1).To(acme.End()),
+		acme.RegexpAddr("a("),
+		acme.All().To(acme.End()),
+		acme.Dot().Plus(acme.All()),
+	} {
+		var ae *acme.AddrError
+		if !errors.As(a.Err(), &ae) {
+			t.Errorf("address %q: err = %v, want *AddrError", a, a.Err())
+		}
+	}
+}
+
+func TestCtl(t *testing.T) {
+	a := acmetest.New()
+	fsys, err := a.Mount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.SetFsys(fsys)
+	fw := a.NewWindow("/tmp/ctl", "one\ntwo\nthree\n")
+	w, err := acme.Open(fw.ID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.CloseFiles()
+
+	if err := w.Select(acme.LineAddr(2)); err != nil {
+		t.Fatal(err)
+	}
+	if q0, q1 := fw.Dot(); q0 != 4 || q1 != 8 {
+		t.Errorf("dot = %d,%d after Select(2), want 4,8", q0, q1)
+	}
+	err = w.SetAddr(acme.RegexpAddr("four"))
+	if !errors.Is(err, acme.ErrAddrRange) {
+		t.Errorf("SetAddr(/four/) = %v, want ErrAddrRange", err)
+	}
+	if err := w.SetAddr(acme.RegexpAddr("(")); err == nil {
+		t.Errorf("SetAddr(/(/) succeeded")
+	}
+
+	if err := w.SetName("/tmp/new name"); err == nil {
+		t.Errorf("SetName accepted a space")
+	}
+	if err := w.SetName("/tmp/ctl2"); err != nil || fw.Name() != "/tmp/ctl2" {
+		t.Errorf("SetName = %v, name %q", err, fw.Name())
+	}
+	if err := w.SetColor("tag.back", "#ffdddd"); err != nil {
+		t.Errorf("SetColor = %v", err)
+	}
+	if err := w.SetColor("tag.back", "red\n"); !errors.Is(err, acme.ErrBadCtl) {
+		t.Errorf("SetColor with newline = %v, want ErrBadCtl", err)
+	}
+	if err := w.SetEncoding("latin1+crlf"); err != nil {
+		t.Errorf("SetEncoding = %v", err)
+	}
+	if err := w.SetDump(""); !errors.Is(err, acme.ErrBadCtl) {
+		t.Errorf("SetDump(\"\") = %v, want ErrBadCtl", err)
+	}
+
+	w.Dirty()
+	err = w.Delete(false)
+	var ce *acme.CtlError
+	if !errors.Is(err, acme.ErrDirty) || !errors.As(err, &ce) || ce.Msg != "del" || ce.ID != fw.ID() {
+		t.Errorf("Delete(false) on dirty window = %v", err)
+	}
+	w.Clean()
+	if err := w.Delete(false); err != nil || !fw.Deleted() {
+		t.Errorf("Delete(false) on clean window = %v", err)
+	}
+}
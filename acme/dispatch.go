@@ -0,0 +1,482 @@
+package acme
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Dispatcher runs the commands executed in a window by calling
+// methods on a handler, as EventLoop does, but parsing their
+// arguments, adding the commands to the tag and answering Help.
+//
+// A method named Exec<Verb> implements the command Verb. It returns
+// nothing or an error, which is shown in the +Errors window. It may
+// take a *Event, for the execute event, followed by one of:
+//
+//	string    the text after the verb, with any chorded argument appended
+//	[]string  that text split into words, which may be quoted as in rc
+//	a struct  options and arguments parsed from the words
+//
+// In a struct, a field tagged `flag:"name"` is set by the option
+// -name, as by package flag: it may be a bool, string, int, int64,
+// uint, float64 or time.Duration, or implement flag.Value. The
+// words after the options set the fields tagged `arg:"name"`, in
+// order; they have the same types, except that a final []string
+// field takes the remaining words. Every argument must be given,
+// except for a final []string. A `help:"text"` tag describes a field.
+//
+// For example, given
+//
+//	func (h *handler) ExecGrep(opt struct {
+//		Count   bool   `flag:"c" help:"only count matching lines"`
+//		Pattern string `arg:"pattern"`
+//	}) error
+//
+// executing "Grep -c main" calls ExecGrep with Count true and Pattern
+// "main", as does executing "Grep -c" with main chorded to it.
+type Dispatcher struct {
+	w    *Win
+	h    interface{}
+	cmds map[string]*command
+	doc  map[string]string
+}
+
+type command struct {
+	verb  string
+	m     reflect.Value
+	event bool         // takes *Event first
+	arg   reflect.Type // nil, string, []string or a struct
+}
+
+var (
+	eventType  = reflect.TypeOf((*Event)(nil))
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	stringType = reflect.TypeOf("")
+	wordsType  = reflect.TypeOf([]string(nil))
+	valueType  = reflect.TypeOf((*flag.Value)(nil)).Elem()
+	durType    = reflect.TypeOf(time.Duration(0))
+)
+
+// NewDispatcher returns a Dispatcher running the commands of h in w.
+// It returns an error if an Exec method has a signature or struct
+// type not described above. Unless h has an ExecHelp method, the
+// Dispatcher provides a Help command listing the commands.
+func NewDispatcher(w *Win, h interface{}) (*Dispatcher, error) {
+	d := &Dispatcher{w: w, h: h, cmds: make(map[string]*command), doc: make(map[string]string)}
+	v := reflect.ValueOf(h)
+	t := v.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		name := t.Method(i).Name
+		if !strings.HasPrefix(name, "Exec") || len(name) == len("Exec") {
+			continue
+		}
+		c, err := newCommand(name[len("Exec"):], v.Method(i))
+		if err != nil {
+			return nil, err
+		}
+		d.cmds[c.verb] = c
+	}
+	if d.cmds["Help"] == nil {
+		d.cmds["Help"] = &command{verb: "Help", m: reflect.ValueOf(d.help)}
+		d.doc["Help"] = "list the commands"
+	}
+	return d, nil
+}
+
+func newCommand(verb string, m reflect.Value) (*command, error) {
+	c := &command{verb: verb, m: m}
+	t := m.Type()
+	bad := func(format string, args ...interface{}) error {
+		return fmt.Errorf("acme: bad method Exec%s: %s", verb, fmt.Sprintf(format, args...))
+	}
+	if t.NumOut() > 1 || t.NumOut() == 1 && t.Out(0) != errorType {
+		return nil, bad("results must be error or nothing")
+	}
+	in := t.NumIn()
+	if in > 0 && t.In(0) == eventType {
+		c.event = true
+		in--
+	}
+	switch {
+	case in > 1:
+		return nil, bad("too many arguments")
+	case in == 1:
+		c.arg = t.In(t.NumIn() - 1)
+		if c.arg != stringType && c.arg != wordsType {
+			if c.arg.Kind() != reflect.Struct {
+				return nil, bad("argument type %v, not string, []string or a struct", c.arg)
+			}
+			if err := checkStruct(c.arg); err != nil {
+				return nil, bad("%v", err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// checkStruct checks the tags and field types of an options struct.
+func checkStruct(t reflect.Type) error {
+	rest := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		_, isFlag := f.Tag.Lookup("flag")
+		_, isArg := f.Tag.Lookup("arg")
+		switch {
+		case !isFlag && !isArg:
+			continue
+		case isFlag && isArg:
+			return fmt.Errorf("field %s is both flag and arg", f.Name)
+		case !f.IsExported():
+			return fmt.Errorf("field %s is not exported", f.Name)
+		case isArg && rest:
+			return fmt.Errorf("field %s follows []string argument", f.Name)
+		case isArg && f.Type == wordsType:
+			rest = true
+		case !settable(f.Type):
+			return fmt.Errorf("field %s has unsupported type %v", f.Name, f.Type)
+		}
+	}
+	return nil
+}
+
+func settable(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(valueType) || t == durType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Int64, reflect.Uint, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// Describe sets the one-line description of the command verb
+// shown by Help.
+func (d *Dispatcher) Describe(verb, text string) {
+	d.doc[verb] = text
+}
+
+// Commands returns the names of the commands, sorted.
+func (d *Dispatcher) Commands() []string {
+	var names []string
+	for name := range d.cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetTag adds the commands to the window's tag.
+func (d *Dispatcher) SetTag() error {
+	return d.w.Fprintf("tag", " %s ", strings.Join(d.Commands(), " "))
+}
+
+// Loop handles the window's events until the window is deleted.
+// It runs execute events naming a command with Execute. Other
+// execute and look events go to the handler's Execute and Look
+// methods, if it is an EventHandler, and otherwise back to acme.
+func (d *Dispatcher) Loop() {
+	eh, _ := d.h.(EventHandler)
+	for e := range d.w.EventChan() {
+		switch e.C2 {
+		case 'x', 'X': // execute
+			if d.Execute(e) {
+				continue
+			}
+			cmd := strings.TrimSpace(string(e.Text))
+			if eh == nil || !eh.Execute(cmd) {
+				d.w.WriteEvent(e)
+			}
+		case 'l', 'L': // look
+			d.w.loadText(e, eh)
+			if eh == nil || !eh.Look(string(e.Text)) {
+				d.w.WriteEvent(e)
+			}
+		}
+	}
+}
+
+// Execute runs the command named by the execute event e,
+// reporting whether e named one.
+func (d *Dispatcher) Execute(e *Event) bool {
+	text := strings.TrimSpace(string(e.Text))
+	verb, arg := text, ""
+	if i := strings.IndexAny(verb, " \t\n"); i >= 0 {
+		verb, arg = verb[:i], strings.TrimSpace(verb[i+1:])
+	}
+	c := d.cmds[verb]
+	if c == nil {
+		return false
+	}
+	if len(e.Arg) > 0 {
+		arg = strings.TrimSpace(arg + " " + string(e.Arg))
+	}
+	if err := d.run(c, e, arg); err != nil {
+		d.w.Errf("%s: %v", verb, err)
+	}
+	return true
+}
+
+// errUsage reports bad arguments; run shows the usage instead.
+var errUsage = errors.New("usage")
+
+func (d *Dispatcher) run(c *command, e *Event, arg string) error {
+	var args []reflect.Value
+	if c.event {
+		args = append(args, reflect.ValueOf(e))
+	}
+	switch {
+	case c.arg == nil:
+		if arg != "" {
+			return fmt.Errorf("takes no arguments")
+		}
+	case c.arg == stringType:
+		args = append(args, reflect.ValueOf(arg))
+	default:
+		words, err := splitWords(arg)
+		if err != nil {
+			return err
+		}
+		if c.arg == wordsType {
+			args = append(args, reflect.ValueOf(words))
+			break
+		}
+		v, err := parseStruct(c.arg, words)
+		if err == errUsage {
+			return fmt.Errorf("usage: %s", usage(c))
+		}
+		if err != nil {
+			return fmt.Errorf("%v\nusage: %s", err, usage(c))
+		}
+		args = append(args, v)
+	}
+	out := c.m.Call(args)
+	if len(out) == 1 && !out[0].IsNil() {
+		return out[0].Interface().(error)
+	}
+	return nil
+}
+
+// parseStruct returns a new value of the struct type t
+// with its fields set from words.
+func parseStruct(t reflect.Type, words []string) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	var argFields []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if name, ok := f.Tag.Lookup("flag"); ok {
+			fs.Var(fieldValue{v.Field(i)}, name, f.Tag.Get("help"))
+		}
+		if _, ok := f.Tag.Lookup("arg"); ok {
+			argFields = append(argFields, i)
+		}
+	}
+	if err := fs.Parse(words); err != nil {
+		if err == flag.ErrHelp {
+			return v, errUsage
+		}
+		return v, err
+	}
+	rest := fs.Args()
+	for _, i := range argFields {
+		f := t.Field(i)
+		if f.Type == wordsType {
+			v.Field(i).Set(reflect.ValueOf(rest))
+			rest = nil
+			break
+		}
+		if len(rest) == 0 {
+			return v, fmt.Errorf("missing %s", f.Tag.Get("arg"))
+		}
+		if err := (fieldValue{v.Field(i)}).Set(rest[0]); err != nil {
+			return v, fmt.Errorf("invalid %s %q: %v", f.Tag.Get("arg"), rest[0], err)
+		}
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		return v, fmt.Errorf("unexpected argument %q", rest[0])
+	}
+	return v, nil
+}
+
+// A fieldValue is a flag.Value setting a struct field.
+type fieldValue struct {
+	v reflect.Value
+}
+
+func (f fieldValue) String() string {
+	if !f.v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(f.v.Interface())
+}
+
+func (f fieldValue) IsBoolFlag() bool {
+	return f.v.Kind() == reflect.Bool
+}
+
+// errParse is returned by fieldValue.Set for a malformed
+// value, as package flag does.
+var errParse = errors.New("parse error")
+
+func (f fieldValue) Set(s string) error {
+	if fv, ok := f.v.Addr().Interface().(flag.Value); ok {
+		return fv.Set(s)
+	}
+	var err error
+	switch {
+	case f.v.Type() == durType:
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		f.v.SetInt(int64(d))
+	case f.v.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		f.v.SetBool(b)
+	case f.v.Kind() == reflect.String:
+		f.v.SetString(s)
+	case f.v.Kind() == reflect.Int || f.v.Kind() == reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 0, 64)
+		f.v.SetInt(n)
+	case f.v.Kind() == reflect.Uint:
+		var n uint64
+		n, err = strconv.ParseUint(s, 0, 64)
+		f.v.SetUint(n)
+	case f.v.Kind() == reflect.Float64:
+		var x float64
+		x, err = strconv.ParseFloat(s, 64)
+		f.v.SetFloat(x)
+	}
+	if err != nil {
+		return errParse
+	}
+	return nil
+}
+
+// splitWords splits s into words separated by spaces, tabs and
+// newlines. As in rc, single quotes quote spaces, and a doubled
+// quote inside them stands for one.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	for {
+		s = strings.TrimLeft(s, " \t\n")
+		if s == "" {
+			return words, nil
+		}
+		var b strings.Builder
+		for s != "" && !strings.ContainsRune(" \t\n", rune(s[0])) {
+			if s[0] != '\'' {
+				b.WriteByte(s[0])
+				s = s[1:]
+				continue
+			}
+			s = s[1:]
+			for {
+				i := strings.IndexByte(s, '\'')
+				if i < 0 {
+					return nil, errors.New("unterminated quote")
+				}
+				b.WriteString(s[:i])
+				s = s[i+1:]
+				if !strings.HasPrefix(s, "'") {
+					break
+				}
+				b.WriteByte('\'')
+				s = s[1:]
+			}
+		}
+		words = append(words, b.String())
+	}
+}
+
+// usage returns the synopsis of c.
+func usage(c *command) string {
+	var b strings.Builder
+	b.WriteString(c.verb)
+	switch {
+	case c.arg == stringType:
+		b.WriteString(" [text]")
+	case c.arg == wordsType:
+		b.WriteString(" [word...]")
+	case c.arg != nil:
+		for i := 0; i < c.arg.NumField(); i++ {
+			f := c.arg.Field(i)
+			if name, ok := f.Tag.Lookup("flag"); ok {
+				if f.Type.Kind() == reflect.Bool {
+					fmt.Fprintf(&b, " [-%s]", name)
+				} else {
+					fmt.Fprintf(&b, " [-%s %s]", name, typeName(f.Type))
+				}
+			}
+		}
+		for i := 0; i < c.arg.NumField(); i++ {
+			f := c.arg.Field(i)
+			if name, ok := f.Tag.Lookup("arg"); ok {
+				if f.Type == wordsType {
+					fmt.Fprintf(&b, " [%s...]", name)
+				} else {
+					fmt.Fprintf(&b, " %s", name)
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+func typeName(t reflect.Type) string {
+	switch {
+	case t == durType:
+		return "duration"
+	case t.Kind() == reflect.Float64:
+		return "number"
+	case t.Kind() == reflect.Int || t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint:
+		return "int"
+	}
+	return "value"
+}
+
+// Help returns the text shown by the Help command: the synopsis
+// and description of each command and the descriptions of their
+// options and arguments.
+func (d *Dispatcher) Help() string {
+	var b strings.Builder
+	for _, name := range d.Commands() {
+		c := d.cmds[name]
+		b.WriteString(usage(c))
+		if doc := d.doc[name]; doc != "" {
+			b.WriteString("\n\t" + doc)
+		}
+		b.WriteString("\n")
+		if c.arg == nil || c.arg.Kind() != reflect.Struct {
+			continue
+		}
+		for i := 0; i < c.arg.NumField(); i++ {
+			f := c.arg.Field(i)
+			help := f.Tag.Get("help")
+			if help == "" {
+				continue
+			}
+			if name, ok := f.Tag.Lookup("flag"); ok {
+				fmt.Fprintf(&b, "\t-%s\t%s\n", name, help)
+			} else if name, ok := f.Tag.Lookup("arg"); ok {
+				fmt.Fprintf(&b, "\t%s\t%s\n", name, help)
+			}
+		}
+	}
+	return b.String()
+}
+
+func (d *Dispatcher) help() {
+	d.w.Err(d.Help())
+}
@@ -0,0 +1,145 @@
+package acme_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/acme/acmetest"
+)
+
+type grepHandler struct {
+	calls chan string
+}
+
+func (h *grepHandler) ExecGrep(opt struct {
+	Count   bool          `flag:"c" help:"only count matching lines"`
+	Max     int           `flag:"m" help:"stop after max matches"`
+	Wait    time.Duration `flag:"t"`
+	Pattern string        `arg:"pattern" help:"regular expression"`
+	Files   []string      `arg:"file"`
+}) error {
+	h.calls <- fmt.Sprintf("Grep %v %d %v %q %q", opt.Count, opt.Max, opt.Wait, opt.Pattern, opt.Files)
+	return nil
+}
+
+func (h *grepHandler) ExecRaw(e *acme.Event, text string) {
+	h.calls <- fmt.Sprintf("Raw %c %q", e.C2, text)
+}
+
+func (h *grepHandler) ExecWords(words []string) error {
+	h.calls <- fmt.Sprintf("Words %q", words)
+	return fmt.Errorf("failed")
+}
+
+func TestDispatcher(t *testing.T) {
+	a := acmetest.New()
+	fsys, err := a.Mount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.SetFsys(fsys)
+	dir := t.TempDir() // unique, since acme.Err reuses windows by name
+	fw := a.NewWindow(dir+"/win", "text\n")
+	w, err := acme.Open(fw.ID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetErrorPrefix(fw.Name())
+	h := &grepHandler{calls: make(chan string, 1)}
+	d, err := acme.NewDispatcher(w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Describe("Grep", "search files")
+	if err := d.SetTag(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(fw.Tag(), " Grep Help Raw Words ") {
+		t.Errorf("tag = %q", fw.Tag())
+	}
+	if err := w.OpenEvent(); err != nil {
+		t.Fatal(err)
+	}
+	go d.Loop()
+
+	call := func(want string) {
+		t.Helper()
+		select {
+		case got := <-h.calls:
+			if got != want {
+				t.Errorf("call = %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no call, want %s", want)
+		}
+	}
+	fw.Exec("Grep -c -m 3 -t 1s main a.go b.go")
+	call(`Grep true 3 1s "main" ["a.go" "b.go"]`)
+	fw.Exec("Grep 'two words'")
+	call(`Grep false 0 0s "two words" []`)
+	fw.Exec("Raw  some  text ")
+	call(`Raw x "some  text"`)
+	fw.Exec("Words a 'b''c'")
+	call(`Words ["a" "b'c"]`)
+
+	// A chorded argument follows the command's own text.
+	fw.SendEvent(acme.Event{C1: 'M', C2: 'x', Q0: 0, Q1: 4, Flag: 8, Text: []byte("Grep -c"), Arg: []byte("chorded")})
+	call(`Grep true 0 0s "chorded" []`)
+
+	errors := func() string {
+		for _, ew := range a.Windows() {
+			if ew.Name() == dir+"/+Errors" {
+				return ew.Body()
+			}
+		}
+		return ""
+	}
+	for _, tt := range []struct{ cmd, want string }{
+		{"Words x", "Words: failed\n"},
+		{"Grep", "Grep: missing pattern\nusage: Grep [-c] [-m int] [-t duration] pattern [file...]\n"},
+		{"Grep -m x p", "Grep: invalid value \"x\" for flag -m: parse error\nusage: Grep"},
+		{"Help me", "Help: takes no arguments\n"},
+		{"Help", "Grep [-c] [-m int] [-t duration] pattern [file...]\n\tsearch files\n\t-c\tonly count matching lines\n"},
+	} {
+		before := errors()
+		fw.Exec(tt.cmd)
+		if !a.Wait(5*time.Second, func() bool { return strings.Contains(errors()[len(before):], tt.want) }) {
+			t.Errorf("%s: +Errors has %q, want %q", tt.cmd, errors()[len(before):], tt.want)
+		}
+	}
+
+	fw.Exec("Other")
+	if !a.Wait(5*time.Second, func() bool { return len(fw.Returned()) == 1 }) {
+		t.Errorf("unknown command not returned to acme")
+	}
+}
+
+func TestDispatcherBadMethod(t *testing.T) {
+	for _, h := range []interface{}{
+		&struct{ badResult }{},
+		&struct{ badArgs }{},
+		&struct{ badField }{},
+	} {
+		if _, err := acme.NewDispatcher(nil, h); err == nil {
+			t.Errorf("NewDispatcher(%T) succeeded", h)
+		}
+	}
+}
+
+type badResult struct{}
+
+func (badResult) ExecX() int { return 0 }
+
+type badArgs struct{}
+
+func (badArgs) ExecX(a, b string) {}
+
+type badField struct{}
+
+func (badField) ExecX(struct {
+	M map[string]int `flag:"m"`
+}) {
+}
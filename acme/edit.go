@@ -0,0 +1,229 @@
+package acme
+
+import (
+	"bytes"
+	"sort"
+	"unicode/utf8"
+)
+
+// An Edit replaces the text between rune offsets Q0 and Q1
+// of a window body with Text.
+type Edit struct {
+	Q0, Q1 int
+	Text   []byte
+}
+
+// ApplyEdits applies edits to the window body as a single change,
+// which one Undo reverts. The offsets of every edit refer to the
+// body as it is before any of them is applied, and the edits must
+// not overlap. Text outside the edits is not rewritten, so dot
+// and the scroll position survive.
+func (w *Win) ApplyEdits(edits []Edit) error {
+	if len(edits) == 0 {
+		return nil
+	}
+	edits = append([]Edit(nil), edits...)
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Q0 < edits[j].Q0 })
+	if err := w.Mark(); err != nil {
+		return err
+	}
+	if err := w.NoMark(); err != nil {
+		return err
+	}
+	// Apply from the end of the body backward
+	// so that earlier offsets stay valid. Insertions at the
+	// same offset end up in the order they were given.
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		if err := w.SetAddr(CharAddr(e.Q0).To(CharAddr(e.Q1))); err != nil {
+			w.Mark()
+			return err
+		}
+		if _, err := w.Write("data", e.Text); err != nil {
+			w.Mark()
+			return err
+		}
+	}
+	return w.Mark()
+}
+
+// ReplaceBody changes the window body to new, rewriting only
+// the lines that differ, as a single change that one Undo reverts.
+func (w *Win) ReplaceBody(new []byte) error {
+	old, err := w.ReadAll("body")
+	if err != nil {
+		return err
+	}
+	return w.ApplyEdits(LineEdits(old, new))
+}
+
+// LineEdits returns edits, in order, that turn old into new
+// by replacing whole lines. Offsets are in runes, as acme counts them.
+func LineEdits(old, new []byte) []Edit {
+	a := splitLines(old)
+	b := splitLines(new)
+
+	// Runes before each line of old.
+	pos := make([]int, len(a)+1)
+	for i, l := range a {
+		pos[i+1] = pos[i] + utf8.RuneCount(l)
+	}
+
+	var edits []Edit
+	i, j := 0, 0
+	for _, op := range diffLines(a, b) {
+		if op.i == i && op.j == j {
+			i, j = op.i+op.n, op.j+op.n
+			continue
+		}
+		edits = append(edits, Edit{Q0: pos[i], Q1: pos[op.i], Text: bytes.Join(b[j:op.j], nil)})
+		i, j = op.i+op.n, op.j+op.n
+	}
+	return edits
+}
+
+// splitLines splits text after each newline.
+// A final line without a newline is a line of its own.
+func splitLines(text []byte) [][]byte {
+	var lines [][]byte
+	for len(text) > 0 {
+		i := bytes.IndexByte(text, '\n') + 1
+		if i == 0 {
+			i = len(text)
+		}
+		lines = append(lines, text[:i])
+		text = text[i:]
+	}
+	return lines
+}
+
+// A match says that a[i:i+n] equals b[j:j+n].
+type match struct {
+	i, j, n int
+}
+
+// diffLines returns the runs of lines common to a and b,
+// in order, ending with an empty run at the end of both.
+// It uses the linear-space version of Myers's O(ND) algorithm,
+// which finds the middle snake of the shortest edit script
+// and recurses on the text before and after it.
+func diffLines(a, b [][]byte) []match {
+	// Compare lines by number rather than by content.
+	ids := make(map[string]int)
+	number := func(lines [][]byte) []int {
+		x := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[string(l)]
+			if !ok {
+				id = len(ids)
+				ids[string(l)] = id
+			}
+			x[i] = id
+		}
+		return x
+	}
+	n := len(a) + len(b)
+	d := &differ{
+		a:   number(a),
+		b:   number(b),
+		off: 2*n + 2,
+		vf:  make([]int, 4*n+5),
+		vb:  make([]int, 4*n+5),
+	}
+	d.compare(0, len(a), 0, len(b))
+	return append(d.ms, match{len(a), len(b), 0})
+}
+
+// A differ holds the state of diffLines.
+type differ struct {
+	a, b   []int
+	off    int   // index of diagonal 0 in vf and vb
+	vf, vb []int // furthest reaching x on each diagonal, forward and backward
+	ms     []match
+}
+
+// add appends the match of a[i:i+n] and b[j:j+n],
+// joining it to the previous one if they abut.
+func (d *differ) add(i, j, n int) {
+	if n == 0 {
+		return
+	}
+	if k := len(d.ms) - 1; k >= 0 && d.ms[k].i+d.ms[k].n == i && d.ms[k].j+d.ms[k].n == j {
+		d.ms[k].n += n
+		return
+	}
+	d.ms = append(d.ms, match{i, j, n})
+}
+
+// compare adds the runs common to a[alo:ahi] and b[blo:bhi].
+func (d *differ) compare(alo, ahi, blo, bhi int) {
+	pre := 0
+	for alo+pre < ahi && blo+pre < bhi && d.a[alo+pre] == d.b[blo+pre] {
+		pre++
+	}
+	d.add(alo, blo, pre)
+	alo, blo = alo+pre, blo+pre
+	suf := 0
+	for alo < ahi-suf && blo < bhi-suf && d.a[ahi-1-suf] == d.b[bhi-1-suf] {
+		suf++
+	}
+	if alo < ahi-suf && blo < bhi-suf {
+		// With the ends trimmed the edit script is at least
+		// two long, so both halves are smaller than the whole.
+		x0, y0, x1, y1 := d.middle(alo, ahi-suf, blo, bhi-suf)
+		d.compare(alo, x0, blo, y0)
+		d.add(x0, y0, x1-x0)
+		d.compare(x1, ahi-suf, y1, bhi-suf)
+	}
+	d.add(ahi-suf, bhi-suf, suf)
+}
+
+// middle returns the middle snake, from (x0, y0) to (x1, y1),
+// of a shortest edit script turning a[alo:ahi] into b[blo:bhi].
+// Diagonal k holds the points with x-y == k, relative to (alo, blo).
+func (d *differ) middle(alo, ahi, blo, bhi int) (x0, y0, x1, y1 int) {
+	n, m := ahi-alo, bhi-blo
+	delta := n - m
+	odd := delta&1 != 0
+	vf, vb, off := d.vf, d.vb, d.off
+	vf[off+1] = 0
+	vb[off+delta+1] = n + 1
+	for D := 0; D <= (n+m+1)/2; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || k != D && vf[off+k-1] < vf[off+k+1] {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[alo+x] == d.b[blo+y] {
+				x, y = x+1, y+1
+			}
+			vf[off+k] = x
+			if odd && k >= delta-(D-1) && k <= delta+(D-1) && x >= vb[off+k] {
+				return alo + sx, blo + sy, alo + x, blo + y
+			}
+		}
+		for j := -D; j <= D; j += 2 {
+			k := delta + j
+			var x int
+			if j == -D || j != D && vb[off+k+1]-1 < vb[off+k-1] {
+				x = vb[off+k+1] - 1
+			} else {
+				x = vb[off+k-1]
+			}
+			y := x - k
+			ex, ey := x, y
+			for x > 0 && y > 0 && d.a[alo+x-1] == d.b[blo+y-1] {
+				x, y = x-1, y-1
+			}
+			vb[off+k] = x
+			if !odd && k >= -D && k <= D && x <= vf[off+k] {
+				return alo + x, blo + y, alo + ex, blo + ey
+			}
+		}
+	}
+	panic("acme: no middle snake")
+}
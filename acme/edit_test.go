@@ -0,0 +1,154 @@
+package acme_test
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/acme/acmetest"
+)
+
+// apply applies edits to old the way ApplyEdits does in a window.
+func apply(old string, edits []acme.Edit) string {
+	r := []rune(old)
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		r = append(r[:e.Q0], append([]rune(string(e.Text)), r[e.Q1:]...)...)
+	}
+	return string(r)
+}
+
+func TestLineEdits(t *testing.T) {
+	tests := []struct {
+		old, new string
+		n        int
+	}{
+		{"", "", 0},
+		{"a\n", "a\n", 0},
+		{"", "a\n", 1},
+		{"a\n", "", 1},
+		{"a\nb\nc\n", "a\nB\nc\n", 1},
+		{"a\nb\nc\n", "a\nc\n", 1},
+		{"a\nb", "a\nb\n", 1},
+		{"a\nb\nc\nd\ne\n", "A\nb\nc\nd\nE\n", 2},
+		{"α\nβ\nγ\n", "α\nβ2\nγ\n", 1},
+	}
+	for _, tt := range tests {
+		edits := acme.LineEdits([]byte(tt.old), []byte(tt.new))
+		if got := apply(tt.old, edits); got != tt.new || len(edits) != tt.n {
+			t.Errorf("LineEdits(%q, %q) = %d edits giving %q, want %d giving %q", tt.old, tt.new, len(edits), got, tt.n, tt.new)
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	words := []string{"a\n", "b\n", "c\n", "αβ\n", "x"}
+	gen := func() string {
+		var b strings.Builder
+		for n := rnd.Intn(20); n > 0; n-- {
+			b.WriteString(words[rnd.Intn(len(words))])
+		}
+		return b.String()
+	}
+	for i := 0; i < 1000; i++ {
+		old, new := gen(), gen()
+		edits := acme.LineEdits([]byte(old), []byte(new))
+		if got := apply(old, edits); got != new {
+			t.Fatalf("LineEdits(%q, %q) gives %q", old, new, got)
+		}
+		kept := lines(old)
+		for _, e := range edits {
+			kept -= lines(string([]rune(old)[e.Q0:e.Q1]))
+		}
+		if want := lcs(strings.SplitAfter(old, "\n"), strings.SplitAfter(new, "\n")); kept != want {
+			t.Fatalf("LineEdits(%q, %q) keeps %d lines, want %d", old, new, kept, want)
+		}
+	}
+}
+
+// lines counts the lines in s, including a final partial one.
+func lines(s string) int {
+	n := strings.Count(s, "\n")
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	return n
+}
+
+// lcs returns the length of the longest common subsequence
+// of the non-empty strings in a and b.
+func lcs(a, b []string) int {
+	if a[len(a)-1] == "" {
+		a = a[:len(a)-1]
+	}
+	if b[len(b)-1] == "" {
+		b = b[:len(b)-1]
+	}
+	l := make([][]int, len(a)+1)
+	for i := range l {
+		l[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				l[i][j] = l[i+1][j+1] + 1
+			} else {
+				l[i][j] = max(l[i+1][j], l[i][j+1])
+			}
+		}
+	}
+	return l[0][0]
+}
+
+func TestLineEditsLarge(t *testing.T) {
+	// A reindent changes every line.
+	var old, new strings.Builder
+	for i := 0; i < 6000; i++ {
+		fmt.Fprintf(&old, "\tline %d\n", i)
+		fmt.Fprintf(&new, "    line %d\n", i)
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	edits := acme.LineEdits([]byte(old.String()), []byte(new.String()))
+	runtime.ReadMemStats(&after)
+	if got := apply(old.String(), edits); got != new.String() {
+		t.Fatalf("LineEdits gives wrong text")
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 16<<20 {
+		t.Errorf("LineEdits allocated %d bytes", n)
+	}
+}
+
+func TestReplaceBody(t *testing.T) {
+	a := acmetest.New()
+	fsys, err := a.Mount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.SetFsys(fsys)
+
+	fw := a.NewWindow("/tmp/x.go", "package x\n\nfunc  f() {\n}\n\nvar  v = 1\n")
+	w, err := acme.Open(fw.ID(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.CloseFiles()
+	// Select "package" so we can check that dot survives.
+	fw.Select(0, 7)
+
+	want := "package x\n\nfunc f() {\n}\n\nvar v = 1\n"
+	if err := w.ReplaceBody([]byte(want)); err != nil {
+		t.Fatal(err)
+	}
+	if got := fw.Body(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if q0, q1 := fw.Dot(); q0 != 0 || q1 != 7 {
+		t.Errorf("dot = %d,%d, want 0,7", q0, q1)
+	}
+	if ctls := strings.Join(fw.Ctls(), " "); ctls != "mark nomark mark" {
+		t.Errorf("ctl messages = %q", ctls)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"plramos.win/9fans/acme"
+)
+
+// A target is a file to edit and where to put dot in it.
+type target struct {
+	file string       // absolute file name
+	addr acme.Address // zero to leave dot alone
+}
+
+// parseArgs parses the command line as $EDITOR callers write it.
+// An argument +n, +/regexp or + (for the last line) applies to the
+// following file, as in vi. A file argument may carry an address
+// after a colon, such as file:12, file:12:5 (line and column) or
+// file:/regexp/, unless a file exists by the full name.
+func parseArgs(args []string) ([]target, error) {
+	var targets []target
+	var next acme.Address
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "+") {
+			a, err := plusAddr(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+			next = a
+			continue
+		}
+		file, addr := splitAddr(arg)
+		if next.String() != "" {
+			addr, next = next, acme.Address{}
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target{abs, addr})
+	}
+	return targets, nil
+}
+
+// plusAddr returns the address for a +arg argument, given without the +.
+func plusAddr(s string) (acme.Address, error) {
+	switch {
+	case s == "":
+		return acme.End().Minus(acme.LineAddr(1)), nil
+	case strings.HasPrefix(s, "/"):
+		a := acme.RegexpAddr(s[1:])
+		return a, a.Err()
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return acme.Address{}, fmt.Errorf("bad line number +%s", s)
+	}
+	return acme.LineAddr(n), nil
+}
+
+// splitAddr splits a file:addr argument at the first colon
+// followed by a valid address.
+func splitAddr(arg string) (file string, addr acme.Address) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, acme.Address{}
+	}
+	for i := 0; i < len(arg); i++ {
+		if arg[i] != ':' {
+			continue
+		}
+		file, rest := arg[:i], arg[i+1:]
+		if line, col, ok := strings.Cut(rest, ":"); ok {
+			l, err1 := strconv.Atoi(line)
+			c, err2 := strconv.Atoi(col)
+			if err1 == nil && err2 == nil && l > 0 && c > 0 {
+				return file, acme.LineAddr(l - 1).Plus(acme.CharAddr(c - 1))
+			}
+		}
+		if a, err := acme.ParseAddress(rest); err == nil {
+			return file, a
+		}
+	}
+	// Not an address: a file name with colons in it.
+	return arg, acme.Address{}
+}
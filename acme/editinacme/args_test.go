@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "odd:1"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	var args []string
+	for _, arg := range []string{
+		"+12", "a",
+		"b:7",
+		"c:3:5",
+		"d:/func main/",
+		"+/x y", "e:9",
+		"+", "f",
+		"odd:1",
+		"new:file",
+		"sub/g.txt",
+	} {
+		if arg[0] != '+' {
+			arg = dir + "/" + arg
+		}
+		args = append(args, arg)
+	}
+	targets, err := parseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ file, addr string }{
+		{"a", "12"},
+		{"b", "7"},
+		{"c", "2+#4"},
+		{"d", "/func main/"},
+		{"e", "/x y/"},
+		{"f", "$-1"},
+		{"odd:1", ""},
+		{"new:file", ""},
+		{"sub/g.txt", ""},
+	}
+	if len(targets) != len(want) {
+		t.Fatalf("got %d targets, want %d", len(targets), len(want))
+	}
+	for i, w := range want {
+		tg := targets[i]
+		if tg.file != filepath.Join(dir, w.file) || tg.addr.String() != w.addr {
+			t.Errorf("target %d = %s %q, want %s %q", i, tg.file, tg.addr, w.file, w.addr)
+		}
+	}
+
+	for _, bad := range []string{"+x", "+-1", "+/("} {
+		if _, err := parseArgs([]string{bad, "a"}); err == nil {
+			t.Errorf("parseArgs(%q) succeeded", bad)
+		}
+	}
+}
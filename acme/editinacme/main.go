@@ -6,77 +6,127 @@
 //
 // Usage:
 //
-//	editinacme <file1> [<file2>...]
+//	editinacme [-a] [-p] [+line] <file1>[:addr] [[+line] <file2>[:addr]...]
 //
 // Editinacme uses the plumber to ask acme to open the file,
 // waits until the file's acme window is deleted, and exits.
+//
+// An argument +n puts dot on line n of the following file, as in vi;
+// +/regexp puts it on the next match of regexp, and + on the last line.
+// A file may also be followed by a colon and an address: file:12,
+// file:12:5 for line 12, column 5, or any acme address such as
+// file:/regexp/. Both forms are what git, crontab and other programs
+// that run $EDITOR pass.
+//
+// With the -p option, editinacme returns as soon as the file is Put,
+// leaving the window open, rather than waiting for it to be deleted.
+// With -a, it opens all the files at once and waits for all of them;
+// otherwise it opens and waits for one at a time.
+//
+// Editinacme exits with a non-zero status if a file was left empty,
+// which git commit takes as an aborted commit message.
 package main
 
 import (
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"strings"
 
 	"plramos.win/9fans/acme"
 )
 
-var openall = flag.Bool("a", false, "Open all files at once, otherwise it will open one at a time")
+var (
+	openall = flag.Bool("a", false, "Open all files at once, otherwise it will open one at a time")
+	waitPut = flag.Bool("p", false, "Return once each file is Put, instead of when its window is deleted")
+)
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("editinacme: ")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: editinacme file1 [file2]...\n")
+		fmt.Fprintf(os.Stderr, "usage: editinacme [-a] [-p] [+line] file1[:addr] [[+line] file2[:addr]]...\n")
 		os.Exit(2)
 	}
 	flag.Parse()
 
-	r, err := acme.Log()
+	targets, err := parseArgs(flag.Args())
 	if err != nil {
 		log.Fatal(err)
 	}
-	var files = make(map[string]struct{})
-	for _, file := range flag.Args() {
-		fullpath, err := filepath.Abs(file)
-		if err != nil {
-			log.Fatal(err)
-		}
-		file = fullpath
+	if len(targets) == 0 {
+		flag.Usage()
+	}
 
-		log.Printf("editing %s", file)
+	wt, err := acme.Watch("")
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		out, err := exec.Command("plumb", "-d", "edit", file).CombinedOutput()
-		if err != nil {
-			log.Fatalf("executing plumb: %v\n%s", err, out)
+	var files = make(map[string]bool)
+	empty := false
+	for i, t := range targets {
+		log.Printf("editing %s", t.file)
+		plumbFile(t)
+		files[t.file] = true
+		if *openall && i < len(targets)-1 {
+			continue
 		}
-		if !*openall {
-			for {
-				ev, err := r.Read()
-				if err != nil {
-					log.Fatalf("reading acme log: %v", err)
-				}
-				if ev.Op == "del" && ev.Name == file {
-					break
-				}
-			}
-		} else {
-			files[file] = struct{}{}
+		wait(wt, files)
+	}
+	for _, t := range targets {
+		if isEmpty(t.file) {
+			log.Printf("%s left empty", t.file)
+			empty = true
 		}
 	}
+	if empty {
+		os.Exit(1)
+	}
+}
+
+// plumbFile asks acme to open the target file with dot at its address.
+func plumbFile(t target) {
+	args := []string{"-d", "edit"}
+	if a := t.addr.String(); a != "" {
+		args = append(args, "-a", "addr="+quote(a))
+	}
+	args = append(args, t.file)
+	out, err := exec.Command("plumb", args...).CombinedOutput()
+	if err != nil {
+		log.Fatalf("executing plumb: %v\n%s", err, out)
+	}
+}
 
+// quote quotes an attribute value for plumb if it needs it.
+func quote(s string) string {
+	if !strings.ContainsAny(s, " \t'") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// wait waits until each of files has been deleted or, with -p,
+// Put, removing them from files as they are.
+func wait(wt *acme.Watcher, files map[string]bool) {
 	for len(files) > 0 {
-		ev, err := r.Read()
-		if err != nil && err != io.EOF {
-			log.Fatalf("reading acme log: %v", err)
-		} else if err == io.EOF {
+		ev, ok := <-wt.Events()
+		if !ok {
+			if err := wt.Err(); err != nil {
+				log.Fatalf("reading acme log: %v", err)
+			}
 			os.Exit(0)
 		}
-		if _, found := files[ev.Name]; ev.Op == "del" && found {
+		if files[ev.Name] && (ev.Op == acme.WatchDel || *waitPut && ev.Op == acme.WatchPut) {
 			delete(files, ev.Name)
 		}
 	}
 }
+
+// isEmpty reports whether the file holds nothing but white space.
+func isEmpty(file string) bool {
+	data, err := os.ReadFile(file)
+	return err != nil || len(strings.TrimSpace(string(data))) == 0
+}
@@ -0,0 +1,267 @@
+package acme
+
+import (
+	"bufio"
+	"context"
+	"path"
+	"sync"
+
+	"plramos.win/9fans/plan9/client"
+)
+
+// A WatchOp says what a WatchEvent reports.
+type WatchOp int
+
+const (
+	WatchNew      WatchOp = iota + 1 // a window was created, by New or Zerox
+	WatchFocus                       // a window was focused
+	WatchGet                         // a window's file was read
+	WatchPut                         // a window's file was written
+	WatchDel                         // a window was deleted
+	WatchWinEvent                    // an attached window had an Event
+)
+
+var watchOps = map[string]WatchOp{
+	"new":   WatchNew,
+	"zerox": WatchNew,
+	"focus": WatchFocus,
+	"get":   WatchGet,
+	"put":   WatchPut,
+	"del":   WatchDel,
+}
+
+func (op WatchOp) String() string {
+	switch op {
+	case WatchNew:
+		return "new"
+	case WatchFocus:
+		return "focus"
+	case WatchGet:
+		return "get"
+	case WatchPut:
+		return "put"
+	case WatchDel:
+		return "del"
+	case WatchWinEvent:
+		return "event"
+	}
+	return "WatchOp(?)"
+}
+
+// A WatchEvent is an event delivered by a Watcher.
+type WatchEvent struct {
+	Op   WatchOp
+	ID   int    // window id
+	Name string // window name, when the event happened
+	Win  *Win   // the attached window, or nil if the window is not attached
+
+	// Win must not be used once its window is deleted
+	// or the Watcher is closed.
+
+	// For WatchWinEvent, the event read from the window's event file.
+	// As with EventLoop, the receiver must give execute and look
+	// events it does not handle back to acme with Win.WriteEvent.
+	Event *Event
+}
+
+// A Watcher merges the acme log with the events of every window
+// whose name matches a pattern, attaching to such windows as they
+// appear. While a window is attached, acme sends its events to the
+// Watcher instead of handling them itself.
+//
+// Events are delivered on an unbuffered channel: until the receiver
+// takes an event, the Watcher reads no more from that window or from
+// the log, and acme holds further events back.
+type Watcher struct {
+	pattern string
+	c       chan WatchEvent
+	log     *LogReader
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	done    chan struct{}
+
+	mu   sync.Mutex
+	wins map[int]*Win
+	err  error
+}
+
+// Watch starts watching acme, attaching to existing and new windows
+// whose names match pattern, in the syntax of path.Match, either as a
+// whole or in their final element: "*.go" matches every Go file.
+// An empty pattern attaches to no windows, leaving only the log.
+func Watch(pattern string) (*Watcher, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	r, err := Log()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := Windows()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wt := &Watcher{
+		pattern: pattern,
+		c:       make(chan WatchEvent),
+		log:     r,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		wins:    make(map[int]*Win),
+	}
+	wt.wg.Add(1)
+	for _, info := range infos {
+		wt.attach(info.ID, info.Name)
+	}
+	go wt.readLog()
+	go func() {
+		wt.wg.Wait()
+		r.Close()
+		close(wt.c)
+		close(wt.done)
+	}()
+	return wt, nil
+}
+
+// Events returns the channel on which the Watcher delivers events.
+// It is closed once the Watcher stops.
+func (wt *Watcher) Events() <-chan WatchEvent {
+	return wt.c
+}
+
+// Close stops the Watcher and detaches from every window, giving
+// back to acme any execute or look events not yet delivered.
+// It returns once the Watcher has stopped.
+func (wt *Watcher) Close() error {
+	wt.cancel()
+	<-wt.done
+	return nil
+}
+
+// Err returns the error that stopped the Watcher, if it stopped
+// on its own, for instance because acme exited.
+func (wt *Watcher) Err() error {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	return wt.err
+}
+
+func (wt *Watcher) matches(name string) bool {
+	if wt.pattern == "" || name == "" {
+		return false
+	}
+	ok, _ := path.Match(wt.pattern, name)
+	if !ok {
+		ok, _ = path.Match(wt.pattern, path.Base(name))
+	}
+	return ok
+}
+
+// send delivers e, reporting false if the Watcher was closed first.
+func (wt *Watcher) send(e WatchEvent) bool {
+	select {
+	case wt.c <- e:
+		return true
+	case <-wt.ctx.Done():
+		return false
+	}
+}
+
+func (wt *Watcher) readLog() {
+	defer wt.wg.Done()
+	for {
+		n, err := wt.log.f.ReadContext(wt.ctx, wt.log.buf[:])
+		if err != nil {
+			if wt.ctx.Err() == nil {
+				wt.mu.Lock()
+				wt.err = err
+				wt.mu.Unlock()
+				wt.cancel()
+			}
+			return
+		}
+		ev, err := parseLogEvent(wt.log.buf[:n])
+		if err != nil {
+			continue
+		}
+		op := watchOps[ev.Op]
+		if op == 0 {
+			continue
+		}
+		var w *Win
+		if op != WatchDel {
+			w = wt.attach(ev.ID, ev.Name)
+		}
+		if !wt.send(WatchEvent{Op: op, ID: ev.ID, Name: ev.Name, Win: w}) {
+			return
+		}
+	}
+}
+
+// attach returns the attached window with the given id,
+// attaching to it first if its name matches.
+func (wt *Watcher) attach(id int, name string) *Win {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	if w := wt.wins[id]; w != nil {
+		return w
+	}
+	if !wt.matches(name) || wt.ctx.Err() != nil {
+		return nil
+	}
+	w, err := Open(id, nil)
+	if err != nil {
+		return nil
+	}
+	if err := w.OpenEvent(); err != nil {
+		w.CloseFiles()
+		w.drop()
+		return nil
+	}
+	w.name = name
+	w.ebuf = bufio.NewReader(ctxReader{wt.ctx, w.event})
+	wt.wins[id] = w
+	wt.wg.Add(1)
+	go wt.readEvents(w, name)
+	return w
+}
+
+func (wt *Watcher) readEvents(w *Win, name string) {
+	defer wt.wg.Done()
+	defer func() {
+		wt.mu.Lock()
+		delete(wt.wins, w.id)
+		wt.mu.Unlock()
+		w.CloseFiles()
+		w.drop()
+	}()
+	for {
+		e, err := w.ReadEvent()
+		if err != nil {
+			// The window is gone or the Watcher is closed.
+			return
+		}
+		if !wt.send(WatchEvent{Op: WatchWinEvent, ID: w.id, Name: name, Win: w, Event: e}) {
+			switch e.C2 {
+			case 'x', 'X', 'l', 'L':
+				w.WriteEvent(e)
+			}
+			return
+		}
+	}
+}
+
+// A ctxReader reads from an acme file until ctx is done,
+// abandoning any read then in progress.
+type ctxReader struct {
+	ctx context.Context
+	f   *client.Fid
+}
+
+func (r ctxReader) Read(b []byte) (int, error) {
+	return r.f.ReadContext(r.ctx, b)
+}
@@ -0,0 +1,95 @@
+package acme_test
+
+import (
+	"testing"
+	"time"
+
+	"plramos.win/9fans/acme"
+	"plramos.win/9fans/acme/acmetest"
+)
+
+func TestWatcher(t *testing.T) {
+	a := acmetest.New()
+	fsys, err := a.Mount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.SetFsys(fsys)
+	goWin := a.NewWindow("/tmp/w/a.go", "package a\n")
+	txtWin := a.NewWindow("/tmp/w/b.txt", "text\n")
+
+	wt, err := acme.Watch("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wt.Close()
+
+	// next returns the next event for window id with the given op,
+	// skipping others, since log and window events interleave.
+	next := func(op acme.WatchOp, id int) acme.WatchEvent {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case e, ok := <-wt.Events():
+				if !ok {
+					t.Fatalf("watcher stopped: %v", wt.Err())
+				}
+				if e.Op == op && e.ID == id {
+					return e
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %v in window %d", op, id)
+			}
+		}
+	}
+
+	goWin.Exec("Hi")
+	e := next(acme.WatchWinEvent, goWin.ID())
+	if e.Win == nil || e.Name != "/tmp/w/a.go" || string(e.Event.Text) != "Hi" {
+		t.Fatalf("event = %+v", e)
+	}
+	e.Win.WriteEvent(e.Event)
+	if !a.Wait(5*time.Second, func() bool { return len(goWin.Returned()) == 1 }) {
+		t.Errorf("written-back event not returned to acme")
+	}
+
+	// Windows that don't match are reported from the log only.
+	txtWin.Focus()
+	if e := next(acme.WatchFocus, txtWin.ID()); e.Win != nil {
+		t.Errorf("non-matching window attached")
+	}
+	txtWin.Exec("Hi")
+	if !a.Wait(5*time.Second, func() bool { return len(txtWin.Returned()) == 1 }) {
+		t.Errorf("event in unattached window not handled by acme")
+	}
+
+	// New matching windows are attached.
+	newWin := a.NewWindow("/tmp/w/c.go", "package c\n")
+	if e := next(acme.WatchNew, newWin.ID()); e.Win == nil {
+		t.Errorf("new window not attached")
+	}
+	newWin.Type(0, "x")
+	if e := next(acme.WatchWinEvent, newWin.ID()); e.Event.C2 != 'I' || string(e.Event.Text) != "x" {
+		t.Errorf("typing event = %+v", e.Event)
+	}
+
+	goWin.Delete()
+	next(acme.WatchDel, goWin.ID())
+
+	// An event not yet delivered when the Watcher closes
+	// goes back to acme, and acme handles later ones itself.
+	newWin.Exec("Pending")
+	time.Sleep(10 * time.Millisecond) // let the Watcher read it
+	wt.Close()
+	if !a.Wait(5*time.Second, func() bool { return len(newWin.Returned()) == 1 }) {
+		t.Errorf("pending event not given back: %+v", newWin.Returned())
+	}
+	newWin.Exec("Later")
+	if !a.Wait(5*time.Second, func() bool { return len(newWin.Returned()) == 2 }) {
+		t.Errorf("event after Close not handled by acme")
+	}
+	if _, ok := <-wt.Events(); ok {
+		t.Errorf("Events not closed after Close")
+	}
+}
@@ -0,0 +1,86 @@
+// 9pserve multiplexes 9P client connections onto a single server.
+//
+// Usage:
+//
+//	9pserve [-v] [-M msize] [-u] address
+//
+// 9pserve speaks 9P with a server on its standard input and output,
+// listens on address for clients, and multiplexes their requests onto
+// the server connection, renumbering tags and fids as needed.
+// The address is a Plan 9 dial string such as "unix!/tmp/sock" or
+// "tcp!*!564", a path to a unix socket, or a bare service name,
+// in which case the socket is created in the name space directory
+// (see client.Namespace).
+//
+// Unlike plan9port's 9pserve, it does not fork into the background.
+// The -u flag is accepted for compatibility and ignored.
+package main // import "plramos.win/9fans/cmd/9pserve"
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"plramos.win/9fans/plan9/dial"
+	"plramos.win/9fans/plan9/mux"
+)
+
+var (
+	verbose = flag.Bool("v", false, "log all messages to standard error")
+	_       = flag.Uint("M", 0, "ignored; the server's message size is used")
+	_       = flag.Bool("u", false, "ignored")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: 9pserve [-v] [-M msize] [-u] address\n")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("9pserve: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+
+	srv := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	m, err := mux.New(srv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m.Chatty = *verbose
+
+	addr := flag.Arg(0)
+	var l net.Listener
+	if strings.ContainsAny(addr, "!/") {
+		var a dial.Addr
+		if a, err = dial.Parse(addr, "9fs"); err == nil {
+			if a.Net == "unix" {
+				os.Remove(a.Host)
+			}
+			if l, err = dial.Listen(addr, "9fs"); err == nil {
+				go m.Serve(l)
+			}
+		}
+	} else {
+		l, err = m.Post(addr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer l.Close()
+
+	m.Wait()
+	if err := m.Err(); err != nil && err != io.EOF {
+		log.Fatal(err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	_ "runtime"
 	"strconv"
 	"strings"
@@ -16,6 +17,7 @@ import (
 
 	"plramos.win/9fans/cmd/acme/internal/adraw"
 	"plramos.win/9fans/cmd/acme/internal/alog"
+	"plramos.win/9fans/cmd/acme/internal/autosave"
 	"plramos.win/9fans/cmd/acme/internal/disk"
 	dumppkg "plramos.win/9fans/cmd/acme/internal/dump"
 	editpkg "plramos.win/9fans/cmd/acme/internal/edit"
@@ -54,6 +56,10 @@ func main() {
 	loadfile := ""
 	winsize := ""
 
+	if home := os.Getenv("HOME"); home != "" {
+		autosave.Dir = filepath.Join(home, "acme.recover")
+	}
+
 	flag.Bool("D", false, "") // ignored
 	flag.BoolVar(&wind.GlobalAutoindent, "a", wind.GlobalAutoindent, "autoindent")
 	flag.BoolVar(&ui.Bartflag, "b", ui.Bartflag, "bartflag")
@@ -64,6 +70,8 @@ func main() {
 	flag.StringVar(&mtpt, "m", mtpt, "mtpt")
 	flag.BoolVar(&swapscrollbuttons, "r", swapscrollbuttons, "swapscrollbuttons")
 	flag.StringVar(&winsize, "W", winsize, "set window `size`")
+	flag.StringVar(&adraw.ThemeFile, "t", adraw.ThemeFile, "read colors from theme `file`")
+	flag.StringVar(&autosave.Dir, "R", autosave.Dir, "keep snapshots of modified windows in `dir` for recovery (empty for none)")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: acme [options] [files...]\n")
 		os.Exit(2)
@@ -137,12 +145,23 @@ func main() {
 	adraw.FontCache = make([]*adraw.RefFont, 1)
 	adraw.FontCache[0] = &adraw.RefFont1
 
+	if adraw.ThemeFile != "" {
+		th, err := adraw.ReadTheme(adraw.ThemeFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		adraw.CurTheme = *th
+		if abs, err := filepath.Abs(adraw.ThemeFile); err == nil {
+			adraw.ThemeFile = abs // for Theme after Local cd
+		}
+	}
 	adraw.Init()
 	// TODO timerinit()
 	regx.Init()
 
 	wind.OnWinclose = func(w *wind.Window) {
 		xfidlog(w, "del")
+		autosave.Remove(w.ID)
 	}
 	ui.OnNewWindow = func(w *wind.Window) {
 		xfidlog(w, "new")
@@ -215,6 +234,7 @@ func main() {
 			}
 		}
 	}
+	recoverwindows()
 	adraw.Display.Flush()
 
 	acmeerrorinit()
@@ -223,6 +243,7 @@ func main() {
 	go waitthread()
 	go xfidallocthread()
 	go newwindowthread()
+	go autosavethread()
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
@@ -235,6 +256,7 @@ func main() {
 	<-exec.Cexit
 	bigLock()
 	killprocs()
+	autosave.RemoveAll()
 	os.Exit(0)
 }
 
@@ -322,6 +344,7 @@ func acmeerrorinit() {
 	}
 	errorfd = r
 	erroutfd = w
+	exec.Errout = w
 	go acmeerrorproc()
 }
 
@@ -0,0 +1,188 @@
+// Snapshots of modified windows, for recovery after a crash.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"plramos.win/9fans/cmd/acme/internal/alog"
+	"plramos.win/9fans/cmd/acme/internal/autosave"
+	"plramos.win/9fans/cmd/acme/internal/enc"
+	"plramos.win/9fans/cmd/acme/internal/file"
+	"plramos.win/9fans/cmd/acme/internal/ui"
+	"plramos.win/9fans/cmd/acme/internal/wind"
+)
+
+// autosaved maps the id of each window with a snapshot
+// to the hash of what it holds.
+var autosaved = make(map[int]uint64)
+
+// snaphash hashes the body of s and the file time it
+// was checked against, which changes when the window is Put.
+func snaphash(s *autosave.Snapshot) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s.ModTime.Format(time.RFC3339Nano)))
+	h.Write(s.Body)
+	return h.Sum64()
+}
+
+// autosavethread snapshots the modified windows every autosave.Interval.
+func autosavethread() {
+	if autosave.Dir == "" {
+		return
+	}
+	lasterr := ""
+	for range time.Tick(autosave.Interval) {
+		bigLock()
+		msg := ""
+		if err := autosaverow(autosaved); err != nil {
+			msg = err.Error()
+		}
+		if msg != "" && msg != lasterr { // once, not every interval
+			alog.Printf("autosave: %s\n", msg)
+		}
+		lasterr = msg
+		bigUnlock()
+	}
+}
+
+// autosaverow writes snapshots of the modified windows whose text
+// has changed since the last ones and removes the snapshots of
+// windows that are no longer modified.
+func autosaverow(saved map[int]uint64) error {
+	var firsterr error
+	seen := make(map[int]bool)
+	files := make(map[*wind.File]bool)
+	for _, c := range wind.TheRow.Col {
+		for _, w := range c.W {
+			t := &w.Body
+			if w.IsDir || w.IsScratch || w.External || files[t.File] {
+				continue
+			}
+			if !t.File.Mod() && len(t.Cache) == 0 {
+				continue
+			}
+			files[t.File] = true // once for all zeroxes
+			seen[w.ID] = true
+			wind.Wincommit(w, t)
+			r := make([]rune, t.Len())
+			t.File.Read(0, r)
+			s := &autosave.Snapshot{
+				Name:  string(t.File.Name()),
+				Enc:   t.File.Enc.String(),
+				Saved: time.Now(),
+				Q0:    t.Q0,
+				Q1:    t.Q1,
+				Body:  []byte(string(r)),
+			}
+			if t.File.Info != nil {
+				s.ModTime = t.File.Info.ModTime()
+			}
+			if sum := snaphash(s); saved[w.ID] != sum {
+				if err := autosave.Write(w.ID, s); err != nil {
+					if firsterr == nil {
+						firsterr = err
+					}
+					continue
+				}
+				saved[w.ID] = sum
+			}
+		}
+	}
+	for id := range saved {
+		if !seen[id] {
+			autosave.Remove(id)
+			delete(saved, id)
+		}
+	}
+	return firsterr
+}
+
+// recoverwindows opens windows holding the snapshots left
+// by acme sessions that did not exit cleanly, unless the files
+// they were taken of have changed on disk since acme read them.
+// A snapshot of a file already open and unmodified replaces the
+// window's text; Undo brings back the file. Snapshots not recovered
+// are archived, so they are reported once.
+func recoverwindows() {
+	snaps, err := autosave.Orphans()
+	if err != nil {
+		alog.Printf("recover: %v\n", err)
+	}
+	if len(snaps) == 0 || len(wind.TheRow.Col) == 0 {
+		return
+	}
+	c := wind.TheRow.Col[len(wind.TheRow.Col)-1]
+	for _, s := range snaps {
+		var info os.FileInfo
+		if s.Name != "" {
+			info, _ = os.Stat(s.Name)
+		}
+		if s.Stale(info) || info != nil && info.IsDir() {
+			archive(s, "%s changed on disk since the text saved %s was read; not recovered", s.Name, s.Saved.Format("2006/01/02 15:04:05"))
+			continue
+		}
+		var w *wind.Window
+		if s.Name != "" {
+			w = ui.LookFile([]rune(s.Name))
+		}
+		if w != nil && (w.IsDir || w.Body.File.Mod()) {
+			archive(s, "%s already open and modified; not recovered", s.Name)
+			continue
+		}
+		var t *wind.Text
+		if w != nil {
+			t = &w.Body
+			file.Seq++
+			t.File.Mark()
+			wind.Textdelete(t, 0, t.Len(), true)
+		} else {
+			w = ui.ColaddAndMouse(c, nil, nil, -1)
+			t = &w.Body
+			if s.Name != "" {
+				wind.Winsetname(w, []rune(s.Name))
+			}
+			xfidlog(w, "new")
+		}
+		if e, err := enc.Parse(enc.Encoding{}, s.Enc); err == nil {
+			t.File.Enc = e
+		}
+		r := []rune(string(s.Body))
+		wind.Textinsert(t, 0, r, true)
+		// Put overwrites the file as it is now.
+		t.File.Info = info
+		t.File.Unread = false
+		t.File.SetMod(true)
+		w.Dirty = true
+		w.Putseq = -1
+		wind.Winsettag(w)
+		q0, q1 := s.Q0, s.Q1
+		if q0 < 0 || q0 > q1 || q1 > len(r) {
+			q0, q1 = 0, 0
+		}
+		wind.Textshow(t, q0, q1, true)
+		if err := autosave.Claim(s, w.ID); err != nil {
+			alog.Printf("recover: %v\n", err)
+		} else {
+			autosaved[w.ID] = snaphash(s)
+		}
+		name := s.Name
+		if name == "" {
+			name = "unnamed window"
+		}
+		alog.Printf("%s: recovered text saved %s; Put to keep it\n", name, s.Saved.Format("2006/01/02 15:04:05"))
+	}
+}
+
+// archive archives snapshot s and reports why it was not recovered.
+func archive(s *autosave.Snapshot, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if file, err := s.Archive(); err != nil {
+		alog.Printf("%s; %v\n", msg, err)
+	} else {
+		alog.Printf("%s; text kept in %s\n", msg, file)
+	}
+}
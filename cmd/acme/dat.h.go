@@ -31,6 +31,7 @@ const (
 	QWtag
 	QWxdata
 	QWindent
+	QWstyle
 	QMAX
 )
 
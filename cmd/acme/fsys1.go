@@ -80,7 +80,7 @@ var dirtab = [11]Dirtab{
 	{"new", plan9.QTDIR, Qnew, 0o500 | plan9.DMDIR},
 }
 
-var dirtabw = [13]Dirtab{
+var dirtabw = [14]Dirtab{
 	{".", plan9.QTDIR, Qdir, 0o500 | plan9.DMDIR},
 	{"addr", plan9.QTFILE, QWaddr, 0o600},
 	{"body", plan9.QTAPPEND, QWbody, 0o600 | plan9.DMAPPEND},
@@ -94,6 +94,7 @@ var dirtabw = [13]Dirtab{
 	{"tag", plan9.QTAPPEND, QWtag, 0o600 | plan9.DMAPPEND},
 	{"xdata", plan9.QTFILE, QWxdata, 0o600},
 	{"indent", plan9.QTFILE, QWindent, 0o600},
+	{"style", plan9.QTFILE, QWstyle, 0o600},
 }
 
 type Mnt struct {
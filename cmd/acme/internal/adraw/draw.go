@@ -24,21 +24,17 @@ var Button2Color *draw.Image
 
 var Button3Color *draw.Image
 
+// ScrollCol and ThumbCol draw the scroll bar of a window body.
+var ScrollCol, ThumbCol *draw.Image
+
+var modButtonCol *draw.Image
+
 func Init() {
-	if TagCols[frame.BACK] == nil {
-		// Blue
-		TagCols[frame.BACK] = Display.AllocImageMix(draw.PaleBlueGreen, draw.White)
-		TagCols[frame.HIGH], _ = Display.AllocImage(draw.Rect(0, 0, 1, 1), Display.ScreenImage.Pix, true, draw.PaleGreyGreen)
-		TagCols[frame.BORD], _ = Display.AllocImage(draw.Rect(0, 0, 1, 1), Display.ScreenImage.Pix, true, draw.PurpleBlue)
-		TagCols[frame.TEXT] = Display.Black
-		TagCols[frame.HTEXT] = Display.Black
-
-		// Yellow
-		TextCols[frame.BACK] = Display.AllocImageMix(draw.PaleYellow, draw.White)
-		TextCols[frame.HIGH], _ = Display.AllocImage(draw.Rect(0, 0, 1, 1), Display.ScreenImage.Pix, true, draw.DarkYellow)
-		TextCols[frame.BORD], _ = Display.AllocImage(draw.Rect(0, 0, 1, 1), Display.ScreenImage.Pix, true, draw.YellowGreen)
-		TextCols[frame.TEXT] = Display.Black
-		TextCols[frame.HTEXT] = Display.Black
+	if themeCols == nil {
+		allocTheme()
+	}
+	if modButtonCol == nil {
+		modButtonCol = allocColor(CurTheme.ModButton)
 	}
 
 	r := draw.Rect(0, 0, Scrollwid()+ButtonBorder(), Font.Height+1)
@@ -50,28 +46,23 @@ func Init() {
 		Button.Free()
 		ModButton.Free()
 		ColButton.Free()
+		Button2Color.Free()
+		Button3Color.Free()
 	}
 
 	Button, _ = Display.AllocImage(r, Display.ScreenImage.Pix, false, draw.NoFill)
-	Button.Draw(r, TagCols[frame.BACK], nil, r.Min)
-	r.Max.X -= ButtonBorder()
-	Button.Border(r, ButtonBorder(), TagCols[frame.BORD], draw.ZP)
+	DrawButton(Button, r, TagCols[:], false)
 
-	r = Button.R
 	ModButton, _ = Display.AllocImage(r, Display.ScreenImage.Pix, false, draw.NoFill)
-	ModButton.Draw(r, TagCols[frame.BACK], nil, r.Min)
-	r.Max.X -= ButtonBorder()
-	ModButton.Border(r, ButtonBorder(), TagCols[frame.BORD], draw.ZP)
-	r = r.Inset(ButtonBorder())
-	tmp, _ := Display.AllocImage(draw.Rect(0, 0, 1, 1), Display.ScreenImage.Pix, true, draw.MedBlue)
-	ModButton.Draw(r, tmp, nil, draw.ZP)
-	tmp.Free()
+	DrawButton(ModButton, r, TagCols[:], true)
 
-	r = Button.R
-	ColButton, _ = Display.AllocImage(r, Display.ScreenImage.Pix, false, draw.PurpleBlue)
+	ColButton, _ = Display.AllocImage(r, Display.ScreenImage.Pix, false, draw.NoFill)
+	tmp := allocColor(CurTheme.ColButton)
+	ColButton.Draw(r, tmp, nil, draw.ZP)
+	tmp.Free()
 
-	Button2Color, _ = Display.AllocImage(r, Display.ScreenImage.Pix, true, 0xAA0000FF)
-	Button3Color, _ = Display.AllocImage(r, Display.ScreenImage.Pix, true, 0x006600FF)
+	Button2Color = allocColor(CurTheme.Button2)
+	Button3Color = allocColor(CurTheme.Button3)
 }
 
 var BoxCursor = draw.Cursor{
@@ -0,0 +1,432 @@
+package adraw
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"plramos.win/9fans/draw"
+	"plramos.win/9fans/draw/frame"
+)
+
+// A Color is a theme color: Fill, or, if Base is set, Fill mixed
+// a quarter over Base, as Display.AllocImageMix does.
+// The zero Color is unset. The colors ParseColor returns
+// are set, even transparent black.
+type Color struct {
+	Fill, Base draw.Color
+	set        bool
+}
+
+// A Palette gives the colors of one kind of text.
+type Palette struct {
+	Back   Color // background
+	High   Color // selection background
+	Border Color // border
+	Text   Color // text
+	HText  Color // selected text
+}
+
+// A TextStyle gives the colors of a named style, which programs
+// apply to runs of body text through a window's style file.
+// An unset color leaves the body's own.
+type TextStyle struct {
+	Text Color
+	Back Color
+}
+
+// A Theme gives the colors acme draws with.
+// Unset colors in the Scroll and Thumb fields
+// follow the body border and background.
+type Theme struct {
+	Tag, Body Palette
+	Scroll    Color // scroll bar
+	Thumb     Color // scroll bar thumb
+	ModButton Color // inside of the button of a modified window
+	ColButton Color // column button
+	Button2   Color // sweeping with the middle button
+	Button3   Color // sweeping with the right button
+	Styles    map[string]TextStyle
+}
+
+// DefaultTheme is acme's traditional blue tags and yellow bodies.
+var DefaultTheme = Theme{
+	Tag: Palette{
+		Back:   Color{Fill: draw.PaleBlueGreen, Base: draw.White},
+		High:   Color{Fill: draw.PaleGreyGreen},
+		Border: Color{Fill: draw.PurpleBlue},
+		Text:   Color{Fill: draw.Black},
+		HText:  Color{Fill: draw.Black},
+	},
+	Body: Palette{
+		Back:   Color{Fill: draw.PaleYellow, Base: draw.White},
+		High:   Color{Fill: draw.DarkYellow},
+		Border: Color{Fill: draw.YellowGreen},
+		Text:   Color{Fill: draw.Black},
+		HText:  Color{Fill: draw.Black},
+	},
+	ModButton: Color{Fill: draw.MedBlue},
+	ColButton: Color{Fill: draw.PurpleBlue},
+	Button2:   Color{Fill: 0xAA0000FF},
+	Button3:   Color{Fill: 0x006600FF},
+	Styles: map[string]TextStyle{
+		"comment":   {Text: Color{Fill: draw.DarkGreen}},
+		"string":    {Text: Color{Fill: 0x990000FF}},
+		"keyword":   {Text: Color{Fill: draw.MedBlue}},
+		"type":      {Text: Color{Fill: draw.BlueGreen}},
+		"number":    {Text: Color{Fill: 0x880088FF}},
+		"error":     {Back: Color{Fill: 0xFFC8C8FF}},
+		"warning":   {Back: Color{Fill: 0xFFE8A0FF}},
+		"highlight": {Back: Color{Fill: 0xC8F0C8FF}},
+	},
+}
+
+// CurTheme is the theme in use.
+var CurTheme = DefaultTheme
+
+// ThemeFile is the file CurTheme was read from, if any.
+var ThemeFile string
+
+// color returns the field of th named by key,
+// or nil if there is none. If win is set, only
+// the colors a window can override are named.
+func (th *Theme) color(key string, win bool) *Color {
+	pal := func(p *Palette, key string) *Color {
+		switch key {
+		case "back":
+			return &p.Back
+		case "high":
+			return &p.High
+		case "border":
+			return &p.Border
+		case "text":
+			return &p.Text
+		case "htext":
+			return &p.HText
+		}
+		return nil
+	}
+	switch {
+	case strings.HasPrefix(key, "tag."):
+		return pal(&th.Tag, key[4:])
+	case strings.HasPrefix(key, "body."):
+		return pal(&th.Body, key[5:])
+	case strings.HasPrefix(key, "style."):
+		return nil // see set
+	case key == "scroll":
+		return &th.Scroll
+	case key == "thumb":
+		return &th.Thumb
+	case win:
+		return nil
+	case key == "button.mod":
+		return &th.ModButton
+	case key == "button.col":
+		return &th.ColButton
+	case key == "button2":
+		return &th.Button2
+	case key == "button3":
+		return &th.Button3
+	}
+	return nil
+}
+
+// Set sets the color named by key to value.
+// The keys are tag.back, tag.high, tag.border, tag.text and tag.htext,
+// the same for body, scroll, thumb, button.mod, button.col,
+// button2 and button3, and, for the text and background of the
+// style name, style.name and style.name.back.
+func (th *Theme) Set(key, value string) error {
+	return th.set(key, value, false)
+}
+
+// SetWindow is like Set but accepts only the keys for the colors
+// a window can override: those of the tag, the body and the scroll bar.
+func (th *Theme) SetWindow(key, value string) error {
+	return th.set(key, value, true)
+}
+
+func (th *Theme) set(key, value string, win bool) error {
+	c := th.color(key, win)
+	var style string
+	if c == nil {
+		name, ok := strings.CutPrefix(key, "style.")
+		if win || !ok || !isStyleName(strings.TrimSuffix(name, ".back")) {
+			return fmt.Errorf("unknown color %s", key)
+		}
+		style = name
+	}
+	v, err := ParseColor(value)
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		*c = v
+		return nil
+	}
+	styles := make(map[string]TextStyle)
+	for name, st := range th.Styles {
+		styles[name] = st
+	}
+	if name, ok := strings.CutSuffix(style, ".back"); ok {
+		st := styles[name]
+		st.Back = v
+		styles[name] = st
+	} else {
+		st := styles[style]
+		st.Text = v
+		styles[style] = st
+	}
+	th.Styles = styles
+	return nil
+}
+
+// isStyleName reports whether s can name a style:
+// letters, digits, - and _.
+func isStyleName(s string) bool {
+	for _, r := range s {
+		if r != '-' && r != '_' && !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Merge returns th with the colors set in o replacing its own.
+// Styles are not merged.
+func (th Theme) Merge(o *Theme) Theme {
+	for _, key := range themeKeys {
+		if c := *o.color(key, false); c != (Color{}) {
+			*th.color(key, false) = c
+		}
+	}
+	return th
+}
+
+var themeKeys = []string{
+	"tag.back", "tag.high", "tag.border", "tag.text", "tag.htext",
+	"body.back", "body.high", "body.border", "body.text", "body.htext",
+	"scroll", "thumb", "button.mod", "button.col", "button2", "button3",
+}
+
+var colorNames = map[string]draw.Color{
+	"black":         draw.Black,
+	"white":         draw.White,
+	"red":           draw.Red,
+	"green":         draw.Green,
+	"blue":          draw.Blue,
+	"cyan":          draw.Cyan,
+	"magenta":       draw.Magenta,
+	"yellow":        draw.Yellow,
+	"paleyellow":    draw.PaleYellow,
+	"darkyellow":    draw.DarkYellow,
+	"darkgreen":     draw.DarkGreen,
+	"palegreen":     draw.PaleGreen,
+	"medgreen":      draw.MedGreen,
+	"darkblue":      draw.DarkBlue,
+	"palebluegreen": draw.PaleBlueGreen,
+	"paleblue":      draw.PaleBlue,
+	"bluegreen":     draw.BlueGreen,
+	"greygreen":     draw.GreyGreen,
+	"palegreygreen": draw.PaleGreyGreen,
+	"yellowgreen":   draw.YellowGreen,
+	"medblue":       draw.MedBlue,
+	"greyblue":      draw.GreyBlue,
+	"palegreyblue":  draw.PaleGreyBlue,
+	"purpleblue":    draw.PurpleBlue,
+}
+
+// ParseColor parses a theme color: one color, or two, meaning
+// the first mixed over the second. Each color is #rrggbb, #rrggbbaa,
+// or the name of one of the draw package's colors, such as paleyellow.
+func ParseColor(s string) (Color, error) {
+	f := strings.Fields(s)
+	if len(f) != 1 && len(f) != 2 {
+		return Color{}, fmt.Errorf("bad color %q", s)
+	}
+	var c [2]draw.Color
+	for i, s := range f {
+		if v, ok := colorNames[strings.ToLower(s)]; ok {
+			c[i] = v
+			continue
+		}
+		hex, ok := strings.CutPrefix(s, "#")
+		if !ok || len(hex) != 6 && len(hex) != 8 {
+			return Color{}, fmt.Errorf("bad color %q", s)
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return Color{}, fmt.Errorf("bad color %q", s)
+		}
+		if len(hex) == 6 {
+			v = v<<8 | 0xFF
+		}
+		c[i] = draw.Color(v)
+	}
+	return Color{Fill: c[0], Base: c[1], set: true}, nil
+}
+
+// ParseTheme parses a theme file, whose lines each set a color,
+// replacing its value in DefaultTheme, as in
+//
+//	# dark tags
+//	tag.back	#202020
+//	tag.text	white
+//	body.back	paleyellow white
+//	style.comment	#606060
+//	style.error.back	#ffc0c0
+//
+// Blank lines and lines beginning with # are ignored.
+func ParseTheme(file string, data []byte) (*Theme, error) {
+	th := DefaultTheme
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		f := strings.Fields(text)
+		if err := th.Set(f[0], strings.Join(f[1:], " ")); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+	}
+	return &th, nil
+}
+
+// ReadTheme reads and parses the theme file.
+func ReadTheme(file string) (*Theme, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTheme(file, data)
+}
+
+// Cols holds the images for drawing a window.
+type Cols struct {
+	Tag, Body     [frame.NCOL]*draw.Image
+	Scroll, Thumb *draw.Image
+}
+
+// AllocCols allocates the images for drawing a window in th.
+func AllocCols(th *Theme) *Cols {
+	c := new(Cols)
+	pal := func(cols *[frame.NCOL]*draw.Image, p *Palette) {
+		cols[frame.BACK] = allocColor(p.Back)
+		cols[frame.HIGH] = allocColor(p.High)
+		cols[frame.BORD] = allocColor(p.Border)
+		cols[frame.TEXT] = allocColor(p.Text)
+		cols[frame.HTEXT] = allocColor(p.HText)
+	}
+	pal(&c.Tag, &th.Tag)
+	pal(&c.Body, &th.Body)
+	scroll, thumb := th.Scroll, th.Thumb
+	if scroll == (Color{}) {
+		scroll = th.Body.Border
+	}
+	if thumb == (Color{}) {
+		thumb = th.Body.Back
+	}
+	c.Scroll = allocColor(scroll)
+	c.Thumb = allocColor(thumb)
+	return c
+}
+
+// Free frees the images.
+func (c *Cols) Free() {
+	if c == nil {
+		return
+	}
+	for _, i := range c.Tag {
+		i.Free()
+	}
+	for _, i := range c.Body {
+		i.Free()
+	}
+	c.Scroll.Free()
+	c.Thumb.Free()
+}
+
+func allocColor(c Color) *draw.Image {
+	if c.Base != 0 {
+		return Display.AllocImageMix(c.Fill, c.Base)
+	}
+	i, _ := Display.AllocImage(draw.Rect(0, 0, 1, 1), Display.ScreenImage.Pix, true, c.Fill)
+	return i
+}
+
+// themeCols holds the images for CurTheme,
+// of which TagCols, TextCols, ScrollCol and ThumbCol are copies.
+var themeCols *Cols
+
+// SetTheme makes th the current theme, allocating its images.
+// It returns the images of the previous theme, which the caller
+// must free once no text refers to them.
+func SetTheme(th *Theme) (old *Cols) {
+	CurTheme = *th
+	for _, st := range styleCols {
+		st.Text.Free()
+		st.Back.Free()
+	}
+	styleCols = nil
+	old = themeCols
+	allocTheme()
+	for _, i := range []*draw.Image{Button, ModButton, ColButton, Button2Color, Button3Color, modButtonCol} {
+		i.Free()
+	}
+	Button = nil
+	modButtonCol = nil
+	Init()
+	return old
+}
+
+func allocTheme() {
+	themeCols = AllocCols(&CurTheme)
+	TagCols = themeCols.Tag
+	TextCols = themeCols.Body
+	ScrollCol = themeCols.Scroll
+	ThumbCol = themeCols.Thumb
+}
+
+// DrawButton draws the button at the left of a window's tag in r,
+// with the colors cols of the tag, marked as modified if mod is set.
+func DrawButton(dst *draw.Image, r draw.Rectangle, cols []*draw.Image, mod bool) {
+	dst.Draw(r, cols[frame.BACK], nil, r.Min)
+	r.Max.X -= ButtonBorder()
+	dst.Border(r, ButtonBorder(), cols[frame.BORD], draw.ZP)
+	if mod {
+		r = r.Inset(ButtonBorder())
+		dst.Draw(r, modButtonCol, nil, draw.ZP)
+	}
+}
+
+// styleCols holds the images for the styles of CurTheme
+// that have been used.
+var styleCols map[string]frame.Style
+
+// LookupStyle returns the images for drawing text in the
+// named style of CurTheme, reporting whether there is one.
+func LookupStyle(name string) (frame.Style, bool) {
+	if st, ok := styleCols[name]; ok {
+		return st, true
+	}
+	ts, ok := CurTheme.Styles[name]
+	if !ok {
+		return frame.Style{}, false
+	}
+	var st frame.Style
+	if ts.Text != (Color{}) {
+		st.Text = allocColor(ts.Text)
+	}
+	if ts.Back != (Color{}) {
+		st.Back = allocColor(ts.Back)
+	}
+	if styleCols == nil {
+		styleCols = make(map[string]frame.Style)
+	}
+	styleCols[name] = st
+	return st, true
+}
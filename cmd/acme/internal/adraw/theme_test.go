@@ -0,0 +1,109 @@
+package adraw
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"plramos.win/9fans/draw"
+)
+
+func TestParseColor(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want Color
+	}{
+		{"#EAFFFF", Color{Fill: 0xEAFFFFFF, set: true}},
+		{"#11223344", Color{Fill: 0x11223344, set: true}},
+		{"PaleYellow", Color{Fill: draw.PaleYellow, set: true}},
+		{"paleyellow white", Color{draw.PaleYellow, draw.White, true}},
+		{" #000000\twhite ", Color{draw.Black, draw.White, true}},
+		{"#00000000", Color{set: true}},
+	} {
+		c, err := ParseColor(tt.s)
+		if err != nil || c != tt.want {
+			t.Errorf("ParseColor(%q) = %v, %v, want %v", tt.s, c, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "#fff", "#gggggg", "puce", "red green blue"} {
+		if c, err := ParseColor(s); err == nil {
+			t.Errorf("ParseColor(%q) = %v, want error", s, c)
+		}
+	}
+}
+
+func TestParseTheme(t *testing.T) {
+	th, err := ParseTheme("dark", []byte(`# dark tags
+tag.back	#202020
+
+tag.text	white
+body.back	paleyellow  white
+button2	red
+style.comment	#606060
+style.todo.back	yellow
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultTheme
+	want.Tag.Back = Color{Fill: 0x202020FF, set: true}
+	want.Tag.Text = Color{Fill: draw.White, set: true}
+	want.Body.Back.set = true
+	want.Button2 = Color{Fill: draw.Red, set: true}
+	want.Styles = map[string]TextStyle{"todo": {Back: Color{Fill: draw.Yellow, set: true}}}
+	for name, st := range DefaultTheme.Styles {
+		want.Styles[name] = st
+	}
+	want.Styles["comment"] = TextStyle{Text: Color{Fill: 0x606060FF, set: true}}
+	if !reflect.DeepEqual(*th, want) {
+		t.Errorf("ParseTheme = %+v, want %+v", *th, want)
+	}
+
+	for _, tt := range []struct{ data, err string }{
+		{"tag.back\n", "bad:1: bad color"},
+		{"\ntag.colour red\n", "bad:2: unknown color tag.colour"},
+		{"tag.back #12\n", `bad:1: bad color "#12"`},
+		{"style.a.b red\n", "bad:1: unknown color style.a.b"},
+	} {
+		_, err := ParseTheme("bad", []byte(tt.data))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("ParseTheme(%q) error = %v, want %s", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var win Theme
+	if err := win.SetWindow("tag.back", "red"); err != nil {
+		t.Fatal(err)
+	}
+	if err := win.SetWindow("scroll", "#101010"); err != nil {
+		t.Fatal(err)
+	}
+	if err := win.SetWindow("body.high", "#00000000"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"button2", "style.comment"} {
+		if err := win.SetWindow(key, "red"); err == nil {
+			t.Errorf("SetWindow(%s) succeeded", key)
+		}
+	}
+	th := DefaultTheme.Merge(&win)
+	want := DefaultTheme
+	want.Tag.Back = Color{Fill: draw.Red, set: true}
+	want.Scroll = Color{Fill: 0x101010FF, set: true}
+	want.Body.High = Color{set: true} // transparent, not unset
+	if !reflect.DeepEqual(th, want) {
+		t.Errorf("Merge = %+v, want %+v", th, want)
+	}
+}
+
+func TestSetStyleCopies(t *testing.T) {
+	th := DefaultTheme
+	if err := th.Set("style.comment", "red"); err != nil {
+		t.Fatal(err)
+	}
+	if DefaultTheme.Styles["comment"].Text.Fill == draw.Red {
+		t.Errorf("Set changed DefaultTheme's styles")
+	}
+}
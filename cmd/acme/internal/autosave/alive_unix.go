@@ -0,0 +1,18 @@
+//go:build unix
+
+package autosave
+
+import (
+	"os"
+	"syscall"
+)
+
+// alive reports whether process pid is running.
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,237 @@
+// Package autosave keeps snapshots of the text of modified windows
+// in a recovery directory, so that edits survive a crash.
+//
+// Each snapshot is a file named pid.session.id, for the acme process,
+// the time it started and the window that wrote it, holding a header of "key value" lines,
+// a blank line, and the window body in UTF-8.
+// Snapshots are written to a temporary file and renamed into place,
+// so a crash while writing leaves the previous snapshot intact.
+// Snapshots that cannot be recovered are archived in the stale
+// subdirectory, where they are kept for a week.
+package autosave
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dir is the recovery directory. If empty, nothing is saved.
+var Dir string
+
+// Interval is the time between snapshots.
+var Interval = 30 * time.Second
+
+// KeepStale is how long archived snapshots are kept.
+var KeepStale = 7 * 24 * time.Hour
+
+// A Snapshot is the saved state of a modified window.
+type Snapshot struct {
+	File    string    // snapshot file, set by Orphans
+	Name    string    // file name of the window
+	Enc     string    // encoding of the file on disk
+	ModTime time.Time // modification time of the file when acme read it
+	Saved   time.Time // time of the snapshot
+	Q0, Q1  int       // selection
+	Body    []byte
+}
+
+// prefix begins the names of this process's snapshot files.
+var prefix = fmt.Sprintf("%d.%x.", os.Getpid(), time.Now().UnixNano())
+
+// Path returns the name of the snapshot file for window id
+// of this process.
+func Path(id int) string {
+	return filepath.Join(Dir, prefix+strconv.Itoa(id))
+}
+
+// Write saves s as the snapshot for window id.
+func Write(id int, s *Snapshot) error {
+	if err := os.MkdirAll(Dir, 0700); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "name %s\n", s.Name)
+	fmt.Fprintf(&b, "enc %s\n", s.Enc)
+	if !s.ModTime.IsZero() {
+		fmt.Fprintf(&b, "mtime %s\n", s.ModTime.Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&b, "saved %s\n", s.Saved.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "dot %d %d\n", s.Q0, s.Q1)
+	fmt.Fprintf(&b, "\n")
+	b.Write(s.Body)
+
+	file := Path(id)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Remove removes the snapshot for window id, if any.
+func Remove(id int) {
+	if Dir != "" {
+		os.Remove(Path(id))
+	}
+}
+
+// RemoveAll removes all the snapshots of this process.
+func RemoveAll() {
+	if Dir == "" {
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(Dir, prefix+"*"))
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
+
+// Read reads the snapshot in file.
+func Read(file string) (*Snapshot, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{File: file}
+	b := bufio.NewReader(bytes.NewReader(data))
+	bad := func(line string) error {
+		return fmt.Errorf("%s: bad snapshot line %q", file, line)
+	}
+	for {
+		line, err := b.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("%s: truncated snapshot", file)
+			}
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		key, val, _ := strings.Cut(line, " ")
+		switch key {
+		case "name":
+			s.Name = val
+		case "enc":
+			s.Enc = val
+		case "mtime", "saved":
+			t, err := time.Parse(time.RFC3339Nano, val)
+			if err != nil {
+				return nil, bad(line)
+			}
+			if key == "mtime" {
+				s.ModTime = t
+			} else {
+				s.Saved = t
+			}
+		case "dot":
+			f := strings.Fields(val)
+			if len(f) != 2 {
+				return nil, bad(line)
+			}
+			q0, err0 := strconv.Atoi(f[0])
+			q1, err1 := strconv.Atoi(f[1])
+			if err0 != nil || err1 != nil {
+				return nil, bad(line)
+			}
+			s.Q0, s.Q1 = q0, q1
+		default:
+			// Ignore keys from newer versions.
+		}
+	}
+	s.Body, _ = io.ReadAll(b)
+	return s, nil
+}
+
+// Orphans returns the snapshots left in Dir by acme processes
+// that are no longer running.
+func Orphans() ([]*Snapshot, error) {
+	if Dir == "" {
+		return nil, nil
+	}
+	ents, err := os.ReadDir(Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	var snaps []*Snapshot
+	var errs []string
+	for _, e := range ents {
+		name := e.Name()
+		spid, _, ok := strings.Cut(name, ".")
+		pid, err := strconv.Atoi(spid)
+		if !ok || err != nil || strings.HasSuffix(name, ".tmp") || strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// A snapshot with this process's id but not its prefix
+		// was left from before a reboot.
+		if pid != os.Getpid() && alive(pid) {
+			continue
+		}
+		s, err := Read(filepath.Join(Dir, name))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		snaps = append(snaps, s)
+	}
+	if errs != nil {
+		err = errors.New(strings.Join(errs, "; "))
+	}
+	return snaps, err
+}
+
+// Claim makes s the snapshot for window id of this process,
+// which now holds its text.
+func Claim(s *Snapshot, id int) error {
+	if err := os.Rename(s.File, Path(id)); err != nil {
+		return err
+	}
+	s.File = Path(id)
+	return nil
+}
+
+// Archive moves s to the stale subdirectory of Dir, where Orphans
+// does not find it, and returns its new name. It removes archived
+// snapshots older than KeepStale.
+func (s *Snapshot) Archive() (string, error) {
+	dir := filepath.Join(Dir, "stale")
+	if ents, err := os.ReadDir(dir); err == nil {
+		for _, e := range ents {
+			if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > KeepStale {
+				os.Remove(filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, filepath.Base(s.File))
+	if err := os.Rename(s.File, file); err != nil {
+		return "", err
+	}
+	now := time.Now() // kept for KeepStale from now
+	os.Chtimes(file, now, now)
+	s.File = file
+	return file, nil
+}
+
+// Stale reports whether the file s was taken of, described by info,
+// has changed since acme read it, so that s should not replace it.
+// A nil info means the file does not exist; a file that exists
+// now but did not when acme read it is stale.
+func (s *Snapshot) Stale(info os.FileInfo) bool {
+	return info != nil && !info.ModTime().Equal(s.ModTime)
+}
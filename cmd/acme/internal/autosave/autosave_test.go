@@ -0,0 +1,214 @@
+package autosave
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testDir(t *testing.T) {
+	old := Dir
+	Dir = t.TempDir()
+	t.Cleanup(func() { Dir = old })
+}
+
+var snap = Snapshot{
+	Name:    "/tmp/x.go",
+	Enc:     "latin1+crlf",
+	ModTime: time.Date(2024, 3, 1, 12, 0, 0, 5, time.UTC),
+	Saved:   time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+	Q0:      2,
+	Q1:      4,
+	Body:    []byte("package x\n\nname not a header\n"),
+}
+
+func equal(a, b *Snapshot) bool {
+	return a.Name == b.Name && a.Enc == b.Enc &&
+		a.ModTime.Equal(b.ModTime) && a.Saved.Equal(b.Saved) &&
+		a.Q0 == b.Q0 && a.Q1 == b.Q1 && bytes.Equal(a.Body, b.Body)
+}
+
+func TestWriteRead(t *testing.T) {
+	testDir(t)
+	s := snap
+	if err := Write(1, &s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(Path(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(got, &s) || got.File != Path(1) {
+		t.Errorf("Read = %+v, want %+v", got, s)
+	}
+
+	// No modification time for a file not yet on disk.
+	s.ModTime = time.Time{}
+	if err := Write(1, &s); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Read(Path(1)); err != nil || !got.ModTime.IsZero() {
+		t.Errorf("Read = %+v, %v, want zero ModTime", got, err)
+	}
+}
+
+// deadPid returns the id of a process that has exited.
+func deadPid(t *testing.T) int {
+	cmd := exec.Command("sh", "-c", "exit 0")
+	if err := cmd.Run(); err != nil {
+		t.Skip(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestOrphans(t *testing.T) {
+	testDir(t)
+	s := snap
+	if err := Write(1, &s); err != nil { // ours: not an orphan
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(Path(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := filepath.Join(Dir, strconv.Itoa(deadPid(t))+".1.3")
+	reboot := filepath.Join(Dir, strconv.Itoa(os.Getpid())+".1.4")
+	for file, b := range map[string][]byte{
+		dead:                         data,
+		reboot:                       data,
+		dead + ".tmp":                data,
+		filepath.Join(Dir, "junk"):   data,
+		dead[:len(dead)-1] + "5":     []byte("name /tmp/y\n"), // truncated
+		filepath.Join(Dir, "x.1.6"):  data,
+		filepath.Join(Dir, "README"): []byte("hello"),
+	} {
+		if err := os.WriteFile(file, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snaps, err := Orphans()
+	if err == nil {
+		t.Errorf("Orphans did not report truncated snapshot")
+	}
+	files := map[string]bool{}
+	for _, o := range snaps {
+		files[o.File] = true
+		if !equal(o, &s) {
+			t.Errorf("%s = %+v, want %+v", o.File, o, s)
+		}
+	}
+	if len(files) != 2 || !files[dead] || !files[reboot] {
+		t.Fatalf("Orphans = %v, want %s and %s", files, dead, reboot)
+	}
+
+	for _, o := range snaps {
+		if o.File == dead {
+			if err := Claim(o, 2); err != nil {
+				t.Fatal(err)
+			}
+			if o.File != Path(2) {
+				t.Errorf("Claim: File = %s, want %s", o.File, Path(2))
+			}
+		}
+	}
+	if _, err := os.Stat(dead); !os.IsNotExist(err) {
+		t.Errorf("claimed snapshot still at %s", dead)
+	}
+
+	RemoveAll()
+	for _, file := range []string{Path(1), Path(2)} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("RemoveAll left %s", file)
+		}
+	}
+	if _, err := os.Stat(reboot); err != nil {
+		t.Errorf("RemoveAll removed another session's snapshot: %v", err)
+	}
+}
+
+type fileInfo struct {
+	os.FileInfo
+	mtime time.Time
+}
+
+func (fi fileInfo) ModTime() time.Time { return fi.mtime }
+
+func TestStale(t *testing.T) {
+	s := snap
+	for _, tt := range []struct {
+		info os.FileInfo
+		want bool
+	}{
+		{nil, false},
+		{fileInfo{mtime: s.ModTime}, false},
+		{fileInfo{mtime: s.ModTime.Add(time.Second)}, true}, // changed before the snapshot
+		{fileInfo{mtime: s.Saved.Add(time.Second)}, true},
+		{fileInfo{mtime: s.ModTime.Add(-time.Second)}, true}, // restored from backup
+	} {
+		if got := s.Stale(tt.info); got != tt.want {
+			t.Errorf("Stale(%v) = %v, want %v", tt.info, got, tt.want)
+		}
+	}
+	s.ModTime = time.Time{} // file did not exist when read
+	for _, tt := range []struct {
+		info os.FileInfo
+		want bool
+	}{
+		{nil, false},
+		{fileInfo{mtime: s.Saved.Add(-time.Second)}, true},
+	} {
+		if got := s.Stale(tt.info); got != tt.want {
+			t.Errorf("Stale(%v) = %v, want %v", tt.info, got, tt.want)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	testDir(t)
+	s := snap
+	if err := Write(1, &s); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(Path(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := filepath.Join(Dir, strconv.Itoa(deadPid(t))+".1.3")
+	if err := os.WriteFile(dead, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(Dir, "stale", strconv.Itoa(deadPid(t))+".1.2")
+	os.MkdirAll(filepath.Dir(old), 0700)
+	if err := os.WriteFile(old, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	long := time.Now().Add(-KeepStale - time.Hour)
+	os.Chtimes(old, long, long)
+	os.Chtimes(dead, long, long)
+
+	snaps, err := Orphans()
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("Orphans = %d snapshots, %v; want 1", len(snaps), err)
+	}
+	file, err := snaps[0].Archive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(Dir, "stale", filepath.Base(dead)); file != want || snaps[0].File != want {
+		t.Errorf("Archive = %s, File %s; want %s", file, snaps[0].File, want)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("archived snapshot: %v", err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Archive kept %s, archived before KeepStale", old)
+	}
+	if snaps, err := Orphans(); err != nil || len(snaps) != 0 {
+		t.Errorf("after Archive, Orphans = %d snapshots, %v; want none", len(snaps), err)
+	}
+}
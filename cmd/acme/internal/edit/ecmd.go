@@ -22,6 +22,7 @@ import (
 
 	"plramos.win/9fans/cmd/acme/internal/alog"
 	"plramos.win/9fans/cmd/acme/internal/bufs"
+	"plramos.win/9fans/cmd/acme/internal/enc"
 	"plramos.win/9fans/cmd/acme/internal/file"
 	"plramos.win/9fans/cmd/acme/internal/fileload"
 	"plramos.win/9fans/cmd/acme/internal/regx"
@@ -286,12 +287,15 @@ func e_cmd(t *wind.Text, cp *Cmd) bool {
 		editerror(fmt.Sprintf("%s is a directory", s))
 	}
 	elogdelete(f, q0, q1)
-	nulls := false
-	fileload.Loadfile(fd, q1, &nulls, readloader(f), nil)
-	if nulls {
-		alog.Printf("%s: NUL bytes elided\n", s)
-	} else if allreplaced && samename {
-		elogfind(f).editclean = true
+	d := &enc.Decoder{Enc: t.File.Enc, Detect: !samename || !t.File.EncSet}
+	fileload.Loadfile(fd, q1, d, readloader(f), nil)
+	fileload.Decodewarn(s, d)
+	if allreplaced && samename {
+		t.File.Enc = d.Enc
+		t.File.Lossy = d.Lossy()
+		if !d.Nulls {
+			elogfind(f).editclean = true
+		}
 	}
 	return true
 }
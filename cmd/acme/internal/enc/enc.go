@@ -0,0 +1,428 @@
+// Package enc converts between the runes acme edits and the bytes
+// of files stored in other character encodings or with CRLF line endings.
+package enc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// A Charset is a character encoding.
+type Charset int
+
+const (
+	UTF8 Charset = iota
+	UTF16LE
+	UTF16BE
+	Latin1
+)
+
+var charsetNames = []string{
+	UTF8:    "utf-8",
+	UTF16LE: "utf-16le",
+	UTF16BE: "utf-16be",
+	Latin1:  "latin1",
+}
+
+func (c Charset) String() string {
+	if 0 <= c && int(c) < len(charsetNames) {
+		return charsetNames[c]
+	}
+	return fmt.Sprintf("Charset(%d)", int(c))
+}
+
+// An Encoding describes how a file's text is stored.
+// The zero Encoding is UTF-8 without a byte order mark
+// and with lines ending in LF.
+type Encoding struct {
+	Charset Charset
+	BOM     bool // file begins with a byte order mark
+	CRLF    bool // lines end in CR LF
+}
+
+// String returns the encoding's name, such as utf-8, latin1+crlf
+// or utf-16le+bom, as accepted by Parse.
+func (e Encoding) String() string {
+	s := e.Charset.String()
+	if e.BOM {
+		s += "+bom"
+	}
+	if e.CRLF {
+		s += "+crlf"
+	}
+	return s
+}
+
+// Parse parses an encoding name: a charset, utf-8, utf-16le,
+// utf-16be or latin1, followed by the options bom, crlf or lf,
+// separated by + or white space. Parse also accepts a few
+// common aliases for the charsets. An option alone
+// changes only that option of e.
+func Parse(e Encoding, s string) (Encoding, error) {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == '+' || r == ' ' || r == '\t' || r == '\n'
+	})
+	if len(words) == 0 {
+		return e, fmt.Errorf("no encoding")
+	}
+	for i, w := range words {
+		switch w {
+		case "bom":
+			e.BOM = true
+			continue
+		case "nobom":
+			e.BOM = false
+			continue
+		case "crlf", "dos":
+			e.CRLF = true
+			continue
+		case "lf", "unix":
+			e.CRLF = false
+			continue
+		}
+		c, ok := lookupCharset(w)
+		if !ok || i > 0 {
+			return e, fmt.Errorf("unknown encoding %s", w)
+		}
+		e.Charset = c
+		e.BOM = c == UTF16LE || c == UTF16BE
+	}
+	if e.BOM && e.Charset == Latin1 {
+		return e, fmt.Errorf("latin1 has no byte order mark")
+	}
+	return e, nil
+}
+
+func lookupCharset(name string) (Charset, bool) {
+	switch name {
+	case "utf-8", "utf8":
+		return UTF8, true
+	case "utf-16le", "utf16le", "utf-16", "utf16":
+		return UTF16LE, true
+	case "utf-16be", "utf16be":
+		return UTF16BE, true
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1":
+		return Latin1, true
+	}
+	return 0, false
+}
+
+// bom returns the byte order mark for e's charset.
+func (e Encoding) bom() []byte {
+	switch e.Charset {
+	case UTF8:
+		return []byte{0xEF, 0xBB, 0xBF}
+	case UTF16LE:
+		return []byte{0xFF, 0xFE}
+	case UTF16BE:
+		return []byte{0xFE, 0xFF}
+	}
+	return nil
+}
+
+// Detect guesses the encoding of a file that begins with b.
+// A byte order mark settles the charset; otherwise text that is not
+// valid UTF-8 is taken to be Latin-1. Lines end in CRLF if b has
+// line endings and all of them are CRLF.
+func Detect(b []byte, eof bool) Encoding {
+	e := detectCharset(b, eof)
+	if e.BOM {
+		b = b[len(e.bom()):]
+	}
+	d := Decoder{Enc: Encoding{Charset: e.Charset}}
+	r := make([]rune, len(b))
+	_, nr := d.Decode(b, r, eof)
+	ncr, nlf := 0, 0
+	for i, c := range r[:nr] {
+		if c == '\n' {
+			nlf++
+			if i > 0 && r[i-1] == '\r' {
+				ncr++
+			}
+		}
+	}
+	e.CRLF = nlf > 0 && ncr == nlf
+	return e
+}
+
+func detectCharset(b []byte, eof bool) Encoding {
+	for _, c := range []Charset{UTF8, UTF16LE, UTF16BE} {
+		e := Encoding{Charset: c, BOM: true}
+		if strings.HasPrefix(string(b), string(e.bom())) {
+			return e
+		}
+	}
+	if !eof {
+		// Leave out a rune cut off at the end.
+		for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+			if utf8.RuneStart(b[len(b)-i]) {
+				if !utf8.FullRune(b[len(b)-i:]) {
+					b = b[:len(b)-i]
+				}
+				break
+			}
+		}
+	}
+	if !utf8.Valid(b) {
+		return Encoding{Charset: Latin1}
+	}
+	return Encoding{Charset: UTF8}
+}
+
+// A Decoder converts the bytes of a file stored in Enc to runes.
+// It elides NULs, which acme cannot edit, and
+// reports what it could not convert exactly.
+type Decoder struct {
+	Enc     Encoding
+	Detect  bool // set Enc with Detect from the start of the file
+	Nulls   bool // NUL characters were elided
+	Invalid bool // bytes not valid in Enc were replaced with U+FFFD
+	BareLF  bool // in a CRLF file, a line ended in LF alone
+
+	detected bool // Enc was set by DetectFile
+	started  bool
+}
+
+// DetectFile sets d.Enc, if d.Detect is set, from the whole of f
+// rather than from the first bytes Decode is given, so that a byte
+// not valid UTF-8 late in a file makes it Latin-1, which reads every
+// byte exactly. It leaves f where it found it. If f cannot seek,
+// Decode detects the encoding as usual.
+func (d *Decoder) DetectFile(f io.ReadSeeker) {
+	if !d.Detect || d.started {
+		return
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	e, err := detectReader(f)
+	if _, serr := f.Seek(off, io.SeekStart); err != nil || serr != nil {
+		return
+	}
+	d.Enc = e
+	d.detected = true
+}
+
+// detectReader is like Detect but reads all of r.
+func detectReader(r io.Reader) (Encoding, error) {
+	buf := make([]byte, 64*1024)
+	n, err := io.ReadFull(r, buf)
+	eof := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !eof {
+		return Encoding{}, err
+	}
+	e := Detect(buf[:n], eof)
+	if e.Charset != UTF8 || e.BOM || eof {
+		return e, nil
+	}
+	p := buf[:n]
+	for {
+		// Check all but a rune cut off at the end, which
+		// moves to the front of buf for the next read.
+		k := len(p)
+		for i := 1; i <= utf8.UTFMax && i <= len(p); i++ {
+			if utf8.RuneStart(p[len(p)-i]) {
+				if !utf8.FullRune(p[len(p)-i:]) {
+					k = len(p) - i
+				}
+				break
+			}
+		}
+		if eof {
+			k = len(p)
+		}
+		if !utf8.Valid(p[:k]) {
+			e.Charset = Latin1
+			return e, nil
+		}
+		if eof {
+			return e, nil
+		}
+		m := copy(buf, p[k:])
+		n, err = io.ReadFull(r, buf[m:])
+		eof = err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return Encoding{}, err
+		}
+		p = buf[:m+n]
+	}
+}
+
+// Decode converts as many bytes at the start of b as it can to runes in r,
+// which must be at least as long as b, returning the number of bytes
+// and runes. Unless eof is set, meaning b runs to the end of the file,
+// Decode leaves bytes at the end of b that might start a longer sequence.
+// The first call's b must begin the file.
+func (d *Decoder) Decode(b []byte, r []rune, eof bool) (nb, nr int) {
+	b0 := b
+	if !d.started {
+		if d.Detect && !d.detected {
+			d.Enc = Detect(b, eof)
+		}
+		if bom := d.Enc.bom(); d.Enc.BOM {
+			if len(b) < len(bom) && !eof && strings.HasPrefix(string(bom), string(b)) {
+				return 0, 0
+			}
+			if strings.HasPrefix(string(b), string(bom)) {
+				b = b[len(bom):]
+			}
+		}
+		d.started = true
+	}
+	for len(b) > 0 {
+		c, w := d.rune(b, eof)
+		if w == 0 {
+			break
+		}
+		if c == '\r' && d.Enc.CRLF {
+			c1, w1 := d.rune(b[w:], eof)
+			if w1 == 0 && !eof {
+				break
+			}
+			if c1 == '\n' {
+				c = '\n'
+				w += w1
+			}
+		} else if c == '\n' && d.Enc.CRLF {
+			d.BareLF = true
+		}
+		b = b[w:]
+		if c == 0 {
+			d.Nulls = true
+			continue
+		}
+		r[nr] = c
+		nr++
+	}
+	return len(b0) - len(b), nr
+}
+
+// Lossy reports whether the text decoded so far would not
+// encode back to the bytes it was read from: NULs were elided
+// or invalid bytes replaced.
+func (d *Decoder) Lossy() bool {
+	return d.Nulls || d.Invalid
+}
+
+// rune decodes the rune at the start of b, returning a width of 0
+// if b holds only part of it and eof is not set.
+func (d *Decoder) rune(b []byte, eof bool) (rune, int) {
+	switch d.Enc.Charset {
+	case Latin1:
+		if len(b) == 0 {
+			return 0, 0
+		}
+		return rune(b[0]), 1
+	case UTF16LE, UTF16BE:
+		u, ok := d.unit(b)
+		if !ok {
+			if eof && len(b) > 0 {
+				d.Invalid = true
+				return utf8.RuneError, len(b)
+			}
+			return 0, 0
+		}
+		if !utf16.IsSurrogate(rune(u)) {
+			return rune(u), 2
+		}
+		u1, ok := d.unit(b[2:])
+		if !ok && !eof {
+			return 0, 0
+		}
+		if c := utf16.DecodeRune(rune(u), rune(u1)); ok && c != utf8.RuneError {
+			return c, 4
+		}
+		d.Invalid = true
+		return utf8.RuneError, 2
+	}
+	if len(b) == 0 || !eof && !utf8.FullRune(b) {
+		return 0, 0
+	}
+	c, w := utf8.DecodeRune(b)
+	if c == utf8.RuneError && w == 1 {
+		d.Invalid = true
+	}
+	return c, w
+}
+
+func (d *Decoder) unit(b []byte) (uint16, bool) {
+	if len(b) < 2 {
+		return 0, false
+	}
+	if d.Enc.Charset == UTF16BE {
+		return uint16(b[0])<<8 | uint16(b[1]), true
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), true
+}
+
+// Start returns the bytes that begin a file in e:
+// its byte order mark, if any.
+func (e Encoding) Start() []byte {
+	if !e.BOM {
+		return nil
+	}
+	return e.bom()
+}
+
+// StoresAll reports whether e can store every rune.
+func (e Encoding) StoresAll() bool {
+	return e.Charset != Latin1
+}
+
+// Check returns an error if some rune in r cannot be stored in e.
+func (e Encoding) Check(r []rune) error {
+	for _, c := range r {
+		if !e.fits(c) {
+			return fmt.Errorf("cannot store %U in %s", c, e.Charset)
+		}
+	}
+	return nil
+}
+
+func (e Encoding) fits(c rune) bool {
+	return e.Charset != Latin1 || c <= 0xFF
+}
+
+// Encode appends the encoding of r to buf.
+// It returns an error, and buf as far as it got,
+// if some rune in r cannot be stored in e.
+func (e Encoding) Encode(buf []byte, r []rune) ([]byte, error) {
+	for _, c := range r {
+		if !e.fits(c) {
+			return buf, fmt.Errorf("cannot store %U in %s", c, e.Charset)
+		}
+		if c == '\n' && e.CRLF {
+			buf = e.appendRune(buf, '\r')
+		}
+		buf = e.appendRune(buf, c)
+	}
+	return buf, nil
+}
+
+func (e Encoding) appendRune(buf []byte, c rune) []byte {
+	switch e.Charset {
+	case Latin1:
+		return append(buf, byte(c))
+	case UTF16LE, UTF16BE:
+		var units []uint16
+		if c >= 0x10000 {
+			c1, c2 := utf16.EncodeRune(c)
+			units = []uint16{uint16(c1), uint16(c2)}
+		} else {
+			units = []uint16{uint16(c)}
+		}
+		for _, u := range units {
+			if e.Charset == UTF16BE {
+				buf = append(buf, byte(u>>8), byte(u))
+			} else {
+				buf = append(buf, byte(u), byte(u>>8))
+			}
+		}
+		return buf
+	}
+	return utf8.AppendRune(buf, c)
+}
@@ -0,0 +1,195 @@
+package enc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var samples = []struct {
+	file string
+	enc  string
+	text string
+}{
+	{"utf8.txt", "utf-8", "Grüße aus Köln\nçà et là\n"},
+	{"utf8-bom.txt", "utf-8+bom", "Grüße aus Köln\nçà et là\n"},
+	{"utf8-crlf.txt", "utf-8+crlf", "Grüße aus Köln\nçà et là\n"},
+	{"latin1.txt", "latin1", "Grüße aus Köln\nçà et là\n"},
+	{"latin1-crlf.txt", "latin1+crlf", "Grüße aus Köln\nçà et là\n"},
+	{"utf16le-crlf.txt", "utf-16le+bom+crlf", "clef \U0001D11E here\nsecond line\n"},
+	{"utf16be.txt", "utf-16be+bom", "clef \U0001D11E here\nsecond line\n"},
+	{"mixed.txt", "utf-8", "one\r\ntwo\nthree\r\n"},
+}
+
+// decode decodes b with d, giving it n bytes at a time
+// the way Get reads a file, a buffer at a time.
+func decode(d *Decoder, b []byte, n int) string {
+	var out []rune
+	var p []byte
+	for {
+		m := min(n, len(b))
+		p = append(p, b[:m]...)
+		b = b[m:]
+		r := make([]rune, len(p))
+		nb, nr := d.Decode(p, r, len(b) == 0)
+		out = append(out, r[:nr]...)
+		p = p[nb:]
+		if len(b) == 0 {
+			return string(out)
+		}
+	}
+}
+
+// encode encodes text in e the way Put writes a file.
+func encode(t *testing.T, e Encoding, text string) []byte {
+	b, err := e.Encode(e.Start(), []rune(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestGetPut(t *testing.T) {
+	for _, tt := range samples {
+		data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := Detect(data, true); e.String() != tt.enc {
+			t.Errorf("%s: Detect = %v, want %s", tt.file, e, tt.enc)
+			continue
+		}
+		for _, n := range []int{1, 2, 3, len(data)} {
+			// Get detects the encoding from its first, large read.
+			d := &Decoder{Detect: true}
+			if n < len(data) {
+				d = &Decoder{Enc: Detect(data, true)}
+			}
+			text := decode(d, data, n)
+			if d.Enc.String() != tt.enc || text != tt.text {
+				t.Errorf("%s by %d: Get = %v %q, want %s %q", tt.file, n, d.Enc, text, tt.enc, tt.text)
+				continue
+			}
+			if d.Nulls || d.Invalid || d.BareLF {
+				t.Errorf("%s by %d: Decoder = %+v", tt.file, n, d)
+			}
+			if b := encode(t, d.Enc, text); !bytes.Equal(b, data) {
+				t.Errorf("%s by %d: Put = %q, want %q", tt.file, n, b, data)
+			}
+		}
+	}
+}
+
+func TestGetPutNul(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "nul.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Decoder{Detect: true}
+	text := decode(d, data, len(data))
+	if text != "onetwo\n" || !d.Nulls || !d.Lossy() {
+		t.Errorf("Get = %q, Decoder %+v; want NUL elided and Lossy", text, d)
+	}
+	if b := encode(t, d.Enc, text); bytes.Equal(b, data) {
+		t.Errorf("Put = %q, want it to differ from the file", b)
+	}
+	d = &Decoder{Detect: true}
+	decode(d, []byte("one\ntwo\n"), 3)
+	if d.Lossy() {
+		t.Errorf("Decoder %+v of plain text is Lossy", d)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	// Get a file as Latin-1 and Put it as UTF-16 with CRLF.
+	data, err := os.ReadFile("testdata/latin1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Parse(Encoding{}, "latin1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := decode(&Decoder{Enc: e}, data, len(data))
+	e, err = Parse(e, "utf-16le+crlf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\xff\xfe" + "G\x00r\x00\xfc\x00\xdf\x00e\x00"
+	if b := encode(t, e, text); !strings.HasPrefix(string(b), want) || !strings.Contains(string(b), "\r\x00\n\x00") {
+		t.Errorf("Put = %q, want prefix %q and CRLF", b, want)
+	}
+
+	if _, err := e.Encode(nil, []rune("€")); err != nil {
+		t.Errorf("Encode(€) in %v: %v", e, err)
+	}
+	if err := (Encoding{Charset: Latin1}).Check([]rune("a€")); err == nil {
+		t.Errorf("Check(€) in latin1 succeeded")
+	}
+}
+
+func TestDecodeReport(t *testing.T) {
+	// A file that starts out CRLF but later has a bare LF.
+	data := []byte(strings.Repeat("line\r\n", 10) + "bare\nz\x00\xff")
+	d := &Decoder{Enc: Encoding{CRLF: true}}
+	text := decode(d, data, 7)
+	if !d.BareLF || !d.Nulls || !d.Invalid {
+		t.Errorf("Decoder = %+v, want BareLF, Nulls and Invalid", d)
+	}
+	if want := strings.Repeat("line\n", 10) + "bare\nz�"; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+}
+
+func TestDetectFile(t *testing.T) {
+	// The byte that is not UTF-8 comes well past the first read.
+	ascii := strings.Repeat("abc\n", 16*1024)
+	tests := []struct {
+		data string
+		enc  string
+	}{
+		{ascii + "caf\xe9\n", "latin1"},
+		{ascii + "café\n", "utf-8"},
+		{ascii[:64*1024-1] + "é" + ascii, "utf-8"}, // split by a read
+		{strings.ReplaceAll(ascii, "\n", "\r\n") + "caf\xe9\r\n", "latin1+crlf"},
+	}
+	for _, tt := range tests {
+		f := strings.NewReader(tt.data)
+		f.Seek(5, 0)
+		d := &Decoder{Detect: true}
+		d.DetectFile(f)
+		if d.Enc.String() != tt.enc {
+			t.Errorf("DetectFile(%d bytes ending %q) = %v, want %s", len(tt.data), tt.data[len(tt.data)-6:], d.Enc, tt.enc)
+		}
+		if off, _ := f.Seek(0, 1); off != 5 {
+			t.Errorf("DetectFile left offset %d, want 5", off)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		old  Encoding
+		s    string
+		want string
+	}{
+		{Encoding{}, "latin1", "latin1"},
+		{Encoding{}, "UTF-16", "utf-16le+bom"},
+		{Encoding{}, "utf-16be nobom crlf", "utf-16be+crlf"},
+		{Encoding{Charset: Latin1}, "crlf", "latin1+crlf"},
+		{Encoding{Charset: UTF8, CRLF: true}, "lf", "utf-8"},
+		{Encoding{}, "utf-8+bom", "utf-8+bom"},
+	} {
+		e, err := Parse(tt.old, tt.s)
+		if err != nil || e.String() != tt.want {
+			t.Errorf("Parse(%v, %q) = %v, %v, want %s", tt.old, tt.s, e, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "ebcdic", "crlf+latin1", "latin1+bom"} {
+		if e, err := Parse(Encoding{}, s); err == nil {
+			t.Errorf("Parse(%q) = %v, want error", s, e)
+		}
+	}
+}
@@ -23,16 +23,20 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
 
 	"plramos.win/9fans/cmd/acme/internal/addr"
+	"plramos.win/9fans/cmd/acme/internal/adraw"
 	"plramos.win/9fans/cmd/acme/internal/alog"
 	"plramos.win/9fans/cmd/acme/internal/bufs"
 	"plramos.win/9fans/cmd/acme/internal/dump"
 	"plramos.win/9fans/cmd/acme/internal/edit"
+	"plramos.win/9fans/cmd/acme/internal/enc"
 	"plramos.win/9fans/cmd/acme/internal/file"
 	"plramos.win/9fans/cmd/acme/internal/fileload"
 	"plramos.win/9fans/cmd/acme/internal/runes"
@@ -52,6 +56,9 @@ var (
 
 var Cwait = make(chan Waitmsg)
 
+// Errout receives the output of Local commands.
+var Errout io.Writer = os.Stderr
+
 type Waitmsg struct {
 	Proc *os.Process
 	Err  error
@@ -80,7 +87,7 @@ type Exectab struct {
 	flag2 bool
 }
 
-var exectab = [30]Exectab{
+var exectab = [32]Exectab{
 	{[]rune("Abort"), doabort, false, XXX, XXX},
 	{[]rune("Cut"), ui.XCut, true, true, true},
 	{[]rune("Del"), del, false, false, XXX},
@@ -88,6 +95,7 @@ var exectab = [30]Exectab{
 	{[]rune("Delete"), del, false, true, XXX},
 	{[]rune("Dump"), dump_, false, true, XXX},
 	{[]rune("Edit"), edit_, false, XXX, XXX},
+	{[]rune("Enc"), encx, false, XXX, XXX},
 	{[]rune("Exit"), xexit, false, XXX, XXX},
 	{[]rune("Font"), ui.Fontx, false, XXX, XXX},
 	{[]rune("Get"), Get, false, true, XXX},
@@ -109,6 +117,7 @@ var exectab = [30]Exectab{
 	{[]rune("Sort"), xsort, false, XXX, XXX},
 	{[]rune("Tab"), tab, false, XXX, XXX},
 	{[]rune("TabExpand"), tabexpand, false, XXX, XXX},
+	{[]rune("Theme"), theme, false, XXX, XXX},
 	{[]rune("Undo"), ui.XUndo, false, true, XXX},
 	{[]rune("Zerox"), zeroxx, false, XXX, XXX},
 }
@@ -447,6 +456,9 @@ func Get(et, t, argt *wind.Text, flag1, _ bool, arg []rune) {
 		wind.Windirfree(u.W)
 	}
 	samename := runes.Equal(r, t.File.Name())
+	if !samename {
+		t.File.EncSet = false
+	}
 	fileload.Textload(t, 0, name, samename)
 	var dirty bool
 	if samename {
@@ -462,6 +474,7 @@ func Get(et, t, argt *wind.Text, flag1, _ bool, arg []rune) {
 	}
 	for i := 0; i < len(t.File.Text); i++ {
 		t.File.Text[i].W.Dirty = dirty
+		t.File.Text[i].W.Putseq = t.File.Seq()
 	}
 	wind.Winsettag(w)
 	t.File.Unread = false
@@ -526,6 +539,14 @@ func Putfile(f *wind.File, q0 int, q1 int, namer []rune) {
 			return
 		}
 	}
+	if f.Lossy && runes.Equal(namer, f.Name()) {
+		alog.Printf("%s not written: NUL bytes were elided or bytes not valid %s replaced when read; use Enc to write it anyway\n", name, f.Enc.Charset)
+		return
+	}
+	if err := checkEncode(f, q0, q1); err != nil {
+		alog.Printf("%s not written: %v; use Enc to change the encoding\n", name, err)
+		return
+	}
 	fd, err := os.Create(name)
 	if err != nil {
 		alog.Printf("can't create file %s: %v\n", name, err)
@@ -549,6 +570,10 @@ func Putfile(f *wind.File, q0 int, q1 int, namer []rune) {
 		goto Rescue2
 	}
 	{
+		var buf []byte
+		if q0 == 0 {
+			buf = f.Enc.Start()
+		}
 		var n int
 		for q := q0; q < q1; q += n {
 			n = q1 - q
@@ -556,12 +581,13 @@ func Putfile(f *wind.File, q0 int, q1 int, namer []rune) {
 				n = bufs.Len / utf8.UTFMax
 			}
 			f.Read(q, r[:n])
-			buf := []byte(string(r[:n])) // TODO(rsc)
+			buf, _ = f.Enc.Encode(buf, r[:n]) // see checkEncode
 			h.Write(buf)
-			if _, err := b.Write(buf); err != nil { // TODO(rsc): avoid alloc
+			if _, err := b.Write(buf); err != nil {
 				alog.Printf("can't write file %s: %v\n", name, err)
 				goto Rescue2
 			}
+			buf = buf[:0]
 		}
 	}
 	if err := b.Flush(); err != nil {
@@ -615,6 +641,25 @@ Rescue2:
 	// fall through
 }
 
+// checkEncode checks that the text q0 to q1 of f can be stored
+// in its encoding, so that Put fails before truncating the file.
+func checkEncode(f *wind.File, q0, q1 int) error {
+	if f.Enc.StoresAll() {
+		return nil
+	}
+	r := bufs.AllocRunes()
+	defer bufs.FreeRunes(r)
+	var n int
+	for q := q0; q < q1; q += n {
+		n = min(q1-q, len(r))
+		f.Read(q, r[:n])
+		if err := f.Enc.Check(r[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func trimspaces(et *wind.Text) {
 	t := &et.W.Body
 	f := t.File
@@ -797,9 +842,140 @@ func local(et, _, argt *wind.Text, _, _ bool, arg []rune) {
 	if len(dir) == 1 && dir[0] == '.' { // sigh
 		dir = nil
 	}
+	if localBuiltin(strings.TrimSpace(string(arg)), dir) {
+		return
+	}
 	Run(nil, string(arg), dir, false, aa, a, false)
 }
 
+// localEnv holds the variables set by Local name=value,
+// as name=value strings, which every command run afterward receives.
+var localEnv struct {
+	sync.Mutex
+	list []string
+}
+
+// localBuiltin interprets the Local commands that change acme's
+// own state, which a subshell could not: "cd dir", which changes
+// acme's directory, and "name=value", which sets a variable in
+// the environment of commands run afterward.
+// A line such as "name=value cmd" is a command for the shell.
+// Relative directories are taken relative to dir.
+// It reports whether s was one of these commands.
+func localBuiltin(s string, dir []rune) bool {
+	if name, value, ok := parseAssign(s); ok {
+		setLocalEnv(name, value)
+		return true
+	}
+	f := strings.Fields(s)
+	if len(f) == 0 || f[0] != "cd" {
+		return false
+	}
+	var d string
+	switch len(f) {
+	case 1:
+		d = os.Getenv("HOME")
+	case 2:
+		d = f[1]
+	default:
+		alog.Printf("Local cd: too many arguments\n")
+		return true
+	}
+	if !filepath.IsAbs(d) {
+		base := ui.Wdir
+		if dir != nil {
+			base = string(dir)
+		}
+		d = filepath.Join(base, d)
+	}
+	if err := os.Chdir(d); err != nil {
+		alog.Printf("Local cd: %v\n", err)
+		return true
+	}
+	ui.Wdir = filepath.Clean(d)
+	return true
+}
+
+// parseAssign parses s as "name=value", where value is a single word,
+// perhaps quoted as in rc, with quotes inside quotes doubled.
+func parseAssign(s string) (name, value string, ok bool) {
+	name, rest, ok := strings.Cut(s, "=")
+	if !ok || !isEnvName(name) {
+		return "", "", false
+	}
+	var b strings.Builder
+	for i := 0; i < len(rest); {
+		switch c := rest[i]; c {
+		case ' ', '\t', '\n':
+			return "", "", false
+		case '\'':
+			for i++; ; i++ {
+				j := strings.IndexByte(rest[i:], '\'')
+				if j < 0 {
+					return "", "", false
+				}
+				b.WriteString(rest[i : i+j])
+				i += j + 1
+				if i == len(rest) || rest[i] != '\'' {
+					break
+				}
+				b.WriteByte('\'')
+			}
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return name, b.String(), true
+}
+
+func isEnvName(s string) bool {
+	for i, r := range s {
+		if r != '_' && !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func setLocalEnv(name, value string) {
+	localEnv.Lock()
+	defer localEnv.Unlock()
+	kv := name + "=" + value
+	for i, old := range localEnv.list {
+		if strings.HasPrefix(old, name+"=") {
+			localEnv.list[i] = kv
+			return
+		}
+	}
+	localEnv.list = append(localEnv.list, kv)
+}
+
+// cmdEnv returns a new environment for a command: acme's own,
+// followed by the variables set by Local and then vars.
+// Each command gets its own, so that commands started at once
+// from different windows do not see each other's variables.
+func cmdEnv(vars ...string) []string {
+	env := os.Environ()
+	localEnv.Lock()
+	env = append(env, localEnv.list...)
+	localEnv.Unlock()
+	return append(env, vars...)
+}
+
+// cmdDir returns the directory in which to run a command from rdir:
+// acme's own if rdir is nil, or $HOME if rdir does not exist.
+func cmdDir(rdir []rune) string {
+	if rdir == nil {
+		return ""
+	}
+	dir := string(rdir)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return os.Getenv("HOME")
+	}
+	return dir
+}
+
 func xkill(_, _, argt *wind.Text, _, _ bool, arg []rune) {
 	var r []rune
 	ui.Getarg(argt, false, false, &r)
@@ -905,6 +1081,58 @@ func indent(et, _, argt *wind.Text, _, _ bool, arg []rune) {
 	}
 }
 
+// theme reads the theme file named by its argument, relative to
+// the window's directory, or rereads the current one, and redraws
+// the screen in its colors. With no theme file, it restores the
+// default colors.
+func theme(et, _, argt *wind.Text, _, _ bool, arg []rune) {
+	file := strings.TrimSpace(string(arg))
+	if file == "" {
+		var r []rune
+		ui.Getarg(argt, false, true, &r)
+		file = string(r)
+	}
+	if file != "" {
+		file = string(wind.Dirname(et, []rune(file)))
+	} else {
+		file = adraw.ThemeFile
+	}
+	th := &adraw.DefaultTheme
+	if file != "" {
+		var err error
+		th, err = adraw.ReadTheme(file)
+		if err != nil {
+			alog.Printf("Theme: %v\n", err)
+			return
+		}
+	}
+	adraw.ThemeFile = file
+	wind.SetTheme(th)
+}
+
+func encx(et, _, argt *wind.Text, _, _ bool, arg []rune) {
+	if et == nil || et.W == nil {
+		return
+	}
+	w := et.W
+	s := strings.TrimSpace(string(arg))
+	if s == "" {
+		var r []rune
+		ui.Getarg(argt, false, true, &r)
+		s = string(r)
+	}
+	if s == "" {
+		alog.Printf("%s: Enc %v\n", string(w.Body.File.Name()), w.Body.File.Enc)
+		return
+	}
+	e, err := enc.Parse(w.Body.File.Enc, s)
+	if err != nil {
+		alog.Printf("Enc: %v\n", err)
+		return
+	}
+	wind.Winsetenc(w, e)
+}
+
 func tab(et, _, argt *wind.Text, _, _ bool, arg []rune) {
 	if et == nil || et.W == nil {
 		return
@@ -956,6 +1184,7 @@ func runproc(win *wind.Window, s string, rdir []rune, newns bool, argaddr, xarg
 	c.IsEditCmd = iseditcmd
 	c.text = s
 	var sfd [3]*os.File
+	var env []string
 	if newns {
 		var incl [][]rune
 		var winid int
@@ -974,11 +1203,9 @@ func runproc(win *wind.Window, s string, rdir []rune, newns bool, argaddr, xarg
 			winid = wind.Activewin.ID
 		}
 
-		os.Setenv("winid", fmt.Sprint(winid)) // TODO(rsc)
-
+		env = cmdEnv(fmt.Sprintf("winid=%d", winid))
 		if filename != "" {
-			os.Setenv("%", filename)       // TODO(rsc)
-			os.Setenv("samfile", filename) // TODO(rsc)
+			env = append(env, "%="+filename, "samfile="+filename)
 		}
 
 		var err error
@@ -1015,11 +1242,21 @@ func runproc(win *wind.Window, s string, rdir []rune, newns bool, argaddr, xarg
 		}
 		fs.Close()
 	} else {
-		// TODO(rsc): This is "Local foo".
-		// Interpret the command since a subshell will not be Local.
-		// Can look for 'Local cd' and 'Local x=y'.
-		alog.Printf("Local not implemented")
-		goto Fail
+		// "Local cmd", once local has interpreted cd and x=y.
+		// Run it in acme's namespace, with output to +Errors.
+		r, w, err := os.Pipe()
+		if err != nil {
+			alog.Printf("Local: %v\n", err)
+			goto Fail
+		}
+		go func() {
+			io.Copy(Errout, r)
+			r.Close()
+		}()
+		sfd[0], _ = os.Open(os.DevNull)
+		sfd[1] = w
+		sfd[2] = w
+		env = cmdEnv()
 	}
 	if win != nil {
 		wind.Winclose(win)
@@ -1029,7 +1266,7 @@ func runproc(win *wind.Window, s string, rdir []rune, newns bool, argaddr, xarg
 	defer sfd[2].Close()
 
 	if argaddr != nil {
-		os.Setenv("acmeaddr", *argaddr) // TODO(rsc)
+		env = append(env, "acmeaddr="+*argaddr)
 	}
 	if Acmeshell != "" {
 		goto Hard
@@ -1058,18 +1295,12 @@ func runproc(win *wind.Window, s string, rdir []rune, newns bool, argaddr, xarg
 		}
 		c.av = av
 
-		var dir string
-		if rdir != nil {
-			dir = string(rdir)
-		}
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			dir = os.Getenv("HOME")
-		}
 		cmd := exec.Command(av[0], av[1:]...)
 		cmd.Stdin = sfd[0]
 		cmd.Stdout = sfd[1]
 		cmd.Stderr = sfd[2]
-		cmd.Dir = dir
+		cmd.Dir = cmdDir(rdir)
+		cmd.Env = env
 		err := cmd.Start()
 		if err == nil {
 			if cpid != nil {
@@ -1089,20 +1320,14 @@ Hard:
 			t += " '" + *xarg + "'" // BUG: what if quote in *xarg? TODO(rsc)
 			c.text = t
 		}
-		var dir string
-		if rdir != nil {
-			dir = string(rdir)
-		}
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			dir = os.Getenv("HOME")
-		}
 		shell := Acmeshell
 		if shell == "" {
 			shell = "rc"
 		}
 		// static void *parg[2];
 		cmd := exec.Command(shell, "-c", t)
-		cmd.Dir = dir
+		cmd.Dir = cmdDir(rdir)
+		cmd.Env = env
 		cmd.Stdin = sfd[0]
 		cmd.Stdout = sfd[1]
 		cmd.Stderr = sfd[2]
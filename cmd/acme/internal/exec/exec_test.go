@@ -0,0 +1,259 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"plramos.win/9fans/cmd/acme/internal/alog"
+	"plramos.win/9fans/cmd/acme/internal/file"
+	"plramos.win/9fans/cmd/acme/internal/ui"
+	"plramos.win/9fans/cmd/acme/internal/wind"
+	"plramos.win/9fans/cmd/internal/base"
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plan9/srv"
+)
+
+// resetLocal restores acme's directory and Local variables after a test.
+func resetLocal(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wdir := ui.Wdir
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		ui.Wdir = wdir
+		localEnv.list = nil
+	})
+}
+
+func TestLocalBuiltin(t *testing.T) {
+	resetLocal(t)
+	var msgs []string
+	alog.Init(func(msg string) { msgs = append(msgs, msg) })
+	defer alog.Init(func(msg string) { fmt.Fprintf(os.Stderr, "acme: %s", msg) })
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if !localBuiltin("cd sub", []rune(dir)) {
+		t.Fatal("cd not interpreted")
+	}
+	want := filepath.Join(dir, "sub")
+	if wd, _ := os.Getwd(); wd != want || ui.Wdir != want {
+		t.Errorf("after cd sub: cwd %s, Wdir %s, want %s", wd, ui.Wdir, want)
+	}
+	if !localBuiltin("cd ..", nil) || ui.Wdir != dir {
+		t.Errorf("after cd .. without dir: Wdir %s, want %s", ui.Wdir, dir)
+	}
+	if !localBuiltin("cd missing", nil) || len(msgs) != 1 || !strings.HasPrefix(msgs[0], "Local cd: ") || ui.Wdir != dir {
+		t.Errorf("cd missing: messages %q, Wdir %s", msgs, ui.Wdir)
+	}
+
+	for _, s := range []string{"x=1", "path='/a:/b c'", "x=2", "_y=", "q='it''s'"} {
+		if !localBuiltin(s, nil) {
+			t.Errorf("%s not interpreted", s)
+		}
+	}
+	if got := strings.Join(localEnv.list, ";"); got != "x=2;path=/a:/b c;_y=;q=it's" {
+		t.Errorf("Local variables = %s", got)
+	}
+	for _, s := range []string{"", "echo a=b", "1x=y", "=y", "cdrom", "mk", "GOOS=linux go build", "x=a b", "x='a", "x='a'' b"} {
+		if localBuiltin(s, nil) {
+			t.Errorf("%q interpreted", s)
+		}
+	}
+}
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestRunLocalConcurrent(t *testing.T) {
+	resetLocal(t)
+	var out syncBuffer
+	defer func(shell string) { Errout = os.Stderr; Acmeshell = shell }(Acmeshell)
+	Errout = &out
+	Acmeshell = "sh"
+
+	dir := t.TempDir()
+	localBuiltin("cd "+dir, nil)
+	localBuiltin("greeting=hello", nil)
+	const n = 10
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("#%d", i)
+		Run(nil, `echo "$acmeaddr $greeting $(pwd)"`, nil, false, &addr, nil, false)
+	}
+	wait(t, n)
+
+	wd, _ := os.Getwd()
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; i < n; i++ {
+		line := fmt.Sprintf("#%d hello %s\n", i, wd)
+		for !strings.Contains(out.String(), line) {
+			if time.Now().After(deadline) {
+				t.Fatalf("output %q does not contain %q", out.String(), line)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// wait waits for n commands started by Run to finish.
+func wait(t *testing.T, n int) {
+	t.Helper()
+	for ncmd, nwait := 0, 0; ncmd < n || nwait < n; {
+		select {
+		case c := <-Ccommand:
+			if c.Proc == nil {
+				t.Fatal("command not started")
+			}
+			ncmd++
+		case w := <-Cwait:
+			if w.Err != nil {
+				t.Error(w.Err)
+			}
+			nwait++
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout: %d started, %d finished", ncmd, nwait)
+		}
+	}
+}
+
+// postAcme posts a stand-in for acme's file server, with a wrsel
+// file for each window id in ids, and makes Run mount it.
+// It returns what the commands write to each window's wrsel.
+func postAcme(t *testing.T, ids []int) map[int]*syncBuffer {
+	ns := t.TempDir()
+	t.Setenv("NAMESPACE", ns)
+	tree := srv.NewTree("glenda", "glenda", 0775)
+	tree.Root.Create("cons", "glenda", 0666, new(syncBuffer))
+	out := make(map[int]*syncBuffer)
+	for _, id := range ids {
+		d, err := tree.Root.Create(fmt.Sprint(id), "glenda", plan9.DMDIR|0775, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[id] = new(syncBuffer)
+		d.Create("wrsel", "glenda", 0666, out[id])
+	}
+	s := &srv.Srv{
+		Tree: tree,
+		Write: func(r *srv.Req) {
+			r.Fid.File.Aux.(*syncBuffer).Write(r.Ifcall.Data)
+			r.Ofcall.Count = uint32(len(r.Ifcall.Data))
+			r.Respond(nil)
+		},
+	}
+	l, err := net.Listen("unix", filepath.Join(ns, "acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { l.Close() })
+
+	mount := Fsysmount
+	Fsysmount = func([]rune, [][]rune) *base.Mntdir { return new(base.Mntdir) }
+	t.Cleanup(func() { Fsysmount = mount })
+	return out
+}
+
+func TestRunWindowsConcurrent(t *testing.T) {
+	resetLocal(t)
+	defer func(shell string) { Acmeshell = shell }(Acmeshell)
+	Acmeshell = ""
+	const n = 20
+	var ids []int
+	for i := 1; i <= n; i++ {
+		ids = append(ids, i)
+	}
+	out := postAcme(t, ids)
+
+	setLocalEnv("greeting", "hello")
+	for _, i := range ids {
+		w := &wind.Window{ID: i}
+		w.Ref = 2 // Run closes its reference
+		w.Body.File = &wind.File{File: new(file.File)}
+		w.Body.File.SetName([]rune(fmt.Sprintf("/win/%d", i)))
+		addr := fmt.Sprintf("#%d", i)
+		// Run env itself, with its output replacing the window's
+		// selection: sh may drop $% as an invalid name.
+		Run(w, "<env", nil, true, &addr, nil, false)
+		// Local can set variables while commands start.
+		setLocalEnv(fmt.Sprintf("v%d", i), "x")
+	}
+	wait(t, n)
+
+	for _, i := range ids {
+		file := fmt.Sprintf("/win/%d", i)
+		want := []string{fmt.Sprintf("winid=%d", i), "%=" + file, "samfile=" + file, fmt.Sprintf("acmeaddr=#%d", i), "greeting=hello"}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got := "\n" + out[i].String()
+			missing := ""
+			for _, kv := range want {
+				if !strings.Contains(got, "\n"+kv+"\n") {
+					missing = kv
+				}
+			}
+			if missing == "" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("window %d: environment lacks %s:\n%s", i, missing, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if v := os.Getenv("winid"); v != "" {
+		t.Errorf("acme's own $winid = %q", v)
+	}
+}
+
+func TestPutLossy(t *testing.T) {
+	var msgs []string
+	alog.Init(func(msg string) { msgs = append(msgs, msg) })
+	defer alog.Init(func(msg string) { fmt.Fprintf(os.Stderr, "acme: %s", msg) })
+
+	data := []byte("one\x00two\n")
+	name := filepath.Join(t.TempDir(), "nul.txt")
+	if err := os.WriteFile(name, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := new(wind.Window)
+	f := &wind.File{File: new(file.File), Info: info, Lossy: true}
+	f.SetName([]rune(name))
+	f.Curtext = &w.Body
+	Putfile(f, 0, 0, f.Name()) // would write an empty file
+	if b, _ := os.ReadFile(name); !bytes.Equal(b, data) || len(msgs) != 1 || !strings.Contains(msgs[0], "not written") {
+		t.Errorf("Put of lossy text: file %q, messages %q", b, msgs)
+	}
+}
@@ -21,17 +21,20 @@ import (
 
 	"plramos.win/9fans/cmd/acme/internal/alog"
 	"plramos.win/9fans/cmd/acme/internal/bufs"
-	"plramos.win/9fans/cmd/acme/internal/runes"
+	"plramos.win/9fans/cmd/acme/internal/enc"
 	"plramos.win/9fans/cmd/acme/internal/util"
 	"plramos.win/9fans/cmd/acme/internal/wind"
 )
 
-func Loadfile(fd *os.File, q0 int, nulls *bool, f func(int, []rune) int, h io.Writer) int {
+// Loadfile reads fd, decoding it with d, and passes the text
+// to f to insert at q0 on. It writes the bytes read to h, if not nil.
+func Loadfile(fd *os.File, q0 int, d *enc.Decoder, f func(int, []rune) int, h io.Writer) int {
 	p := make([]byte, bufs.Len+utf8.UTFMax+1)
-	r := make([]rune, bufs.Len)
+	r := make([]rune, len(p))
 	m := 0
 	n := 1
 	q1 := q0
+	d.DetectFile(fd)
 	/*
 	 * At top of loop, may have m bytes left over from
 	 * last pass, possibly representing a partial rune.
@@ -47,10 +50,7 @@ func Loadfile(fd *os.File, q0 int, nulls *bool, f func(int, []rune) int, h io.Wr
 			h.Write(p[m : m+n])
 		}
 		m += n
-		nb, nr, nulls1 := runes.Convert(p[:m], r, err == io.EOF)
-		if nulls1 {
-			*nulls = true
-		}
+		nb, nr := d.Decode(p[:m], r, err == io.EOF)
 		copy(p, p[nb:m])
 		m -= nb
 		q1 += f(q1, r[:nr])
@@ -65,9 +65,9 @@ func fileloader(f *wind.File) func(pos int, data []rune) int {
 	}
 }
 
-func fileload1(f *wind.File, pos int, fd *os.File, nulls *bool, h io.Writer) int {
+func fileload1(f *wind.File, pos int, fd *os.File, d *enc.Decoder, h io.Writer) int {
 	if pos > f.Len() {
 		util.Fatal("internal error: fileload1")
 	}
-	return Loadfile(fd, pos, nulls, fileloader(f), h)
+	return Loadfile(fd, pos, d, fileloader(f), h)
 }
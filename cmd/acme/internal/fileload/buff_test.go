@@ -0,0 +1,82 @@
+package fileload
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"plramos.win/9fans/cmd/acme/internal/enc"
+)
+
+func TestLoadfileEncoding(t *testing.T) {
+	// Long enough that reads split surrogate pairs and CRLFs.
+	text := strings.Repeat("a\U0001D11Eb\n", 20000)
+	data := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(strings.ReplaceAll(text, "\n", "\r\n"))) {
+		data = append(data, byte(u), byte(u>>8))
+	}
+	file := filepath.Join(t.TempDir(), "utf16.txt")
+	if err := os.WriteFile(file, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	var got []rune
+	var raw bytes.Buffer
+	d := &enc.Decoder{Detect: true}
+	n := Loadfile(fd, 0, d, func(q int, r []rune) int {
+		if q != len(got) {
+			t.Fatalf("insert at %d, want %d", q, len(got))
+		}
+		got = append(got, r...)
+		return len(r)
+	}, &raw)
+	if n != len(got) || string(got) != text {
+		t.Fatalf("Loadfile = %d runes, text matches %v", n, string(got) == text)
+	}
+	if d.Enc.String() != "utf-16le+bom+crlf" || d.Invalid || d.BareLF {
+		t.Errorf("Decoder = %+v", d)
+	}
+	if !bytes.Equal(raw.Bytes(), data) {
+		t.Errorf("hashed bytes differ from file")
+	}
+	b, err := d.Enc.Encode(d.Enc.Start(), got)
+	if err != nil || !bytes.Equal(b, data) {
+		t.Errorf("Encode = %d bytes, %v; want file back", len(b), err)
+	}
+}
+
+func TestLoadfileLate(t *testing.T) {
+	// A Latin-1 byte past the first read must not be lost.
+	data := []byte(strings.Repeat("x", 64*1024) + "caf\xe9\n")
+	file := filepath.Join(t.TempDir(), "late.txt")
+	if err := os.WriteFile(file, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	var got []rune
+	d := &enc.Decoder{Detect: true}
+	Loadfile(fd, 0, d, func(q int, r []rune) int {
+		got = append(got, r...)
+		return len(r)
+	}, nil)
+	if d.Enc.Charset != enc.Latin1 || d.Invalid {
+		t.Errorf("Decoder = %+v, want latin1 read exactly", d)
+	}
+	b, err := d.Enc.Encode(d.Enc.Start(), got)
+	if err != nil || !bytes.Equal(b, data) {
+		t.Errorf("Encode = %d bytes, %v; want file back", len(b), err)
+	}
+}
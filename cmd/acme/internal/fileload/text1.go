@@ -25,6 +25,7 @@ import (
 	"plramos.win/9fans/cmd/acme/internal/alog"
 	"plramos.win/9fans/cmd/acme/internal/bufs"
 	"plramos.win/9fans/cmd/acme/internal/complete"
+	"plramos.win/9fans/cmd/acme/internal/enc"
 	"plramos.win/9fans/cmd/acme/internal/runes"
 	"plramos.win/9fans/cmd/acme/internal/util"
 	"plramos.win/9fans/cmd/acme/internal/wind"
@@ -55,7 +56,7 @@ func Textload(t *wind.Text, q0 int, file string, setqid bool) int {
 		alog.Printf("can't fstat %s: %v\n", file, err)
 		return -1
 	}
-	nulls := false
+	var d *enc.Decoder
 	var h hash.Hash
 	var rp []rune
 	var i int
@@ -103,10 +104,18 @@ func Textload(t *wind.Text, q0 int, file string, setqid bool) int {
 	} else {
 		t.W.IsDir = false
 		t.W.Filemenu = true
+		d = &enc.Decoder{Enc: t.File.Enc}
 		if q0 == 0 {
 			h = sha1.New()
+			d.Detect = !t.File.EncSet
+		}
+		q1 = q0 + fileload(t.File, q0, f, d, h)
+		if d.Detect {
+			t.File.Enc = d.Enc
+		}
+		if q0 == 0 {
+			t.File.Lossy = d.Lossy()
 		}
-		q1 = q0 + fileload(t.File, q0, f, &nulls, h)
 	}
 	if setqid {
 		if h != nil {
@@ -146,8 +155,8 @@ func Textload(t *wind.Text, q0 int, file string, setqid bool) int {
 		}
 		wind.Textsetselect(u, q0, q0)
 	}
-	if nulls {
-		alog.Printf("%s: NUL bytes elided\n", file)
+	if d != nil {
+		Decodewarn(file, d)
 	}
 	return q1 - q0
 }
@@ -212,9 +221,23 @@ func Textcomplete(t *wind.Text) []rune {
 	return rp
 }
 
-func fileload(f *wind.File, p0 int, fd *os.File, nulls *bool, h io.Writer) int {
+func fileload(f *wind.File, p0 int, fd *os.File, d *enc.Decoder, h io.Writer) int {
 	if f.Seq() > 0 {
 		util.Fatal("undo in file.load unimplemented")
 	}
-	return fileload1(f, p0, fd, nulls, h)
+	return fileload1(f, p0, fd, d, h)
+}
+
+// Decodewarn reports what d could not read exactly from file,
+// which Put would therefore not write back as it was.
+func Decodewarn(file string, d *enc.Decoder) {
+	if d.Nulls {
+		alog.Printf("%s: NUL bytes elided; Put will refuse until Enc\n", file)
+	}
+	if d.Invalid {
+		alog.Printf("%s: bytes not valid %s replaced; Put will refuse until Enc\n", file, d.Enc.Charset)
+	}
+	if d.BareLF {
+		alog.Printf("%s: mixed line endings; Put will end all lines in CRLF\n", file)
+	}
 }
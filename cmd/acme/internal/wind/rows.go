@@ -6,6 +6,7 @@ import (
 	"plramos.win/9fans/cmd/acme/internal/adraw"
 	"plramos.win/9fans/cmd/acme/internal/util"
 	"plramos.win/9fans/draw"
+	"plramos.win/9fans/draw/frame"
 )
 
 type Row struct {
@@ -150,6 +151,30 @@ func Rowresize(row *Row, r draw.Rectangle) {
 	}
 }
 
+// SetTheme makes th acme's theme and redraws the screen in it.
+func SetTheme(th *adraw.Theme) {
+	old := []*adraw.Cols{adraw.SetTheme(th)}
+	copy(TheRow.Tag.Fr.Cols[:], adraw.TagCols[:])
+	for _, c := range TheRow.Col {
+		copy(c.Tag.Fr.Cols[:], adraw.TagCols[:])
+		for _, w := range c.W {
+			old = append(old, winsetcols(w))
+		}
+	}
+	adraw.Display.ScreenImage.Draw(TheRow.R, adraw.Display.White, nil, draw.ZP)
+	Rowresize(&TheRow, TheRow.R)
+	for _, c := range TheRow.Col {
+		if len(c.W) == 0 {
+			r := c.R
+			r.Min.Y = c.Tag.All.Max.Y + adraw.Border()
+			adraw.Display.ScreenImage.Draw(r, adraw.TextCols[frame.BACK], nil, draw.ZP)
+		}
+	}
+	for _, c := range old {
+		c.Free()
+	}
+}
+
 func Rowclean(row *Row) bool {
 	clean := true
 	for i := 0; i < len(row.Col); i++ {
@@ -18,7 +18,6 @@ import (
 	"plramos.win/9fans/cmd/acme/internal/adraw"
 	"plramos.win/9fans/cmd/acme/internal/util"
 	"plramos.win/9fans/draw"
-	"plramos.win/9fans/draw/frame"
 )
 
 var scrtmp *draw.Image
@@ -74,10 +73,11 @@ func Textscrdraw(t *Text) {
 	r2 := scrpos(r1, t.Org, t.Org+t.Fr.NumChars, t.Len())
 	if !(r2 == t.lastsr) {
 		t.lastsr = r2
-		b.Draw(r1, t.Fr.Cols[frame.BORD], nil, draw.ZP)
-		b.Draw(r2, t.Fr.Cols[frame.BACK], nil, draw.ZP)
+		cols := wincols(t.W)
+		b.Draw(r1, cols.Scroll, nil, draw.ZP)
+		b.Draw(r2, cols.Thumb, nil, draw.ZP)
 		r2.Min.X = r2.Max.X - 1
-		b.Draw(r2, t.Fr.Cols[frame.BORD], nil, draw.ZP)
+		b.Draw(r2, cols.Scroll, nil, draw.ZP)
 		t.Fr.B.Draw(r, b, nil, draw.Pt(0, r1.Min.Y))
 		//flushimage(display, 1); // BUG?
 	}
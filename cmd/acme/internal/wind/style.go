@@ -0,0 +1,148 @@
+package wind
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"plramos.win/9fans/cmd/acme/internal/adraw"
+	"plramos.win/9fans/draw/frame"
+)
+
+// A styleRun gives the style name of the text q0 to q1.
+type styleRun struct {
+	q0, q1 int
+	name   string
+}
+
+// styleRuns is a list of runs sorted by position,
+// none overlapping or empty.
+type styleRuns []styleRun
+
+// set gives q0 to q1 the style name,
+// or no style if name is "plain".
+func (s *styleRuns) set(q0, q1 int, name string) {
+	if q0 >= q1 {
+		return
+	}
+	var out styleRuns
+	for _, r := range *s {
+		if r.q1 <= q0 || r.q0 >= q1 {
+			out = append(out, r)
+			continue
+		}
+		if r.q0 < q0 {
+			out = append(out, styleRun{r.q0, q0, r.name})
+		}
+		if r.q1 > q1 {
+			out = append(out, styleRun{q1, r.q1, r.name})
+		}
+	}
+	if name != "plain" {
+		out = append(out, styleRun{q0, q1, name})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].q0 < out[j].q0 })
+	*s = out
+}
+
+// insert adjusts the runs for the insertion of n runes at q0.
+// Text inserted inside a run takes its style.
+func (s styleRuns) insert(q0, n int) {
+	for i := range s {
+		r := &s[i]
+		if r.q0 >= q0 {
+			r.q0 += n
+		}
+		if r.q1 > q0 {
+			r.q1 += n
+		}
+	}
+}
+
+// delete adjusts the runs for the deletion of q0 to q1.
+func (s *styleRuns) delete(q0, q1 int) {
+	at := func(q int) int {
+		switch {
+		case q >= q1:
+			return q - (q1 - q0)
+		case q > q0:
+			return q0
+		}
+		return q
+	}
+	out := (*s)[:0]
+	for _, r := range *s {
+		r.q0, r.q1 = at(r.q0), at(r.q1)
+		if r.q0 < r.q1 {
+			out = append(out, r)
+		}
+	}
+	*s = out
+}
+
+// at returns the style name at q, "" for none,
+// and the end of the text from q on that shares it.
+func (s styleRuns) at(q int) (name string, end int) {
+	i := sort.Search(len(s), func(i int) bool { return s[i].q1 > q })
+	if i == len(s) {
+		return "", -1
+	}
+	if s[i].q0 > q {
+		return "", s[i].q0
+	}
+	return s[i].name, s[i].q1
+}
+
+// textstyler is t.Fr.Styler when t has styled text.
+func (t *Text) textstyler(p int) (frame.Style, int) {
+	q := t.Org + p
+	name, end := t.styles.at(q)
+	if end < 0 {
+		return frame.Style{}, t.Fr.NumChars - p
+	}
+	st, _ := adraw.LookupStyle(name)
+	return st, end - q
+}
+
+// setstyler installs or removes t's frame styler
+// according to whether t has styled text.
+func (t *Text) setstyler() {
+	if len(t.styles) == 0 {
+		t.Fr.Styler = nil
+	} else if t.Fr.Styler == nil {
+		t.Fr.Styler = t.textstyler
+	}
+}
+
+// Textsetstyle gives the text q0 to q1 of t and the other texts
+// of its file the style name, or no style if name is "plain",
+// and redraws the text shown.
+func Textsetstyle(t *Text, q0, q1 int, name string) {
+	for _, u := range t.File.Text {
+		u.styles.set(q0, q1, name)
+		u.setstyler()
+		u.Fr.Restyle(q0-u.Org, q1-u.Org)
+	}
+}
+
+// Textclearstyles removes all styles from t and the other texts
+// of its file and redraws them.
+func Textclearstyles(t *Text) {
+	for _, u := range t.File.Text {
+		if len(u.styles) == 0 {
+			continue
+		}
+		u.styles = nil
+		u.Fr.Restyle(0, u.Fr.NumChars)
+		u.setstyler()
+	}
+}
+
+// Textstyles returns the styled runs of t, one "q0 q1 name" per line.
+func Textstyles(t *Text) string {
+	var b strings.Builder
+	for _, r := range t.styles {
+		fmt.Fprintf(&b, "%d %d %s\n", r.q0, r.q1, r.name)
+	}
+	return b.String()
+}
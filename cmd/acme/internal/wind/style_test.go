@@ -0,0 +1,50 @@
+package wind
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStyleRuns(t *testing.T) {
+	var s styleRuns
+	s.set(10, 20, "comment")
+	s.set(0, 5, "keyword")
+	s.set(15, 25, "string")
+	s.set(2, 3, "plain")
+	want := styleRuns{{0, 2, "keyword"}, {3, 5, "keyword"}, {10, 15, "comment"}, {15, 25, "string"}}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("after set: %v, want %v", s, want)
+	}
+
+	s.insert(12, 3) // inside comment: grows it
+	s.insert(5, 1)  // at the end of keyword: does not
+	want = styleRuns{{0, 2, "keyword"}, {3, 5, "keyword"}, {11, 19, "comment"}, {19, 29, "string"}}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("after insert: %v, want %v", s, want)
+	}
+
+	s.delete(1, 12)
+	want = styleRuns{{0, 1, "keyword"}, {1, 8, "comment"}, {8, 18, "string"}}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("after delete: %v, want %v", s, want)
+	}
+
+	for _, tt := range []struct {
+		q    int
+		name string
+		end  int
+	}{
+		{0, "keyword", 1},
+		{1, "comment", 8},
+		{17, "string", 18},
+		{18, "", -1},
+	} {
+		if name, end := s.at(tt.q); name != tt.name || end != tt.end {
+			t.Errorf("at(%d) = %q, %d, want %q, %d", tt.q, name, end, tt.name, tt.end)
+		}
+	}
+	s.set(3, 5, "plain")
+	if name, end := s.at(3); name != "" || end != 5 {
+		t.Errorf("at(3) after clearing = %q, %d, want \"\", 5", name, end)
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"plramos.win/9fans/cmd/acme/internal/adraw"
 	"plramos.win/9fans/cmd/acme/internal/bufs"
+	"plramos.win/9fans/cmd/acme/internal/enc"
 	"plramos.win/9fans/cmd/acme/internal/file"
 	"plramos.win/9fans/cmd/acme/internal/runes"
 	"plramos.win/9fans/cmd/acme/internal/util"
@@ -34,6 +35,7 @@ type Text struct {
 	Cache    []rune
 	Nofill   bool
 	Needundo bool
+	styles   styleRuns // styled text, set through the style file
 }
 
 func (t *Text) RuneAt(pos int) rune { return Textreadc(t, pos) }
@@ -47,6 +49,9 @@ type File struct {
 	Info    os.FileInfo
 	SHA1    [20]byte
 	Unread  bool
+	Enc     enc.Encoding // how the file is stored
+	EncSet  bool         // Enc was given by Enc or ctl, not detected
+	Lossy   bool         // NULs were elided or invalid bytes replaced when read
 	dumpid  int
 }
 
@@ -131,6 +136,8 @@ func Textreset(t *Text) {
 	t.Org = 0
 	t.Q0 = 0
 	t.Q1 = 0
+	t.styles = nil
+	t.setstyler()
 	t.File.ResetLogs()
 	t.File.Truncate()
 }
@@ -328,6 +335,7 @@ func Textinsert(t *Text, q0 int, r []rune, tofile bool) {
 		}
 
 	}
+	t.styles.insert(q0, len(r))
 	if q0 < t.IQ1 {
 		t.IQ1 += len(r)
 	}
@@ -381,6 +389,8 @@ func Textdelete(t *Text, q0 int, q1 int, tofile bool) {
 			}
 		}
 	}
+	t.styles.delete(q0, q1)
+	t.setstyler()
 	if q0 < t.IQ1 {
 		t.IQ1 -= util.Min(n, t.IQ1-q0)
 	}
@@ -623,6 +633,7 @@ func Textsetorigin(t *Text, org int, exact bool) {
 		n := t.Org - org
 		r := make([]rune, n)
 		t.File.Read(org, r)
+		t.Org = org // for the styler
 		t.Fr.Insert(r, 0)
 	} else {
 		t.Fr.Delete(0, t.Fr.NumChars)
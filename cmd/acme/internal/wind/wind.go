@@ -11,6 +11,7 @@ import (
 	"plramos.win/9fans/cmd/acme/internal/adraw"
 	"plramos.win/9fans/cmd/acme/internal/alog"
 	"plramos.win/9fans/cmd/acme/internal/bufs"
+	"plramos.win/9fans/cmd/acme/internal/enc"
 	"plramos.win/9fans/cmd/acme/internal/file"
 	"plramos.win/9fans/cmd/acme/internal/runes"
 	"plramos.win/9fans/cmd/acme/internal/util"
@@ -61,6 +62,8 @@ type Window struct {
 	Editoutlk   util.QLock
 	External    bool
 	IsTabExpand bool
+	Colors      *adraw.Theme // colors set by ctl, overriding adraw.CurTheme
+	cols        *adraw.Cols  // images for Colors, or nil
 }
 
 // Text.what
@@ -107,7 +110,13 @@ func Init(w *Window, clone *Window, r draw.Rectangle) {
 
 	util.Incref(&adraw.RefFont1.Ref)
 	f := fileaddtext(nil, &w.Tag)
-	textinit(&w.Tag, f, r1, &adraw.RefFont1, adraw.TagCols[:])
+	if clone != nil && clone.Colors != nil {
+		th := *clone.Colors
+		w.Colors = &th
+		th = adraw.CurTheme.Merge(w.Colors)
+		w.cols = adraw.AllocCols(&th)
+	}
+	textinit(&w.Tag, f, r1, &adraw.RefFont1, wincols(w).Tag[:])
 	w.Tag.What = Tag
 	// tag is a copy of the contents, not a tracked image
 	if clone != nil {
@@ -130,23 +139,21 @@ func Init(w *Window, clone *Window, r draw.Rectangle) {
 		f = clone.Body.File
 		w.Body.Org = clone.Body.Org
 		w.IsScratch = clone.IsScratch
+		w.Body.styles = append(styleRuns(nil), clone.Body.styles...)
+		w.Body.setstyler()
 		rf = adraw.FindFont(false, false, false, clone.Body.Reffont.F.Name)
 	} else {
 		rf = adraw.FindFont(false, false, false, "")
 	}
 	f = fileaddtext(f, &w.Body)
 	w.Body.What = Body
-	textinit(&w.Body, f, r1, rf, adraw.TextCols[:])
+	textinit(&w.Body, f, r1, rf, wincols(w).Body[:])
 	r1.Min.Y -= 1
 	r1.Max.Y = r1.Min.Y + 1
-	adraw.Display.ScreenImage.Draw(r1, adraw.TagCols[frame.BORD], nil, draw.ZP)
+	adraw.Display.ScreenImage.Draw(r1, w.Tag.Fr.Cols[frame.BORD], nil, draw.ZP)
 	Textscrdraw(&w.Body)
 	w.R = r
-	var br draw.Rectangle
-	br.Min = w.Tag.ScrollR.Min
-	br.Max.X = br.Min.X + adraw.Button.R.Dx()
-	br.Max.Y = br.Min.Y + adraw.Button.R.Dy()
-	adraw.Display.ScreenImage.Draw(br, adraw.Button, nil, adraw.Button.R.Min)
+	windrawbutton(w)
 	w.Filemenu = true
 	w.Maxlines = w.Body.Fr.MaxLines
 	w.Autoindent = GlobalAutoindent
@@ -163,17 +170,68 @@ func Init(w *Window, clone *Window, r draw.Rectangle) {
  * Draw the appropriate button.
  */
 func windrawbutton(w *Window) {
+	mod := !w.IsDir && !w.IsScratch && (w.Body.File.Mod() || len(w.Body.Cache) != 0)
 	b := adraw.Button
-	if !w.IsDir && !w.IsScratch && (w.Body.File.Mod() || len(w.Body.Cache) != 0) {
+	if mod {
 		b = adraw.ModButton
 	}
 	var br draw.Rectangle
 	br.Min = w.Tag.ScrollR.Min
 	br.Max.X = br.Min.X + b.R.Dx()
 	br.Max.Y = br.Min.Y + b.R.Dy()
+	if w.cols != nil {
+		adraw.DrawButton(adraw.Display.ScreenImage, br, w.cols.Tag[:], mod)
+		return
+	}
 	adraw.Display.ScreenImage.Draw(br, b, nil, b.R.Min)
 }
 
+// wincols returns the images for drawing w.
+func wincols(w *Window) *adraw.Cols {
+	if w.cols != nil {
+		return w.cols
+	}
+	return &adraw.Cols{Tag: adraw.TagCols, Body: adraw.TextCols, Scroll: adraw.ScrollCol, Thumb: adraw.ThumbCol}
+}
+
+// Winsetenc sets the encoding in which Get and Put read and write
+// w's file. The tag shows Put, since Put now changes the file.
+func Winsetenc(w *Window, e enc.Encoding) {
+	f := w.Body.File
+	f.Enc = e
+	f.EncSet = true
+	f.Lossy = false
+	for _, t := range f.Text {
+		t.W.Putseq = -1
+		Winsettag(t.W)
+	}
+}
+
+// Winsetcolors reallocates w's images after a change
+// to w.Colors or to adraw.CurTheme, and redraws w
+// if it is on the screen.
+func Winsetcolors(w *Window) {
+	old := winsetcols(w)
+	if w.Col != nil && w.R.Dy() > 0 {
+		Winresize(w, w.R, false, true)
+	}
+	old.Free()
+}
+
+// winsetcols reallocates w's images, returning the old ones.
+func winsetcols(w *Window) (old *adraw.Cols) {
+	old = w.cols
+	w.cols = nil
+	if w.Colors != nil {
+		th := adraw.CurTheme.Merge(w.Colors)
+		w.cols = adraw.AllocCols(&th)
+	}
+	cols := wincols(w)
+	copy(w.Tag.Fr.Cols[:], cols.Tag[:])
+	copy(w.Body.Fr.Cols[:], cols.Body[:])
+	return old
+}
+
 func Delrunepos(w *Window) int {
 	_, i := parsetag(w, 0)
 	i += 2
@@ -259,12 +317,12 @@ func Winresize(w *Window, r draw.Rectangle, safe, keepextra bool) int {
 		if y+1+w.Body.Fr.Font.Height <= r.Max.Y { // room for one line
 			r1.Min.Y = y
 			r1.Max.Y = y + 1
-			adraw.Display.ScreenImage.Draw(r1, adraw.TagCols[frame.BORD], nil, draw.ZP)
+			adraw.Display.ScreenImage.Draw(r1, w.Tag.Fr.Cols[frame.BORD], nil, draw.ZP)
 			y++
 			r1.Min.Y = util.Min(y, r.Max.Y)
 			r1.Max.Y = r.Max.Y
 		} else {
-			adraw.Display.ScreenImage.Draw(r1, adraw.TagCols[frame.BACK], nil, draw.ZP)
+			adraw.Display.ScreenImage.Draw(r1, w.Tag.Fr.Cols[frame.BACK], nil, draw.ZP)
 			r1.Min.Y = y
 			r1.Max.Y = y
 		}
@@ -342,6 +400,8 @@ func Winclose(w *Window) {
 		Windirfree(w)
 		textclose(&w.Tag)
 		textclose(&w.Body)
+		w.cols.Free()
+		w.cols = nil
 		if Activewin == w {
 			Activewin = nil
 		}
@@ -611,12 +671,13 @@ func Winctlprint(w *Window, fonts bool) string {
 		bool2int(w.Dirty),
 	)
 	if fonts {
-		base += fmt.Sprintf("%11d %q %11d %11d %11d ",
+		base += fmt.Sprintf("%11d %q %11d %11d %11d %s ",
 			w.Body.Fr.R.Dx(),
 			w.Body.Reffont.F.Name,
 			w.Body.Fr.MaxTab,
 			bool2int(w.Body.File.Seq() != 0),
 			bool2int(w.Body.File.RedoSeq() != 0),
+			w.Body.File.Enc,
 		)
 	}
 	return base
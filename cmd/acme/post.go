@@ -2,16 +2,21 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"os/exec"
 	"strings"
 
-	"plramos.win/9fans/plan9/client"
+	"plramos.win/9fans/cmd/acme/internal/util"
+	"plramos.win/9fans/plan9/mux"
 )
 
 var chattyfuse int
 
+// post9pservice makes the 9P server talking on rfd and wfd
+// available to other programs as the service name.
+// In plan9port this runs 9pserve; here the multiplexer
+// runs inside acme itself.
 func post9pservice(rfd, wfd *os.File, name, mtpt string) error {
 	if name == "" && mtpt == "" {
 		rfd.Close()
@@ -20,20 +25,24 @@ func post9pservice(rfd, wfd *os.File, name, mtpt string) error {
 	}
 
 	if name != "" {
-		var addr string
-		if strings.Contains(addr, "!") { // assume is already network address
-			addr = name
-		} else {
-			addr = "unix!" + client.Namespace() + "/" + name
-		}
-		cmd := exec.Command("9pserve", "-u", addr)
-		cmd.Stdin = rfd
-		cmd.Stdout = wfd
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			return err
+		if strings.Contains(name, "!") && !strings.HasPrefix(name, "unix!") {
+			return fmt.Errorf("cannot post to network address %s", name)
 		}
+		srv := struct {
+			io.Reader
+			io.Writer
+		}{rfd, wfd}
+		// mux.New does the version exchange with the server,
+		// which cannot answer until acme's threads are running.
+		go func() {
+			m, err := mux.New(srv)
+			if err == nil {
+				_, err = m.Post(strings.TrimPrefix(name, "unix!"))
+			}
+			if err != nil {
+				util.Fatal("can't post service: " + err.Error())
+			}
+		}()
 		if mtpt != "" {
 			// reopen
 			log.Fatalf("post9pservice mount not implemented")
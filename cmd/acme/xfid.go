@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
@@ -27,6 +28,7 @@ import (
 	"plramos.win/9fans/cmd/acme/internal/bufs"
 	"plramos.win/9fans/cmd/acme/internal/disk"
 	editpkg "plramos.win/9fans/cmd/acme/internal/edit"
+	"plramos.win/9fans/cmd/acme/internal/enc"
 	"plramos.win/9fans/cmd/acme/internal/exec"
 	"plramos.win/9fans/cmd/acme/internal/file"
 	"plramos.win/9fans/cmd/acme/internal/runes"
@@ -47,6 +49,7 @@ var (
 	Eaddr     string = "address out of range"
 	Einuse    string = "already in use"
 	Ebadevent string = "bad event syntax"
+	Ebadstyle string = "bad style syntax"
 )
 
 // extern var Eperm [unknown]C.char
@@ -373,6 +376,9 @@ func xfidread(x *Xfid) {
 			buf = []byte("on")
 		}
 		goto Readbuf
+	case QWstyle:
+		buf = []byte(wind.Textstyles(&w.Body))
+		goto Readbuf
 	default:
 		respond(x, &fc, fmt.Sprintf("unknown qid %d in read", q))
 	}
@@ -527,6 +533,9 @@ func xfidwrite(x *Xfid) {
 	case QWevent:
 		xfideventwrite(x, w)
 
+	case QWstyle:
+		xfidstylewrite(x, w)
+
 	case Qcons, QWerrors, QWbody, QWwrsel, QWtag:
 		var t *wind.Text
 		switch qid {
@@ -589,6 +598,42 @@ func xfidwrite(x *Xfid) {
 	}
 }
 
+// xfidstylewrite handles a write to the style file,
+// whose lines are either "q0 q1 name", giving the body text
+// q0 to q1 the style name, or "clear", removing all styles.
+func xfidstylewrite(x *Xfid, w *wind.Window) {
+	var fc plan9.Fcall
+	t := &w.Body
+	wind.Wincommit(w, t)
+	for _, line := range strings.Split(string(x.fcall.Data), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) == 1 && f[0] == "clear" {
+			wind.Textclearstyles(t)
+			continue
+		}
+		if len(f) != 3 {
+			respond(x, &fc, Ebadstyle)
+			return
+		}
+		q0, err0 := strconv.Atoi(f[0])
+		q1, err1 := strconv.Atoi(f[1])
+		if err0 != nil || err1 != nil {
+			respond(x, &fc, Ebadstyle)
+			return
+		}
+		if q0 < 0 || q0 > q1 || q1 > t.Len() {
+			respond(x, &fc, Eaddr)
+			return
+		}
+		wind.Textsetstyle(t, q0, q1, f[2])
+	}
+	fc.Count = uint32(len(x.fcall.Data))
+	respond(x, &fc, "")
+}
+
 func xfidctlwrite(x *Xfid, w *wind.Window) {
 	scrdraw := false
 	settag := false
@@ -768,6 +813,47 @@ func xfidctlwrite(x *Xfid, w *wind.Window) {
 			w.Filemenu = true
 			settag = true
 			p = p[4:]
+		} else if strings.HasPrefix(p, "color ") { // override a color
+			pp := p[6:]
+			p = p[6:]
+			i := strings.Index(pp, "\n")
+			if i <= 0 {
+				err = Ebadctl
+				break
+			}
+			pp = pp[:i]
+			p = p[i+1:]
+			key, value, _ := strings.Cut(strings.TrimSpace(pp), " ")
+			th := new(adraw.Theme)
+			if w.Colors != nil {
+				*th = *w.Colors
+			}
+			if e := th.SetWindow(key, value); e != nil {
+				err = e.Error()
+				break
+			}
+			w.Colors = th
+			wind.Winsetcolors(w)
+		} else if strings.HasPrefix(p, "encoding ") { // set file encoding for Get and Put
+			pp := p[9:]
+			p = p[9:]
+			i := strings.Index(pp, "\n")
+			if i <= 0 {
+				err = Ebadctl
+				break
+			}
+			pp = pp[:i]
+			p = p[i+1:]
+			e, perr := enc.Parse(w.Body.File.Enc, pp)
+			if perr != nil {
+				err = perr.Error()
+				break
+			}
+			wind.Winsetenc(w, e)
+		} else if strings.HasPrefix(p, "nocolor") { // restore theme colors
+			w.Colors = nil
+			wind.Winsetcolors(w)
+			p = p[7:]
 		} else if strings.HasPrefix(p, "cleartag") { // wipe tag right of bar
 			wind.Wincleartatg(w)
 			settag = true
@@ -0,0 +1,256 @@
+// Plumber is a Go implementation of the Plan 9 plumber.
+//
+// Usage:
+//
+//	plumber [-d] [-p rulesfile] [-s service]
+//
+// Plumber reads the plumbing rules (by default $HOME/lib/plumbing,
+// or $PLAN9/plumb/initial.plumbing if that does not exist) and serves
+// them as a 9P file tree posted as the service "plumb" in the name space
+// directory. Writing a message to the send file applies the rules to it
+// and delivers the result to the destination port, one file per port,
+// or runs the command given by the matching rule.
+// Reading the rules file returns the current rules; writing it
+// replaces them.
+package main // import "plramos.win/9fans/cmd/plumber"
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plan9/client"
+	"plramos.win/9fans/plan9/srv"
+	"plramos.win/9fans/plumb/rules"
+)
+
+var (
+	debug     = flag.Bool("d", false, "print 9P messages")
+	rulesFile = flag.String("p", "", "read rules from `file`")
+	service   = flag.String("s", "plumb", "post as `service`")
+)
+
+// Ports that exist whether or not the rules mention them.
+var stdPorts = []string{"edit", "image", "msntext", "postscript", "seemail", "sendmail", "showmail", "web"}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: plumber [-d] [-p rulesfile] [-s service]\n")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("plumber: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 0 {
+		usage()
+	}
+
+	file := *rulesFile
+	if file == "" {
+		file = filepath.Join(os.Getenv("HOME"), "lib/plumbing")
+		if _, err := os.Stat(file); err != nil {
+			file = filepath.Join(os.Getenv("PLAN9"), "plumb/initial.plumbing")
+		}
+	}
+	rs, err := rules.ParseFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := newPlumber(rs)
+	s := p.srv()
+	s.Chatty = *debug
+
+	l, err := post(*service)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(s.Serve(l))
+}
+
+// post listens on the unix socket for service in the name space directory.
+func post(service string) (net.Listener, error) {
+	ns := client.Namespace()
+	if err := os.MkdirAll(ns, 0700); err != nil {
+		return nil, err
+	}
+	addr := filepath.Join(ns, service)
+	if c, err := net.Dial("unix", addr); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("%s: service already posted", addr)
+	}
+	os.Remove(addr)
+	return net.Listen("unix", addr)
+}
+
+// A plumber holds the rules and ports being served.
+type plumber struct {
+	user string
+	tree *srv.Tree
+	send *srv.File
+	rf   *srv.File // rules
+
+	mu    sync.Mutex
+	rules *rules.Rules
+	ports map[string]*port
+}
+
+func newPlumber(rs *rules.Rules) *plumber {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "none"
+	}
+	p := &plumber{
+		user:  user,
+		tree:  srv.NewTree(user, user, 0555),
+		rules: rs,
+		ports: make(map[string]*port),
+	}
+	p.send, _ = p.tree.Root.Create("send", user, 0222, nil)
+	p.rf, _ = p.tree.Root.Create("rules", user, 0600, nil)
+	p.addPorts()
+	return p
+}
+
+// addPorts creates files for ports named in the rules.
+// The caller must hold p.mu or be the only user of p.
+func (p *plumber) addPorts() {
+	for _, name := range append(stdPorts, p.rules.Ports()...) {
+		if p.ports[name] != nil {
+			continue
+		}
+		f, err := p.tree.Root.Create(name, p.user, 0444, nil)
+		if err != nil {
+			continue
+		}
+		pt := &port{name: name, readers: make(map[*reader]bool)}
+		f.Aux = pt
+		p.ports[name] = pt
+	}
+}
+
+func (p *plumber) srv() *srv.Srv {
+	return &srv.Srv{
+		Tree:       p.tree,
+		Open:       p.open,
+		Read:       p.read,
+		Write:      p.write,
+		Destroyfid: p.destroyfid,
+	}
+}
+
+func (p *plumber) open(r *srv.Req) {
+	switch f := r.Fid.File; {
+	case f.Aux != nil:
+		pt := f.Aux.(*port)
+		rd := &reader{port: pt}
+		r.Fid.Aux = rd
+		pt.add(rd)
+	case f == p.send:
+		r.Fid.Aux = new(sendBuf)
+	case f == p.rf && r.Ifcall.Mode&3 != plan9.OREAD:
+		rb := new(rulesBuf)
+		if r.Ifcall.Mode&plan9.OTRUNC == 0 {
+			p.mu.Lock()
+			rb.text = append(rb.text, p.rules.Text...)
+			p.mu.Unlock()
+		}
+		r.Fid.Aux = rb
+	}
+	r.Respond(nil)
+}
+
+func (p *plumber) read(r *srv.Req) {
+	switch aux := r.Fid.Aux.(type) {
+	case *reader:
+		aux.read(r)
+	default:
+		if r.Fid.File == p.rf {
+			p.mu.Lock()
+			text := p.rules.Text
+			p.mu.Unlock()
+			r.ReadBytes(text)
+			r.Respond(nil)
+			return
+		}
+		r.Respond(srv.Eperm)
+	}
+}
+
+func (p *plumber) write(r *srv.Req) {
+	r.Ofcall.Count = uint32(len(r.Ifcall.Data))
+	switch aux := r.Fid.Aux.(type) {
+	case *sendBuf:
+		r.Respond(p.sendData(aux, r.Ifcall.Data))
+	case *rulesBuf:
+		aux.text = append(aux.text, r.Ifcall.Data...)
+		r.Respond(p.loadRules(aux, false))
+	default:
+		r.Respond(srv.Eperm)
+	}
+}
+
+func (p *plumber) destroyfid(fid *srv.Fid) {
+	switch aux := fid.Aux.(type) {
+	case *reader:
+		aux.port.remove(aux)
+	case *rulesBuf:
+		if err := p.loadRules(aux, true); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// A rulesBuf accumulates text written to the rules file.
+type rulesBuf struct {
+	text   []byte
+	loaded bool
+}
+
+// loadRules installs the rules written so far, if they parse.
+// Until the file is closed, a parse error may just mean
+// that more text is still to come.
+func (p *plumber) loadRules(rb *rulesBuf, closing bool) error {
+	rs, err := rules.Parse("rules", rb.text, nil)
+	if err != nil {
+		rb.loaded = false
+		if closing {
+			return err
+		}
+		return nil
+	}
+	if rb.loaded && closing {
+		return nil
+	}
+	p.mu.Lock()
+	p.rules = rs
+	p.addPorts()
+	p.mu.Unlock()
+	rb.loaded = true
+	return nil
+}
+
+// run starts cmd in dir using rc, or sh if rc is not available.
+func run(cmd, dir string) error {
+	shell := "rc"
+	if _, err := exec.LookPath(shell); err != nil {
+		shell = "sh"
+	}
+	c := exec.Command(shell, "-c", cmd)
+	c.Dir = dir
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Start(); err != nil {
+		return err
+	}
+	go c.Wait()
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"plramos.win/9fans/plan9/srv"
+	"plramos.win/9fans/plumb"
+)
+
+// A port is a destination for plumb messages.
+// Every open of the port's file receives its own copy of each message.
+type port struct {
+	name string
+
+	mu      sync.Mutex
+	readers map[*reader]bool
+	held    [][]byte // messages waiting for a client to open the port
+}
+
+// A reader is a single open of a port file.
+type reader struct {
+	port *port
+
+	// guarded by port.mu
+	queue   [][]byte
+	partial []byte
+	pending []*srv.Req
+}
+
+func (pt *port) add(rd *reader) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.readers[rd] = true
+	rd.queue = append(rd.queue, pt.held...)
+	pt.held = nil
+}
+
+func (pt *port) remove(rd *reader) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	delete(pt.readers, rd)
+	for _, r := range rd.pending {
+		r.Respond(srv.Error("port closed"))
+	}
+	rd.pending = nil
+}
+
+// deliver queues msg for every reader of pt.
+// It reports false if there are no readers.
+func (pt *port) deliver(msg []byte) bool {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	if len(pt.readers) == 0 {
+		return false
+	}
+	for rd := range pt.readers {
+		rd.queue = append(rd.queue, msg)
+		rd.kick()
+	}
+	return true
+}
+
+// hold keeps msg until the next reader opens pt.
+func (pt *port) hold(msg []byte) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.held = append(pt.held, msg)
+}
+
+func (rd *reader) read(r *srv.Req) {
+	pt := rd.port
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	rd.pending = append(rd.pending, r)
+	if rd.kick(); !rd.isPending(r) {
+		return
+	}
+	go func() {
+		<-r.Context().Done()
+		pt.mu.Lock()
+		defer pt.mu.Unlock()
+		if rd.isPending(r) {
+			rd.drop(r)
+			r.Respond(srv.Error("interrupted"))
+		}
+	}()
+}
+
+func (rd *reader) isPending(r *srv.Req) bool {
+	for _, r1 := range rd.pending {
+		if r1 == r {
+			return true
+		}
+	}
+	return false
+}
+
+func (rd *reader) drop(r *srv.Req) {
+	for i, r1 := range rd.pending {
+		if r1 == r {
+			rd.pending = append(rd.pending[:i], rd.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// kick answers pending reads from the queued messages.
+// A message longer than a read is returned across several reads.
+// The caller must hold rd.port.mu.
+func (rd *reader) kick() {
+	for len(rd.pending) > 0 {
+		if len(rd.partial) == 0 {
+			if len(rd.queue) == 0 {
+				return
+			}
+			rd.partial = rd.queue[0]
+			rd.queue = rd.queue[1:]
+		}
+		r := rd.pending[0]
+		rd.pending = rd.pending[1:]
+		n := int(r.Ifcall.Count)
+		if n > len(rd.partial) {
+			n = len(rd.partial)
+		}
+		r.Ofcall.Data = rd.partial[:n]
+		rd.partial = rd.partial[n:]
+		r.Respond(nil)
+	}
+}
+
+// A sendBuf accumulates text written to the send file,
+// since a large message may arrive in several writes.
+type sendBuf struct {
+	buf []byte
+}
+
+var errNoPort = errors.New("no port for message")
+
+// sendData adds data to the messages written on sb
+// and plumbs each complete message.
+func (p *plumber) sendData(sb *sendBuf, data []byte) error {
+	sb.buf = append(sb.buf, data...)
+	for len(sb.buf) > 0 {
+		rd := bytes.NewReader(sb.buf)
+		m := new(plumb.Message)
+		if err := m.Recv(rd); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil // wait for more
+			}
+			sb.buf = nil
+			return err
+		}
+		sb.buf = sb.buf[len(sb.buf)-rd.Len():]
+		if err := p.plumb(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// plumb applies the rules to m and acts on the result.
+func (p *plumber) plumb(m *plumb.Message) error {
+	p.mu.Lock()
+	rs := p.rules
+	p.mu.Unlock()
+	e, err := rs.Match(m)
+	if err != nil {
+		return err
+	}
+	if e.Msg.Dst == "" {
+		e.Msg.Dst = e.Port
+	}
+	var buf bytes.Buffer
+	e.Msg.Send(&buf)
+	msg := buf.Bytes()
+
+	p.mu.Lock()
+	pt := p.ports[e.Port]
+	p.mu.Unlock()
+	if pt != nil && pt.deliver(msg) {
+		return nil
+	}
+	switch {
+	case e.Start != "":
+		return run(e.Start, e.Msg.Dir)
+	case e.Client != "" && pt != nil:
+		pt.hold(msg)
+		return run(e.Client, e.Msg.Dir)
+	}
+	return errNoPort
+}
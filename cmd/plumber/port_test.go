@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"plramos.win/9fans/plumb"
+	"plramos.win/9fans/plumb/rules"
+)
+
+func TestSendSplit(t *testing.T) {
+	rs, err := rules.Parse("test", []byte("type is text\nplumb to edit\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPlumber(rs)
+	rd := &reader{port: p.ports["edit"]}
+	rd.port.add(rd)
+
+	m := &plumb.Message{
+		Src:  "test",
+		Type: "text",
+		Attr: &plumb.Attribute{Name: "addr", Value: "1"},
+		Data: []byte("hello, world"),
+	}
+	var buf bytes.Buffer
+	m.Send(&buf)
+	m.Send(&buf)
+	b := buf.Bytes()
+
+	// Two messages in writes split at every point of the first.
+	for i := 1; i < len(b)/2; i++ {
+		rd.queue = nil
+		sb := new(sendBuf)
+		for _, w := range [][]byte{b[:i], b[i : len(b)/2+1], b[len(b)/2+1:]} {
+			if err := p.sendData(sb, w); err != nil {
+				t.Fatalf("split at %d: %v", i, err)
+			}
+		}
+		if len(rd.queue) != 2 || len(sb.buf) != 0 {
+			t.Fatalf("split at %d: %d messages queued, %d bytes left", i, len(rd.queue), len(sb.buf))
+		}
+		got := new(plumb.Message)
+		if err := got.Recv(bytes.NewReader(rd.queue[1])); err != nil || string(got.Data) != "hello, world" || got.Dst != "edit" {
+			t.Fatalf("split at %d: got %+v, %v", i, got, err)
+		}
+	}
+
+	if err := p.sendData(new(sendBuf), []byte("a\nb\nc\ntext\n\nxyz\n")); err == nil {
+		t.Errorf("sendData accepted a bad message")
+	}
+}
@@ -49,6 +49,14 @@ type Frame struct {
 	P0, P1 int // selection
 	MaxTab int // max size of tab, in pixels
 
+	// Styler, if set, gives the style of the rune at offset p
+	// in the frame and the number of runes, at least one,
+	// from p on that share it. Styles apply to text drawn
+	// in the frame's plain colors, not to selected text.
+	// Callers must redraw text whose style changes;
+	// see Restyle.
+	Styler func(p int) (style Style, n int)
+
 	// Read-only to clients.
 	Font         *draw.Font        // of chars in the frame
 	Display      *draw.Display     // on which frame appears
@@ -71,6 +79,13 @@ type Frame struct {
 	modified  bool        // changed since frselect
 }
 
+// A Style gives the colors of a run of styled text.
+// A nil image leaves the frame's plain color in its place.
+type Style struct {
+	Text *draw.Image // text color
+	Back *draw.Image // background color behind the characters
+}
+
 // BACK, HIGH, BORD, TEXT, and HTEXT are indices into Frame.Cols/
 const (
 	BACK  = iota // background color
@@ -7,16 +7,77 @@ import (
 )
 
 func (f *Frame) drawtext(pt draw.Point, text, back *draw.Image) {
+	p := 0
 	for nb := 0; nb < len(f.box); nb++ {
 		b := &f.box[nb]
 		f.cklinewrap(&pt, b)
 		if f.NoRedraw == 0 && b.nrune >= 0 {
-			f.B.BytesBg(pt, text, draw.Point{}, f.Font, b.bytes, back, draw.Point{})
+			f.bytesBg(pt, p, b.bytes, b.nrune, text, back)
 		}
+		p += b.NRUNE()
 		pt.X += b.wid
 	}
 }
 
+// bytesBg draws the nr runes in s, which start at rune offset p,
+// at pt in text on back, or, if text is the frame's plain text color,
+// in the styles f.Styler gives for them.
+func (f *Frame) bytesBg(pt draw.Point, p int, s []byte, nr int, text, back *draw.Image) {
+	if f.Styler == nil || text != f.Cols[TEXT] {
+		f.B.BytesBg(pt, text, draw.Point{}, f.Font, s, back, draw.Point{})
+		return
+	}
+	for nr > 0 {
+		st, n := f.Styler(p)
+		if n < 1 {
+			n = 1
+		}
+		if n > nr {
+			n = nr
+		}
+		t, b := text, back
+		if st.Text != nil {
+			t = st.Text
+		}
+		if st.Back != nil {
+			b = st.Back
+		}
+		i := runeindex(s, n)
+		pt = f.B.BytesBg(pt, t, draw.Point{}, f.Font, s[:i], b, draw.Point{})
+		s = s[i:]
+		p += n
+		nr -= n
+	}
+}
+
+// Restyle redraws the text between rune offsets p0 and p1
+// after a change in the styles f.Styler gives for it.
+// Selected text, which is drawn without styles, is left alone.
+func (f *Frame) Restyle(p0, p1 int) {
+	if p0 < 0 {
+		p0 = 0
+	}
+	if p1 > f.NumChars {
+		p1 = f.NumChars
+	}
+	if p0 >= p1 || f.B == nil || f.NoRedraw != 0 {
+		return
+	}
+	ticked := f.Ticked
+	if ticked {
+		f.Tick(f.PointOf(f.P0), false)
+	}
+	if q1 := min(p1, f.P0); p0 < q1 {
+		f.Drawsel0(f.PointOf(p0), p0, q1, f.Cols[BACK], f.Cols[TEXT])
+	}
+	if q0 := max(p0, f.P1); q0 < p1 {
+		f.Drawsel0(f.PointOf(q0), q0, p1, f.Cols[BACK], f.Cols[TEXT])
+	}
+	if ticked {
+		f.Tick(f.PointOf(f.P0), true)
+	}
+}
+
 // Drawsel repaints a section of the frame,
 // delimited by character positions p0 and p1, either with
 // plain background or entirely highlighted, according to the
@@ -95,7 +156,7 @@ func (f *Frame) Drawsel0(pt draw.Point, p0, p1 int, back, text *draw.Image) draw
 		}
 		f.B.Draw(draw.Rect(pt.X, pt.Y, x, pt.Y+f.Font.Height), back, nil, pt)
 		if b.nrune >= 0 {
-			f.B.BytesBg(pt, text, draw.Point{}, f.Font, ptr, back, draw.Point{})
+			f.bytesBg(pt, p, ptr, nr, text, back)
 		}
 		pt.X += w
 		p += nr
@@ -268,6 +268,9 @@ func (f *Frame) Insert(text []rune, p int) {
 		tcol = f.Cols[TEXT]
 	}
 	f.SelectPaint(ppt0, ppt1, col)
+	if f.Styler != nil {
+		tmpf.Styler = func(p int) (Style, int) { return f.Styler(p0 + p) }
+	}
 	tmpf.drawtext(ppt0, tcol, col)
 	f.addbox(nn0, len(tmpf.box))
 	copy(f.box[nn0:], tmpf.box)
@@ -0,0 +1,323 @@
+// Package auth implements Plan 9 authentication protocols
+// for 9P connections: p9any negotiation, p9sk1, and a simple
+// shared-secret protocol.
+//
+// Keys are held by an Agent, which stands in for factotum(4).
+// A Keyring is an Agent holding keys in memory, written in
+// factotum's attribute syntax:
+//
+//	proto=p9sk1 dom=example.com user=glenda !password=secret
+//
+// Attributes whose names begin with ! are secret and are not
+// printed. To mount an authenticated service:
+//
+//	keys := new(auth.Keyring)
+//	keys.Add("proto=p9sk1 dom=example.com user=glenda !password=secret")
+//	fsys, err := client.MountAuth("tcp", "example.com:564", "", auth.NewAuthenticator(keys))
+package auth // import "plramos.win/9fans/plan9/auth"
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An Info describes the outcome of a successful authentication.
+type Info struct {
+	Cuid   string // caller's user name
+	Suid   string // server's user name
+	Secret []byte // shared secret established by the protocol
+}
+
+// A Key is a set of attributes describing a key,
+// in the style of factotum(4).
+type Key struct {
+	Attr map[string]string
+}
+
+// ParseKey parses a key written as space-separated name=value pairs.
+// Values may be quoted with single quotes as in rc(1).
+func ParseKey(s string) (*Key, error) {
+	k := &Key{Attr: make(map[string]string)}
+	for _, f := range tokenize(s) {
+		i := strings.IndexByte(f, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("auth: bad key attribute %q", f)
+		}
+		k.Attr[f[:i]] = f[i+1:]
+	}
+	if k.Attr["proto"] == "" {
+		return nil, errors.New("auth: key has no proto attribute")
+	}
+	return k, nil
+}
+
+// tokenize splits s into space-separated fields,
+// honoring rc-style single quotes.
+func tokenize(s string) []string {
+	var fields []string
+	var b strings.Builder
+	in, quoted := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quoted && c == '\'':
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+			} else {
+				quoted = false
+			}
+		case quoted:
+			b.WriteByte(c)
+		case c == '\'':
+			quoted, in = true, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if in {
+				fields = append(fields, b.String())
+				b.Reset()
+				in = false
+			}
+		default:
+			b.WriteByte(c)
+			in = true
+		}
+	}
+	if in {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
+
+// Get returns the value of the named attribute.
+func (k *Key) Get(name string) string {
+	return k.Attr[name]
+}
+
+// String returns the key in attribute syntax
+// with the values of secret attributes elided.
+func (k *Key) String() string {
+	var names []string
+	for name := range k.Attr {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		if strings.HasPrefix(name, "!") {
+			b.WriteString(name + "?")
+			continue
+		}
+		v := k.Attr[name]
+		if v == "" || strings.ContainsAny(v, " \t\n'") {
+			v = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		}
+		b.WriteString(name + "=" + v)
+	}
+	return b.String()
+}
+
+// matches reports whether k has every attribute in query.
+// An empty value in query matches any value.
+func (k *Key) matches(query map[string]string) bool {
+	for name, v := range query {
+		kv, ok := k.Attr[name]
+		if !ok || v != "" && kv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// An Agent holds keys on behalf of a program, like factotum(4).
+type Agent interface {
+	// Keys returns the keys having all the attributes in query.
+	Keys(query map[string]string) ([]*Key, error)
+}
+
+// A Keyring is an Agent holding keys in memory.
+// The zero value is an empty keyring.
+type Keyring struct {
+	mu   sync.Mutex
+	keys []*Key
+}
+
+// Add parses the key s and adds it to r.
+func (r *Keyring) Add(s string) error {
+	k, err := ParseKey(s)
+	if err != nil {
+		return err
+	}
+	r.AddKey(k)
+	return nil
+}
+
+// AddKey adds k to r.
+func (r *Keyring) AddKey(k *Key) {
+	r.mu.Lock()
+	r.keys = append(r.keys, k)
+	r.mu.Unlock()
+}
+
+// Load adds the keys in the named file, one per line.
+// Blank lines and lines beginning with # are ignored.
+func (r *Keyring) Load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := r.Add(text); err != nil {
+			return fmt.Errorf("%s:%d: %v", file, line, err)
+		}
+	}
+	return sc.Err()
+}
+
+// Keys implements Agent.
+func (r *Keyring) Keys(query map[string]string) ([]*Key, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var keys []*Key
+	for _, k := range r.keys {
+		if k.matches(query) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// ErrNoKey is returned when an Agent has no key for a protocol.
+var ErrNoKey = errors.New("auth: no key")
+
+// findKey returns the first key in a matching query.
+func findKey(a Agent, query map[string]string) (*Key, error) {
+	keys, err := a.Keys(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		var q []string
+		for name, v := range query {
+			q = append(q, name+"="+v)
+		}
+		sort.Strings(q)
+		return nil, fmt.Errorf("%w matching %s", ErrNoKey, strings.Join(q, " "))
+	}
+	return keys[0], nil
+}
+
+// A Proto is an authentication protocol.
+type Proto interface {
+	// Name returns the protocol's name, as used in p9any negotiation
+	// and in the proto attribute of keys.
+	Name() string
+
+	// Client runs the client side of the protocol over rw
+	// in the authentication domain dom, as user.
+	Client(rw io.ReadWriter, a Agent, dom, user string) (*Info, error)
+
+	// Server runs the server side of the protocol over rw.
+	Server(rw io.ReadWriter, a Agent, dom string) (*Info, error)
+}
+
+// DefaultProtos lists the protocols used by NewAuthenticator,
+// in order of preference.
+var DefaultProtos = []Proto{P9sk1{}, Secret{}}
+
+// An Authenticator authenticates 9P connections with p9any,
+// using the keys in Agent. It implements client.Authenticator.
+type Authenticator struct {
+	Agent  Agent
+	Protos []Proto // if nil, DefaultProtos
+
+	// Info is set after a successful authentication.
+	Info *Info
+}
+
+// NewAuthenticator returns an Authenticator using the keys in a.
+func NewAuthenticator(a Agent) *Authenticator {
+	return &Authenticator{Agent: a}
+}
+
+// Authenticate runs p9any as user over rw,
+// typically an auth fid.
+func (a *Authenticator) Authenticate(rw io.ReadWriter, user, aname string) error {
+	info, err := P9anyClient(rw, a.Agent, a.protos(), user)
+	if err != nil {
+		return err
+	}
+	a.Info = info
+	return nil
+}
+
+func (a *Authenticator) protos() []Proto {
+	if a.Protos == nil {
+		return DefaultProtos
+	}
+	return a.Protos
+}
+
+// Error is the type of protocol failures.
+type Error string
+
+func (e Error) Error() string { return "auth: " + string(e) }
+
+func random(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("auth: no randomness: " + err.Error())
+	}
+	return b
+}
+
+// A conn buffers the reads of an authentication conversation,
+// so that a string arrives in one read rather than a byte at a time.
+// Each message on an auth fid comes in a read of its own,
+// and the bytes read past a string wait in the buffer
+// for the message that follows.
+type conn struct {
+	*bufio.Reader
+	io.Writer
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	if c, ok := rw.(*conn); ok {
+		return c
+	}
+	return &conn{bufio.NewReaderSize(rw, maxNegotiation+1), rw}
+}
+
+// readString reads a NUL-terminated string of at most max bytes.
+func readString(c *conn, max int) (string, error) {
+	b, err := c.ReadSlice(0)
+	switch {
+	case err == bufio.ErrBufferFull || err == nil && len(b)-1 > max:
+		return "", Error("string too long")
+	case err == io.EOF:
+		return "", io.ErrUnexpectedEOF
+	case err != nil:
+		return "", err
+	}
+	return string(b[:len(b)-1]), nil
+}
+
+func writeString(w io.Writer, s string) error {
+	_, err := w.Write(append([]byte(s), 0))
+	return err
+}
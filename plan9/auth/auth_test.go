@@ -0,0 +1,254 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"plramos.win/9fans/plan9/auth"
+	"plramos.win/9fans/plan9/client"
+	"plramos.win/9fans/plan9/srv"
+)
+
+// An authConv connects an auth fid to a server-side
+// authentication conversation.
+type authConv struct {
+	c     net.Conn
+	done  chan struct{}
+	reads int // Treads of the auth fid
+	info  *auth.Info
+	err   error
+}
+
+// newSrv returns a server that requires authentication with
+// p9any using the keys in agent.
+func newSrv(agent auth.Agent, protos []auth.Proto) *srv.Srv {
+	tree := srv.NewTree("glenda", "glenda", 0775)
+	tree.Root.Create("file", "glenda", 0664, nil)
+	return &srv.Srv{
+		Tree: tree,
+		Auth: func(r *srv.Req) {
+			c1, c2 := net.Pipe()
+			conv := &authConv{c: c2, done: make(chan struct{})}
+			go func() {
+				conv.info, conv.err = auth.P9anyServer(c1, agent, protos)
+				c1.Close()
+				close(conv.done)
+			}()
+			r.Afid.Aux = conv
+			r.Respond(nil)
+		},
+		Read: func(r *srv.Req) {
+			conv, ok := r.Fid.Aux.(*authConv)
+			if !ok {
+				r.ReadString("data")
+				r.Respond(nil)
+				return
+			}
+			conv.reads++
+			buf := make([]byte, r.Ifcall.Count)
+			n, err := conv.c.Read(buf)
+			if err != nil {
+				r.Respond(srv.Error("authentication failed"))
+				return
+			}
+			r.Ofcall.Data = buf[:n]
+			r.Respond(nil)
+		},
+		Write: func(r *srv.Req) {
+			conv := r.Fid.Aux.(*authConv)
+			n, err := conv.c.Write(r.Ifcall.Data)
+			if err != nil {
+				r.Respond(srv.Error("authentication failed"))
+				return
+			}
+			r.Ofcall.Count = uint32(n)
+			r.Respond(nil)
+		},
+		Attach: func(r *srv.Req) {
+			if r.Afid == nil {
+				r.Respond(srv.Error("authentication required"))
+				return
+			}
+			conv := r.Afid.Aux.(*authConv)
+			<-conv.done
+			if conv.err != nil || conv.info.Cuid != r.Ifcall.Uname {
+				r.Respond(srv.Eperm)
+				return
+			}
+			// One read for each message from the server.
+			if max := 4; conv.reads > max {
+				r.Respond(srv.Error(fmt.Sprintf("auth fid read %d times, want at most %d", conv.reads, max)))
+				return
+			}
+			r.Respond(nil)
+		},
+		Destroyfid: func(fid *srv.Fid) {
+			if conv, ok := fid.Aux.(*authConv); ok {
+				conv.c.Close()
+			}
+		},
+	}
+}
+
+// ticketServer returns a DialAuth function connecting to
+// a fake authentication server that knows the given passwords.
+func ticketServer(t *testing.T, passwords map[string]string) func(string) (io.ReadWriteCloser, error) {
+	return func(host string) (io.ReadWriteCloser, error) {
+		if host != "9fans.net" {
+			t.Errorf("dial auth server %q", host)
+		}
+		c1, c2 := net.Pipe()
+		go func() {
+			defer c1.Close()
+			buf := make([]byte, auth.TICKREQLEN)
+			if _, err := io.ReadFull(c1, buf); err != nil {
+				return
+			}
+			tr, err := auth.UnmarshalTicketreq(buf)
+			if err != nil {
+				return
+			}
+			hpw, ok1 := passwords[tr.Hostid]
+			apw, ok2 := passwords[tr.Authid]
+			if !ok1 || !ok2 || tr.Hostid != tr.Uid {
+				msg := make([]byte, 1+auth.AERRLEN)
+				msg[0] = auth.AuthErr
+				copy(msg[1:], "no such user")
+				c1.Write(msg)
+				return
+			}
+			tk := &auth.Ticket{Num: auth.AuthTc, Chal: tr.Chal, Cuid: tr.Uid, Suid: tr.Uid}
+			rand.Read(tk.Key[:])
+			out := append([]byte{auth.AuthOK}, tk.Encrypt(auth.PassToKey(hpw))...)
+			tk.Num = auth.AuthTs
+			out = append(out, tk.Encrypt(auth.PassToKey(apw))...)
+			c1.Write(out)
+		}()
+		return c2, nil
+	}
+}
+
+func attach(t *testing.T, s *srv.Srv, a client.Authenticator, user string) (*client.Fsys, error) {
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c1)
+	conn, err := client.NewConn(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.AuthAttach(a, user, "")
+}
+
+func keyring(t *testing.T, keys ...string) *auth.Keyring {
+	r := new(auth.Keyring)
+	for _, k := range keys {
+		if err := r.Add(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestP9sk1(t *testing.T) {
+	p := auth.P9sk1{DialAuth: ticketServer(t, map[string]string{
+		"glenda":   "glendapass",
+		"bootes":   "a rather long password",
+		"intruder": "x",
+	})}
+	protos := []auth.Proto{p}
+	server := keyring(t, "proto=p9sk1 dom=9fans.net user=bootes '!password=a rather long password'")
+	s := newSrv(server, protos)
+
+	a := &auth.Authenticator{
+		Agent:  keyring(t, "proto=p9sk1 dom=9fans.net user=glenda !password=glendapass"),
+		Protos: protos,
+	}
+	fsys, err := attach(t, s, a, "glenda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Info == nil || a.Info.Cuid != "glenda" || len(a.Info.Secret) != auth.DESKEYLEN {
+		t.Errorf("Info = %+v", a.Info)
+	}
+	if _, err := fsys.Stat("file"); err != nil {
+		t.Error(err)
+	}
+
+	// Wrong password.
+	a.Agent = keyring(t, "proto=p9sk1 dom=9fans.net user=glenda !password=wrong")
+	if _, err := attach(t, s, a, "glenda"); err == nil || !strings.Contains(err.Error(), "password mismatch") {
+		t.Errorf("attach with wrong password: %v", err)
+	}
+}
+
+func TestSecret(t *testing.T) {
+	server := keyring(t,
+		"proto=secret dom=local user=glenda !password=s3cret",
+		"proto=secret dom=local user=bootes !password=other")
+	s := newSrv(server, auth.DefaultProtos)
+
+	a := auth.NewAuthenticator(keyring(t, "proto=secret dom=local user=glenda !password=s3cret"))
+	if _, err := attach(t, s, a, "glenda"); err != nil {
+		t.Fatal(err)
+	}
+	if a.Info.Cuid != "glenda" {
+		t.Errorf("Cuid = %q", a.Info.Cuid)
+	}
+
+	a = auth.NewAuthenticator(keyring(t, "proto=secret dom=local user=glenda !password=guess"))
+	if _, err := attach(t, s, a, "glenda"); err == nil {
+		t.Errorf("attach with wrong secret succeeded")
+	}
+
+	// No key for the offered protocols.
+	a = auth.NewAuthenticator(keyring(t, "proto=secret dom=elsewhere user=glenda !password=s3cret"))
+	if _, err := attach(t, s, a, "glenda"); err == nil || !strings.Contains(err.Error(), "no usable protocol") {
+		t.Errorf("attach without key: %v", err)
+	}
+}
+
+func TestNoAuth(t *testing.T) {
+	s := &srv.Srv{Tree: srv.NewTree("glenda", "glenda", 0775)}
+	a := auth.NewAuthenticator(new(auth.Keyring))
+	if _, err := attach(t, s, a, "glenda"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTicket(t *testing.T) {
+	key := auth.PassToKey("password")
+	tk := &auth.Ticket{Num: auth.AuthTc, Cuid: "glenda", Suid: "bootes"}
+	copy(tk.Chal[:], "chalchal")
+	copy(tk.Key[:], "1234567")
+	b := tk.Encrypt(key)
+	if len(b) != auth.TICKETLEN || strings.Contains(string(b), "glenda") {
+		t.Fatalf("bad encryption %q", b)
+	}
+	tk1, err := auth.DecryptTicket(key, b)
+	if err != nil || *tk1 != *tk {
+		t.Fatalf("DecryptTicket = %+v, %v, want %+v", tk1, err, tk)
+	}
+	if tk2, _ := auth.DecryptTicket(auth.PassToKey("Password"), b); *tk2 == *tk {
+		t.Fatalf("decrypted with wrong key")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	k, err := auth.ParseKey("proto=p9sk1 dom=9fans.net user='glenda' '!password=it''s secret'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Get("user") != "glenda" || k.Get("!password") != "it's secret" {
+		t.Errorf("key = %v", k.Attr)
+	}
+	if s := k.String(); s != "!password? dom=9fans.net proto=p9sk1 user=glenda" {
+		t.Errorf("String() = %q", s)
+	}
+	if _, err := auth.ParseKey("dom=x"); err == nil {
+		t.Errorf("key without proto accepted")
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"io"
+	"strings"
+)
+
+// p9any negotiates which protocol to use. The server sends
+//
+//	v.2 proto@dom proto@dom ...
+//
+// listing what it will accept; the client answers "proto dom"
+// and, in version 2, the server confirms with "OK".
+// Each message is NUL-terminated.
+
+const maxNegotiation = 4096
+
+// P9anyClient runs the client side of p9any over rw as user,
+// choosing the first protocol offered by the server that
+// is also in protos and for which a holds a key.
+func P9anyClient(rw io.ReadWriter, a Agent, protos []Proto, user string) (*Info, error) {
+	c := newConn(rw)
+	offer, err := readString(c, maxNegotiation)
+	if err != nil {
+		return nil, err
+	}
+	v2 := false
+	if strings.HasPrefix(offer, "v.") {
+		if !strings.HasPrefix(offer, "v.2 ") {
+			return nil, Error("unknown p9any version in " + offer)
+		}
+		v2 = true
+		offer = offer[4:]
+	}
+	for _, o := range strings.Fields(offer) {
+		name, dom, _ := strings.Cut(o, "@")
+		p := lookupProto(protos, name)
+		if p == nil {
+			continue
+		}
+		if _, err := findKey(a, clientQuery(name, dom, user)); err != nil {
+			continue
+		}
+		if err := writeString(rw, name+" "+dom); err != nil {
+			return nil, err
+		}
+		if v2 {
+			ok, err := readString(c, maxNegotiation)
+			if err != nil {
+				return nil, err
+			}
+			if ok != "OK" {
+				return nil, Error("p9any: server refused " + name + ": " + ok)
+			}
+		}
+		return p.Client(c, a, dom, user)
+	}
+	return nil, Error("p9any: no usable protocol in offer " + offer)
+}
+
+// P9anyServer runs the server side of p9any over rw,
+// offering each protocol in protos for every domain
+// in which a holds a key for it.
+func P9anyServer(rw io.ReadWriter, a Agent, protos []Proto) (*Info, error) {
+	var offers []string
+	for _, p := range protos {
+		keys, err := a.Keys(map[string]string{"proto": p.Name()})
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, k := range keys {
+			if dom := k.Get("dom"); !seen[dom] {
+				seen[dom] = true
+				offers = append(offers, p.Name()+"@"+dom)
+			}
+		}
+	}
+	if len(offers) == 0 {
+		return nil, ErrNoKey
+	}
+	c := newConn(rw)
+	if err := writeString(rw, "v.2 "+strings.Join(offers, " ")); err != nil {
+		return nil, err
+	}
+	reply, err := readString(c, maxNegotiation)
+	if err != nil {
+		return nil, err
+	}
+	name, dom, _ := strings.Cut(reply, " ")
+	var chosen Proto
+	for _, o := range offers {
+		if o == name+"@"+dom {
+			chosen = lookupProto(protos, name)
+		}
+	}
+	if chosen == nil {
+		writeString(rw, "bad choice")
+		return nil, Error("p9any: client chose unoffered " + reply)
+	}
+	if err := writeString(rw, "OK"); err != nil {
+		return nil, err
+	}
+	return chosen.Server(c, a, dom)
+}
+
+func lookupProto(protos []Proto, name string) Proto {
+	for _, p := range protos {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// clientQuery returns the key query for the client side
+// of proto in dom as user.
+func clientQuery(proto, dom, user string) map[string]string {
+	q := map[string]string{"proto": proto, "dom": dom}
+	if user != "" {
+		q["user"] = user
+	}
+	return q
+}
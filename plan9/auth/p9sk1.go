@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/des"
+	"encoding/binary"
+	"io"
+
+	"plramos.win/9fans/plan9/dial"
+)
+
+// Sizes from authsrv(2).
+const (
+	ANAMELEN   = 28
+	DOMLEN     = 48
+	DESKEYLEN  = 7
+	CHALLEN    = 8
+	TICKREQLEN = 3*ANAMELEN + CHALLEN + DOMLEN + 1
+	TICKETLEN  = CHALLEN + 2*ANAMELEN + DESKEYLEN + 1
+	AUTHENTLEN = CHALLEN + 4 + 1
+)
+
+// Message types from authsrv(2).
+const (
+	AuthTreq = 1  // ticket request
+	AuthOK   = 4  // success
+	AuthErr  = 5  // error, followed by a 64-byte message
+	AuthTs   = 64 // ticket encrypted with server's key
+	AuthTc   = 65 // ticket encrypted with client's key
+	AuthAs   = 66 // server authenticator
+	AuthAc   = 67 // client authenticator
+
+	AERRLEN = 64
+)
+
+// A Ticketreq asks the authentication server for tickets.
+type Ticketreq struct {
+	Type    byte
+	Authid  string // server's user
+	Authdom string
+	Chal    [CHALLEN]byte
+	Hostid  string // user making the request
+	Uid     string // user to become
+}
+
+// Bytes returns the wire form of tr.
+func (tr *Ticketreq) Bytes() []byte {
+	b := []byte{tr.Type}
+	b = pname(b, tr.Authid, ANAMELEN)
+	b = pname(b, tr.Authdom, DOMLEN)
+	b = append(b, tr.Chal[:]...)
+	b = pname(b, tr.Hostid, ANAMELEN)
+	return pname(b, tr.Uid, ANAMELEN)
+}
+
+// UnmarshalTicketreq decodes a ticket request.
+func UnmarshalTicketreq(b []byte) (*Ticketreq, error) {
+	if len(b) != TICKREQLEN {
+		return nil, Error("bad ticket request length")
+	}
+	tr := &Ticketreq{Type: b[0]}
+	b = b[1:]
+	tr.Authid, b = gname(b, ANAMELEN)
+	tr.Authdom, b = gname(b, DOMLEN)
+	b = b[copy(tr.Chal[:], b):]
+	tr.Hostid, b = gname(b, ANAMELEN)
+	tr.Uid, _ = gname(b, ANAMELEN)
+	return tr, nil
+}
+
+// A Ticket grants the holder of Key the identity Suid
+// on a server where the caller is known as Cuid.
+type Ticket struct {
+	Num  byte
+	Chal [CHALLEN]byte
+	Cuid string
+	Suid string
+	Key  [DESKEYLEN]byte
+}
+
+// Encrypt returns the wire form of t, encrypted with key.
+func (t *Ticket) Encrypt(key [DESKEYLEN]byte) []byte {
+	b := []byte{t.Num}
+	b = append(b, t.Chal[:]...)
+	b = pname(b, t.Cuid, ANAMELEN)
+	b = pname(b, t.Suid, ANAMELEN)
+	b = append(b, t.Key[:]...)
+	encrypt(key, b)
+	return b
+}
+
+// DecryptTicket decrypts and decodes a ticket.
+func DecryptTicket(key [DESKEYLEN]byte, b []byte) (*Ticket, error) {
+	if len(b) != TICKETLEN {
+		return nil, Error("bad ticket length")
+	}
+	b = append([]byte(nil), b...)
+	decrypt(key, b)
+	t := &Ticket{Num: b[0]}
+	b = b[1:]
+	b = b[copy(t.Chal[:], b):]
+	t.Cuid, b = gname(b, ANAMELEN)
+	t.Suid, b = gname(b, ANAMELEN)
+	copy(t.Key[:], b)
+	return t, nil
+}
+
+type authenticator struct {
+	num  byte
+	chal [CHALLEN]byte
+	id   uint32
+}
+
+func (a *authenticator) encrypt(key [DESKEYLEN]byte) []byte {
+	b := []byte{a.num}
+	b = append(b, a.chal[:]...)
+	b = binary.LittleEndian.AppendUint32(b, a.id)
+	encrypt(key, b)
+	return b
+}
+
+func decryptAuthenticator(key [DESKEYLEN]byte, b []byte) *authenticator {
+	b = append([]byte(nil), b...)
+	decrypt(key, b)
+	a := &authenticator{num: b[0]}
+	copy(a.chal[:], b[1:])
+	a.id = binary.LittleEndian.Uint32(b[1+CHALLEN:])
+	return a
+}
+
+func pname(b []byte, s string, n int) []byte {
+	name := make([]byte, n)
+	copy(name[:n-1], s)
+	return append(b, name...)
+}
+
+func gname(b []byte, n int) (string, []byte) {
+	name := b[:n]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name), b[n:]
+}
+
+// PassToKey converts a password to a DES key,
+// as passtokey in authsrv(2).
+func PassToKey(password string) [DESKEYLEN]byte {
+	var key [DESKEYLEN]byte
+	buf := make([]byte, ANAMELEN)
+	n := len(password)
+	if n >= ANAMELEN {
+		n = ANAMELEN - 1
+	}
+	copy(buf, "        ")
+	copy(buf, password[:n])
+	buf[n] = 0
+	t := buf
+	for {
+		for i := 0; i < DESKEYLEN; i++ {
+			key[i] = t[i]>>uint(i) + t[i+1]<<uint(8-(i+1))
+		}
+		if n <= 8 {
+			return key
+		}
+		n -= 8
+		t = t[8:]
+		if n < 8 {
+			t = buf[len(buf)-len(t)-(8-n):]
+			n = 8
+		}
+		encrypt(key, t[:8])
+	}
+}
+
+// des56to64 expands a 56-bit key into the 64-bit DES form.
+func des56to64(k56 [DESKEYLEN]byte) []byte {
+	hi := uint32(k56[0])<<24 | uint32(k56[1])<<16 | uint32(k56[2])<<8 | uint32(k56[3])
+	lo := uint32(k56[4])<<24 | uint32(k56[5])<<16 | uint32(k56[6])<<8
+	return []byte{
+		byte(hi>>24) & 0xfe,
+		byte(hi>>17) & 0xfe,
+		byte(hi>>10) & 0xfe,
+		byte(hi>>3) & 0xfe,
+		byte(hi<<4|lo>>28) & 0xfe,
+		byte(lo>>21) & 0xfe,
+		byte(lo>>14) & 0xfe,
+		byte(lo>>7) & 0xfe,
+	}
+}
+
+// encrypt encrypts b in place as Plan 9's encrypt does:
+// DES on overlapping 8-byte blocks spaced 7 bytes apart,
+// so that any buffer of at least 8 bytes can be encrypted.
+func encrypt(key [DESKEYLEN]byte, b []byte) {
+	c, err := des.NewCipher(des56to64(key))
+	if err != nil {
+		panic(err)
+	}
+	n := len(b) - 1
+	r := n % 7
+	n /= 7
+	i := 0
+	for ; i < n; i++ {
+		c.Encrypt(b[7*i:7*i+8], b[7*i:7*i+8])
+	}
+	if r != 0 {
+		j := 7*i - 7 + r
+		c.Encrypt(b[j:j+8], b[j:j+8])
+	}
+}
+
+// decrypt reverses encrypt.
+func decrypt(key [DESKEYLEN]byte, b []byte) {
+	c, err := des.NewCipher(des56to64(key))
+	if err != nil {
+		panic(err)
+	}
+	n := len(b) - 1
+	r := n % 7
+	n /= 7
+	if r != 0 {
+		j := 7*n - 7 + r
+		c.Decrypt(b[j:j+8], b[j:j+8])
+	}
+	for i := n - 1; i >= 0; i-- {
+		c.Decrypt(b[7*i:7*i+8], b[7*i:7*i+8])
+	}
+}
+
+// keyOf returns the DES key in k,
+// from its !password attribute.
+func keyOf(k *Key) ([DESKEYLEN]byte, error) {
+	pw, ok := k.Attr["!password"]
+	if !ok {
+		return [DESKEYLEN]byte{}, Error("key has no !password: " + k.String())
+	}
+	return PassToKey(pw), nil
+}
+
+// P9sk1 is the p9sk1 protocol, which uses tickets
+// from an authentication server and DES keys derived
+// from passwords.
+//
+// Keys have the form
+//
+//	proto=p9sk1 dom=example.com user=glenda !password=secret
+//
+// optionally with auth=host naming the authentication server.
+type P9sk1 struct {
+	// DialAuth connects to the authentication server
+	// named by the key's auth attribute, or else the domain.
+	// If nil, it dials host as a dial string with the
+	// default service ticket (TCP port 567).
+	DialAuth func(host string) (io.ReadWriteCloser, error)
+}
+
+// Name returns "p9sk1".
+func (P9sk1) Name() string { return "p9sk1" }
+
+func (p P9sk1) dialAuth(host string) (io.ReadWriteCloser, error) {
+	if p.DialAuth != nil {
+		return p.DialAuth(host)
+	}
+	return dial.Dial(host, "ticket")
+}
+
+// Client runs the client side of p9sk1.
+func (p P9sk1) Client(rw io.ReadWriter, a Agent, dom, user string) (*Info, error) {
+	k, err := findKey(a, clientQuery("p9sk1", dom, user))
+	if err != nil {
+		return nil, err
+	}
+	ckey, err := keyOf(k)
+	if err != nil {
+		return nil, err
+	}
+	if user == "" {
+		user = k.Get("user")
+	}
+
+	var chc [CHALLEN]byte
+	copy(chc[:], random(CHALLEN))
+	if _, err := rw.Write(chc[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, TICKREQLEN)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return nil, err
+	}
+	tr, err := UnmarshalTicketreq(buf)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Type != AuthTreq {
+		return nil, Error("p9sk1: bad ticket request")
+	}
+	tr.Hostid = k.Get("user")
+	tr.Uid = user
+
+	host := k.Get("auth")
+	if host == "" {
+		host = tr.Authdom
+	}
+	tickets, err := p.getTickets(host, tr)
+	if err != nil {
+		return nil, err
+	}
+	t, err := DecryptTicket(ckey, tickets[:TICKETLEN])
+	if err != nil {
+		return nil, err
+	}
+	if t.Num != AuthTc || t.Chal != tr.Chal {
+		return nil, Error("p9sk1: password mismatch with auth server")
+	}
+
+	ac := authenticator{num: AuthAc, chal: tr.Chal}
+	msg := append(tickets[TICKETLEN:], ac.encrypt(t.Key)...)
+	if _, err := rw.Write(msg); err != nil {
+		return nil, err
+	}
+	buf = make([]byte, AUTHENTLEN)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return nil, err
+	}
+	as := decryptAuthenticator(t.Key, buf)
+	if as.num != AuthAs || as.chal != chc {
+		return nil, Error("p9sk1: server lies")
+	}
+	return &Info{Cuid: t.Cuid, Suid: t.Suid, Secret: t.Key[:]}, nil
+}
+
+// getTickets asks the authentication server at host
+// for the pair of tickets answering tr.
+func (p P9sk1) getTickets(host string, tr *Ticketreq) ([]byte, error) {
+	c, err := p.dialAuth(host)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	tr.Type = AuthTreq
+	if _, err := c.Write(tr.Bytes()); err != nil {
+		return nil, err
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(c, status); err != nil {
+		return nil, err
+	}
+	switch status[0] {
+	case AuthOK:
+		tickets := make([]byte, 2*TICKETLEN)
+		if _, err := io.ReadFull(c, tickets); err != nil {
+			return nil, err
+		}
+		return tickets, nil
+	case AuthErr:
+		msg := make([]byte, AERRLEN)
+		io.ReadFull(c, msg)
+		s, _ := gname(msg, AERRLEN)
+		return nil, Error("auth server: " + s)
+	}
+	return nil, Error("auth server protocol botch")
+}
+
+// Server runs the server side of p9sk1 in dom,
+// using a key of the form proto=p9sk1 dom=dom user=authid.
+func (p P9sk1) Server(rw io.ReadWriter, a Agent, dom string) (*Info, error) {
+	k, err := findKey(a, map[string]string{"proto": "p9sk1", "dom": dom})
+	if err != nil {
+		return nil, err
+	}
+	skey, err := keyOf(k)
+	if err != nil {
+		return nil, err
+	}
+
+	var chc [CHALLEN]byte
+	if _, err := io.ReadFull(rw, chc[:]); err != nil {
+		return nil, err
+	}
+	tr := &Ticketreq{Type: AuthTreq, Authid: k.Get("user"), Authdom: dom}
+	copy(tr.Chal[:], random(CHALLEN))
+	if _, err := rw.Write(tr.Bytes()); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, TICKETLEN+AUTHENTLEN)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		return nil, err
+	}
+	t, err := DecryptTicket(skey, buf[:TICKETLEN])
+	if err != nil {
+		return nil, err
+	}
+	if t.Num != AuthTs || t.Chal != tr.Chal {
+		return nil, Error("p9sk1: bad ticket")
+	}
+	ac := decryptAuthenticator(t.Key, buf[TICKETLEN:])
+	if ac.num != AuthAc || ac.chal != tr.Chal || ac.id != 0 {
+		return nil, Error("p9sk1: bad authenticator")
+	}
+	as := authenticator{num: AuthAs, chal: chc}
+	if _, err := rw.Write(as.encrypt(t.Key)); err != nil {
+		return nil, err
+	}
+	return &Info{Cuid: t.Cuid, Suid: t.Suid, Secret: t.Key[:]}, nil
+}
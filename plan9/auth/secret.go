@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
+)
+
+// Secret is a simple challenge-response protocol for a client
+// and server that share a secret, for use where there is no
+// authentication server. Both sides hold keys of the form
+//
+//	proto=secret dom=example.com user=glenda !password=secret
+//
+// The exchange is
+//
+//	C→S: user NUL, Nc
+//	S→C: Ns
+//	C→S: HMAC(secret, "client", Nc, Ns, user)
+//	S→C: HMAC(secret, "server", Nc, Ns, user)
+//
+// where Nc and Ns are random nonces of NonceLen bytes
+// and HMAC is HMAC-SHA256.
+type Secret struct{}
+
+// NonceLen is the length of the nonces used by Secret.
+const NonceLen = 32
+
+// Name returns "secret".
+func (Secret) Name() string { return "secret" }
+
+func secretMAC(secret, nc, ns []byte, role, user string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(role))
+	m.Write(nc)
+	m.Write(ns)
+	m.Write([]byte(user))
+	return m.Sum(nil)
+}
+
+func secretOf(k *Key) ([]byte, error) {
+	pw, ok := k.Attr["!password"]
+	if !ok {
+		return nil, Error("key has no !password: " + k.String())
+	}
+	return []byte(pw), nil
+}
+
+// Client runs the client side of Secret.
+func (Secret) Client(rw io.ReadWriter, a Agent, dom, user string) (*Info, error) {
+	k, err := findKey(a, clientQuery("secret", dom, user))
+	if err != nil {
+		return nil, err
+	}
+	secret, err := secretOf(k)
+	if err != nil {
+		return nil, err
+	}
+	if user == "" {
+		user = k.Get("user")
+	}
+	nc := random(NonceLen)
+	if _, err := rw.Write(append(append([]byte(user), 0), nc...)); err != nil {
+		return nil, err
+	}
+	ns := make([]byte, NonceLen)
+	if _, err := io.ReadFull(rw, ns); err != nil {
+		return nil, err
+	}
+	if _, err := rw.Write(secretMAC(secret, nc, ns, "client", user)); err != nil {
+		return nil, err
+	}
+	mac := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(rw, mac); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, secretMAC(secret, nc, ns, "server", user)) {
+		return nil, Error("secret: server lies")
+	}
+	return &Info{Cuid: user, Suid: user, Secret: secretMAC(secret, nc, ns, "key", user)}, nil
+}
+
+// Server runs the server side of Secret,
+// looking up the key for the user the client names.
+func (Secret) Server(rw io.ReadWriter, a Agent, dom string) (*Info, error) {
+	c := newConn(rw)
+	user, err := readString(c, ANAMELEN)
+	if err != nil {
+		return nil, err
+	}
+	nc := make([]byte, NonceLen)
+	if _, err := io.ReadFull(c, nc); err != nil {
+		return nil, err
+	}
+	k, err := findKey(a, map[string]string{"proto": "secret", "dom": dom, "user": user})
+	if err != nil {
+		return nil, err
+	}
+	secret, err := secretOf(k)
+	if err != nil {
+		return nil, err
+	}
+	ns := random(NonceLen)
+	if _, err := c.Write(ns); err != nil {
+		return nil, err
+	}
+	mac := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(c, mac); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, secretMAC(secret, nc, ns, "client", user)) {
+		return nil, Error("secret: authentication failed for " + user)
+	}
+	if _, err := c.Write(secretMAC(secret, nc, ns, "server", user)); err != nil {
+		return nil, err
+	}
+	return &Info{Cuid: user, Suid: user, Secret: secretMAC(secret, nc, ns, "key", user)}, nil
+}
@@ -4,10 +4,12 @@
 package client // import "plramos.win/9fans/plan9/client"
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"sync"
 	"sync/atomic"
+	"syscall"
 
 	"plramos.win/9fans/plan9"
 )
@@ -27,6 +29,20 @@ type Conn struct {
 	released bool
 }
 
+// An ErrnoError is an error returned by a 9P2000.u server,
+// which sends a Unix error number along with the message.
+// It unwraps to the corresponding syscall.Errno, so that
+// errors.Is(err, syscall.ENOENT) and errors.Is(err, fs.ErrNotExist)
+// work as they do for local files.
+type ErrnoError struct {
+	Ename string
+	Errno syscall.Errno
+}
+
+func (e *ErrnoError) Error() string { return e.Ename }
+
+func (e *ErrnoError) Unwrap() error { return e.Errno }
+
 var errClosed = fmt.Errorf("connection has been closed")
 
 // Close forces a close of the connection and all Fids derived
@@ -80,25 +96,46 @@ type conn struct {
 	tagmap   map[uint16]chan *plan9.Fcall
 	freetag  map[uint16]bool
 	freefid  map[uint32]bool
+	flushes  map[uint16]uint16 // tag of outstanding Tflush -> its oldtag
+	flushing map[uint16]bool   // oldtags held until their Rflush
 	nexttag  uint16
 	nextfid  uint32
 	msize    uint32
 	version  string
+	dialect  plan9.Dialect
 	w, x     sync.Mutex
 	muxer    bool
 	refCount int32 // atomic
 }
 
+// NewConn negotiates a 9P2000 connection over rwc.
 func NewConn(rwc io.ReadWriteCloser) (*Conn, error) {
+	return NewConnDialect(rwc, plan9.Dialect9P2000)
+}
+
+// NewConnDialect is like NewConn but proposes dialect d
+// in the version exchange. The server may answer with plain 9P2000
+// instead, in which case the connection uses that;
+// c.Dialect reports the outcome.
+//
+// On a 9P2000.u connection, errors carry Unix error numbers
+// (see ErrnoError) and Fid.Stat, Fid.Wstat and Fid.Dirread use
+// the extended stat format.
+// On a 9P2000.L connection, Rlerror replies are returned as
+// syscall.Errno values, and Fid.Open, Fid.Create and Fid.Stat
+// are implemented with Tlopen, Tlcreate and Tgetattr.
+func NewConnDialect(rwc io.ReadWriteCloser, d plan9.Dialect) (*Conn, error) {
 	c := &conn{
 		rwc:      rwc,
 		tagmap:   make(map[uint16]chan *plan9.Fcall),
 		freetag:  make(map[uint16]bool),
 		freefid:  make(map[uint32]bool),
+		flushes:  make(map[uint16]uint16),
+		flushing: make(map[uint16]bool),
 		nexttag:  1,
 		nextfid:  1,
 		msize:    131072,
-		version:  "9P2000",
+		version:  d.Version(),
 		refCount: 1,
 	}
 
@@ -120,14 +157,26 @@ func NewConn(rwc io.ReadWriteCloser) (*Conn, error) {
 		return nil, plan9.ProtocolError(fmt.Sprintf("invalid msize %d in Rversion", rx.Msize))
 	}
 	c.msize = rx.Msize
-	if rx.Version != "9P2000" {
+	rd, ok := plan9.ParseDialect(rx.Version)
+	if !ok || rd != d && rd != plan9.Dialect9P2000 {
 		return nil, plan9.ProtocolError(fmt.Sprintf("invalid version %s in Rversion", rx.Version))
 	}
+	c.version = rx.Version
+	c.dialect = rd
 	return &Conn{
 		_c: c,
 	}, nil
 }
 
+// Dialect returns the protocol dialect negotiated for c.
+func (c *Conn) Dialect() plan9.Dialect {
+	conn, err := c.conn()
+	if err != nil {
+		return plan9.Dialect9P2000
+	}
+	return conn.dialect
+}
+
 func (c *conn) newFid(fid uint32, qid plan9.Qid) *Fid {
 	c.acquire()
 	return &Fid{
@@ -195,7 +244,20 @@ func (c *conn) mux(rx *plan9.Fcall) {
 
 	ch := c.tagmap[rx.Tag]
 	delete(c.tagmap, rx.Tag)
-	c.freetag[rx.Tag] = true
+	if !c.flushing[rx.Tag] {
+		c.freetag[rx.Tag] = true
+	}
+	if old, ok := c.flushes[rx.Tag]; ok {
+		// The flushed request will get no reply if it
+		// has not had one already, and its tag can be reused.
+		delete(c.flushes, rx.Tag)
+		delete(c.flushing, old)
+		if och := c.tagmap[old]; och != nil {
+			delete(c.tagmap, old)
+			och <- &flushed
+		}
+		c.freetag[old] = true
+	}
 	c.muxer = false
 	for _, ch2 := range c.tagmap {
 		c.muxer = true
@@ -209,7 +271,7 @@ func (c *conn) read() (*plan9.Fcall, error) {
 	if err := c.getErr(); err != nil {
 		return nil, err
 	}
-	f, err := plan9.ReadFcall(c.rwc)
+	f, err := c.dialect.ReadFcall(c.rwc)
 	if err != nil {
 		c.setErr(err)
 		return nil, err
@@ -221,21 +283,38 @@ func (c *conn) write(f *plan9.Fcall) error {
 	if err := c.getErr(); err != nil {
 		return err
 	}
-	err := plan9.WriteFcall(c.rwc, f)
+	err := c.dialect.WriteFcall(c.rwc, f)
 	if err != nil {
 		c.setErr(err)
 	}
 	return err
 }
 
-var yourTurn plan9.Fcall
+// yourTurn tells a waiting rpc to read the next reply;
+// flushed tells it that its request was flushed.
+var yourTurn, flushed plan9.Fcall
 
 func (c *conn) rpc(tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error) {
+	return c.rpcContext(context.Background(), tx, clunkFid)
+}
+
+// rpcContext is like rpc but flushes the request if ctx is done
+// before the reply arrives. If the reply wins the race with
+// the Tflush, rpcContext returns it; otherwise it returns ctx.Err().
+func (c *conn) rpcContext(ctx context.Context, tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	ch := make(chan *plan9.Fcall, 1)
 	tx.Tag, err = c.newtag(ch)
 	if err != nil {
 		return nil, err
 	}
+	if tx.Type == plan9.Tflush {
+		c.x.Lock()
+		c.flushes[tx.Tag] = tx.Oldtag
+		c.x.Unlock()
+	}
 	c.w.Lock()
 	err = c.write(tx)
 	// Mark the fid as clunked inside the write lock so that we're
@@ -261,8 +340,16 @@ func (c *conn) rpc(tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error)
 	if err != nil {
 		return nil, err
 	}
+	if ctx.Done() != nil {
+		tag := tx.Tag
+		stop := context.AfterFunc(ctx, func() { c.flush(tag, ch) })
+		defer stop()
+	}
 
 	for rx = range ch {
+		if rx == &flushed {
+			return nil, ctx.Err()
+		}
 		if rx != &yourTurn {
 			break
 		}
@@ -277,14 +364,51 @@ func (c *conn) rpc(tx *plan9.Fcall, clunkFid *Fid) (rx *plan9.Fcall, err error)
 		return nil, c.getErr()
 	}
 	if rx.Type == plan9.Rerror {
+		if rx.Errno != 0 && rx.Errno != plan9.NOUID {
+			return nil, &ErrnoError{rx.Ename, syscall.Errno(rx.Errno)}
+		}
 		return nil, Error(rx.Ename)
 	}
+	if rx.Type == plan9.Rlerror && c.dialect == plan9.Dialect9P2000L {
+		return nil, syscall.Errno(rx.Errno)
+	}
 	if rx.Type != tx.Type+1 {
 		return nil, plan9.ProtocolError("packet type mismatch")
 	}
 	return rx, nil
 }
 
+// flush sends a Tflush for the request with the given tag,
+// whose reply is awaited on ch. The reply to the Tflush
+// is handled in mux.
+func (c *conn) flush(tag uint16, ch chan *plan9.Fcall) {
+	c.x.Lock()
+	if c.tagmap[tag] != ch {
+		// Already answered.
+		c.x.Unlock()
+		return
+	}
+	c.flushing[tag] = true
+	c.x.Unlock()
+	_, err := c.rpc(&plan9.Fcall{Type: plan9.Tflush, Oldtag: tag}, nil)
+	if err == nil {
+		return
+	}
+	// The Tflush failed, so the connection is probably dead.
+	// Stop waiting for the reply unless the waiter is
+	// the one reading, in which case it will see the error.
+	c.x.Lock()
+	defer c.x.Unlock()
+	delete(c.flushing, tag)
+	if c.tagmap[tag] == ch {
+		select {
+		case ch <- &flushed:
+			delete(c.tagmap, tag)
+		default:
+		}
+	}
+}
+
 func (c *conn) acquire() {
 	atomic.AddInt32(&c.refCount, 1)
 }
@@ -0,0 +1,279 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"plramos.win/9fans/plan9"
+)
+
+// serveL answers 9P2000.L requests on c with canned replies.
+func serveL(t *testing.T, c net.Conn, version string) {
+	d := plan9.Dialect9P2000L
+	for {
+		tx, err := d.ReadFcall(c)
+		if err != nil {
+			return
+		}
+		rx := &plan9.Fcall{Type: tx.Type + 1, Tag: tx.Tag}
+		switch tx.Type {
+		case plan9.Tversion:
+			rx.Msize = tx.Msize
+			rx.Version = version
+			if version == plan9.VERSION9P {
+				d = plan9.Dialect9P2000
+			}
+		case plan9.Tattach:
+			if d == plan9.Dialect9P2000L && tx.Uid != 1000 {
+				t.Errorf("attach uid = %d, want 1000", tx.Uid)
+			}
+			rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+		case plan9.Twalk:
+			if len(tx.Wname) > 0 && tx.Wname[0] == "missing" {
+				rx = &plan9.Fcall{Type: plan9.Rlerror, Tag: tx.Tag, Errno: uint32(syscall.ENOENT)}
+				break
+			}
+			rx.Wqid = make([]plan9.Qid, len(tx.Wname))
+		case plan9.Tgetattr:
+			rx.Attr = plan9.LAttr{Valid: plan9.GetattrBasic, Mode: plan9.SIFDIR | 0755, Uid: 1000}
+		case plan9.Tclunk:
+		default:
+			rx = &plan9.Fcall{Type: plan9.Rlerror, Tag: tx.Tag, Errno: uint32(syscall.ENOSYS)}
+		}
+		if err := d.WriteFcall(c, rx); err != nil {
+			return
+		}
+	}
+}
+
+func TestDialectL(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go serveL(t, c1, plan9.VERSION9PL)
+	conn, err := NewConnDialect(c2, plan9.Dialect9P2000L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Dialect() != plan9.Dialect9P2000L {
+		t.Fatalf("Dialect() = %v", conn.Dialect())
+	}
+	fsys, err := conn.AttachUid(nil, "glenda", "", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := fsys.Stat("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "dir" || d.Mode != plan9.DMDIR|0755 || d.Uid != "1000" {
+		t.Errorf("Stat = %v", d)
+	}
+	if _, err := fsys.Stat("missing"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Stat(missing) = %v, want ENOENT", err)
+	}
+}
+
+func TestDialectFallback(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go serveL(t, c1, plan9.VERSION9P)
+	conn, err := NewConnDialect(c2, plan9.Dialect9P2000L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Dialect() != plan9.Dialect9P2000 {
+		t.Fatalf("Dialect() = %v, want 9P2000", conn.Dialect())
+	}
+	fsys, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.root.Getattr(plan9.GetattrAll); err != errNotL {
+		t.Errorf("Getattr = %v, want %v", err, errNotL)
+	}
+}
+
+// serveU answers 9P2000.u requests on c with canned replies,
+// sending each Tcreate to created.
+func serveU(c net.Conn, created chan<- *plan9.Fcall) {
+	d := plan9.Dialect9P2000u
+	for {
+		tx, err := d.ReadFcall(c)
+		if err != nil {
+			return
+		}
+		rx := &plan9.Fcall{Type: tx.Type + 1, Tag: tx.Tag}
+		switch tx.Type {
+		case plan9.Tversion:
+			rx.Msize = tx.Msize
+			rx.Version = plan9.VERSION9PU
+		case plan9.Tattach:
+			rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+		case plan9.Twalk:
+			if len(tx.Wname) > 0 && tx.Wname[0] == "missing" {
+				rx = &plan9.Fcall{Type: plan9.Rerror, Tag: tx.Tag, Ename: "no such file", Errno: uint32(syscall.ENOENT)}
+				break
+			}
+			rx.Wqid = make([]plan9.Qid, len(tx.Wname))
+		case plan9.Tstat:
+			dir := &plan9.Dir{Name: "link", Mode: plan9.DMSYMLINK | 0777, Extension: "/target", Nuid: 1000}
+			rx.Stat = d.AppendDir(nil, dir)
+		case plan9.Tcreate:
+			created <- tx
+		case plan9.Tclunk:
+		default:
+			rx = &plan9.Fcall{Type: plan9.Rerror, Tag: tx.Tag, Ename: "unsupported"}
+		}
+		if err := d.WriteFcall(c, rx); err != nil {
+			return
+		}
+	}
+}
+
+func TestDialectU(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	created := make(chan *plan9.Fcall, 1)
+	go serveU(c1, created)
+	conn, err := NewConnDialect(c2, plan9.Dialect9P2000u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fsys, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fsys.Stat("missing")
+	if !errors.Is(err, syscall.ENOENT) || err.Error() != "no such file" {
+		t.Errorf("Stat(missing) = %v, want ENOENT", err)
+	}
+	if _, err := fsys.Open("unsupported", plan9.OREAD); err != Error("unsupported") {
+		t.Errorf("Open = %v, want plain Error", err)
+	}
+
+	fid, err := fsys.root.Walk("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := fid.Readlink()
+	if err != nil || target != "/target" {
+		t.Errorf("Readlink = %q, %v", target, err)
+	}
+	fid.Close()
+
+	if _, err := fsys.root.Mknod("null", plan9.SIFCHR|0666, 1, 3, 0); err != nil {
+		t.Fatal(err)
+	}
+	tx := <-created
+	if tx.Name != "null" || tx.Perm != plan9.DMDEVICE|0666 || tx.Extension != "c 1 3" {
+		t.Errorf("Mknod sent %v", tx)
+	}
+}
+
+// serveFlush answers 9P2000 requests on c, holding each Tread
+// until it is flushed. If late is set, the read is answered
+// just before the Rflush, as a server that lost the race would.
+// Each Tread and Tflush is sent to held.
+func serveFlush(c net.Conn, late bool, held chan<- *plan9.Fcall) {
+	reads := make(map[uint16]*plan9.Fcall)
+	for {
+		tx, err := plan9.ReadFcall(c)
+		if err != nil {
+			return
+		}
+		rx := &plan9.Fcall{Type: tx.Type + 1, Tag: tx.Tag}
+		switch tx.Type {
+		case plan9.Tversion:
+			rx.Msize = tx.Msize
+			rx.Version = plan9.VERSION9P
+		case plan9.Tattach:
+			rx.Qid = plan9.Qid{Type: plan9.QTDIR}
+		case plan9.Twalk:
+			rx.Wqid = make([]plan9.Qid, len(tx.Wname))
+		case plan9.Topen:
+		case plan9.Tread:
+			reads[tx.Tag] = tx
+			held <- tx
+			continue
+		case plan9.Tflush:
+			held <- tx
+			if late && reads[tx.Oldtag] != nil {
+				r := &plan9.Fcall{Type: plan9.Rread, Tag: tx.Oldtag, Data: []byte("late")}
+				if err := plan9.WriteFcall(c, r); err != nil {
+					return
+				}
+			}
+			delete(reads, tx.Oldtag)
+		case plan9.Tclunk:
+		default:
+			rx = &plan9.Fcall{Type: plan9.Rerror, Tag: tx.Tag, Ename: "unsupported"}
+		}
+		if err := plan9.WriteFcall(c, rx); err != nil {
+			return
+		}
+	}
+}
+
+func TestReadContext(t *testing.T) {
+	for _, late := range []bool{false, true} {
+		c1, c2 := net.Pipe()
+		held := make(chan *plan9.Fcall, 2)
+		go serveFlush(c1, late, held)
+		conn, err := NewConn(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys, err := conn.Attach(nil, "glenda", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fid, err := fsys.Open("event", plan9.OREAD)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		buf := make([]byte, 100)
+		var n int
+		go func() {
+			var err error
+			n, err = fid.ReadContext(ctx, buf)
+			done <- err
+		}()
+		read := <-held
+		cancel()
+		err = <-done
+		if late {
+			if err != nil || string(buf[:n]) != "late" {
+				t.Errorf("late reply: ReadContext = %q, %v, want reply", buf[:n], err)
+			}
+		} else if err != context.Canceled {
+			t.Errorf("ReadContext = %v, want %v", err, context.Canceled)
+		}
+		if tx := <-held; tx.Type != plan9.Tflush || tx.Oldtag != read.Tag {
+			t.Errorf("bad Tflush %v", tx)
+		}
+
+		// The connection must still work, and the flushed tag
+		// must be free for reuse.
+		if _, err := fsys.Open("other", plan9.OREAD); err != nil {
+			t.Errorf("Open after flush: %v", err)
+		}
+		if _, err := fid.ReadContext(ctx, buf); err != context.Canceled {
+			t.Errorf("ReadContext with done ctx = %v", err)
+		}
+		conn.Close()
+		c1.Close()
+	}
+}
@@ -3,11 +3,14 @@
 package client
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
 	"regexp"
 	"strings"
+
+	"plramos.win/9fans/plan9/dial"
 )
 
 func Dial(network, addr string) (*Conn, error) {
@@ -18,11 +21,38 @@ func Dial(network, addr string) (*Conn, error) {
 	return NewConn(c)
 }
 
+// DialService connects to the named service in the name space
+// directory, or, if service contains a !, to the dial string service.
 func DialService(service string) (*Conn, error) {
+	if strings.Contains(service, "!") {
+		return DialString(service)
+	}
 	ns := Namespace()
 	return Dial("unix", ns+"/"+service)
 }
 
+// DialString connects to the Plan 9 dial string addr,
+// such as "tcp!host!564", "net!host!9fs" or "unix!/path".
+// If addr names no service, the 9fs service (port 564) is used.
+func DialString(addr string) (*Conn, error) {
+	c, err := dial.Dial(addr, "9fs")
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c)
+}
+
+// DialStringTLS is like DialString but runs 9P over TLS.
+// To accept only a known server certificate, use a
+// configuration from dial.PinnedConfig.
+func DialStringTLS(addr string, config *tls.Config) (*Conn, error) {
+	c, err := dial.DialTLS(addr, "9fs", config)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c)
+}
+
 func Mount(network, addr string) (*Fsys, error) {
 	c, err := Dial(network, addr)
 	if err != nil {
@@ -35,6 +65,50 @@ func Mount(network, addr string) (*Fsys, error) {
 	return fsys, err
 }
 
+// MountAuth is like Mount but authenticates with a
+// and attaches to aname.
+func MountAuth(network, addr, aname string, a Authenticator) (*Fsys, error) {
+	c, err := Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	fsys, err := c.AuthAttach(a, getuser(), aname)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.Release()
+	return fsys, nil
+}
+
+// MountDialString connects to the dial string addr and attaches to aname.
+// If config is not nil, the connection runs over TLS.
+// If a is not nil, it is used to authenticate first, as in MountAuth.
+func MountDialString(addr, aname string, config *tls.Config, a Authenticator) (*Fsys, error) {
+	var c *Conn
+	var err error
+	if config != nil {
+		c, err = DialStringTLS(addr, config)
+	} else {
+		c, err = DialString(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fsys *Fsys
+	if a != nil {
+		fsys, err = c.AuthAttach(a, getuser(), aname)
+	} else {
+		fsys, err = c.Attach(nil, getuser(), aname)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.Release()
+	return fsys, nil
+}
+
 func MountService(service string) (*Fsys, error) {
 	c, err := DialService(service)
 	if err != nil {
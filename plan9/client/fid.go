@@ -12,7 +12,7 @@ func (fid *Fid) Dirread() ([]*plan9.Dir, error) {
 	if err != nil {
 		return nil, err
 	}
-	return dirUnpack(buf[0:n])
+	return dirUnpack(buf[0:n], fid.dialect())
 }
 
 func (fid *Fid) Dirreadall() ([]*plan9.Dir, error) {
@@ -33,7 +33,7 @@ func (fid *Fid) Dirreadall() ([]*plan9.Dir, error) {
 	}
 }
 
-func dirUnpack(b []byte) ([]*plan9.Dir, error) {
+func dirUnpack(b []byte, dialect plan9.Dialect) ([]*plan9.Dir, error) {
 	var err error
 	dirs := make([]*plan9.Dir, 0, 10)
 	for len(b) > 0 {
@@ -47,7 +47,7 @@ func dirUnpack(b []byte) ([]*plan9.Dir, error) {
 			break
 		}
 		var d *plan9.Dir
-		d, err = plan9.UnmarshalDir(b[0 : n+2])
+		d, err = dialect.UnmarshalDir(b[0 : n+2])
 		if err != nil {
 			break
 		}
@@ -0,0 +1,433 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"plramos.win/9fans/plan9"
+)
+
+// The methods in this file send 9P2000.L messages
+// and can only be used on connections that negotiated that dialect,
+// except that Symlink, Mknod, Link and Readlink also work on 9P2000.u
+// connections, where they use Tcreate extensions and Tstat.
+
+var (
+	errNotL = Error("operation requires 9P2000.L")
+	errNotU = Error("operation requires 9P2000.u or 9P2000.L")
+)
+
+func (fid *Fid) connL() (*conn, error) {
+	conn, err := fid.conn()
+	if err != nil {
+		return nil, err
+	}
+	if conn.dialect != plan9.Dialect9P2000L {
+		return nil, errNotL
+	}
+	return conn, nil
+}
+
+// lopenFlags converts a Plan 9 open mode to Linux open flags.
+func lopenFlags(mode uint8) uint32 {
+	var flags uint32
+	switch mode & 3 {
+	case plan9.OREAD, plan9.OEXEC:
+		flags = plan9.LORDONLY
+	case plan9.OWRITE:
+		flags = plan9.LOWRONLY
+	case plan9.ORDWR:
+		flags = plan9.LORDWR
+	}
+	if mode&plan9.OTRUNC != 0 {
+		flags |= plan9.LOTRUNC
+	}
+	return flags
+}
+
+// Lopen opens fid with the Linux open flags.
+func (fid *Fid) Lopen(flags uint32) error {
+	return fid.lopen(context.Background(), flags)
+}
+
+func (fid *Fid) lopen(ctx context.Context, flags uint32) error {
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tlopen, Fid: fid.fid, Flags: flags}
+	rx, err := conn.rpcContext(ctx, tx, nil)
+	if err != nil {
+		return err
+	}
+	fid.qid = rx.Qid
+	return nil
+}
+
+// Lcreate creates the file name in the directory fid
+// and opens it with the Linux open flags.
+// As with Create, fid then refers to the new file.
+func (fid *Fid) Lcreate(name string, flags, mode, gid uint32) error {
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tlcreate, Fid: fid.fid, Name: name, Flags: flags, Lmode: mode, Gid: gid}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return err
+	}
+	fid.qid = rx.Qid
+	return nil
+}
+
+// createL implements Create on a 9P2000.L connection.
+// Directories are made with Tmkdir and then opened,
+// since Tlcreate only creates regular files.
+func (fid *Fid) createL(conn *conn, name string, mode uint8, perm plan9.Perm) error {
+	lmode := uint32(perm & 0777)
+	if perm&plan9.DMDIR == 0 {
+		flags := lopenFlags(mode) | plan9.LOCREAT
+		if perm&plan9.DMEXCL != 0 {
+			flags |= plan9.LOEXCL
+		}
+		return fid.Lcreate(name, flags, lmode, plan9.NOUID)
+	}
+	if _, err := fid.Mkdir(name, lmode, plan9.NOUID); err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Twalk, Fid: fid.fid, Newfid: fid.fid, Wname: []string{name}}
+	if _, err := conn.rpc(tx, nil); err != nil {
+		return err
+	}
+	return fid.Lopen(plan9.LORDONLY | plan9.LODIRECTORY)
+}
+
+// Getattr returns the attributes of fid.
+// Mask is a combination of the plan9.Getattr bits
+// saying which attributes are wanted.
+func (fid *Fid) Getattr(mask uint64) (*plan9.LAttr, error) {
+	return fid.getattr(context.Background(), mask)
+}
+
+func (fid *Fid) getattr(ctx context.Context, mask uint64) (*plan9.LAttr, error) {
+	conn, err := fid.connL()
+	if err != nil {
+		return nil, err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tgetattr, Fid: fid.fid, Mask: mask}
+	rx, err := conn.rpcContext(ctx, tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &rx.Attr, nil
+}
+
+// Setattr changes the attributes of fid selected by a.Valid.
+func (fid *Fid) Setattr(a *plan9.LSetattr) error {
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tsetattr, Fid: fid.fid, Setattr: *a}
+	_, err = conn.rpc(tx, nil)
+	return err
+}
+
+// Statfs returns information about the file system containing fid.
+func (fid *Fid) Statfs() (*plan9.LStatfs, error) {
+	conn, err := fid.connL()
+	if err != nil {
+		return nil, err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tstatfs, Fid: fid.fid}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &rx.Statfs, nil
+}
+
+// Readdir reads the next directory entries from the open directory fid.
+// At the end of the directory it returns io.EOF.
+func (fid *Fid) Readdir() ([]plan9.LDirent, error) {
+	conn, err := fid.connL()
+	if err != nil {
+		return nil, err
+	}
+	fid.f.Lock()
+	o := fid.offset
+	fid.f.Unlock()
+	tx := &plan9.Fcall{Type: plan9.Treaddir, Fid: fid.fid, Offset: uint64(o), Count: conn.msize - plan9.IOHDRSZ}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rx.Data) == 0 {
+		return nil, io.EOF
+	}
+	d, err := plan9.UnmarshalLDirents(rx.Data)
+	if err != nil {
+		return nil, err
+	}
+	// The offset of a directory is the cookie of the last entry read.
+	fid.f.Lock()
+	fid.offset = int64(d[len(d)-1].Offset)
+	fid.f.Unlock()
+	return d, nil
+}
+
+// ReaddirAll reads the remaining entries of the open directory fid.
+func (fid *Fid) ReaddirAll() ([]plan9.LDirent, error) {
+	var all []plan9.LDirent
+	for {
+		d, err := fid.Readdir()
+		all = append(all, d...)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return all, err
+		}
+	}
+}
+
+// Mkdir creates the directory name in the directory fid.
+func (fid *Fid) Mkdir(name string, mode, gid uint32) (plan9.Qid, error) {
+	conn, err := fid.connL()
+	if err != nil {
+		return plan9.Qid{}, err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tmkdir, Fid: fid.fid, Name: name, Lmode: mode, Gid: gid}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return plan9.Qid{}, err
+	}
+	return rx.Qid, nil
+}
+
+// createU creates a special file in the directory fid
+// using a 9P2000.u Tcreate extension. Unlike Create, it leaves
+// fid referring to the directory.
+func (fid *Fid) createU(name string, perm plan9.Perm, ext string) (plan9.Qid, error) {
+	nfid, err := fid.Walk("")
+	if err != nil {
+		return plan9.Qid{}, err
+	}
+	defer nfid.Close()
+	if err := nfid.CreateExtension(name, plan9.OREAD, perm, ext); err != nil {
+		return plan9.Qid{}, err
+	}
+	return nfid.qid, nil
+}
+
+// Symlink creates a symbolic link name to target in the directory fid.
+// Gid is ignored on 9P2000.u connections.
+func (fid *Fid) Symlink(name, target string, gid uint32) (plan9.Qid, error) {
+	if fid.dialect() == plan9.Dialect9P2000u {
+		return fid.createU(name, plan9.DMSYMLINK|0777, target)
+	}
+	conn, err := fid.connL()
+	if err != nil {
+		return plan9.Qid{}, err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tsymlink, Fid: fid.fid, Name: name, Target: target, Gid: gid}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return plan9.Qid{}, err
+	}
+	return rx.Qid, nil
+}
+
+// Mknod creates the device or special file name in the directory fid.
+// Mode holds the Unix file type and permission bits.
+// Gid is ignored on 9P2000.u connections.
+func (fid *Fid) Mknod(name string, mode, major, minor, gid uint32) (plan9.Qid, error) {
+	if fid.dialect() == plan9.Dialect9P2000u {
+		perm := plan9.Perm(mode & 0777)
+		var ext string
+		switch mode & plan9.SIFMT {
+		case plan9.SIFBLK:
+			perm |= plan9.DMDEVICE
+			ext = fmt.Sprintf("b %d %d", major, minor)
+		case plan9.SIFCHR:
+			perm |= plan9.DMDEVICE
+			ext = fmt.Sprintf("c %d %d", major, minor)
+		case plan9.SIFIFO:
+			perm |= plan9.DMNAMEDPIPE
+		case plan9.SIFSOCK:
+			perm |= plan9.DMSOCKET
+		default:
+			return plan9.Qid{}, Error("mknod: unsupported file type")
+		}
+		return fid.createU(name, perm, ext)
+	}
+	conn, err := fid.connL()
+	if err != nil {
+		return plan9.Qid{}, err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tmknod, Fid: fid.fid, Name: name, Lmode: mode, Major: major, Minor: minor, Gid: gid}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return plan9.Qid{}, err
+	}
+	return rx.Qid, nil
+}
+
+// Readlink returns the target of the symbolic link fid.
+func (fid *Fid) Readlink() (string, error) {
+	if fid.dialect() == plan9.Dialect9P2000u {
+		d, err := fid.Stat()
+		if err != nil {
+			return "", err
+		}
+		if d.Mode&plan9.DMSYMLINK == 0 {
+			return "", Error("not a symbolic link")
+		}
+		return d.Extension, nil
+	}
+	conn, err := fid.connL()
+	if err != nil {
+		return "", err
+	}
+	tx := &plan9.Fcall{Type: plan9.Treadlink, Fid: fid.fid}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return "", err
+	}
+	return rx.Target, nil
+}
+
+// Link creates a hard link name to target in the directory fid.
+func (fid *Fid) Link(target *Fid, name string) error {
+	if fid.dialect() == plan9.Dialect9P2000u {
+		_, err := fid.createU(name, plan9.DMLINK, fmt.Sprint(target.fid))
+		return err
+	}
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tlink, Dfid: fid.fid, Fid: target.fid, Name: name}
+	_, err = conn.rpc(tx, nil)
+	return err
+}
+
+// Rename moves fid to name in the directory dir.
+func (fid *Fid) Rename(dir *Fid, name string) error {
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Trename, Fid: fid.fid, Dfid: dir.fid, Name: name}
+	_, err = conn.rpc(tx, nil)
+	return err
+}
+
+// Renameat renames oldname in the directory fid to newname in newdir.
+func (fid *Fid) Renameat(oldname string, newdir *Fid, newname string) error {
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Trenameat, Fid: fid.fid, Name: oldname, Dfid: newdir.fid, Newname: newname}
+	_, err = conn.rpc(tx, nil)
+	return err
+}
+
+// Unlinkat removes name from the directory fid.
+// Flags is 0 or AT_REMOVEDIR (0x200) to remove a directory.
+func (fid *Fid) Unlinkat(name string, flags uint32) error {
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tunlinkat, Fid: fid.fid, Name: name, Flags: flags}
+	_, err = conn.rpc(tx, nil)
+	return err
+}
+
+// Fsync flushes fid's data to stable storage,
+// and unless datasync is set, its metadata too.
+func (fid *Fid) Fsync(datasync bool) error {
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tfsync, Fid: fid.fid}
+	if datasync {
+		tx.Flags = 1
+	}
+	_, err = conn.rpc(tx, nil)
+	return err
+}
+
+// Lock acquires or releases the byte-range lock l on fid
+// and returns the server's status, one of plan9.LockSuccess,
+// LockBlocked, LockError or LockGrace.
+func (fid *Fid) Lock(l *plan9.LLock) (uint8, error) {
+	conn, err := fid.connL()
+	if err != nil {
+		return 0, err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tlock, Fid: fid.fid, Lock: *l}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return rx.Status, nil
+}
+
+// Getlock tests whether the lock l could be placed on fid.
+// It returns l with Type set to plan9.LockUnlck if so,
+// or else a description of a conflicting lock.
+func (fid *Fid) Getlock(l *plan9.LLock) (*plan9.LLock, error) {
+	conn, err := fid.connL()
+	if err != nil {
+		return nil, err
+	}
+	tx := &plan9.Fcall{Type: plan9.Tgetlock, Fid: fid.fid, Lock: *l}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &rx.Lock, nil
+}
+
+// Xattrwalk returns a new fid for reading the extended attribute name
+// of fid, or the list of attribute names if name is empty,
+// along with the attribute's size.
+func (fid *Fid) Xattrwalk(name string) (*Fid, uint64, error) {
+	conn, err := fid.connL()
+	if err != nil {
+		return nil, 0, err
+	}
+	xfidnum, err := conn.newfidnum()
+	if err != nil {
+		return nil, 0, err
+	}
+	tx := &plan9.Fcall{Type: plan9.Txattrwalk, Fid: fid.fid, Newfid: xfidnum, Name: name}
+	rx, err := conn.rpc(tx, nil)
+	if err != nil {
+		conn.putfidnum(xfidnum)
+		return nil, 0, err
+	}
+	return conn.newFid(xfidnum, fid.qid), rx.Size, nil
+}
+
+// Xattrcreate turns fid into a fid for writing size bytes
+// of the extended attribute name. The attribute is set
+// when fid is closed.
+func (fid *Fid) Xattrcreate(name string, size uint64, flags uint32) error {
+	conn, err := fid.connL()
+	if err != nil {
+		return err
+	}
+	tx := &plan9.Fcall{Type: plan9.Txattrcreate, Fid: fid.fid, Name: name, Size: size, Flags: flags}
+	_, err = conn.rpc(tx, nil)
+	return err
+}
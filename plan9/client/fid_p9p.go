@@ -4,6 +4,7 @@
 package client
 
 import (
+	"context"
 	"io"
 	"os"
 	"strings"
@@ -18,12 +19,13 @@ type Fid struct {
 	qid  plan9.Qid
 	fid  uint32
 	mode uint8
-	// f guards offset and c.
+	// f guards offset, window and c.
 	f sync.Mutex
 	// c holds the underlying connection.
 	// It's nil after the Fid has been closed.
 	_c     *conn
 	offset int64
+	window int // see SetWindow
 }
 
 func (fid *Fid) conn() (*conn, error) {
@@ -65,11 +67,27 @@ func (fid *Fid) clunked() error {
 }
 
 func (fid *Fid) Create(name string, mode uint8, perm plan9.Perm) error {
+	return fid.CreateExtension(name, mode, perm, "")
+}
+
+// CreateExtension is like Create but also sends the 9P2000.u
+// extension string, which describes special files:
+// the target of a symbolic link (perm&DMSYMLINK),
+// "b major minor" or "c major minor" for a device (perm&DMDEVICE),
+// or the number of an existing fid for a hard link (perm&DMLINK).
+// On other dialects ext must be empty.
+func (fid *Fid) CreateExtension(name string, mode uint8, perm plan9.Perm, ext string) error {
 	conn, err := fid.conn()
 	if err != nil {
 		return err
 	}
-	tx := &plan9.Fcall{Type: plan9.Tcreate, Fid: fid.fid, Name: name, Mode: mode, Perm: perm}
+	if ext != "" && conn.dialect != plan9.Dialect9P2000u {
+		return errNotU
+	}
+	if conn.dialect == plan9.Dialect9P2000L {
+		return fid.createL(conn, name, mode, perm)
+	}
+	tx := &plan9.Fcall{Type: plan9.Tcreate, Fid: fid.fid, Name: name, Mode: mode, Perm: perm, Extension: ext}
 	rx, err := conn.rpc(tx, nil)
 	if err != nil {
 		return err
@@ -80,29 +98,55 @@ func (fid *Fid) Create(name string, mode uint8, perm plan9.Perm) error {
 }
 
 func (fid *Fid) Open(mode uint8) error {
+	return fid.OpenContext(context.Background(), mode)
+}
+
+// OpenContext is like Open but flushes the request
+// and returns ctx.Err() if ctx is done before the reply arrives.
+// The context variants of the other methods behave the same way.
+// If the reply arrives first, it is used as usual.
+func (fid *Fid) OpenContext(ctx context.Context, mode uint8) error {
 	conn, err := fid.conn()
 	if err != nil {
 		return err
 	}
+	if conn.dialect == plan9.Dialect9P2000L {
+		return fid.lopen(ctx, lopenFlags(mode))
+	}
 	tx := &plan9.Fcall{Type: plan9.Topen, Fid: fid.fid, Mode: mode}
-	if _, err := conn.rpc(tx, nil); err != nil {
+	if _, err := conn.rpcContext(ctx, tx, nil); err != nil {
 		return err
 	}
 	fid.mode = mode
 	return nil
 }
 
+func (fid *Fid) dialect() plan9.Dialect {
+	conn, err := fid.conn()
+	if err != nil {
+		return plan9.Dialect9P2000
+	}
+	return conn.dialect
+}
+
 func (fid *Fid) Qid() plan9.Qid {
 	return fid.qid
 }
 
 func (fid *Fid) Read(b []byte) (n int, err error) {
-	return fid.readAt(b, -1)
+	return fid.readAt(context.Background(), b, -1)
+}
+
+// ReadContext is like Read but can be abandoned; see OpenContext.
+// It is useful for files such as acme's event file,
+// where a read blocks until there is something to report.
+func (fid *Fid) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	return fid.readAt(ctx, b, -1)
 }
 
 func (fid *Fid) ReadAt(b []byte, offset int64) (n int, err error) {
 	for len(b) > 0 {
-		m, err := fid.readAt(b, offset)
+		m, err := fid.readAt(context.Background(), b, offset)
 		if err != nil {
 			return n, err
 		}
@@ -115,7 +159,7 @@ func (fid *Fid) ReadAt(b []byte, offset int64) (n int, err error) {
 	return n, nil
 }
 
-func (fid *Fid) readAt(b []byte, offset int64) (n int, err error) {
+func (fid *Fid) readAt(ctx context.Context, b []byte, offset int64) (n int, err error) {
 	conn, err := fid.conn()
 	if err != nil {
 		return 0, err
@@ -132,7 +176,7 @@ func (fid *Fid) readAt(b []byte, offset int64) (n int, err error) {
 		fid.f.Unlock()
 	}
 	tx := &plan9.Fcall{Type: plan9.Tread, Fid: fid.fid, Offset: uint64(o), Count: uint32(n)}
-	rx, err := conn.rpc(tx, nil)
+	rx, err := conn.rpcContext(ctx, tx, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -196,20 +240,37 @@ func (fid *Fid) Seek(n int64, whence int) (int64, error) {
 }
 
 func (fid *Fid) Stat() (*plan9.Dir, error) {
+	return fid.StatContext(context.Background())
+}
+
+// StatContext is like Stat but can be abandoned; see OpenContext.
+func (fid *Fid) StatContext(ctx context.Context) (*plan9.Dir, error) {
 	conn, err := fid.conn()
 	if err != nil {
 		return nil, err
 	}
+	if conn.dialect == plan9.Dialect9P2000L {
+		a, err := fid.getattr(ctx, plan9.GetattrBasic)
+		if err != nil {
+			return nil, err
+		}
+		return a.Dir(), nil
+	}
 	tx := &plan9.Fcall{Type: plan9.Tstat, Fid: fid.fid}
-	rx, err := conn.rpc(tx, nil)
+	rx, err := conn.rpcContext(ctx, tx, nil)
 	if err != nil {
 		return nil, err
 	}
-	return plan9.UnmarshalDir(rx.Stat)
+	return conn.dialect.UnmarshalDir(rx.Stat)
 }
 
 // TODO(rsc): Could use ...string instead?
 func (fid *Fid) Walk(name string) (*Fid, error) {
+	return fid.WalkContext(context.Background(), name)
+}
+
+// WalkContext is like Walk but can be abandoned; see OpenContext.
+func (fid *Fid) WalkContext(ctx context.Context, name string) (*Fid, error) {
 	conn, err := fid.conn()
 	if err != nil {
 		return nil, err
@@ -238,7 +299,7 @@ func (fid *Fid) Walk(name string) (*Fid, error) {
 			n = plan9.MAXWELEM
 		}
 		tx := &plan9.Fcall{Type: plan9.Twalk, Fid: fromfidnum, Newfid: wfidnum, Wname: elem[0:n]}
-		rx, err := conn.rpc(tx, nil)
+		rx, err := conn.rpcContext(ctx, tx, nil)
 		if err == nil && len(rx.Wqid) != n {
 			err = Error("file '" + name + "' not found")
 		}
@@ -266,7 +327,18 @@ func (fid *Fid) Write(b []byte) (n int, err error) {
 	return fid.WriteAt(b, -1)
 }
 
+// WriteContext is like Write but can be abandoned; see OpenContext.
+// If ctx is done partway through a write larger than a message,
+// n counts the bytes written before then.
+func (fid *Fid) WriteContext(ctx context.Context, b []byte) (n int, err error) {
+	return fid.writeAll(ctx, b, -1)
+}
+
 func (fid *Fid) WriteAt(b []byte, offset int64) (n int, err error) {
+	return fid.writeAll(context.Background(), b, offset)
+}
+
+func (fid *Fid) writeAll(ctx context.Context, b []byte, offset int64) (n int, err error) {
 	conn, err := fid.conn()
 	if err != nil {
 		return 0, err
@@ -280,7 +352,7 @@ func (fid *Fid) WriteAt(b []byte, offset int64) (n int, err error) {
 		if uint32(want) > msize {
 			want = int(msize)
 		}
-		got, err := fid.writeAt(b[tot:tot+want], offset)
+		got, err := fid.writeAt(ctx, b[tot:tot+want], offset)
 		tot += got
 		if err != nil {
 			return tot, err
@@ -293,7 +365,7 @@ func (fid *Fid) WriteAt(b []byte, offset int64) (n int, err error) {
 	return tot, nil
 }
 
-func (fid *Fid) writeAt(b []byte, offset int64) (n int, err error) {
+func (fid *Fid) writeAt(ctx context.Context, b []byte, offset int64) (n int, err error) {
 	conn, err := fid.conn()
 	if err != nil {
 		return 0, err
@@ -305,7 +377,7 @@ func (fid *Fid) writeAt(b []byte, offset int64) (n int, err error) {
 		fid.f.Unlock()
 	}
 	tx := &plan9.Fcall{Type: plan9.Twrite, Fid: fid.fid, Offset: uint64(o), Data: b}
-	rx, err := conn.rpc(tx, nil)
+	rx, err := conn.rpcContext(ctx, tx, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -322,10 +394,7 @@ func (fid *Fid) Wstat(d *plan9.Dir) error {
 	if err != nil {
 		return err
 	}
-	b, err := d.Bytes()
-	if err != nil {
-		return err
-	}
+	b := conn.dialect.AppendDir(nil, d)
 	tx := &plan9.Fcall{Type: plan9.Twstat, Fid: fid.fid, Stat: b}
 	_, err = conn.rpc(tx, nil)
 	return err
@@ -1,7 +1,15 @@
 package client
 
-import "os"
+import (
+	"os"
+
+	"plramos.win/9fans/plan9"
+)
 
 type Fid struct {
 	*os.File
 }
+
+func (fid *Fid) dialect() plan9.Dialect {
+	return plan9.Dialect9P2000
+}
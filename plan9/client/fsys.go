@@ -3,6 +3,9 @@
 package client
 
 import (
+	"context"
+	"io"
+	"path"
 	"strings"
 
 	"plramos.win/9fans/plan9"
@@ -21,16 +24,50 @@ func (c *Conn) Auth(uname, aname string) (*Fid, error) {
 	if err != nil {
 		return nil, err
 	}
-	tx := &plan9.Fcall{Type: plan9.Tauth, Afid: afidnum, Uname: uname, Aname: aname}
+	tx := &plan9.Fcall{Type: plan9.Tauth, Afid: afidnum, Uname: uname, Aname: aname, Uid: plan9.NOUID}
 	rx, err := conn.rpc(tx, nil)
 	if err != nil {
 		conn.putfidnum(afidnum)
 		return nil, err
 	}
-	return conn.newFid(afidnum, rx.Qid), nil
+	return conn.newFid(afidnum, rx.Aqid), nil
+}
+
+// An Authenticator runs an authentication protocol
+// over the conversation rw, which reads and writes an auth fid,
+// to prove to the server that the client speaks for user.
+// Package plramos.win/9fans/plan9/auth provides implementations.
+type Authenticator interface {
+	Authenticate(rw io.ReadWriter, user, aname string) error
+}
+
+// AuthAttach authenticates as user using a and attaches to aname.
+// If the server refuses Tauth, as servers that need no
+// authentication do, AuthAttach attaches without an auth fid.
+func (c *Conn) AuthAttach(a Authenticator, user, aname string) (*Fsys, error) {
+	afid, err := c.Auth(user, aname)
+	if err != nil {
+		fsys, err1 := c.Attach(nil, user, aname)
+		if err1 != nil {
+			return nil, err
+		}
+		return fsys, nil
+	}
+	defer afid.Close()
+	if err := a.Authenticate(afid, user, aname); err != nil {
+		return nil, err
+	}
+	return c.Attach(afid, user, aname)
 }
 
 func (c *Conn) Attach(afid *Fid, user, aname string) (*Fsys, error) {
+	return c.AttachUid(afid, user, aname, plan9.NOUID)
+}
+
+// AttachUid is like Attach but also sends the numeric user id uid,
+// which 9P2000.u and 9P2000.L servers may use in place of user.
+// On a 9P2000 connection uid is ignored.
+func (c *Conn) AttachUid(afid *Fid, user, aname string, uid uint32) (*Fsys, error) {
 	conn, err := c.conn()
 	if err != nil {
 		return nil, err
@@ -39,7 +76,7 @@ func (c *Conn) Attach(afid *Fid, user, aname string) (*Fsys, error) {
 	if err != nil {
 		return nil, err
 	}
-	tx := &plan9.Fcall{Type: plan9.Tattach, Afid: plan9.NOFID, Fid: fidnum, Uname: user, Aname: aname}
+	tx := &plan9.Fcall{Type: plan9.Tattach, Afid: plan9.NOFID, Fid: fidnum, Uname: user, Aname: aname, Uid: uid}
 	if afid != nil {
 		tx.Afid = afid.fid
 	}
@@ -106,6 +143,20 @@ func (fs *Fsys) Open(name string, mode uint8) (*Fid, error) {
 	return fid, nil
 }
 
+// OpenContext is like Open but flushes the walk or open
+// and returns ctx.Err() if ctx is done first.
+func (fs *Fsys) OpenContext(ctx context.Context, name string, mode uint8) (*Fid, error) {
+	fid, err := fs.root.WalkContext(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := fid.OpenContext(ctx, mode); err != nil {
+		fid.Close()
+		return nil, err
+	}
+	return fid, nil
+}
+
 func (fs *Fsys) Remove(name string) error {
 	fid, err := fs.root.Walk(name)
 	if err != nil {
@@ -121,6 +172,10 @@ func (fs *Fsys) Stat(name string) (*plan9.Dir, error) {
 	}
 	d, err := fid.Stat()
 	fid.Close()
+	if err == nil && d.Name == "" {
+		// Tgetattr does not return a name.
+		d.Name = path.Base("/" + name)
+	}
 	return d, err
 }
 
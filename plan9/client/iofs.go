@@ -0,0 +1,295 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"plramos.win/9fans/plan9"
+)
+
+// FS returns a view of fsys as an io/fs file system,
+// for use with fs.WalkDir, http.FS, template.ParseFS and the like.
+// (Fsys cannot implement fs.FS itself, because its Open method
+// takes a Plan 9 open mode.)
+//
+// The result implements fs.StatFS, fs.ReadDirFS and fs.ReadFileFS.
+// Files are opened for reading; directory files implement
+// fs.ReadDirFile, and regular files implement io.Seeker and io.ReaderAt.
+func (fsys *Fsys) FS() *FS {
+	return &FS{fsys}
+}
+
+// An FS is the io/fs view of an Fsys returned by Fsys.FS.
+type FS struct {
+	fsys *Fsys
+}
+
+var (
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// Open opens the named file for reading.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	fid, err := f.fsys.root.Walk(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	d, err := fid.Stat()
+	if err != nil {
+		fid.Close()
+		return nil, pathError("open", name, err)
+	}
+	if err := fid.Open(plan9.OREAD); err != nil {
+		fid.Close()
+		return nil, pathError("open", name, err)
+	}
+	fixName(d, name)
+	return &file{fs: f, fid: fid, name: name, dir: d}, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+// Its Sys method returns the underlying *plan9.Dir.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	d, err := f.fsys.Stat(name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	fixName(d, name)
+	return fileInfo{d}, nil
+}
+
+// ReadDir reads the named directory
+// and returns its entries sorted by file name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	ff, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+	dir := ff.(*file)
+	if !dir.dir.Qid.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	list, err := dir.ReadDir(-1)
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, err
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	ff, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+	data, err := io.ReadAll(ff)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+	return data, nil
+}
+
+// fixName sets the name of the root to "." as io/fs expects,
+// and fills in names that the server did not send,
+// as for 9P2000.L.
+func fixName(d *plan9.Dir, name string) {
+	if name == "." {
+		d.Name = "."
+	} else if d.Name == "" {
+		d.Name = path.Base(name)
+	}
+}
+
+// pathError wraps a 9P error in an fs.PathError.
+// Plan 9 errors are plain strings, so the usual messages
+// for missing files and denied permission are translated
+// to fs.ErrNotExist and fs.ErrPermission.
+func pathError(op, name string, err error) error {
+	if e, ok := err.(Error); ok {
+		msg := string(e)
+		switch {
+		case strings.Contains(msg, "not found"),
+			strings.Contains(msg, "does not exist"),
+			strings.Contains(msg, "no such file"):
+			err = fs.ErrNotExist
+		case strings.Contains(msg, "permission denied"):
+			err = fs.ErrPermission
+		}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+type file struct {
+	fs   *FS
+	fid  *Fid
+	name string
+	dir  *plan9.Dir
+	ents []fs.DirEntry // unread directory entries
+	read bool          // ents has been loaded
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return fileInfo{f.dir}, nil
+}
+
+func (f *file) Read(b []byte) (int, error) {
+	if f.dir.Qid.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	if len(b) == 0 {
+		// A zero-length Tread would look like end of file.
+		return 0, nil
+	}
+	return f.fid.Read(b)
+}
+
+func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: fs.ErrInvalid}
+	}
+	n, err := f.fid.ReadAt(b, off)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	return f.fid.Seek(offset, whence)
+}
+
+func (f *file) Close() error {
+	return f.fid.Close()
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !f.read {
+		if err := f.loadDir(); err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: err}
+		}
+		f.read = true
+	}
+	if n <= 0 {
+		list := f.ents
+		f.ents = nil
+		return list, nil
+	}
+	if len(f.ents) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(f.ents) {
+		n = len(f.ents)
+	}
+	list := f.ents[:n]
+	f.ents = f.ents[n:]
+	return list, nil
+}
+
+func (f *file) loadDir() error {
+	if f.fid.dialect() == plan9.Dialect9P2000L {
+		// Rreaddir entries carry only a name and qid;
+		// the rest of the information is fetched on demand.
+		ents, err := f.fid.ReaddirAll()
+		if err != nil {
+			return err
+		}
+		for _, e := range ents {
+			if e.Name == "." || e.Name == ".." {
+				continue
+			}
+			f.ents = append(f.ents, &lazyEntry{fs: f.fs, name: path.Join(f.name, e.Name), qid: e.Qid})
+		}
+		return nil
+	}
+	dirs, err := f.fid.Dirreadall()
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		f.ents = append(f.ents, fs.FileInfoToDirEntry(fileInfo{d}))
+	}
+	return nil
+}
+
+// A lazyEntry is a directory entry whose FileInfo
+// is only fetched when asked for.
+type lazyEntry struct {
+	fs   *FS
+	name string
+	qid  plan9.Qid
+}
+
+func (e *lazyEntry) Name() string { return path.Base(e.name) }
+func (e *lazyEntry) IsDir() bool  { return e.qid.IsDir() }
+
+func (e *lazyEntry) Type() fs.FileMode {
+	switch {
+	case e.qid.Type&plan9.QTDIR != 0:
+		return fs.ModeDir
+	case e.qid.Type&plan9.QTSYMLINK != 0:
+		return fs.ModeSymlink
+	}
+	return 0
+}
+
+func (e *lazyEntry) Info() (fs.FileInfo, error) { return e.fs.Stat(e.name) }
+
+func (e *lazyEntry) String() string { return fs.FormatDirEntry(e) }
+
+// A fileInfo adapts a plan9.Dir to fs.FileInfo.
+type fileInfo struct {
+	d *plan9.Dir
+}
+
+func (fi fileInfo) Name() string       { return fi.d.Name }
+func (fi fileInfo) Size() int64        { return int64(fi.d.Length) }
+func (fi fileInfo) Mode() fs.FileMode  { return fileMode(fi.d.Mode) }
+func (fi fileInfo) ModTime() time.Time { return time.Unix(int64(fi.d.Mtime), 0) }
+func (fi fileInfo) IsDir() bool        { return fi.d.Mode&plan9.DMDIR != 0 }
+func (fi fileInfo) Sys() interface{}   { return fi.d }
+
+func (fi fileInfo) String() string { return fs.FormatFileInfo(fi) }
+
+var permModes = []struct {
+	perm plan9.Perm
+	mode fs.FileMode
+}{
+	{plan9.DMDIR, fs.ModeDir},
+	{plan9.DMAPPEND, fs.ModeAppend},
+	{plan9.DMEXCL, fs.ModeExclusive},
+	{plan9.DMTMP, fs.ModeTemporary},
+	{plan9.DMSYMLINK, fs.ModeSymlink},
+	{plan9.DMDEVICE, fs.ModeDevice},
+	{plan9.DMNAMEDPIPE, fs.ModeNamedPipe},
+	{plan9.DMSOCKET, fs.ModeSocket},
+	{plan9.DMSETUID, fs.ModeSetuid},
+	{plan9.DMSETGID, fs.ModeSetgid},
+}
+
+// fileMode converts Plan 9 permissions to an fs.FileMode.
+func fileMode(p plan9.Perm) fs.FileMode {
+	m := fs.FileMode(p & 0777)
+	for _, pm := range permModes {
+		if p&pm.perm != 0 {
+			m |= pm.mode
+		}
+	}
+	return m
+}
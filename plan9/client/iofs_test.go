@@ -0,0 +1,62 @@
+//go:build !plan9
+// +build !plan9
+
+package client_test
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"testing"
+	"testing/fstest"
+
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plan9/client"
+	"plramos.win/9fans/plan9/srv"
+)
+
+func TestFS(t *testing.T) {
+	tree := srv.NewTree("glenda", "glenda", 0775)
+	dir, _ := tree.Root.Create("dir", "glenda", plan9.DMDIR|0775, nil)
+	dir.Create("empty", "glenda", plan9.DMDIR|0775, nil)
+	for _, f := range []struct {
+		dir        *srv.File
+		name, data string
+	}{
+		{tree.Root, "hello", "hello, world\n"},
+		{dir, "inner", "inner\n"},
+	} {
+		file, _ := f.dir.Create(f.name, "glenda", 0664, f.data)
+		file.Update(func(d *plan9.Dir) { d.Length = uint64(len(f.data)) })
+	}
+	s := &srv.Srv{
+		Tree: tree,
+		Read: func(r *srv.Req) {
+			data, _ := r.Fid.File.Aux.(string)
+			r.ReadString(data)
+			r.Respond(nil)
+		},
+	}
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c1)
+	conn, err := client.NewConn(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fsys, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys.FS(), "hello", "dir/inner", "dir/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(fsys.FS(), "dir")
+	if err != nil || !fi.IsDir() || fi.Mode() != fs.ModeDir|0775 {
+		t.Errorf("Stat(dir) = %v, %v", fi, err)
+	}
+	if _, err := fs.ReadFile(fsys.FS(), "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile(missing) = %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,184 @@
+//go:build !plan9
+// +build !plan9
+
+package client
+
+import (
+	"io"
+
+	"plramos.win/9fans/plan9"
+)
+
+// SetWindow sets the largest number of Tread or Twrite requests
+// WriteTo and ReadFrom keep outstanding at once.
+// The default, 1, sends each request only after the previous
+// one has been answered, as Read and Write do. Only files whose
+// contents are addressed by offset, as ordinary files' are,
+// may be given a larger window: a server may handle requests
+// in flight together in any order.
+func (fid *Fid) SetWindow(n int) {
+	if n < 1 {
+		n = 1
+	}
+	fid.f.Lock()
+	fid.window = n
+	fid.f.Unlock()
+}
+
+// maxWindow returns the window to use for a transfer.
+// Directories and append-only files are always
+// transferred one request at a time.
+func (fid *Fid) maxWindow() int {
+	if fid.qid.Type&(plan9.QTDIR|plan9.QTAPPEND) != 0 {
+		return 1
+	}
+	fid.f.Lock()
+	defer fid.f.Unlock()
+	return max(fid.window, 1)
+}
+
+type rpcResult struct {
+	rx  *plan9.Fcall
+	err error
+}
+
+// start sends tx in the background; the reply arrives on the result.
+func (c *conn) start(tx *plan9.Fcall) <-chan rpcResult {
+	ch := make(chan rpcResult, 1)
+	go func() {
+		rx, err := c.rpc(tx, nil)
+		ch <- rpcResult{rx, err}
+	}()
+	return ch
+}
+
+// wait waits for the outstanding requests in q.
+func wait(q []<-chan rpcResult) {
+	for _, ch := range q {
+		<-ch
+	}
+}
+
+// WriteTo implements io.WriterTo, copying the file from the
+// current offset to w and advancing the offset.
+//
+// If SetWindow has allowed it, after each full-sized read
+// it widens the window of reads in flight, so that large
+// files are copied at link speed rather than one round trip
+// per message. A short read drains the window and continues
+// one read at a time, so files that return less than was
+// asked for see only one outstanding read.
+func (fid *Fid) WriteTo(w io.Writer) (n int64, err error) {
+	conn, err := fid.conn()
+	if err != nil {
+		return 0, err
+	}
+	chunk := int(conn.msize - plan9.IOHDRSZ)
+	max := fid.maxWindow()
+	fid.f.Lock()
+	next := fid.offset
+	fid.f.Unlock()
+
+	var q []<-chan rpcResult
+	defer func() { wait(q) }()
+	win := 1
+	for {
+		for len(q) < win {
+			q = append(q, conn.start(&plan9.Fcall{Type: plan9.Tread, Fid: fid.fid, Offset: uint64(next), Count: uint32(chunk)}))
+			next += int64(chunk)
+		}
+		r := <-q[0]
+		q = q[1:]
+		if r.err != nil {
+			return n, r.err
+		}
+		data := r.rx.Data
+		if len(data) == 0 {
+			return n, nil
+		}
+		m, err := w.Write(data)
+		n += int64(m)
+		fid.f.Lock()
+		fid.offset += int64(m)
+		off := fid.offset
+		fid.f.Unlock()
+		if err != nil {
+			return n, err
+		}
+		if m < len(data) {
+			return n, io.ErrShortWrite
+		}
+		if len(data) < chunk {
+			wait(q)
+			q = nil
+			next = off
+			win = 1
+		} else if win < max {
+			win = min(2*win, max)
+		}
+	}
+}
+
+type pendingWrite struct {
+	n  int
+	ch <-chan rpcResult
+}
+
+// ReadFrom implements io.ReaderFrom, copying r to the file
+// at the current offset and advancing the offset.
+// Like WriteTo, it keeps a widening window of writes in flight
+// if SetWindow has allowed it.
+func (fid *Fid) ReadFrom(r io.Reader) (n int64, err error) {
+	conn, err := fid.conn()
+	if err != nil {
+		return 0, err
+	}
+	chunk := int(conn.msize - plan9.IOHDRSZ)
+	max := fid.maxWindow()
+	fid.f.Lock()
+	next := fid.offset
+	fid.f.Unlock()
+
+	var q []pendingWrite
+	defer func() {
+		for _, p := range q {
+			<-p.ch
+		}
+	}()
+	var rerr error
+	win := 1
+	for {
+		for len(q) < win && rerr == nil {
+			buf := make([]byte, chunk)
+			var m int
+			m, rerr = r.Read(buf)
+			if m > 0 {
+				tx := &plan9.Fcall{Type: plan9.Twrite, Fid: fid.fid, Offset: uint64(next), Data: buf[:m]}
+				q = append(q, pendingWrite{m, conn.start(tx)})
+				next += int64(m)
+			}
+		}
+		if len(q) == 0 {
+			if rerr == io.EOF {
+				rerr = nil
+			}
+			return n, rerr
+		}
+		p := q[0]
+		q = q[1:]
+		res := <-p.ch
+		if res.err != nil {
+			return n, res.err
+		}
+		n += int64(res.rx.Count)
+		fid.f.Lock()
+		fid.offset += int64(res.rx.Count)
+		fid.f.Unlock()
+		if int(res.rx.Count) < p.n {
+			return n, io.ErrShortWrite
+		}
+		if win < max {
+			win = min(2*win, max)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+//go:build !plan9
+// +build !plan9
+
+package client_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plan9/client"
+	"plramos.win/9fans/plan9/srv"
+)
+
+// slowConn delays each write by a fixed latency
+// without limiting how many writes are in flight.
+type slowConn struct {
+	net.Conn
+	latency time.Duration
+	q       chan slowMsg
+}
+
+type slowMsg struct {
+	due  time.Time
+	data []byte
+}
+
+func newSlowConn(c net.Conn, latency time.Duration) *slowConn {
+	s := &slowConn{Conn: c, latency: latency, q: make(chan slowMsg, 1024)}
+	go func() {
+		for m := range s.q {
+			time.Sleep(time.Until(m.due))
+			if _, err := c.Write(m.data); err != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *slowConn) Write(b []byte) (int, error) {
+	s.q <- slowMsg{time.Now().Add(s.latency), append([]byte(nil), b...)}
+	return len(b), nil
+}
+
+// blobServer serves a single file "blob" whose contents are data,
+// replying after the given latency. Each write takes hold
+// to handle, and maxWrites records how many overlapped.
+type blobServer struct {
+	latency time.Duration
+	hold    time.Duration
+	mu      sync.Mutex
+	data    []byte
+
+	writes, maxWrites int
+}
+
+func (b *blobServer) mount(t testing.TB) *client.Fsys {
+	tree := srv.NewTree("glenda", "glenda", 0775)
+	tree.Root.Create("blob", "glenda", 0664, nil)
+	s := &srv.Srv{
+		Tree: tree,
+		Read: func(r *srv.Req) {
+			b.mu.Lock()
+			r.ReadBytes(b.data)
+			b.mu.Unlock()
+			r.Respond(nil)
+		},
+		Write: func(r *srv.Req) {
+			go func() {
+				b.mu.Lock()
+				b.writes++
+				b.maxWrites = max(b.maxWrites, b.writes)
+				b.mu.Unlock()
+				time.Sleep(b.hold)
+				b.mu.Lock()
+				b.writes--
+				off := int(r.Ifcall.Offset)
+				if end := off + len(r.Ifcall.Data); end > len(b.data) {
+					b.data = append(b.data, make([]byte, end-len(b.data))...)
+				}
+				copy(b.data[off:], r.Ifcall.Data)
+				b.mu.Unlock()
+				r.Ofcall.Count = uint32(len(r.Ifcall.Data))
+				r.Respond(nil)
+			}()
+		},
+	}
+	c1, c2 := net.Pipe()
+	go s.ServeConn(newSlowConn(c1, b.latency))
+	conn, err := client.NewConn(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Release()
+	return fsys
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	data := make([]byte, 1<<20+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	rev := make([]byte, len(data))
+	for i := range data {
+		rev[i] = data[len(data)-1-i]
+	}
+	for _, window := range []int{0, 8} {
+		b := &blobServer{hold: 100 * time.Microsecond, data: bytes.Clone(data)}
+		fsys := b.mount(t)
+
+		fid, err := fsys.Open("blob", plan9.ORDWR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if window != 0 {
+			fid.SetWindow(window)
+		}
+		var buf bytes.Buffer
+		n, err := io.Copy(&buf, fid)
+		if err != nil || n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("window %d: WriteTo = %d, %v; data match %v", window, n, err, bytes.Equal(buf.Bytes(), data))
+		}
+		if off, _ := fid.Seek(0, io.SeekCurrent); off != n {
+			t.Errorf("window %d: offset after WriteTo = %d, want %d", window, off, n)
+		}
+
+		fid.Seek(0, io.SeekStart)
+		n, err = fid.ReadFrom(bytes.NewReader(rev))
+		fid.Close()
+		if err != nil || n != int64(len(rev)) {
+			t.Fatalf("window %d: ReadFrom = %d, %v", window, n, err)
+		}
+		if !bytes.Equal(b.data, rev) {
+			t.Errorf("window %d: ReadFrom wrote wrong data", window)
+		}
+		// Without SetWindow, writes go one at a time.
+		if pipelined := b.maxWrites > 1; pipelined != (window > 1) {
+			t.Errorf("window %d: %d writes in flight at once", window, b.maxWrites)
+		}
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	for _, window := range []int{1, 16} {
+		b.Run(fmt.Sprintf("window=%d", window), func(b *testing.B) {
+			s := &blobServer{latency: 500 * time.Microsecond, data: make([]byte, 4<<20)}
+			fsys := s.mount(b)
+			b.SetBytes(int64(len(s.data)))
+			for i := 0; i < b.N; i++ {
+				fid, err := fsys.Open("blob", plan9.OREAD)
+				if err != nil {
+					b.Fatal(err)
+				}
+				fid.SetWindow(window)
+				if _, err := fid.WriteTo(io.Discard); err != nil {
+					b.Fatal(err)
+				}
+				fid.Close()
+			}
+		})
+	}
+}
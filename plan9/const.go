@@ -1,8 +1,10 @@
 package plan9
 
 const (
-	VERSION9P = "9P2000"
-	MAXWELEM  = 16
+	VERSION9P  = "9P2000"
+	VERSION9PU = "9P2000.u"
+	VERSION9PL = "9P2000.L"
+	MAXWELEM   = 16
 
 	OREAD     = 0
 	OWRITE    = 1
@@ -38,6 +40,7 @@ const (
 	DMAUTH      = 0x08000000
 	DMTMP       = 0x04000000
 	DMSYMLINK   = 0x02000000
+	DMLINK      = 0x01000000
 	DMDEVICE    = 0x00800000
 	DMNAMEDPIPE = 0x00200000
 	DMSOCKET    = 0x00100000
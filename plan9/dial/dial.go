@@ -0,0 +1,219 @@
+// Package dial parses Plan 9 dial strings and uses them
+// to make and accept network connections.
+//
+// A dial string has the form net!host!service, as in dial(3):
+//
+//	tcp!9fans.net!564
+//	tcp!9fans.net!9fs
+//	net!9fans.net!9fs
+//	unix!/tmp/ns.glenda.:0/acme
+//	tcp!*!564
+//
+// The network net is tcp, udp or unix, or net, which means tcp.
+// For unix, the rest of the string is a socket path.
+// The service may be a port number or a name; names are looked up
+// in a table of Plan 9 services and then in the system's services.
+// A host of * in Listen means all addresses.
+package dial // import "plramos.win/9fans/plan9/dial"
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// An Addr is a parsed dial string.
+type Addr struct {
+	Net     string // tcp, udp, unix or net
+	Host    string // host name or address, or socket path for unix
+	Service string // port number or service name; empty for unix
+}
+
+// String returns a in dial string form.
+func (a Addr) String() string {
+	if a.Net == "unix" || a.Service == "" {
+		return a.Net + "!" + a.Host
+	}
+	return a.Net + "!" + a.Host + "!" + a.Service
+}
+
+// Parse parses the dial string s. Like netmkaddr in dial(3),
+// it fills in missing parts: a string without ! is a host
+// (or, if it contains a /, a unix socket path) on the network net,
+// and a missing service is taken from defService.
+func Parse(s, defService string) (Addr, error) {
+	if s == "" {
+		return Addr{}, errors.New("dial: empty address")
+	}
+	f := strings.SplitN(s, "!", 3)
+	var a Addr
+	switch len(f) {
+	case 1:
+		if strings.Contains(s, "/") {
+			return Addr{Net: "unix", Host: s}, nil
+		}
+		a = Addr{Net: "net", Host: s, Service: defService}
+	case 2:
+		a = Addr{Net: f[0], Host: f[1], Service: defService}
+	default:
+		a = Addr{Net: f[0], Host: f[1], Service: f[2]}
+	}
+	switch a.Net {
+	case "unix":
+		// The path may itself contain !.
+		return Addr{Net: "unix", Host: strings.TrimPrefix(s, "unix!")}, nil
+	case "tcp", "udp", "net":
+	default:
+		return Addr{}, fmt.Errorf("dial: unknown network %q in %s", a.Net, s)
+	}
+	if a.Host == "" {
+		return Addr{}, fmt.Errorf("dial: missing host in %s", s)
+	}
+	if a.Service == "" {
+		return Addr{}, fmt.Errorf("dial: missing service in %s", s)
+	}
+	return a, nil
+}
+
+// services maps Plan 9 service names that are usually
+// missing from /etc/services to port numbers.
+var services = map[string]int{
+	"9fs":      564,
+	"ticket":   567,
+	"exportfs": 17007,
+	"cpu":      17013,
+	"rexauth":  17021,
+	"styx":     6666,
+}
+
+// Network returns the network and address for a
+// as used by package net.
+func (a Addr) Network() (network, address string, err error) {
+	if a.Net == "unix" {
+		return "unix", a.Host, nil
+	}
+	network = a.Net
+	if network == "net" {
+		network = "tcp"
+	}
+	port, ok := services[a.Service]
+	if !ok {
+		if port, err = strconv.Atoi(a.Service); err != nil {
+			if port, err = net.LookupPort(network, a.Service); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	host := a.Host
+	if host == "*" {
+		host = ""
+	}
+	return network, net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// Dial connects to the dial string addr,
+// using defService if addr names no service.
+func Dial(addr, defService string) (net.Conn, error) {
+	a, err := Parse(addr, defService)
+	if err != nil {
+		return nil, err
+	}
+	network, address, err := a.Network()
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial(network, address)
+}
+
+// DialTLS is like Dial but runs TLS over the connection.
+// If config does not set ServerName, it is set from addr.
+func DialTLS(addr, defService string, config *tls.Config) (net.Conn, error) {
+	a, err := Parse(addr, defService)
+	if err != nil {
+		return nil, err
+	}
+	network, address, err := a.Network()
+	if err != nil {
+		return nil, err
+	}
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = new(tls.Config)
+	}
+	if config.ServerName == "" && a.Net != "unix" {
+		config = config.Clone()
+		config.ServerName = a.Host
+	}
+	tc := tls.Client(c, config)
+	if err := tc.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Listen announces on the dial string addr,
+// using defService if addr names no service.
+// A host of * listens on all addresses.
+func Listen(addr, defService string) (net.Listener, error) {
+	a, err := Parse(addr, defService)
+	if err != nil {
+		return nil, err
+	}
+	network, address, err := a.Network()
+	if err != nil {
+		return nil, err
+	}
+	return net.Listen(network, address)
+}
+
+// Fingerprint returns the fingerprint of cert used for pinning,
+// in the form sha256=hex.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "sha256=" + hex.EncodeToString(sum[:])
+}
+
+// PinnedConfig returns a TLS configuration that accepts a server
+// only if its certificate has one of the given fingerprints,
+// as returned by Fingerprint. The certificate need not be
+// signed by a known authority.
+func PinnedConfig(pins ...string) (*tls.Config, error) {
+	ok := make(map[string]bool)
+	for _, p := range pins {
+		p = strings.ToLower(p)
+		if !strings.HasPrefix(p, "sha256=") {
+			p = "sha256=" + p
+		}
+		if b, err := hex.DecodeString(p[len("sha256="):]); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("dial: bad certificate fingerprint %q", p)
+		}
+		ok[p] = true
+	}
+	return &tls.Config{
+		// Verification is done by VerifyPeerCertificate instead.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) == 0 {
+				return errors.New("dial: server sent no certificate")
+			}
+			cert, err := x509.ParseCertificate(raw[0])
+			if err != nil {
+				return err
+			}
+			if fp := Fingerprint(cert); !ok[fp] {
+				return fmt.Errorf("dial: certificate %s is not pinned", fp)
+			}
+			return nil
+		},
+	}, nil
+}
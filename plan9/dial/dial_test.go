@@ -0,0 +1,122 @@
+package dial
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var parseTests = []struct {
+	in      string
+	addr    Addr
+	network string
+	address string
+}{
+	{"tcp!host!564", Addr{"tcp", "host", "564"}, "tcp", "host:564"},
+	{"net!host!9fs", Addr{"net", "host", "9fs"}, "tcp", "host:564"},
+	{"tcp!host", Addr{"tcp", "host", "9fs"}, "tcp", "host:564"},
+	{"host", Addr{"net", "host", "9fs"}, "tcp", "host:564"},
+	{"tcp!*!ticket", Addr{"tcp", "*", "ticket"}, "tcp", ":567"},
+	{"tcp!::1!564", Addr{"tcp", "::1", "564"}, "tcp", "[::1]:564"},
+	{"unix!/tmp/ns.glenda.:0/acme", Addr{"unix", "/tmp/ns.glenda.:0/acme", ""}, "unix", "/tmp/ns.glenda.:0/acme"},
+	{"unix!/tmp/a!b", Addr{"unix", "/tmp/a!b", ""}, "unix", "/tmp/a!b"},
+	{"/tmp/sock", Addr{"unix", "/tmp/sock", ""}, "unix", "/tmp/sock"},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		a, err := Parse(tt.in, "9fs")
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if a != tt.addr {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, a, tt.addr)
+		}
+		network, address, err := a.Network()
+		if err != nil || network != tt.network || address != tt.address {
+			t.Errorf("Parse(%q).Network() = %q, %q, %v, want %q, %q", tt.in, network, address, err, tt.network, tt.address)
+		}
+	}
+	for _, s := range []string{"", "il!host!564", "tcp!!564", "tcp!host!"} {
+		if a, err := Parse(s, ""); err == nil {
+			t.Errorf("Parse(%q) = %+v, want error", s, a)
+		}
+	}
+}
+
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestPinned(t *testing.T) {
+	cert := selfSigned(t)
+	l, err := Listen("tcp!127.0.0.1!0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tl := tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}})
+	go func() {
+		for {
+			c, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.WriteString(c, "hello")
+				c.Close()
+			}()
+		}
+	}()
+	addr := "tcp!127.0.0.1!" + l.Addr().String()[strings.LastIndex(l.Addr().String(), ":")+1:]
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := PinnedConfig(Fingerprint(leaf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := DialTLS(addr, "", config)
+	if err != nil {
+		t.Fatalf("DialTLS with pin: %v", err)
+	}
+	b, err := io.ReadAll(c)
+	c.Close()
+	if string(b) != "hello" {
+		t.Errorf("read %q, %v", b, err)
+	}
+
+	other, _ := PinnedConfig(strings.Repeat("00", 32))
+	if c, err := DialTLS(addr, "", other); err == nil {
+		c.Close()
+		t.Errorf("DialTLS with wrong pin succeeded")
+	}
+	if _, err := PinnedConfig("sha256=xyz"); err == nil {
+		t.Errorf("PinnedConfig accepted bad fingerprint")
+	}
+}
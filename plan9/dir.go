@@ -27,6 +27,12 @@ type Dir struct {
 	Uid    string
 	Gid    string
 	Muid   string
+
+	// 9P2000.u extensions
+	Extension string // symlink target, device "b major minor", etc.
+	Nuid      uint32
+	Ngid      uint32
+	Nmuid     uint32
 }
 
 var nullDir = Dir{
@@ -41,13 +47,17 @@ var nullDir = Dir{
 	"",
 	"",
 	"",
+	"",
+	^uint32(0),
+	^uint32(0),
+	^uint32(0),
 }
 
 func (d *Dir) Null() {
 	*d = nullDir
 }
 
-func pdir(b []byte, d *Dir) []byte {
+func pdir(b []byte, d *Dir, dl Dialect) []byte {
 	n := len(b)
 	b = pbit16(b, 0) // length, filled in later
 	b = pbit16(b, d.Type)
@@ -61,19 +71,40 @@ func pdir(b []byte, d *Dir) []byte {
 	b = pstring(b, d.Uid)
 	b = pstring(b, d.Gid)
 	b = pstring(b, d.Muid)
+	if dl == Dialect9P2000u {
+		b = pstring(b, d.Extension)
+		b = pbit32(b, d.Nuid)
+		b = pbit32(b, d.Ngid)
+		b = pbit32(b, d.Nmuid)
+	}
 	pbit16(b[0:n], uint16(len(b)-(n+2)))
 	return b
 }
 
 func (d *Dir) Append(buf []byte) []byte {
-	return pdir(buf, d)
+	return pdir(buf, d, Dialect9P2000)
 }
 
 func (d *Dir) Bytes() ([]byte, error) {
-	return pdir(nil, d), nil
+	return pdir(nil, d, Dialect9P2000), nil
 }
 
 func UnmarshalDir(b []byte) (d *Dir, err error) {
+	return unmarshalDir(b, Dialect9P2000)
+}
+
+// AppendDir appends the encoding of d in dialect dl to buf.
+// Only 9P2000.u changes the stat format.
+func (dl Dialect) AppendDir(buf []byte, d *Dir) []byte {
+	return pdir(buf, d, dl)
+}
+
+// UnmarshalDir decodes a stat entry in dialect dl.
+func (dl Dialect) UnmarshalDir(b []byte) (*Dir, error) {
+	return unmarshalDir(b, dl)
+}
+
+func unmarshalDir(b []byte, dl Dialect) (d *Dir, err error) {
 	defer func() {
 		if v := recover(); v != nil {
 			d = nil
@@ -98,6 +129,12 @@ func UnmarshalDir(b []byte) (d *Dir, err error) {
 	d.Uid, b = gstring(b)
 	d.Gid, b = gstring(b)
 	d.Muid, b = gstring(b)
+	if dl == Dialect9P2000u {
+		d.Extension, b = gstring(b)
+		d.Nuid, b = gbit32(b)
+		d.Ngid, b = gbit32(b)
+		d.Nmuid, b = gbit32(b)
+	}
 
 	if len(b) != 0 {
 		panic(1)
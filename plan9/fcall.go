@@ -38,6 +38,23 @@ type Fcall struct {
 	Errno     uint32 // Rerror
 	Uid       uint32 // Tattach, Tauth
 	Extension string // Tcreate
+
+	// 9P2000.L extensions
+	Flags   uint32   // Tlopen, Tlcreate, Txattrcreate, Tunlinkat, Tfsync, Tlock
+	Gid     uint32   // Tlcreate, Tsymlink, Tmknod, Tmkdir
+	Lmode   uint32   // Tlcreate, Tmknod, Tmkdir
+	Target  string   // Tsymlink, Rreadlink
+	Major   uint32   // Tmknod
+	Minor   uint32   // Tmknod
+	Dfid    uint32   // Trename, Tlink, Trenameat
+	Newname string   // Trenameat
+	Size    uint64   // Rxattrwalk, Txattrcreate
+	Mask    uint64   // Tgetattr
+	Attr    LAttr    // Rgetattr
+	Setattr LSetattr // Tsetattr
+	Statfs  LStatfs  // Rstatfs
+	Lock    LLock    // Tlock, Tgetlock, Rgetlock
+	Status  uint8    // Rlock
 }
 
 const (
@@ -72,12 +89,23 @@ const (
 	Tmax
 )
 
+// Bytes returns the 9P2000 encoding of f.
 func (f *Fcall) Bytes() ([]byte, error) {
+	return f.marshal(Dialect9P2000)
+}
+
+func (f *Fcall) marshal(d Dialect) ([]byte, error) {
 	b := pbit32(nil, 0) // length: fill in later
 	b = pbit8(b, f.Type)
 	b = pbit16(b, f.Tag)
 	switch f.Type {
 	default:
+		if d == Dialect9P2000L {
+			var ok bool
+			if b, ok = pfcallL(b, f); ok {
+				break
+			}
+		}
 		return nil, ProtocolError("invalid type")
 
 	case Tversion:
@@ -91,12 +119,18 @@ func (f *Fcall) Bytes() ([]byte, error) {
 		b = pbit32(b, f.Afid)
 		b = pstring(b, f.Uname)
 		b = pstring(b, f.Aname)
+		if d != Dialect9P2000 {
+			b = pbit32(b, f.Uid)
+		}
 
 	case Tattach:
 		b = pbit32(b, f.Fid)
 		b = pbit32(b, f.Afid)
 		b = pstring(b, f.Uname)
 		b = pstring(b, f.Aname)
+		if d != Dialect9P2000 {
+			b = pbit32(b, f.Uid)
+		}
 
 	case Twalk:
 		b = pbit32(b, f.Fid)
@@ -118,6 +152,9 @@ func (f *Fcall) Bytes() ([]byte, error) {
 		b = pstring(b, f.Name)
 		b = pperm(b, f.Perm)
 		b = pbit8(b, f.Mode)
+		if d == Dialect9P2000u {
+			b = pstring(b, f.Extension)
+		}
 
 	case Tread:
 		b = pbit32(b, f.Fid)
@@ -144,6 +181,9 @@ func (f *Fcall) Bytes() ([]byte, error) {
 
 	case Rerror:
 		b = pstring(b, f.Ename)
+		if d == Dialect9P2000u {
+			b = pbit32(b, f.Errno)
+		}
 
 	case Rflush, Rclunk, Rremove, Rwstat:
 		// nothing
@@ -183,7 +223,12 @@ func (f *Fcall) Bytes() ([]byte, error) {
 	return b, nil
 }
 
+// UnmarshalFcall decodes a 9P2000 message.
 func UnmarshalFcall(b []byte) (f *Fcall, err error) {
+	return unmarshalFcall(b, Dialect9P2000)
+}
+
+func unmarshalFcall(b []byte, d Dialect) (f *Fcall, err error) {
 	defer func() {
 		if recover() != nil {
 			println("bad fcall at ", b)
@@ -203,7 +248,10 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 
 	switch f.Type {
 	default:
-		panic(1)
+		if d != Dialect9P2000L {
+			panic(1)
+		}
+		b = gfcallL(b, f)
 
 	case Tversion:
 		f.Msize, b = gbit32(b)
@@ -216,12 +264,18 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 		f.Afid, b = gbit32(b)
 		f.Uname, b = gstring(b)
 		f.Aname, b = gstring(b)
+		if d != Dialect9P2000 {
+			f.Uid, b = gbit32(b)
+		}
 
 	case Tattach:
 		f.Fid, b = gbit32(b)
 		f.Afid, b = gbit32(b)
 		f.Uname, b = gstring(b)
 		f.Aname, b = gstring(b)
+		if d != Dialect9P2000 {
+			f.Uid, b = gbit32(b)
+		}
 
 	case Twalk:
 		f.Fid, b = gbit32(b)
@@ -245,6 +299,9 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 		f.Name, b = gstring(b)
 		f.Perm, b = gperm(b)
 		f.Mode, b = gbit8(b)
+		if d == Dialect9P2000u {
+			f.Extension, b = gstring(b)
+		}
 
 	case Tread:
 		f.Fid, b = gbit32(b)
@@ -280,6 +337,9 @@ func UnmarshalFcall(b []byte) (f *Fcall, err error) {
 
 	case Rerror:
 		f.Ename, b = gstring(b)
+		if d == Dialect9P2000u {
+			f.Errno, b = gbit32(b)
+		}
 
 	case Rflush, Rclunk, Rremove, Rwstat:
 		// nothing
@@ -355,6 +415,9 @@ func (f *Fcall) String() string {
 	case Rattach:
 		return fmt.Sprintf("Rattach tag %d qid %v", f.Tag, f.Qid)
 	case Rerror:
+		if f.Errno != 0 {
+			return fmt.Sprintf("Rerror tag %d ename %s errno %d", f.Tag, f.Ename, f.Errno)
+		}
 		return fmt.Sprintf("Rerror tag %d ename %s", f.Tag, f.Ename)
 	case Tflush:
 		return fmt.Sprintf("Tflush tag %d oldtag %d", f.Tag, f.Oldtag)
@@ -370,6 +433,10 @@ func (f *Fcall) String() string {
 	case Ropen:
 		return fmt.Sprintf("Ropen tag %d qid %v iouint %d", f.Tag, f.Qid, f.Iounit)
 	case Tcreate:
+		if f.Extension != "" {
+			return fmt.Sprintf("Tcreate tag %d fid %d name %s perm %v mode %d extension %s",
+				f.Tag, f.Fid, f.Name, f.Perm, f.Mode, f.Extension)
+		}
 		return fmt.Sprintf("Tcreate tag %d fid %d name %s perm %v mode %d",
 			f.Tag, f.Fid, f.Name, f.Perm, f.Mode)
 	case Rcreate:
@@ -412,10 +479,79 @@ func (f *Fcall) String() string {
 	case Rwstat:
 		return fmt.Sprintf("FidRwstat tag %d", f.Tag)
 	}
+	if s, ok := stringL(f); ok {
+		return s
+	}
 	return fmt.Sprintf("unknown type %d", f.Type)
 }
 
+// ReadFcall reads a single 9P2000 message from r.
 func ReadFcall(r io.Reader) (*Fcall, error) {
+	return Dialect9P2000.ReadFcall(r)
+}
+
+// WriteFcall writes the 9P2000 encoding of f to w.
+func WriteFcall(w io.Writer, f *Fcall) error {
+	return Dialect9P2000.WriteFcall(w, f)
+}
+
+// A Dialect is a variant of the 9P protocol.
+// The dialects differ in the encoding of some messages,
+// so a connection must agree on one in its Tversion exchange.
+type Dialect int
+
+const (
+	Dialect9P2000  Dialect = iota // plain 9P2000
+	Dialect9P2000u                // 9P2000.u, with Unix extensions
+	Dialect9P2000L                // 9P2000.L, for Linux
+)
+
+// Version returns the version string that names d in Tversion.
+func (d Dialect) Version() string {
+	switch d {
+	case Dialect9P2000u:
+		return VERSION9PU
+	case Dialect9P2000L:
+		return VERSION9PL
+	}
+	return VERSION9P
+}
+
+// ParseDialect returns the dialect named by a Tversion version string.
+func ParseDialect(version string) (Dialect, bool) {
+	switch version {
+	case VERSION9P:
+		return Dialect9P2000, true
+	case VERSION9PU:
+		return Dialect9P2000u, true
+	case VERSION9PL:
+		return Dialect9P2000L, true
+	}
+	return 0, false
+}
+
+// Marshal returns the encoding of f in dialect d.
+func (d Dialect) Marshal(f *Fcall) ([]byte, error) {
+	return f.marshal(d)
+}
+
+// Unmarshal decodes a message in dialect d.
+func (d Dialect) Unmarshal(b []byte) (*Fcall, error) {
+	return unmarshalFcall(b, d)
+}
+
+// WriteFcall writes the encoding of f in dialect d to w.
+func (d Dialect) WriteFcall(w io.Writer, f *Fcall) error {
+	b, err := f.marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// ReadFcall reads a single message in dialect d from r.
+func (d Dialect) ReadFcall(r io.Reader) (*Fcall, error) {
 	// 128 bytes should be enough for most messages
 	buf := make([]byte, 128)
 	_, err := io.ReadFull(r, buf[0:4])
@@ -440,14 +576,5 @@ func ReadFcall(r io.Reader) (*Fcall, error) {
 	if err != nil {
 		return nil, err
 	}
-	return UnmarshalFcall(buf)
-}
-
-func WriteFcall(w io.Writer, f *Fcall) error {
-	b, err := f.Bytes()
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(b)
-	return err
+	return unmarshalFcall(buf, d)
 }
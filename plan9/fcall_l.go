@@ -0,0 +1,753 @@
+package plan9
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// 9P2000.L message types.
+// The .L dialect replaces Rerror with Rlerror and adds
+// Linux file system operations; Topen, Tcreate, Tstat and Twstat
+// are replaced by Tlopen, Tlcreate, Tgetattr and Tsetattr.
+const (
+	Tlerror = 6 + iota // illegal
+	Rlerror
+	Tstatfs
+	Rstatfs
+	_
+	_
+	Tlopen
+	Rlopen
+	Tlcreate
+	Rlcreate
+	Tsymlink
+	Rsymlink
+	Tmknod
+	Rmknod
+	Trename
+	Rrename
+	Treadlink
+	Rreadlink
+	Tgetattr
+	Rgetattr
+	Tsetattr
+	Rsetattr
+	_
+	_
+	Txattrwalk
+	Rxattrwalk
+	Txattrcreate
+	Rxattrcreate
+)
+
+const (
+	Treaddir = 40 + iota
+	Rreaddir
+)
+
+const (
+	Tfsync = 50 + iota
+	Rfsync
+	Tlock
+	Rlock
+	Tgetlock
+	Rgetlock
+)
+
+const (
+	Tlink = 70 + iota
+	Rlink
+	Tmkdir
+	Rmkdir
+	Trenameat
+	Rrenameat
+	Tunlinkat
+	Runlinkat
+)
+
+// Tgetattr request mask bits, also used in LAttr.Valid.
+const (
+	GetattrMode        = 0x00000001
+	GetattrNlink       = 0x00000002
+	GetattrUid         = 0x00000004
+	GetattrGid         = 0x00000008
+	GetattrRdev        = 0x00000010
+	GetattrAtime       = 0x00000020
+	GetattrMtime       = 0x00000040
+	GetattrCtime       = 0x00000080
+	GetattrIno         = 0x00000100
+	GetattrSize        = 0x00000200
+	GetattrBlocks      = 0x00000400
+	GetattrBtime       = 0x00000800
+	GetattrGen         = 0x00001000
+	GetattrDataVersion = 0x00002000
+
+	GetattrBasic = 0x000007ff // Mode through Blocks
+	GetattrAll   = 0x00003fff
+)
+
+// Tsetattr valid bits.
+const (
+	SetattrMode     = 0x00000001
+	SetattrUid      = 0x00000002
+	SetattrGid      = 0x00000004
+	SetattrSize     = 0x00000008
+	SetattrAtime    = 0x00000010
+	SetattrMtime    = 0x00000020
+	SetattrCtime    = 0x00000040
+	SetattrAtimeSet = 0x00000080
+	SetattrMtimeSet = 0x00000100
+)
+
+// Tlock and Tgetlock lock types, flags and Rlock status values.
+const (
+	LockRdlck = 0
+	LockWrlck = 1
+	LockUnlck = 2
+
+	LockFlagsBlock   = 1
+	LockFlagsReclaim = 2
+
+	LockSuccess = 0
+	LockBlocked = 1
+	LockError   = 2
+	LockGrace   = 3
+)
+
+// Linux open flags, used by Tlopen and Tlcreate.
+const (
+	LORDONLY    = 00000000
+	LOWRONLY    = 00000001
+	LORDWR      = 00000002
+	LOCREAT     = 00000100
+	LOEXCL      = 00000200
+	LONOCTTY    = 00000400
+	LOTRUNC     = 00001000
+	LOAPPEND    = 00002000
+	LONONBLOCK  = 00004000
+	LODSYNC     = 00010000
+	LODIRECT    = 00040000
+	LOLARGEFILE = 00100000
+	LODIRECTORY = 00200000
+	LONOFOLLOW  = 00400000
+	LONOATIME   = 01000000
+	LOCLOEXEC   = 02000000
+	LOSYNC      = 04000000
+)
+
+// A Timespec is a time in seconds and nanoseconds since the Unix epoch.
+type Timespec struct {
+	Sec  uint64
+	Nsec uint64
+}
+
+// An LAttr holds the attributes returned by Rgetattr.
+// Valid reports which of the fields the server filled in.
+type LAttr struct {
+	Valid       uint64
+	Qid         Qid
+	Mode        uint32
+	Uid         uint32
+	Gid         uint32
+	Nlink       uint64
+	Rdev        uint64
+	Size        uint64
+	Blksize     uint64
+	Blocks      uint64
+	Atime       Timespec
+	Mtime       Timespec
+	Ctime       Timespec
+	Btime       Timespec
+	Gen         uint64
+	DataVersion uint64
+}
+
+// Unix file type and mode bits, as found in LAttr.Mode.
+const (
+	SIFMT   = 0170000
+	SIFSOCK = 0140000
+	SIFLNK  = 0120000
+	SIFREG  = 0100000
+	SIFBLK  = 0060000
+	SIFDIR  = 0040000
+	SIFCHR  = 0020000
+	SIFIFO  = 0010000
+	SISUID  = 0004000
+	SISGID  = 0002000
+)
+
+// Dir converts a to a Dir, as closely as the two allow.
+// The name is unknown, and the user and group are given
+// by their decimal ids.
+func (a *LAttr) Dir() *Dir {
+	d := &Dir{
+		Qid:    a.Qid,
+		Mode:   Perm(a.Mode & 0777),
+		Atime:  uint32(a.Atime.Sec),
+		Mtime:  uint32(a.Mtime.Sec),
+		Length: a.Size,
+		Uid:    strconv.FormatUint(uint64(a.Uid), 10),
+		Gid:    strconv.FormatUint(uint64(a.Gid), 10),
+	}
+	switch a.Mode & SIFMT {
+	case SIFDIR:
+		d.Mode |= DMDIR
+		d.Length = 0
+	case SIFLNK:
+		d.Mode |= DMSYMLINK
+	case SIFSOCK:
+		d.Mode |= DMSOCKET
+	case SIFIFO:
+		d.Mode |= DMNAMEDPIPE
+	case SIFBLK, SIFCHR:
+		d.Mode |= DMDEVICE
+	}
+	if a.Mode&SISUID != 0 {
+		d.Mode |= DMSETUID
+	}
+	if a.Mode&SISGID != 0 {
+		d.Mode |= DMSETGID
+	}
+	return d
+}
+
+// An LSetattr holds the attributes sent in Tsetattr.
+// Valid reports which of the fields should be changed.
+type LSetattr struct {
+	Valid uint32
+	Mode  uint32
+	Uid   uint32
+	Gid   uint32
+	Size  uint64
+	Atime Timespec
+	Mtime Timespec
+}
+
+// An LStatfs holds the file system information returned by Rstatfs.
+type LStatfs struct {
+	Type    uint32
+	Bsize   uint32
+	Blocks  uint64
+	Bfree   uint64
+	Bavail  uint64
+	Files   uint64
+	Ffree   uint64
+	Fsid    uint64
+	Namelen uint32
+}
+
+// An LLock describes a POSIX byte-range lock in Tlock, Tgetlock and Rgetlock.
+// Flags is only used by Tlock.
+type LLock struct {
+	Type     uint8
+	Flags    uint32
+	Start    uint64
+	Length   uint64
+	ProcID   uint32
+	ClientID string
+}
+
+// An LDirent is a directory entry as returned by Rreaddir.
+// Offset is the offset to pass to Treaddir to read the next entry.
+type LDirent struct {
+	Qid    Qid
+	Offset uint64
+	Type   uint8
+	Name   string
+}
+
+// Append appends the encoding of d to buf.
+func (d *LDirent) Append(buf []byte) []byte {
+	buf = pqid(buf, d.Qid)
+	buf = pbit64(buf, d.Offset)
+	buf = pbit8(buf, d.Type)
+	return pstring(buf, d.Name)
+}
+
+// UnmarshalLDirents decodes the data of an Rreaddir message.
+func UnmarshalLDirents(b []byte) (d []LDirent, err error) {
+	defer func() {
+		if recover() != nil {
+			d = nil
+			err = ProtocolError("malformed readdir entry")
+		}
+	}()
+	for len(b) > 0 {
+		var e LDirent
+		e.Qid, b = gqid(b)
+		e.Offset, b = gbit64(b)
+		e.Type, b = gbit8(b)
+		e.Name, b = gstring(b)
+		d = append(d, e)
+	}
+	return d, nil
+}
+
+func ptime(b []byte, t Timespec) []byte {
+	b = pbit64(b, t.Sec)
+	return pbit64(b, t.Nsec)
+}
+
+func gtime(b []byte) (Timespec, []byte) {
+	var t Timespec
+	t.Sec, b = gbit64(b)
+	t.Nsec, b = gbit64(b)
+	return t, b
+}
+
+func plock(b []byte, l *LLock, flags bool) []byte {
+	b = pbit8(b, l.Type)
+	if flags {
+		b = pbit32(b, l.Flags)
+	}
+	b = pbit64(b, l.Start)
+	b = pbit64(b, l.Length)
+	b = pbit32(b, l.ProcID)
+	return pstring(b, l.ClientID)
+}
+
+func glock(b []byte, l *LLock, flags bool) []byte {
+	l.Type, b = gbit8(b)
+	if flags {
+		l.Flags, b = gbit32(b)
+	}
+	l.Start, b = gbit64(b)
+	l.Length, b = gbit64(b)
+	l.ProcID, b = gbit32(b)
+	l.ClientID, b = gstring(b)
+	return b
+}
+
+// pfcallL appends the body of a 9P2000.L-only message to b.
+// It reports false if f.Type is not such a message.
+func pfcallL(b []byte, f *Fcall) ([]byte, bool) {
+	switch f.Type {
+	default:
+		return b, false
+
+	case Rlerror:
+		b = pbit32(b, f.Errno)
+
+	case Tstatfs, Treadlink:
+		b = pbit32(b, f.Fid)
+
+	case Rstatfs:
+		s := &f.Statfs
+		b = pbit32(b, s.Type)
+		b = pbit32(b, s.Bsize)
+		b = pbit64(b, s.Blocks)
+		b = pbit64(b, s.Bfree)
+		b = pbit64(b, s.Bavail)
+		b = pbit64(b, s.Files)
+		b = pbit64(b, s.Ffree)
+		b = pbit64(b, s.Fsid)
+		b = pbit32(b, s.Namelen)
+
+	case Tlopen:
+		b = pbit32(b, f.Fid)
+		b = pbit32(b, f.Flags)
+
+	case Rlopen, Rlcreate:
+		b = pqid(b, f.Qid)
+		b = pbit32(b, f.Iounit)
+
+	case Tlcreate:
+		b = pbit32(b, f.Fid)
+		b = pstring(b, f.Name)
+		b = pbit32(b, f.Flags)
+		b = pbit32(b, f.Lmode)
+		b = pbit32(b, f.Gid)
+
+	case Tsymlink:
+		b = pbit32(b, f.Fid)
+		b = pstring(b, f.Name)
+		b = pstring(b, f.Target)
+		b = pbit32(b, f.Gid)
+
+	case Rsymlink, Rmknod, Rmkdir:
+		b = pqid(b, f.Qid)
+
+	case Tmknod:
+		b = pbit32(b, f.Fid)
+		b = pstring(b, f.Name)
+		b = pbit32(b, f.Lmode)
+		b = pbit32(b, f.Major)
+		b = pbit32(b, f.Minor)
+		b = pbit32(b, f.Gid)
+
+	case Trename:
+		b = pbit32(b, f.Fid)
+		b = pbit32(b, f.Dfid)
+		b = pstring(b, f.Name)
+
+	case Rreadlink:
+		b = pstring(b, f.Target)
+
+	case Tgetattr:
+		b = pbit32(b, f.Fid)
+		b = pbit64(b, f.Mask)
+
+	case Rgetattr:
+		a := &f.Attr
+		b = pbit64(b, a.Valid)
+		b = pqid(b, a.Qid)
+		b = pbit32(b, a.Mode)
+		b = pbit32(b, a.Uid)
+		b = pbit32(b, a.Gid)
+		b = pbit64(b, a.Nlink)
+		b = pbit64(b, a.Rdev)
+		b = pbit64(b, a.Size)
+		b = pbit64(b, a.Blksize)
+		b = pbit64(b, a.Blocks)
+		b = ptime(b, a.Atime)
+		b = ptime(b, a.Mtime)
+		b = ptime(b, a.Ctime)
+		b = ptime(b, a.Btime)
+		b = pbit64(b, a.Gen)
+		b = pbit64(b, a.DataVersion)
+
+	case Tsetattr:
+		a := &f.Setattr
+		b = pbit32(b, f.Fid)
+		b = pbit32(b, a.Valid)
+		b = pbit32(b, a.Mode)
+		b = pbit32(b, a.Uid)
+		b = pbit32(b, a.Gid)
+		b = pbit64(b, a.Size)
+		b = ptime(b, a.Atime)
+		b = ptime(b, a.Mtime)
+
+	case Rrename, Rsetattr, Rxattrcreate, Rfsync, Rlink, Rrenameat, Runlinkat:
+		// no body
+
+	case Txattrwalk:
+		b = pbit32(b, f.Fid)
+		b = pbit32(b, f.Newfid)
+		b = pstring(b, f.Name)
+
+	case Rxattrwalk:
+		b = pbit64(b, f.Size)
+
+	case Txattrcreate:
+		b = pbit32(b, f.Fid)
+		b = pstring(b, f.Name)
+		b = pbit64(b, f.Size)
+		b = pbit32(b, f.Flags)
+
+	case Treaddir:
+		b = pbit32(b, f.Fid)
+		b = pbit64(b, f.Offset)
+		b = pbit32(b, f.Count)
+
+	case Rreaddir:
+		b = pbit32(b, uint32(len(f.Data)))
+		b = append(b, f.Data...)
+
+	case Tfsync:
+		b = pbit32(b, f.Fid)
+		b = pbit32(b, f.Flags)
+
+	case Tlock:
+		b = pbit32(b, f.Fid)
+		b = plock(b, &f.Lock, true)
+
+	case Rlock:
+		b = pbit8(b, f.Status)
+
+	case Tgetlock:
+		b = pbit32(b, f.Fid)
+		b = plock(b, &f.Lock, false)
+
+	case Rgetlock:
+		b = plock(b, &f.Lock, false)
+
+	case Tlink:
+		b = pbit32(b, f.Dfid)
+		b = pbit32(b, f.Fid)
+		b = pstring(b, f.Name)
+
+	case Tmkdir:
+		b = pbit32(b, f.Fid)
+		b = pstring(b, f.Name)
+		b = pbit32(b, f.Lmode)
+		b = pbit32(b, f.Gid)
+
+	case Trenameat:
+		b = pbit32(b, f.Fid)
+		b = pstring(b, f.Name)
+		b = pbit32(b, f.Dfid)
+		b = pstring(b, f.Newname)
+
+	case Tunlinkat:
+		b = pbit32(b, f.Fid)
+		b = pstring(b, f.Name)
+		b = pbit32(b, f.Flags)
+	}
+	return b, true
+}
+
+// gfcallL decodes the body of a 9P2000.L-only message into f.
+// It panics if f.Type is not such a message.
+func gfcallL(b []byte, f *Fcall) []byte {
+	switch f.Type {
+	default:
+		panic(1)
+
+	case Rlerror:
+		f.Errno, b = gbit32(b)
+
+	case Tstatfs, Treadlink:
+		f.Fid, b = gbit32(b)
+
+	case Rstatfs:
+		s := &f.Statfs
+		s.Type, b = gbit32(b)
+		s.Bsize, b = gbit32(b)
+		s.Blocks, b = gbit64(b)
+		s.Bfree, b = gbit64(b)
+		s.Bavail, b = gbit64(b)
+		s.Files, b = gbit64(b)
+		s.Ffree, b = gbit64(b)
+		s.Fsid, b = gbit64(b)
+		s.Namelen, b = gbit32(b)
+
+	case Tlopen:
+		f.Fid, b = gbit32(b)
+		f.Flags, b = gbit32(b)
+
+	case Rlopen, Rlcreate:
+		f.Qid, b = gqid(b)
+		f.Iounit, b = gbit32(b)
+
+	case Tlcreate:
+		f.Fid, b = gbit32(b)
+		f.Name, b = gstring(b)
+		f.Flags, b = gbit32(b)
+		f.Lmode, b = gbit32(b)
+		f.Gid, b = gbit32(b)
+
+	case Tsymlink:
+		f.Fid, b = gbit32(b)
+		f.Name, b = gstring(b)
+		f.Target, b = gstring(b)
+		f.Gid, b = gbit32(b)
+
+	case Rsymlink, Rmknod, Rmkdir:
+		f.Qid, b = gqid(b)
+
+	case Tmknod:
+		f.Fid, b = gbit32(b)
+		f.Name, b = gstring(b)
+		f.Lmode, b = gbit32(b)
+		f.Major, b = gbit32(b)
+		f.Minor, b = gbit32(b)
+		f.Gid, b = gbit32(b)
+
+	case Trename:
+		f.Fid, b = gbit32(b)
+		f.Dfid, b = gbit32(b)
+		f.Name, b = gstring(b)
+
+	case Rreadlink:
+		f.Target, b = gstring(b)
+
+	case Tgetattr:
+		f.Fid, b = gbit32(b)
+		f.Mask, b = gbit64(b)
+
+	case Rgetattr:
+		a := &f.Attr
+		a.Valid, b = gbit64(b)
+		a.Qid, b = gqid(b)
+		a.Mode, b = gbit32(b)
+		a.Uid, b = gbit32(b)
+		a.Gid, b = gbit32(b)
+		a.Nlink, b = gbit64(b)
+		a.Rdev, b = gbit64(b)
+		a.Size, b = gbit64(b)
+		a.Blksize, b = gbit64(b)
+		a.Blocks, b = gbit64(b)
+		a.Atime, b = gtime(b)
+		a.Mtime, b = gtime(b)
+		a.Ctime, b = gtime(b)
+		a.Btime, b = gtime(b)
+		a.Gen, b = gbit64(b)
+		a.DataVersion, b = gbit64(b)
+
+	case Tsetattr:
+		a := &f.Setattr
+		f.Fid, b = gbit32(b)
+		a.Valid, b = gbit32(b)
+		a.Mode, b = gbit32(b)
+		a.Uid, b = gbit32(b)
+		a.Gid, b = gbit32(b)
+		a.Size, b = gbit64(b)
+		a.Atime, b = gtime(b)
+		a.Mtime, b = gtime(b)
+
+	case Rrename, Rsetattr, Rxattrcreate, Rfsync, Rlink, Rrenameat, Runlinkat:
+		// no body
+
+	case Txattrwalk:
+		f.Fid, b = gbit32(b)
+		f.Newfid, b = gbit32(b)
+		f.Name, b = gstring(b)
+
+	case Rxattrwalk:
+		f.Size, b = gbit64(b)
+
+	case Txattrcreate:
+		f.Fid, b = gbit32(b)
+		f.Name, b = gstring(b)
+		f.Size, b = gbit64(b)
+		f.Flags, b = gbit32(b)
+
+	case Treaddir:
+		f.Fid, b = gbit32(b)
+		f.Offset, b = gbit64(b)
+		f.Count, b = gbit32(b)
+
+	case Rreaddir:
+		var n uint32
+		n, b = gbit32(b)
+		if len(b) != int(n) {
+			panic(1)
+		}
+		f.Data = b
+		b = nil
+
+	case Tfsync:
+		f.Fid, b = gbit32(b)
+		f.Flags, b = gbit32(b)
+
+	case Tlock:
+		f.Fid, b = gbit32(b)
+		b = glock(b, &f.Lock, true)
+
+	case Rlock:
+		f.Status, b = gbit8(b)
+
+	case Tgetlock:
+		f.Fid, b = gbit32(b)
+		b = glock(b, &f.Lock, false)
+
+	case Rgetlock:
+		b = glock(b, &f.Lock, false)
+
+	case Tlink:
+		f.Dfid, b = gbit32(b)
+		f.Fid, b = gbit32(b)
+		f.Name, b = gstring(b)
+
+	case Tmkdir:
+		f.Fid, b = gbit32(b)
+		f.Name, b = gstring(b)
+		f.Lmode, b = gbit32(b)
+		f.Gid, b = gbit32(b)
+
+	case Trenameat:
+		f.Fid, b = gbit32(b)
+		f.Name, b = gstring(b)
+		f.Dfid, b = gbit32(b)
+		f.Newname, b = gstring(b)
+
+	case Tunlinkat:
+		f.Fid, b = gbit32(b)
+		f.Name, b = gstring(b)
+		f.Flags, b = gbit32(b)
+	}
+	return b
+}
+
+func stringL(f *Fcall) (string, bool) {
+	var s string
+	switch f.Type {
+	default:
+		return "", false
+	case Rlerror:
+		s = fmt.Sprintf("Rlerror tag %d ecode %d", f.Tag, f.Errno)
+	case Tstatfs:
+		s = fmt.Sprintf("Tstatfs tag %d fid %d", f.Tag, f.Fid)
+	case Rstatfs:
+		s = fmt.Sprintf("Rstatfs tag %d %+v", f.Tag, f.Statfs)
+	case Tlopen:
+		s = fmt.Sprintf("Tlopen tag %d fid %d flags %#o", f.Tag, f.Fid, f.Flags)
+	case Rlopen:
+		s = fmt.Sprintf("Rlopen tag %d qid %v iounit %d", f.Tag, f.Qid, f.Iounit)
+	case Tlcreate:
+		s = fmt.Sprintf("Tlcreate tag %d fid %d name %s flags %#o mode %#o gid %d",
+			f.Tag, f.Fid, f.Name, f.Flags, f.Lmode, f.Gid)
+	case Rlcreate:
+		s = fmt.Sprintf("Rlcreate tag %d qid %v iounit %d", f.Tag, f.Qid, f.Iounit)
+	case Tsymlink:
+		s = fmt.Sprintf("Tsymlink tag %d fid %d name %s target %s gid %d",
+			f.Tag, f.Fid, f.Name, f.Target, f.Gid)
+	case Rsymlink:
+		s = fmt.Sprintf("Rsymlink tag %d qid %v", f.Tag, f.Qid)
+	case Tmknod:
+		s = fmt.Sprintf("Tmknod tag %d fid %d name %s mode %#o major %d minor %d gid %d",
+			f.Tag, f.Fid, f.Name, f.Lmode, f.Major, f.Minor, f.Gid)
+	case Rmknod:
+		s = fmt.Sprintf("Rmknod tag %d qid %v", f.Tag, f.Qid)
+	case Trename:
+		s = fmt.Sprintf("Trename tag %d fid %d dfid %d name %s", f.Tag, f.Fid, f.Dfid, f.Name)
+	case Rrename:
+		s = fmt.Sprintf("Rrename tag %d", f.Tag)
+	case Treadlink:
+		s = fmt.Sprintf("Treadlink tag %d fid %d", f.Tag, f.Fid)
+	case Rreadlink:
+		s = fmt.Sprintf("Rreadlink tag %d target %s", f.Tag, f.Target)
+	case Tgetattr:
+		s = fmt.Sprintf("Tgetattr tag %d fid %d mask %#x", f.Tag, f.Fid, f.Mask)
+	case Rgetattr:
+		s = fmt.Sprintf("Rgetattr tag %d %+v", f.Tag, f.Attr)
+	case Tsetattr:
+		s = fmt.Sprintf("Tsetattr tag %d fid %d %+v", f.Tag, f.Fid, f.Setattr)
+	case Rsetattr:
+		s = fmt.Sprintf("Rsetattr tag %d", f.Tag)
+	case Txattrwalk:
+		s = fmt.Sprintf("Txattrwalk tag %d fid %d newfid %d name %s", f.Tag, f.Fid, f.Newfid, f.Name)
+	case Rxattrwalk:
+		s = fmt.Sprintf("Rxattrwalk tag %d size %d", f.Tag, f.Size)
+	case Txattrcreate:
+		s = fmt.Sprintf("Txattrcreate tag %d fid %d name %s size %d flags %d",
+			f.Tag, f.Fid, f.Name, f.Size, f.Flags)
+	case Rxattrcreate:
+		s = fmt.Sprintf("Rxattrcreate tag %d", f.Tag)
+	case Treaddir:
+		s = fmt.Sprintf("Treaddir tag %d fid %d offset %d count %d", f.Tag, f.Fid, f.Offset, f.Count)
+	case Rreaddir:
+		s = fmt.Sprintf("Rreaddir tag %d count %d", f.Tag, len(f.Data))
+	case Tfsync:
+		s = fmt.Sprintf("Tfsync tag %d fid %d datasync %d", f.Tag, f.Fid, f.Flags)
+	case Rfsync:
+		s = fmt.Sprintf("Rfsync tag %d", f.Tag)
+	case Tlock:
+		s = fmt.Sprintf("Tlock tag %d fid %d %+v", f.Tag, f.Fid, f.Lock)
+	case Rlock:
+		s = fmt.Sprintf("Rlock tag %d status %d", f.Tag, f.Status)
+	case Tgetlock:
+		s = fmt.Sprintf("Tgetlock tag %d fid %d %+v", f.Tag, f.Fid, f.Lock)
+	case Rgetlock:
+		s = fmt.Sprintf("Rgetlock tag %d %+v", f.Tag, f.Lock)
+	case Tlink:
+		s = fmt.Sprintf("Tlink tag %d dfid %d fid %d name %s", f.Tag, f.Dfid, f.Fid, f.Name)
+	case Rlink:
+		s = fmt.Sprintf("Rlink tag %d", f.Tag)
+	case Tmkdir:
+		s = fmt.Sprintf("Tmkdir tag %d dfid %d name %s mode %#o gid %d",
+			f.Tag, f.Fid, f.Name, f.Lmode, f.Gid)
+	case Rmkdir:
+		s = fmt.Sprintf("Rmkdir tag %d qid %v", f.Tag, f.Qid)
+	case Trenameat:
+		s = fmt.Sprintf("Trenameat tag %d olddirfid %d oldname %s newdirfid %d newname %s",
+			f.Tag, f.Fid, f.Name, f.Dfid, f.Newname)
+	case Rrenameat:
+		s = fmt.Sprintf("Rrenameat tag %d", f.Tag)
+	case Tunlinkat:
+		s = fmt.Sprintf("Tunlinkat tag %d dirfid %d name %s flags %d", f.Tag, f.Fid, f.Name, f.Flags)
+	case Runlinkat:
+		s = fmt.Sprintf("Runlinkat tag %d", f.Tag)
+	}
+	return s, true
+}
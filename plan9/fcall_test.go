@@ -0,0 +1,106 @@
+package plan9
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var lFcalls = []*Fcall{
+	{Type: Tattach, Tag: 1, Fid: 1, Afid: NOFID, Uname: "glenda", Aname: "", Uid: 1000},
+	{Type: Rlerror, Tag: 1, Errno: 2},
+	{Type: Tlopen, Tag: 2, Fid: 3, Flags: LORDWR | LOTRUNC},
+	{Type: Rlopen, Tag: 2, Qid: Qid{Path: 7, Vers: 1}, Iounit: 8192},
+	{Type: Tlcreate, Tag: 3, Fid: 3, Name: "x", Flags: LOCREAT, Lmode: 0644, Gid: 100},
+	{Type: Tgetattr, Tag: 4, Fid: 3, Mask: GetattrAll},
+	{Type: Rgetattr, Tag: 4, Attr: LAttr{Valid: GetattrBasic, Mode: SIFREG | 0644, Size: 12, Mtime: Timespec{5, 6}}},
+	{Type: Tsetattr, Tag: 5, Fid: 3, Setattr: LSetattr{Valid: SetattrSize, Size: 3}},
+	{Type: Rsetattr, Tag: 5},
+	{Type: Treaddir, Tag: 6, Fid: 3, Offset: 10, Count: 100},
+	{Type: Tsymlink, Tag: 7, Fid: 3, Name: "l", Target: "/tmp", Gid: 1},
+	{Type: Tmknod, Tag: 7, Fid: 3, Name: "n", Lmode: SIFCHR | 0600, Major: 1, Minor: 3},
+	{Type: Rreadlink, Tag: 7, Target: "/tmp"},
+	{Type: Trenameat, Tag: 8, Fid: 3, Name: "a", Dfid: 4, Newname: "b"},
+	{Type: Tlink, Tag: 8, Dfid: 3, Fid: 4, Name: "c"},
+	{Type: Tlock, Tag: 9, Fid: 3, Lock: LLock{Type: LockWrlck, Flags: LockFlagsBlock, Length: 10, ProcID: 4, ClientID: "me"}},
+	{Type: Rlock, Tag: 9, Status: LockBlocked},
+	{Type: Rgetlock, Tag: 9, Lock: LLock{Type: LockUnlck, ClientID: "me"}},
+	{Type: Rstatfs, Tag: 10, Statfs: LStatfs{Bsize: 4096, Blocks: 100, Namelen: 255}},
+	{Type: Txattrcreate, Tag: 11, Fid: 3, Name: "user.x", Size: 4},
+	{Type: Tunlinkat, Tag: 12, Fid: 3, Name: "d", Flags: 0x200},
+	{Type: Rmkdir, Tag: 13, Qid: Qid{Path: 9, Type: QTDIR}},
+}
+
+func TestDialectL(t *testing.T) {
+	for _, f := range lFcalls {
+		b, err := Dialect9P2000L.Marshal(f)
+		if err != nil {
+			t.Errorf("Marshal(%v): %v", f, err)
+			continue
+		}
+		g, err := Dialect9P2000L.ReadFcall(bytes.NewReader(b))
+		if err != nil {
+			t.Errorf("ReadFcall(%v): %v", f, err)
+			continue
+		}
+		if !reflect.DeepEqual(f, g) {
+			t.Errorf("round trip:\n\thave %v\n\twant %v", g, f)
+		}
+	}
+	if _, err := (&Fcall{Type: Tlopen}).Bytes(); err == nil {
+		t.Errorf("9P2000 encoding of Tlopen succeeded")
+	}
+}
+
+func TestReaddirL(t *testing.T) {
+	ents := []LDirent{
+		{Qid: Qid{Path: 1, Type: QTDIR}, Offset: 1, Type: 4, Name: "."},
+		{Qid: Qid{Path: 2}, Offset: 2, Type: 8, Name: "file"},
+	}
+	var data []byte
+	for i := range ents {
+		data = ents[i].Append(data)
+	}
+	b, err := Dialect9P2000L.Marshal(&Fcall{Type: Rreaddir, Tag: 1, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Dialect9P2000L.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalLDirents(f.Data)
+	if err != nil || !reflect.DeepEqual(got, ents) {
+		t.Fatalf("UnmarshalLDirents = %v, %v, want %v", got, err, ents)
+	}
+}
+
+func TestDialectU(t *testing.T) {
+	for _, f := range []*Fcall{
+		{Type: Tattach, Tag: 1, Fid: 1, Afid: NOFID, Uname: "glenda", Uid: 1000},
+		{Type: Rerror, Tag: 1, Ename: "file does not exist", Errno: 2},
+		{Type: Tcreate, Tag: 2, Fid: 1, Name: "l", Perm: DMSYMLINK | 0777, Extension: "/tmp"},
+	} {
+		b, err := Dialect9P2000u.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := Dialect9P2000u.Unmarshal(b)
+		if err != nil || !reflect.DeepEqual(f, g) {
+			t.Errorf("round trip: have %v, %v, want %v", g, err, f)
+		}
+		if _, err := UnmarshalFcall(b); err == nil {
+			t.Errorf("9P2000 decoding of %v succeeded", f)
+		}
+	}
+
+	d := &Dir{Name: "l", Mode: DMSYMLINK | 0777, Uid: "glenda", Extension: "/tmp", Nuid: 1000, Ngid: 100, Nmuid: NOUID}
+	b := Dialect9P2000u.AppendDir(nil, d)
+	d1, err := Dialect9P2000u.UnmarshalDir(b)
+	if err != nil || !reflect.DeepEqual(d, d1) {
+		t.Errorf("dir round trip: have %v, %v, want %v", d1, err, d)
+	}
+	if _, err := UnmarshalDir(b); err == nil {
+		t.Errorf("9P2000 decoding of 9P2000.u dir succeeded")
+	}
+}
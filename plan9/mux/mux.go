@@ -0,0 +1,692 @@
+// Package mux multiplexes many 9P client connections onto a single
+// connection to a 9P server, in the manner of plan9port's 9pserve.
+//
+// Each client negotiates its own version and sees its own tag and fid
+// spaces; the multiplexer renumbers them onto the server connection,
+// forwards flushes, and clunks a client's fids when it disconnects.
+package mux // import "plramos.win/9fans/plan9/mux"
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plan9/client"
+)
+
+// A Mux is a multiplexer attached to a single server connection.
+type Mux struct {
+	// Chatty causes all messages to be logged to standard error.
+	Chatty bool
+
+	srv   io.ReadWriter
+	msize uint32
+
+	wmu sync.Mutex // serializes writes to srv
+
+	mu      sync.Mutex
+	err     error
+	tags    map[uint16]*msg
+	freetag []uint16
+	nexttag uint16
+	freefid []uint32
+	nextfid uint32
+	clients map[*conn]bool
+	done    chan struct{} // closed when the server connection ends
+}
+
+// A msg is a request forwarded to the server.
+type msg struct {
+	c      *conn // nil for requests made by the mux itself
+	gen    int   // c.gen when the request arrived
+	ctag   uint16
+	stag   uint16
+	tx     *plan9.Fcall
+	fid    uint32 // client fid being clunked, or being created by auth, attach or walk
+	sfid   uint32
+	newfid bool // request creates fid
+	clunk  bool // request clunks fid
+
+	// A Tflush names its target by server tag, so that tag must not be
+	// reused until the Tflush has been answered too.
+	oldm    *msg // Tflush: the request being flushed
+	nflush  int  // number of Tflushes outstanding for this request
+	replied bool // reply seen but tag still held for a Tflush
+}
+
+// New returns a multiplexer for the server connection srv,
+// after negotiating the 9P version and message size with it.
+// The caller should then call Serve or ServeConn to accept clients.
+func New(srv io.ReadWriter) (*Mux, error) {
+	m := &Mux{
+		srv:     srv,
+		msize:   131072,
+		tags:    make(map[uint16]*msg),
+		nexttag: 1,
+		nextfid: 1,
+		clients: make(map[*conn]bool),
+		done:    make(chan struct{}),
+	}
+	tx := &plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: m.msize, Version: plan9.VERSION9P}
+	if err := plan9.WriteFcall(srv, tx); err != nil {
+		return nil, err
+	}
+	rx, err := plan9.ReadFcall(srv)
+	if err != nil {
+		return nil, err
+	}
+	if rx.Type != plan9.Rversion || rx.Tag != plan9.NOTAG {
+		return nil, plan9.ProtocolError(fmt.Sprintf("invalid type/tag in Tversion exchange: %v %v", rx.Type, rx.Tag))
+	}
+	if rx.Msize > m.msize || rx.Msize < 256 {
+		return nil, plan9.ProtocolError(fmt.Sprintf("invalid msize %d in Rversion", rx.Msize))
+	}
+	if rx.Version != plan9.VERSION9P {
+		return nil, plan9.ProtocolError(fmt.Sprintf("invalid version %s in Rversion", rx.Version))
+	}
+	m.msize = rx.Msize
+	go m.readServer()
+	return m, nil
+}
+
+// Serve accepts client connections on l and serves each in a new goroutine.
+func (m *Mux) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go m.ServeConn(c)
+	}
+}
+
+// Post listens on the unix socket named service in the name space
+// directory (see client.Namespace) and serves clients from it
+// in a background goroutine. It refuses to replace a socket
+// that some other process is still serving.
+func (m *Mux) Post(service string) (net.Listener, error) {
+	ns := client.Namespace()
+	if err := os.MkdirAll(ns, 0700); err != nil {
+		return nil, err
+	}
+	addr := filepath.Join(ns, service)
+	if c, err := net.Dial("unix", addr); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("%s: service already posted", addr)
+	}
+	os.Remove(addr)
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	go m.Serve(l)
+	return l, nil
+}
+
+// Wait blocks until the server connection has ended.
+func (m *Mux) Wait() {
+	<-m.done
+}
+
+// Err returns the error that ended the server connection, if any.
+func (m *Mux) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *Mux) logf(format string, args ...interface{}) {
+	if m.Chatty {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+func (m *Mux) newtag() (uint16, error) {
+	if n := len(m.freetag); n > 0 {
+		t := m.freetag[n-1]
+		m.freetag = m.freetag[:n-1]
+		return t, nil
+	}
+	if m.nexttag == plan9.NOTAG {
+		return 0, plan9.ProtocolError("out of tags")
+	}
+	t := m.nexttag
+	m.nexttag++
+	return t, nil
+}
+
+func (m *Mux) newfid() (uint32, error) {
+	if n := len(m.freefid); n > 0 {
+		f := m.freefid[n-1]
+		m.freefid = m.freefid[:n-1]
+		return f, nil
+	}
+	if m.nextfid == plan9.NOFID {
+		return 0, plan9.ProtocolError("out of fids")
+	}
+	f := m.nextfid
+	m.nextfid++
+	return f, nil
+}
+
+// send assigns a server tag to mm and writes its request to the server.
+func (m *Mux) send(mm *msg) error {
+	m.mu.Lock()
+	err := m.register(mm)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.transmit(mm)
+}
+
+// register assigns a server tag to mm. The caller must hold m.mu.
+func (m *Mux) register(mm *msg) error {
+	if m.err != nil {
+		return m.err
+	}
+	tag, err := m.newtag()
+	if err != nil {
+		return err
+	}
+	mm.stag = tag
+	mm.tx.Tag = tag
+	m.tags[tag] = mm
+	return nil
+}
+
+func (m *Mux) transmit(mm *msg) error {
+	m.logf("mux -> %v\n", mm.tx)
+	m.wmu.Lock()
+	err := plan9.WriteFcall(m.srv, mm.tx)
+	m.wmu.Unlock()
+	if err != nil {
+		m.fail(err)
+	}
+	return err
+}
+
+// flush sends a Tflush for old, which must be outstanding.
+// It reports false if old has already been answered.
+func (m *Mux) flush(old, mm *msg) (bool, error) {
+	m.mu.Lock()
+	if m.tags[old.stag] != old {
+		m.mu.Unlock()
+		return false, nil
+	}
+	mm.tx = &plan9.Fcall{Type: plan9.Tflush, Oldtag: old.stag}
+	mm.oldm = old
+	if err := m.register(mm); err != nil {
+		m.mu.Unlock()
+		return true, err
+	}
+	old.nflush++
+	m.mu.Unlock()
+	return true, m.transmit(mm)
+}
+
+// clunk sends a Tclunk for the server fid sfid on the mux's own behalf.
+func (m *Mux) clunk(sfid uint32) {
+	m.send(&msg{
+		tx:    &plan9.Fcall{Type: plan9.Tclunk, Fid: sfid},
+		sfid:  sfid,
+		clunk: true,
+	})
+}
+
+// fail records err as the reason the server connection died
+// and closes all clients.
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = err
+		close(m.done)
+	}
+	var cs []*conn
+	for c := range m.clients {
+		cs = append(cs, c)
+	}
+	m.mu.Unlock()
+	for _, c := range cs {
+		c.rwc.Close()
+	}
+}
+
+func (m *Mux) readServer() {
+	for {
+		rx, err := plan9.ReadFcall(m.srv)
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		m.logf("mux <- %v\n", rx)
+		m.mu.Lock()
+		mm := m.tags[rx.Tag]
+		var lost *msg
+		if mm != nil && mm.oldm != nil && m.tags[mm.oldm.stag] == mm.oldm {
+			// The server flushed the request without answering it,
+			// so it never will: the request was not done.
+			lost = mm.oldm
+			delete(m.tags, lost.stag)
+			lost.replied = true
+		}
+		m.mu.Unlock()
+		if mm == nil {
+			m.logf("mux: unexpected tag %d from server\n", rx.Tag)
+			continue
+		}
+		if lost != nil {
+			// Before the Rflush, so the client may reuse the tag.
+			m.abandon(lost)
+		}
+
+		// Forward the reply before releasing the tag, so that
+		// a client Tflush racing with it is answered afterward.
+		m.reply(mm, rx)
+
+		m.mu.Lock()
+		delete(m.tags, mm.stag)
+		if mm.nflush == 0 {
+			m.freetag = append(m.freetag, mm.stag)
+		} else {
+			mm.replied = true
+		}
+		if old := mm.oldm; old != nil {
+			old.nflush--
+			if old.nflush == 0 && old.replied {
+				m.freetag = append(m.freetag, old.stag)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// reply updates the fid tables for the reply rx to mm
+// and forwards it to the client, if the client is still there.
+func (m *Mux) reply(mm *msg, rx *plan9.Fcall) {
+	ok := rx.Type == mm.tx.Type+1
+	if mm.tx.Type == plan9.Twalk && ok && len(rx.Wqid) < len(mm.tx.Wname) {
+		ok = false // partial walk does not create newfid
+	}
+	c := mm.c
+	switch {
+	case mm.clunk:
+		m.mu.Lock()
+		m.freefid = append(m.freefid, mm.sfid)
+		m.mu.Unlock()
+	case mm.newfid && !ok:
+		if c != nil {
+			c.dropfid(mm)
+		}
+		m.mu.Lock()
+		m.freefid = append(m.freefid, mm.sfid)
+		m.mu.Unlock()
+	case mm.newfid && ok:
+		if c == nil || !c.commitfid(mm) {
+			// Client went away while the fid was being made.
+			go m.clunk(mm.sfid)
+		}
+	}
+	if c == nil {
+		return
+	}
+	c.reply(mm, rx)
+}
+
+// abandon retires mm, a request the server flushed without answering.
+func (m *Mux) abandon(mm *msg) {
+	c := mm.c
+	switch {
+	case mm.clunk:
+		// The fid may still exist on the server.
+		go m.clunk(mm.sfid)
+	case mm.newfid:
+		if c != nil {
+			c.dropfid(mm)
+		}
+		m.mu.Lock()
+		m.freefid = append(m.freefid, mm.sfid)
+		m.mu.Unlock()
+	}
+	if c != nil {
+		c.mu.Lock()
+		if c.tags[mm.ctag] == mm {
+			delete(c.tags, mm.ctag)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// A conn is a single client connection.
+type conn struct {
+	m     *Mux
+	rwc   io.ReadWriteCloser
+	msize uint32
+
+	mu     sync.Mutex
+	closed bool
+	gen    int               // incremented by Tversion
+	fids   map[uint32]uint32 // client fid -> server fid; 0 while being made
+	tags   map[uint16]*msg   // outstanding requests by client tag
+
+	// Replies wait in out for the writer goroutine,
+	// so that a slow client holds up no one else.
+	out  []*plan9.Fcall
+	outc sync.Cond // signaled when out grows or closed is set
+}
+
+// ServeConn serves a single client connection until it is closed.
+func (m *Mux) ServeConn(rwc io.ReadWriteCloser) {
+	c := &conn{
+		m:     m,
+		rwc:   rwc,
+		msize: m.msize,
+		fids:  make(map[uint32]uint32),
+		tags:  make(map[uint16]*msg),
+	}
+	c.outc.L = &c.mu
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		rwc.Close()
+		return
+	}
+	m.clients[c] = true
+	m.mu.Unlock()
+	go c.writer()
+
+	for {
+		tx, err := plan9.ReadFcall(rwc)
+		if err != nil {
+			break
+		}
+		m.logf("c%p <- %v\n", c, tx)
+		c.request(tx)
+	}
+	c.hangup()
+}
+
+// write queues rx to be sent to the client.
+func (c *conn) write(rx *plan9.Fcall) {
+	c.mu.Lock()
+	c.queue(rx)
+	c.mu.Unlock()
+}
+
+// queue appends rx to the client's output. The caller must hold c.mu.
+func (c *conn) queue(rx *plan9.Fcall) {
+	c.out = append(c.out, rx)
+	c.outc.Signal()
+}
+
+// writer sends the queued replies to the client
+// until the client has hung up.
+func (c *conn) writer() {
+	var err error
+	c.mu.Lock()
+	for {
+		for len(c.out) == 0 && !c.closed {
+			c.outc.Wait()
+		}
+		if len(c.out) == 0 {
+			break
+		}
+		out := c.out
+		c.out = nil
+		c.mu.Unlock()
+		for _, rx := range out {
+			if err != nil {
+				continue
+			}
+			c.m.logf("c%p -> %v\n", c, rx)
+			if err = plan9.WriteFcall(c.rwc, rx); err != nil {
+				c.rwc.Close() // end ServeConn
+			}
+		}
+		c.mu.Lock()
+	}
+	c.mu.Unlock()
+}
+
+func (c *conn) error(tx *plan9.Fcall, err string) {
+	c.write(&plan9.Fcall{Type: plan9.Rerror, Tag: tx.Tag, Ename: err})
+}
+
+// lookup returns the server fid for client fid.
+func (c *conn) lookup(fid uint32) (uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sfid, ok := c.fids[fid]
+	return sfid, ok && sfid != 0
+}
+
+// reserve allocates a server fid for the new client fid,
+// marking the client fid in use until the server replies.
+func (c *conn) reserve(fid uint32) (uint32, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.fids[fid]; ok {
+		return 0, "duplicate fid"
+	}
+	c.m.mu.Lock()
+	sfid, err := c.m.newfid()
+	c.m.mu.Unlock()
+	if err != nil {
+		return 0, err.Error()
+	}
+	c.fids[fid] = 0
+	return sfid, ""
+}
+
+func (c *conn) commitfid(mm *msg) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || mm.gen != c.gen {
+		return false
+	}
+	c.fids[mm.fid] = mm.sfid
+	return true
+}
+
+func (c *conn) dropfid(mm *msg) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if mm.gen != c.gen {
+		return
+	}
+	if s, ok := c.fids[mm.fid]; ok && s == 0 {
+		delete(c.fids, mm.fid)
+	}
+}
+
+func (c *conn) request(tx *plan9.Fcall) {
+	switch tx.Type {
+	case plan9.Tversion:
+		c.version(tx)
+		return
+	case plan9.Tflush:
+		c.flush(tx)
+		return
+	}
+
+	c.mu.Lock()
+	if c.tags[tx.Tag] != nil {
+		c.mu.Unlock()
+		c.error(tx, "duplicate tag")
+		return
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	stx := *tx
+	mm := &msg{c: c, gen: gen, ctag: tx.Tag, tx: &stx}
+	switch tx.Type {
+	default:
+		c.error(tx, "unknown message")
+		return
+
+	case plan9.Tauth:
+		sfid, e := c.reserve(tx.Afid)
+		if e != "" {
+			c.error(tx, e)
+			return
+		}
+		stx.Afid = sfid
+		mm.fid, mm.sfid, mm.newfid = tx.Afid, sfid, true
+
+	case plan9.Tattach:
+		if tx.Afid != plan9.NOFID {
+			safid, ok := c.lookup(tx.Afid)
+			if !ok {
+				c.error(tx, "unknown fid")
+				return
+			}
+			stx.Afid = safid
+		}
+		sfid, e := c.reserve(tx.Fid)
+		if e != "" {
+			c.error(tx, e)
+			return
+		}
+		stx.Fid = sfid
+		mm.fid, mm.sfid, mm.newfid = tx.Fid, sfid, true
+
+	case plan9.Twalk:
+		sfid, ok := c.lookup(tx.Fid)
+		if !ok {
+			c.error(tx, "unknown fid")
+			return
+		}
+		stx.Fid = sfid
+		stx.Newfid = sfid
+		if tx.Newfid != tx.Fid {
+			snewfid, e := c.reserve(tx.Newfid)
+			if e != "" {
+				c.error(tx, e)
+				return
+			}
+			stx.Newfid = snewfid
+			mm.fid, mm.sfid, mm.newfid = tx.Newfid, snewfid, true
+		}
+
+	case plan9.Tclunk, plan9.Tremove:
+		sfid, ok := c.lookup(tx.Fid)
+		if !ok {
+			c.error(tx, "unknown fid")
+			return
+		}
+		// The fid is gone whatever the server says.
+		c.mu.Lock()
+		delete(c.fids, tx.Fid)
+		c.mu.Unlock()
+		stx.Fid = sfid
+		mm.fid, mm.sfid, mm.clunk = tx.Fid, sfid, true
+
+	case plan9.Topen, plan9.Tcreate, plan9.Tread, plan9.Twrite, plan9.Tstat, plan9.Twstat:
+		sfid, ok := c.lookup(tx.Fid)
+		if !ok {
+			c.error(tx, "unknown fid")
+			return
+		}
+		stx.Fid = sfid
+	}
+
+	c.mu.Lock()
+	c.tags[tx.Tag] = mm
+	c.mu.Unlock()
+	if err := c.m.send(mm); err != nil {
+		c.mu.Lock()
+		delete(c.tags, tx.Tag)
+		c.mu.Unlock()
+		c.error(tx, err.Error())
+	}
+}
+
+func (c *conn) version(tx *plan9.Fcall) {
+	// A new session: abandon the old one.
+	c.reset()
+	rx := &plan9.Fcall{Type: plan9.Rversion, Tag: tx.Tag, Msize: c.m.msize}
+	if tx.Msize < rx.Msize {
+		rx.Msize = tx.Msize
+	}
+	c.msize = rx.Msize
+	if strings.HasPrefix(tx.Version, plan9.VERSION9P) {
+		rx.Version = plan9.VERSION9P
+	} else {
+		rx.Version = "unknown"
+	}
+	c.write(rx)
+}
+
+func (c *conn) flush(tx *plan9.Fcall) {
+	c.mu.Lock()
+	old := c.tags[tx.Oldtag]
+	gen := c.gen
+	c.mu.Unlock()
+	// The Tflush itself is not recorded in c.tags:
+	// flushing a flush is pointless.
+	mm := &msg{c: c, gen: gen, ctag: tx.Tag}
+	if old != nil {
+		sent, err := c.m.flush(old, mm)
+		if err != nil {
+			c.error(tx, err.Error())
+		}
+		if sent {
+			return
+		}
+	}
+	c.write(&plan9.Fcall{Type: plan9.Rflush, Tag: tx.Tag})
+}
+
+func (c *conn) reply(mm *msg, rx *plan9.Fcall) {
+	// Queue the reply while retiring the tag, so that a Tflush
+	// that no longer finds it is answered after this reply.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || mm.gen != c.gen {
+		return
+	}
+	if c.tags[mm.ctag] == mm {
+		delete(c.tags, mm.ctag)
+	}
+	rx.Tag = mm.ctag
+	c.queue(rx)
+}
+
+// reset flushes the client's outstanding requests and
+// clunks its fids on the server.
+func (c *conn) reset() {
+	c.mu.Lock()
+	tags := c.tags
+	fids := c.fids
+	c.tags = make(map[uint16]*msg)
+	c.fids = make(map[uint32]uint32)
+	c.gen++
+	c.mu.Unlock()
+
+	for _, mm := range tags {
+		c.m.flush(mm, new(msg))
+	}
+	for _, sfid := range fids {
+		if sfid != 0 {
+			c.m.clunk(sfid)
+		}
+	}
+}
+
+func (c *conn) hangup() {
+	c.mu.Lock()
+	c.closed = true
+	c.outc.Signal()
+	c.mu.Unlock()
+	c.reset()
+	c.m.mu.Lock()
+	delete(c.m.clients, c)
+	c.m.mu.Unlock()
+	c.rwc.Close()
+}
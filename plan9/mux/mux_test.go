@@ -0,0 +1,300 @@
+package mux
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plan9/client"
+	"plramos.win/9fans/plan9/srv"
+)
+
+type testServer struct {
+	mu       sync.Mutex
+	live     int // fids currently allocated
+	blocking chan bool
+}
+
+func (ts *testServer) srv() *srv.Srv {
+	tree := srv.NewTree("glenda", "glenda", 0775)
+	tree.Root.Create("file", "glenda", 0664, "data")
+	tree.Root.Create("block", "glenda", 0664, nil)
+	return &srv.Srv{
+		Tree: tree,
+		Attach: func(r *srv.Req) {
+			ts.mu.Lock()
+			ts.live++
+			ts.mu.Unlock()
+			r.Respond(nil)
+		},
+		Clone: func(old, new *srv.Fid) error {
+			ts.mu.Lock()
+			ts.live++
+			ts.mu.Unlock()
+			new.File = old.File
+			new.Qid = old.Qid
+			return nil
+		},
+		Destroyfid: func(*srv.Fid) {
+			ts.mu.Lock()
+			ts.live--
+			ts.mu.Unlock()
+		},
+		Read: func(r *srv.Req) {
+			if r.Fid.File.Name == "block" {
+				ts.blocking <- true
+				<-r.Context().Done()
+				r.Respond(srv.Error("interrupted"))
+				return
+			}
+			r.ReadString("data")
+			r.Respond(nil)
+		},
+	}
+}
+
+func (ts *testServer) fids() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.live
+}
+
+func newMux(t *testing.T) (*Mux, *testServer) {
+	ts := &testServer{blocking: make(chan bool, 1)}
+	s1, s2 := net.Pipe()
+	go ts.srv().ServeConn(s1)
+	m, err := New(s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s2.Close() })
+	return m, ts
+}
+
+func dial(t *testing.T, m *Mux) (net.Conn, *client.Fsys) {
+	c1, c2 := net.Pipe()
+	go m.ServeConn(c1)
+	conn, err := client.NewConn(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c2, fsys
+}
+
+func TestClients(t *testing.T) {
+	m, ts := newMux(t)
+	var clients []net.Conn
+	for i := 0; i < 3; i++ {
+		c, fsys := dial(t, m)
+		clients = append(clients, c)
+		// Every client uses the same fid numbers.
+		fid, err := fsys.Open("file", plan9.OREAD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(fid)
+		if err != nil || string(data) != "data" {
+			t.Fatalf("client %d: read %q, %v", i, data, err)
+		}
+		// Leave fid open; hangup must clunk it.
+	}
+	if n := ts.fids(); n != 6 {
+		t.Errorf("server has %d fids, want 6", n)
+	}
+	for _, c := range clients {
+		c.Close()
+	}
+	waitFor(t, func() bool { return ts.fids() == 0 })
+}
+
+func TestFlush(t *testing.T) {
+	m, ts := newMux(t)
+	c1, c2 := net.Pipe()
+	go m.ServeConn(c1)
+	defer c2.Close()
+
+	send := func(tx *plan9.Fcall) {
+		if err := plan9.WriteFcall(c2, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv := func() *plan9.Fcall {
+		rx, err := plan9.ReadFcall(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rx
+	}
+	send(&plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: plan9.VERSION9P})
+	recv()
+	send(&plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 7, Afid: plan9.NOFID, Uname: "glenda"})
+	recv()
+	send(&plan9.Fcall{Type: plan9.Twalk, Tag: 1, Fid: 7, Newfid: 8, Wname: []string{"block"}})
+	recv()
+	send(&plan9.Fcall{Type: plan9.Topen, Tag: 1, Fid: 8, Mode: plan9.OREAD})
+	recv()
+	send(&plan9.Fcall{Type: plan9.Tread, Tag: 3, Fid: 8, Count: 100})
+	<-ts.blocking
+	send(&plan9.Fcall{Type: plan9.Tflush, Tag: 4, Oldtag: 3})
+	if rx := recv(); rx.Tag != 3 || rx.Type != plan9.Rerror {
+		t.Fatalf("got %v, want Rerror tag 3", rx)
+	}
+	if rx := recv(); rx.Tag != 4 || rx.Type != plan9.Rflush {
+		t.Fatalf("got %v, want Rflush tag 4", rx)
+	}
+	send(&plan9.Fcall{Type: plan9.Twalk, Tag: 1, Fid: 7, Newfid: 8})
+	if rx := recv(); rx.Type != plan9.Rerror {
+		t.Fatalf("walk to fid in use: got %v, want Rerror", rx)
+	}
+}
+
+func TestFlushDropsReply(t *testing.T) {
+	// A server that answers Tflush with only an Rflush,
+	// dropping the reply to the flushed request.
+	s1, s2 := net.Pipe()
+	t.Cleanup(func() { s2.Close() })
+	walks := make(chan *plan9.Fcall, 1)
+	dropped := false
+	var mu sync.Mutex
+	stags := make(map[uint16]bool) // server tags in use
+	go func() {
+		for {
+			tx, err := plan9.ReadFcall(s1)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			if stags[tx.Tag] {
+				mu.Unlock()
+				plan9.WriteFcall(s1, &plan9.Fcall{Type: plan9.Rerror, Tag: tx.Tag, Ename: "duplicate tag"})
+				continue
+			}
+			stags[tx.Tag] = true
+			if tx.Type == plan9.Tflush {
+				delete(stags, tx.Oldtag)
+			}
+			mu.Unlock()
+			rx := &plan9.Fcall{Type: tx.Type + 1, Tag: tx.Tag}
+			switch tx.Type {
+			case plan9.Tversion:
+				rx.Msize, rx.Version = tx.Msize, tx.Version
+			case plan9.Twalk:
+				if !dropped {
+					dropped = true
+					walks <- tx // never answered
+					continue
+				}
+			}
+			mu.Lock()
+			delete(stags, tx.Tag)
+			mu.Unlock()
+			plan9.WriteFcall(s1, rx)
+		}
+	}()
+	m, err := New(s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c1, c2 := net.Pipe()
+	go m.ServeConn(c1)
+	defer c2.Close()
+	rpc := func(tx *plan9.Fcall) *plan9.Fcall {
+		t.Helper()
+		if err := plan9.WriteFcall(c2, tx); err != nil {
+			t.Fatal(err)
+		}
+		rx, err := plan9.ReadFcall(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rx
+	}
+	rpc(&plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: plan9.VERSION9P})
+	rpc(&plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 7, Afid: plan9.NOFID, Uname: "glenda"})
+	plan9.WriteFcall(c2, &plan9.Fcall{Type: plan9.Twalk, Tag: 3, Fid: 7, Newfid: 8})
+	<-walks
+	if rx := rpc(&plan9.Fcall{Type: plan9.Tflush, Tag: 4, Oldtag: 3}); rx.Tag != 4 || rx.Type != plan9.Rflush {
+		t.Fatalf("got %v, want Rflush tag 4", rx)
+	}
+
+	// The flushed request's tag and fid are free again,
+	// on both the client and the server side.
+	for i := 0; i < 3; i++ {
+		if rx := rpc(&plan9.Fcall{Type: plan9.Twalk, Tag: 3, Fid: 7, Newfid: 8}); rx.Type != plan9.Rwalk {
+			t.Fatalf("walk %d reusing tag and fid: got %v, want Rwalk", i, rx)
+		}
+		if rx := rpc(&plan9.Fcall{Type: plan9.Tclunk, Tag: 3, Fid: 8}); rx.Type != plan9.Rclunk {
+			t.Fatalf("clunk %d: got %v, want Rclunk", i, rx)
+		}
+	}
+	m.mu.Lock()
+	n, nfree, nalloc := len(m.tags), len(m.freetag), int(m.nexttag-1)
+	m.mu.Unlock()
+	if n != 0 || nfree != nalloc {
+		t.Errorf("server tags: %d outstanding, %d of %d free", n, nfree, nalloc)
+	}
+}
+
+func TestStalledClient(t *testing.T) {
+	m, _ := newMux(t)
+	c1, c2 := net.Pipe()
+	go m.ServeConn(c1)
+	defer c2.Close()
+	for _, tx := range []*plan9.Fcall{
+		{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: plan9.VERSION9P},
+		{Type: plan9.Tattach, Tag: 1, Fid: 7, Afid: plan9.NOFID, Uname: "glenda"},
+	} {
+		plan9.WriteFcall(c2, tx)
+		if _, err := plan9.ReadFcall(c2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Replies to this client pile up unread.
+	for tag := uint16(1); tag <= 5; tag++ {
+		plan9.WriteFcall(c2, &plan9.Fcall{Type: plan9.Tstat, Tag: tag, Fid: 7})
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		c, fsys := dial(t, m)
+		defer c.Close()
+		_, err := fsys.Stat("file")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled client blocked another")
+	}
+
+	seen := make(map[uint16]bool)
+	for i := 0; i < 5; i++ {
+		rx, err := plan9.ReadFcall(c2)
+		if err != nil || rx.Type != plan9.Rstat || seen[rx.Tag] {
+			t.Fatalf("reply %d = %v, %v", i, rx, err)
+		}
+		seen[rx.Tag] = true
+	}
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
@@ -0,0 +1,378 @@
+package srv
+
+import (
+	"plramos.win/9fans/plan9"
+)
+
+func (c *conn) dispatch(r *Req) {
+	switch r.Ifcall.Type {
+	default:
+		r.Respond(Error("unknown message"))
+	case plan9.Tauth:
+		c.auth(r)
+	case plan9.Tattach:
+		c.attach(r)
+	case plan9.Twalk:
+		c.walk(r)
+	case plan9.Topen:
+		c.open(r)
+	case plan9.Tcreate:
+		c.create(r)
+	case plan9.Tread:
+		c.read(r)
+	case plan9.Twrite:
+		c.write1(r)
+	case plan9.Tclunk:
+		c.clunk(r)
+	case plan9.Tremove:
+		c.remove(r)
+	case plan9.Tstat:
+		c.stat(r)
+	case plan9.Twstat:
+		c.wstat(r)
+	}
+}
+
+// setFid looks up r.Ifcall.Fid and records it in r.Fid.
+// If the fid is unknown, it responds to r and returns false.
+func (c *conn) setFid(r *Req) bool {
+	r.Fid = c.lookupFid(r.Ifcall.Fid)
+	if r.Fid == nil {
+		r.Respond(Eunknownfid)
+		return false
+	}
+	return true
+}
+
+func (c *conn) auth(r *Req) {
+	if r.Afid = c.allocFid(r.Ifcall.Afid); r.Afid == nil {
+		r.Respond(Edupfid)
+		return
+	}
+	r.Afid.Uid = r.Ifcall.Uname
+	r.Ofcall.Aqid = plan9.Qid{Type: plan9.QTAUTH}
+	if c.srv.Auth == nil {
+		r.Respond(Enoauth)
+		return
+	}
+	c.srv.Auth(r)
+}
+
+func (c *conn) attach(r *Req) {
+	if r.Fid = c.allocFid(r.Ifcall.Fid); r.Fid == nil {
+		r.Respond(Edupfid)
+		return
+	}
+	if r.Ifcall.Afid != plan9.NOFID {
+		r.Afid = c.lookupFid(r.Ifcall.Afid)
+		if r.Afid == nil || r.Afid.Qid.Type&plan9.QTAUTH == 0 {
+			r.Respond(Eunknownfid)
+			return
+		}
+	}
+	r.Fid.Uid = r.Ifcall.Uname
+	if t := c.srv.Tree; t != nil {
+		r.Fid.File = t.Root
+		r.Fid.Qid = t.Root.Stat().Qid
+		r.Ofcall.Qid = r.Fid.Qid
+	}
+	switch {
+	case c.srv.Attach != nil:
+		c.srv.Attach(r)
+	case c.srv.Tree != nil:
+		r.Respond(nil)
+	default:
+		r.Respond(Error("no attach function"))
+	}
+}
+
+func (c *conn) walk(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	if r.Fid.IsOpen() {
+		r.Respond(Error("cannot clone open fid"))
+		return
+	}
+	if len(r.Ifcall.Wname) > 0 && !r.Fid.Qid.IsDir() {
+		r.Respond(Ewalknodir)
+		return
+	}
+	if r.Ifcall.Newfid == r.Ifcall.Fid {
+		r.Newfid = r.Fid
+	} else {
+		if r.Newfid = c.allocFid(r.Ifcall.Newfid); r.Newfid == nil {
+			r.Respond(Edupfid)
+			return
+		}
+		r.Newfid.Uid = r.Fid.Uid
+		if c.srv.Clone != nil {
+			if err := c.srv.Clone(r.Fid, r.Newfid); err != nil {
+				r.Respond(err)
+				return
+			}
+		} else {
+			r.Newfid.Qid = r.Fid.Qid
+			r.Newfid.File = r.Fid.File
+			r.Newfid.Aux = r.Fid.Aux
+		}
+	}
+	switch {
+	case c.srv.Walk != nil:
+		c.srv.Walk(r)
+	case c.srv.Tree != nil:
+		c.walkTree(r)
+	case c.srv.Walk1 != nil:
+		c.walk1(r)
+	case len(r.Ifcall.Wname) == 0:
+		r.Respond(nil)
+	default:
+		r.Respond(Error("no walk function"))
+	}
+}
+
+func (c *conn) walkTree(r *Req) {
+	var err error
+	f := r.Newfid.File
+	for _, name := range r.Ifcall.Wname {
+		if f.Stat().Mode&plan9.DMDIR == 0 {
+			err = Ewalknodir
+			break
+		}
+		if !hasPerm(f, r.Fid.Uid, plan9.AEXEC) {
+			err = Eperm
+			break
+		}
+		nf := f.Walk(name)
+		if nf == nil {
+			err = Enotfound
+			break
+		}
+		r.Ofcall.Wqid = append(r.Ofcall.Wqid, nf.Stat().Qid)
+		f = nf
+	}
+	if len(r.Ofcall.Wqid) == len(r.Ifcall.Wname) {
+		r.Newfid.File = f
+		r.Respond(nil)
+		return
+	}
+	if len(r.Ofcall.Wqid) > 0 {
+		err = nil
+	}
+	r.Respond(err)
+}
+
+func (c *conn) walk1(r *Req) {
+	var err error
+	orig := r.Newfid.Qid
+	for _, name := range r.Ifcall.Wname {
+		var q plan9.Qid
+		q, err = c.srv.Walk1(r.Newfid, name)
+		if err != nil {
+			break
+		}
+		r.Newfid.Qid = q
+		r.Ofcall.Wqid = append(r.Ofcall.Wqid, q)
+	}
+	if len(r.Ofcall.Wqid) < len(r.Ifcall.Wname) && r.Newfid == r.Fid {
+		// A failed walk leaves fid unchanged.
+		r.Fid.Qid = orig
+	}
+	if len(r.Ofcall.Wqid) > 0 {
+		err = nil
+	}
+	r.Respond(err)
+}
+
+var openPerm = [4]int{
+	plan9.OREAD:  plan9.AREAD,
+	plan9.OWRITE: plan9.AWRITE,
+	plan9.ORDWR:  plan9.AREAD | plan9.AWRITE,
+	plan9.OEXEC:  plan9.AEXEC,
+}
+
+func (c *conn) open(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	if r.Fid.IsOpen() {
+		r.Respond(Ebotch)
+		return
+	}
+	mode := r.Ifcall.Mode
+	if r.Fid.Qid.IsDir() && (mode&3 != plan9.OREAD || mode&(plan9.OTRUNC|plan9.ORCLOSE) != 0) {
+		r.Respond(Eisdir)
+		return
+	}
+	if f := r.Fid.File; f != nil {
+		p := openPerm[mode&3]
+		if mode&plan9.OTRUNC != 0 {
+			p |= plan9.AWRITE
+		}
+		if !hasPerm(f, r.Fid.Uid, p) {
+			r.Respond(Eperm)
+			return
+		}
+	}
+	r.Ofcall.Qid = r.Fid.Qid
+	if c.srv.Open != nil {
+		c.srv.Open(r)
+		return
+	}
+	r.Respond(nil)
+}
+
+func (c *conn) create(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	if r.Fid.IsOpen() {
+		r.Respond(Ebotch)
+		return
+	}
+	if !r.Fid.Qid.IsDir() {
+		r.Respond(Ecreatenondir)
+		return
+	}
+	dir := r.Fid.File
+	if dir != nil && !hasPerm(dir, r.Fid.Uid, plan9.AWRITE) {
+		r.Respond(Eperm)
+		return
+	}
+	switch {
+	case c.srv.Create != nil:
+		c.srv.Create(r)
+	case dir != nil:
+		// Permissions are restricted by the directory's, as in Plan 9.
+		perm := r.Ifcall.Perm
+		dperm := dir.Stat().Mode
+		if perm&plan9.DMDIR != 0 {
+			perm &= ^plan9.Perm(0777) | dperm&0777
+		} else {
+			perm &= ^plan9.Perm(0666) | dperm&0666
+		}
+		f, err := dir.Create(r.Ifcall.Name, r.Fid.Uid, perm, nil)
+		if err != nil {
+			r.Respond(err)
+			return
+		}
+		r.Fid.File = f
+		r.Ofcall.Qid = f.Stat().Qid
+		r.Respond(nil)
+	default:
+		r.Respond(Enocreate)
+	}
+}
+
+func (c *conn) read(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	if !r.Fid.canRead() {
+		r.Respond(Ebotch)
+		return
+	}
+	if max := c.msize - plan9.IOHDRSZ; r.Ifcall.Count > max {
+		r.Ifcall.Count = max
+	}
+	if f := r.Fid.File; f != nil && r.Fid.Qid.IsDir() {
+		children := f.Children()
+		err := r.ReadDir(func(n int) (*plan9.Dir, bool) {
+			if n >= len(children) {
+				return nil, false
+			}
+			d := children[n].Stat()
+			return &d, true
+		})
+		r.Respond(err)
+		return
+	}
+	if c.srv.Read == nil {
+		r.Respond(Error("read prohibited"))
+		return
+	}
+	c.srv.Read(r)
+}
+
+func (c *conn) write1(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	if !r.Fid.canWrite() {
+		r.Respond(Ebotch)
+		return
+	}
+	if max := c.msize - plan9.IOHDRSZ; uint32(len(r.Ifcall.Data)) > max {
+		r.Ifcall.Data = r.Ifcall.Data[:max]
+	}
+	if c.srv.Write == nil {
+		r.Respond(Enowrite)
+		return
+	}
+	c.srv.Write(r)
+}
+
+func (c *conn) clunk(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	r.Respond(nil)
+}
+
+func (c *conn) remove(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	if f := r.Fid.File; f != nil {
+		if f.Parent() == f || !hasPerm(f.Parent(), r.Fid.Uid, plan9.AWRITE) {
+			r.Respond(Eperm)
+			return
+		}
+		if len(f.Children()) > 0 {
+			r.Respond(Error("directory not empty"))
+			return
+		}
+	}
+	switch {
+	case c.srv.Remove != nil:
+		c.srv.Remove(r)
+	case r.Fid.File != nil:
+		r.Respond(nil)
+	default:
+		r.Respond(Enoremove)
+	}
+}
+
+func (c *conn) stat(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	if f := r.Fid.File; f != nil {
+		r.Dir = f.Stat()
+	}
+	switch {
+	case c.srv.Stat != nil:
+		c.srv.Stat(r)
+	case r.Fid.File != nil:
+		r.Respond(nil)
+	default:
+		r.Respond(Enostat)
+	}
+}
+
+func (c *conn) wstat(r *Req) {
+	if !c.setFid(r) {
+		return
+	}
+	d, err := plan9.UnmarshalDir(r.Ifcall.Stat)
+	if err != nil {
+		r.Respond(Ebaddir)
+		return
+	}
+	r.Dir = *d
+	if c.srv.Wstat == nil {
+		r.Respond(Enowstat)
+		return
+	}
+	c.srv.Wstat(r)
+}
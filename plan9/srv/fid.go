@@ -0,0 +1,30 @@
+package srv
+
+import "plramos.win/9fans/plan9"
+
+// A Fid is the server's state for a single client fid.
+type Fid struct {
+	Fid   uint32
+	Qid   plan9.Qid
+	Omode int    // open mode, or -1 if the fid is not open
+	Uid   string // user name from Tattach or Tauth
+	File  *File  // file in Srv.Tree, if any
+	Aux   interface{}
+
+	diroffset uint64
+	dirindex  int
+}
+
+// IsOpen reports whether the fid has been opened or created.
+func (f *Fid) IsOpen() bool {
+	return f.Omode != -1
+}
+
+func (f *Fid) canRead() bool {
+	return f.Omode != -1 && f.Omode&3 != plan9.OWRITE
+}
+
+func (f *Fid) canWrite() bool {
+	m := f.Omode & 3
+	return f.Omode != -1 && (m == plan9.OWRITE || m == plan9.ORDWR)
+}
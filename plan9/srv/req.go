@@ -0,0 +1,215 @@
+package srv
+
+import (
+	"context"
+
+	"plramos.win/9fans/plan9"
+)
+
+// A Req is a single outstanding 9P request.
+type Req struct {
+	Tag    uint16
+	Ifcall plan9.Fcall // the request
+	Ofcall plan9.Fcall // the reply, filled in by handlers
+	Fid    *Fid
+	Newfid *Fid      // Twalk: the fid being walked to; may equal Fid
+	Afid   *Fid      // Tauth: the new auth fid; Tattach: the auth fid, if any
+	Oldreq *Req      // Tflush: the request being flushed
+	Dir    plan9.Dir // Tstat: the reply; Twstat: the request
+	Aux    interface{}
+
+	c         *conn
+	gen       int
+	ctx       context.Context
+	cancel    context.CancelFunc
+	flush     []*Req
+	responded bool
+}
+
+func newReq(c *conn, fc *plan9.Fcall) *Req {
+	r := &Req{Tag: fc.Tag, Ifcall: *fc, c: c}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+// Context returns a context that is canceled when the request
+// is flushed or the connection ends.
+// Handlers that block, such as reads of event files,
+// should respond with an error once it is done.
+func (r *Req) Context() context.Context {
+	return r.ctx
+}
+
+// Respond sends the reply to r. If err is non-nil, the reply is an
+// Rerror carrying err's text; otherwise it is r.Ofcall.
+// Respond must be called exactly once for every request.
+func (r *Req) Respond(err error) {
+	c := r.c
+	c.mu.Lock()
+	stale := r.gen != c.gen
+	c.mu.Unlock()
+	if !stale {
+		err = r.finish(err)
+	}
+	if err != nil {
+		r.Ofcall = plan9.Fcall{Type: plan9.Rerror, Ename: err.Error()}
+	} else {
+		r.Ofcall.Type = r.Ifcall.Type + 1
+	}
+	r.Ofcall.Tag = r.Tag
+
+	c.wmu.Lock()
+	c.send(r)
+	c.wmu.Unlock()
+}
+
+// send writes r's reply followed by the Rflush replies of any
+// requests that were waiting for it. The caller must hold c.wmu.
+func (c *conn) send(r *Req) {
+	c.mu.Lock()
+	if r.responded {
+		c.mu.Unlock()
+		panic("srv: Respond called twice")
+	}
+	r.responded = true
+	stale := r.gen != c.gen
+	if c.reqs[r.Tag] == r {
+		delete(c.reqs, r.Tag)
+	}
+	flush := r.flush
+	r.flush = nil
+	c.mu.Unlock()
+
+	if !stale {
+		c.write(&r.Ofcall)
+	}
+	r.cancel()
+	if r.Ifcall.Type != plan9.Tversion && r.Ifcall.Type != plan9.Tflush {
+		c.wg.Done()
+	}
+	for _, f := range flush {
+		f.Ofcall = plan9.Fcall{Type: plan9.Rflush, Tag: f.Tag}
+		c.send(f)
+	}
+}
+
+// finish updates the fid table to reflect the outcome of r
+// and returns the error that should be sent, if any.
+func (r *Req) finish(err error) error {
+	c := r.c
+	switch r.Ifcall.Type {
+	case plan9.Tauth:
+		if err != nil {
+			c.removeFid(r.Afid)
+			break
+		}
+		r.Afid.Qid = r.Ofcall.Aqid
+		// An auth fid is read and written without being opened.
+		r.Afid.Omode = plan9.ORDWR
+
+	case plan9.Tattach:
+		if err != nil {
+			c.removeFid(r.Fid)
+			break
+		}
+		r.Fid.Qid = r.Ofcall.Qid
+
+	case plan9.Twalk:
+		if err == nil && len(r.Ofcall.Wqid) == 0 && len(r.Ifcall.Wname) > 0 {
+			err = Enotfound
+		}
+		if err == nil && len(r.Ofcall.Wqid) == len(r.Ifcall.Wname) {
+			if n := len(r.Ofcall.Wqid); n > 0 {
+				r.Newfid.Qid = r.Ofcall.Wqid[n-1]
+			}
+			break
+		}
+		if r.Newfid != nil && r.Newfid != r.Fid {
+			c.removeFid(r.Newfid)
+		}
+
+	case plan9.Topen, plan9.Tcreate:
+		if err != nil {
+			break
+		}
+		r.Fid.Omode = int(r.Ifcall.Mode)
+		r.Fid.Qid = r.Ofcall.Qid
+
+	case plan9.Tread:
+		if err == nil && uint32(len(r.Ofcall.Data)) > r.Ifcall.Count {
+			r.Ofcall.Data = r.Ofcall.Data[:r.Ifcall.Count]
+		}
+
+	case plan9.Tremove:
+		if err == nil && r.Fid != nil && r.Fid.File != nil {
+			r.Fid.File.Remove()
+		}
+		c.removeFid(r.Fid)
+
+	case plan9.Tclunk:
+		c.removeFid(r.Fid)
+
+	case plan9.Tstat:
+		if err == nil {
+			r.Ofcall.Stat, err = r.Dir.Bytes()
+		}
+	}
+	return err
+}
+
+// ReadBytes responds to a read of a file whose contents are b,
+// copying the requested section of b into r.Ofcall.Data.
+// It does not call Respond.
+func (r *Req) ReadBytes(b []byte) {
+	off := r.Ifcall.Offset
+	if off >= uint64(len(b)) {
+		r.Ofcall.Data = nil
+		return
+	}
+	b = b[off:]
+	if uint64(len(b)) > uint64(r.Ifcall.Count) {
+		b = b[:r.Ifcall.Count]
+	}
+	r.Ofcall.Data = b
+}
+
+// ReadString is like ReadBytes but takes a string.
+func (r *Req) ReadString(s string) {
+	r.ReadBytes([]byte(s))
+}
+
+// ReadDir fills r.Ofcall.Data with as many directory entries
+// as fit in the read, calling gen(n) to obtain the n'th entry;
+// gen returns false when there are no more.
+// Reads must either start at offset zero or continue where
+// the previous read left off. ReadDir does not call Respond.
+func (r *Req) ReadDir(gen func(n int) (*plan9.Dir, bool)) error {
+	fid := r.Fid
+	if r.Ifcall.Offset == 0 {
+		fid.diroffset = 0
+		fid.dirindex = 0
+	} else if r.Ifcall.Offset != fid.diroffset {
+		return Ebadoffset
+	}
+	var data []byte
+	n := fid.dirindex
+	for {
+		d, ok := gen(n)
+		if !ok {
+			break
+		}
+		b := d.Append(nil)
+		if len(data)+len(b) > int(r.Ifcall.Count) {
+			if len(data) == 0 {
+				return Ebadcount
+			}
+			break
+		}
+		data = append(data, b...)
+		n++
+	}
+	fid.dirindex = n
+	fid.diroffset += uint64(len(data))
+	r.Ofcall.Data = data
+	return nil
+}
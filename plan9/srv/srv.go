@@ -0,0 +1,319 @@
+// Package srv provides a framework for writing 9P2000 file servers.
+//
+// It follows the shape of Plan 9's lib9p: a Srv holds a set of
+// request handlers, each of which receives a *Req and must eventually
+// call its Respond method, possibly from another goroutine.
+// The framework takes care of version negotiation, the fid and tag
+// tables, Tflush bookkeeping and packing directory reads.
+//
+// A server can either supply a Tree of Files, in which case walks,
+// stats and directory reads are handled automatically and only file
+// contents need handlers, or it can leave Tree nil and implement
+// the handlers (Attach, Walk1 or Walk, Read, ...) itself.
+package srv // import "plramos.win/9fans/plan9/srv"
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"plramos.win/9fans/plan9"
+)
+
+// An Error is a 9P error string returned in an Rerror message.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+var (
+	Ebadattach    = Error("unknown specifier in attach")
+	Ebadoffset    = Error("bad offset")
+	Ebadcount     = Error("bad count")
+	Ebotch        = Error("9P protocol botch")
+	Ecreatenondir = Error("create in non-directory")
+	Edupfid       = Error("duplicate fid")
+	Eduptag       = Error("duplicate tag")
+	Eisdir        = Error("is a directory")
+	Enocreate     = Error("create prohibited")
+	Enoremove     = Error("remove prohibited")
+	Enostat       = Error("stat prohibited")
+	Enotfound     = Error("file not found")
+	Enowrite      = Error("write prohibited")
+	Enowstat      = Error("wstat prohibited")
+	Eperm         = Error("permission denied")
+	Eunknownfid   = Error("unknown fid")
+	Ebaddir       = Error("bad directory in wstat")
+	Ewalknodir    = Error("walk in non-directory")
+	Enoauth       = Error("authentication not required")
+)
+
+// A Srv describes a 9P file server.
+//
+// Each handler is called in its own goroutine and must arrange for
+// r.Respond to be called exactly once. Handlers that are nil get
+// default behavior: for servers with a Tree that means the obvious
+// operation on the tree; otherwise it is usually an error.
+type Srv struct {
+	// Tree, if non-nil, is the file tree served by Srv.
+	Tree *Tree
+
+	// Auth handles Tauth; if nil, the server requires no authentication.
+	// Reads and writes of the resulting auth fid, which has
+	// Qid.Type QTAUTH, go to Read and Write, and Attach finds
+	// it in r.Afid.
+	Auth   func(r *Req)
+	Attach func(r *Req)
+	Open   func(r *Req)
+	Create func(r *Req)
+	Read   func(r *Req)
+	Write  func(r *Req)
+	Remove func(r *Req)
+	Stat   func(r *Req)
+	Wstat  func(r *Req)
+
+	// Walk handles an entire Twalk message.
+	// If Walk is nil, the server walks Tree if it is non-nil,
+	// or else calls Clone and Walk1 for each path element.
+	Walk func(r *Req)
+
+	// Walk1 walks fid to the named child and returns its qid.
+	// The framework records the qid in fid.Qid.
+	Walk1 func(fid *Fid, name string) (plan9.Qid, error)
+
+	// Clone is called when a walk creates a new fid from old.
+	// If it is nil, Qid, Uid, File and Aux are copied.
+	Clone func(old, new *Fid) error
+
+	// Flush is called when a Tflush arrives for r.Oldreq,
+	// after r.Oldreq's context has been canceled.
+	// Flush must not respond to r: the Rflush is sent
+	// automatically once r.Oldreq has been responded to.
+	Flush func(r *Req)
+
+	// Destroyfid is called when a fid is clunked or removed,
+	// or when the connection ends.
+	Destroyfid func(fid *Fid)
+
+	// Msize is the largest message size the server accepts.
+	// If zero, 8192+IOHDRSZ is used.
+	Msize uint32
+
+	// Chatty causes all 9P messages to be logged to standard error.
+	Chatty bool
+}
+
+// Serve accepts connections on l and serves each one in a new goroutine.
+func (s *Srv) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			s.ServeConn(c)
+			c.Close()
+		}()
+	}
+}
+
+// ServeConn serves 9P on rw until reading from it fails.
+// It returns nil if the connection ended with a clean EOF.
+func (s *Srv) ServeConn(rw io.ReadWriter) error {
+	c := &conn{
+		srv:   s,
+		rw:    rw,
+		msize: s.Msize,
+		fids:  make(map[uint32]*Fid),
+		reqs:  make(map[uint16]*Req),
+	}
+	if c.msize == 0 {
+		c.msize = 8192 + plan9.IOHDRSZ
+	}
+	err := c.serve()
+	c.shutdown()
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// A conn is the state of a single 9P connection.
+type conn struct {
+	srv   *Srv
+	rw    io.ReadWriter
+	msize uint32
+	gen   int // incremented by Tversion; stale replies are dropped
+
+	mu   sync.Mutex
+	fids map[uint32]*Fid
+	reqs map[uint16]*Req
+	wg   sync.WaitGroup
+
+	wmu sync.Mutex // serializes writes to rw
+}
+
+func (c *conn) serve() error {
+	for {
+		fc, err := plan9.ReadFcall(c.rw)
+		if err != nil {
+			return err
+		}
+		if c.srv.Chatty {
+			fmt.Fprintf(os.Stderr, "<- %v\n", fc)
+		}
+		r := c.newReq(fc)
+		if r == nil {
+			continue
+		}
+		switch fc.Type {
+		case plan9.Tversion:
+			c.version(r)
+		case plan9.Tflush:
+			c.flush(r)
+		default:
+			c.wg.Add(1) // done in send
+			go c.dispatch(r)
+		}
+	}
+}
+
+// newReq allocates the request for fc, replying with an error
+// and returning nil if its tag is already in use.
+func (c *conn) newReq(fc *plan9.Fcall) *Req {
+	r := newReq(c, fc)
+	c.mu.Lock()
+	if c.reqs[fc.Tag] != nil {
+		c.mu.Unlock()
+		c.writeError(fc, Eduptag)
+		return nil
+	}
+	c.reqs[fc.Tag] = r
+	r.gen = c.gen
+	c.mu.Unlock()
+	return r
+}
+
+func (c *conn) writeError(fc *plan9.Fcall, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.write(&plan9.Fcall{Type: plan9.Rerror, Tag: fc.Tag, Ename: err.Error()})
+}
+
+// write writes f to the connection. The caller must hold c.wmu.
+func (c *conn) write(f *plan9.Fcall) {
+	if c.srv.Chatty {
+		fmt.Fprintf(os.Stderr, "-> %v\n", f)
+	}
+	plan9.WriteFcall(c.rw, f)
+}
+
+func (c *conn) version(r *Req) {
+	// Abort everything outstanding and start afresh.
+	c.mu.Lock()
+	c.gen++
+	for tag, r1 := range c.reqs {
+		if r1 != r {
+			r1.cancel()
+			delete(c.reqs, tag)
+		}
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+	c.destroyFids()
+
+	if r.Ifcall.Msize < 256 {
+		r.Respond(Error("version: message size too small"))
+		return
+	}
+	if r.Ifcall.Msize < c.msize {
+		c.msize = r.Ifcall.Msize
+	}
+	r.Ofcall.Msize = c.msize
+	if strings.HasPrefix(r.Ifcall.Version, plan9.VERSION9P) {
+		r.Ofcall.Version = plan9.VERSION9P
+	} else {
+		r.Ofcall.Version = "unknown"
+	}
+	c.mu.Lock()
+	r.gen = c.gen
+	c.mu.Unlock()
+	r.Respond(nil)
+}
+
+func (c *conn) flush(r *Req) {
+	c.mu.Lock()
+	old := c.reqs[r.Ifcall.Oldtag]
+	if old == nil || old == r {
+		c.mu.Unlock()
+		r.Respond(nil)
+		return
+	}
+	r.Oldreq = old
+	old.flush = append(old.flush, r)
+	c.mu.Unlock()
+	old.cancel()
+	if c.srv.Flush != nil {
+		c.srv.Flush(r)
+	}
+}
+
+// shutdown cancels outstanding requests and destroys all fids
+// after the connection has ended.
+func (c *conn) shutdown() {
+	c.mu.Lock()
+	c.gen++
+	for _, r := range c.reqs {
+		r.cancel()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+	c.destroyFids()
+}
+
+func (c *conn) destroyFids() {
+	c.mu.Lock()
+	fids := c.fids
+	c.fids = make(map[uint32]*Fid)
+	c.mu.Unlock()
+	if c.srv.Destroyfid != nil {
+		for _, f := range fids {
+			c.srv.Destroyfid(f)
+		}
+	}
+}
+
+func (c *conn) lookupFid(fid uint32) *Fid {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fids[fid]
+}
+
+func (c *conn) allocFid(fid uint32) *Fid {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fids[fid] != nil {
+		return nil
+	}
+	f := &Fid{Fid: fid, Omode: -1}
+	c.fids[fid] = f
+	return f
+}
+
+func (c *conn) removeFid(f *Fid) {
+	if f == nil { // request failed before its fid was found
+		return
+	}
+	c.mu.Lock()
+	if c.fids[f.Fid] != f {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.fids, f.Fid)
+	c.mu.Unlock()
+	if c.srv.Destroyfid != nil {
+		c.srv.Destroyfid(f)
+	}
+}
@@ -0,0 +1,287 @@
+package srv
+
+import (
+	"io"
+	"net"
+	"sort"
+	"testing"
+
+	"plramos.win/9fans/plan9"
+	"plramos.win/9fans/plan9/client"
+)
+
+func testTree(t *testing.T) *Tree {
+	tree := NewTree("glenda", "glenda", 0775)
+	d, err := tree.Root.Create("dir", "glenda", plan9.DMDIR|0775, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Root.Create("hello", "glenda", 0664, "hello, world\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Create("inner", "glenda", 0664, "inner\n"); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func mount(t *testing.T, s *Srv) *client.Fsys {
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c1)
+	conn, err := client.NewConn(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := conn.Attach(nil, "glenda", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return fsys
+}
+
+func readString(r *Req) {
+	s, _ := r.Fid.File.Aux.(string)
+	r.ReadString(s)
+	r.Respond(nil)
+}
+
+func TestTree(t *testing.T) {
+	fsys := mount(t, &Srv{Tree: testTree(t), Read: readString})
+
+	for name, want := range map[string]string{
+		"hello":        "hello, world\n",
+		"dir/inner":    "inner\n",
+		"dir/../hello": "hello, world\n",
+	} {
+		fid, err := fsys.Open(name, plan9.OREAD)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		data, err := io.ReadAll(fid)
+		fid.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if string(data) != want {
+			t.Errorf("read %s = %q, want %q", name, data, want)
+		}
+	}
+
+	fid, err := fsys.Open("/", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := fid.Dirreadall()
+	fid.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, d := range dirs {
+		names = append(names, d.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "dir" || names[1] != "hello" {
+		t.Errorf("root dir = %v, want [dir hello]", names)
+	}
+
+	if _, err := fsys.Open("missing", plan9.OREAD); err == nil {
+		t.Errorf("open missing: no error")
+	}
+	if _, err := fsys.Open("hello/x", plan9.OREAD); err == nil {
+		t.Errorf("open hello/x: no error")
+	}
+	if _, err := fsys.Open("dir", plan9.OWRITE); err == nil {
+		t.Errorf("open dir for writing: no error")
+	}
+
+	d, err := fsys.Stat("dir/inner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "inner" || d.Uid != "glenda" || d.Mode != 0664 {
+		t.Errorf("stat dir/inner = %v", d)
+	}
+}
+
+func TestCreateRemove(t *testing.T) {
+	tree := testTree(t)
+	fsys := mount(t, &Srv{Tree: tree})
+
+	fid, err := fsys.Create("dir/new", plan9.OREAD, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fid.Close()
+	d, err := fsys.Stat("dir/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Mode != 0775 {
+		t.Errorf("created mode = %v, want %v", d.Mode, plan9.Perm(0775))
+	}
+	if err := fsys.Remove("dir"); err == nil {
+		t.Errorf("remove non-empty dir: no error")
+	}
+	if err := fsys.Remove("dir/new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("dir/new"); err == nil {
+		t.Errorf("stat after remove: no error")
+	}
+}
+
+func TestWalk1(t *testing.T) {
+	// A handler-based server with a single file "x".
+	s := &Srv{
+		Attach: func(r *Req) {
+			r.Ofcall.Qid = plan9.Qid{Type: plan9.QTDIR}
+			r.Respond(nil)
+		},
+		Walk1: func(fid *Fid, name string) (plan9.Qid, error) {
+			if fid.Qid.IsDir() && name == "x" {
+				return plan9.Qid{Path: 1}, nil
+			}
+			return plan9.Qid{}, Enotfound
+		},
+		Read: func(r *Req) {
+			if r.Fid.Qid.IsDir() {
+				r.Respond(r.ReadDir(func(n int) (*plan9.Dir, bool) {
+					if n > 0 {
+						return nil, false
+					}
+					return &plan9.Dir{Name: "x", Qid: plan9.Qid{Path: 1}}, true
+				}))
+				return
+			}
+			r.ReadString("x data")
+			r.Respond(nil)
+		},
+	}
+	fsys := mount(t, s)
+	fid, err := fsys.Open("x", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(fid)
+	fid.Close()
+	if string(data) != "x data" {
+		t.Errorf("read x = %q", data)
+	}
+	fid, err = fsys.Open(".", plan9.OREAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := fid.Dirreadall()
+	fid.Close()
+	if err != nil || len(dirs) != 1 || dirs[0].Name != "x" {
+		t.Errorf("dirread = %v, %v", dirs, err)
+	}
+	if _, err := fsys.Open("y", plan9.OREAD); err == nil {
+		t.Errorf("open y: no error")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	tree := testTree(t)
+	s := &Srv{
+		Tree: tree,
+		Read: func(r *Req) {
+			<-r.Context().Done()
+			r.Respond(Error("interrupted"))
+		},
+	}
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c1)
+	defer c2.Close()
+
+	rpc := func(tx *plan9.Fcall) *plan9.Fcall {
+		t.Helper()
+		if err := plan9.WriteFcall(c2, tx); err != nil {
+			t.Fatal(err)
+		}
+		rx, err := plan9.ReadFcall(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rx.Type == plan9.Rerror {
+			t.Fatalf("%v: %s", tx, rx.Ename)
+		}
+		return rx
+	}
+	rpc(&plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: plan9.VERSION9P})
+	rpc(&plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 1, Afid: plan9.NOFID, Uname: "glenda"})
+	rpc(&plan9.Fcall{Type: plan9.Twalk, Tag: 1, Fid: 1, Newfid: 2, Wname: []string{"hello"}})
+	rpc(&plan9.Fcall{Type: plan9.Topen, Tag: 1, Fid: 2, Mode: plan9.OREAD})
+
+	plan9.WriteFcall(c2, &plan9.Fcall{Type: plan9.Tread, Tag: 5, Fid: 2, Count: 100})
+	plan9.WriteFcall(c2, &plan9.Fcall{Type: plan9.Tflush, Tag: 6, Oldtag: 5})
+	rx, err := plan9.ReadFcall(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rx.Tag != 5 || rx.Type != plan9.Rerror {
+		t.Fatalf("first reply = %v, want Rerror tag 5", rx)
+	}
+	rx, err = plan9.ReadFcall(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rx.Tag != 6 || rx.Type != plan9.Rflush {
+		t.Fatalf("second reply = %v, want Rflush tag 6", rx)
+	}
+
+	// Flushing a tag that is not outstanding replies at once.
+	rpc(&plan9.Fcall{Type: plan9.Tflush, Tag: 7, Oldtag: 99})
+}
+
+func TestBadFid(t *testing.T) {
+	s := &Srv{
+		Tree: testTree(t),
+		Auth: func(r *Req) { r.Respond(nil) },
+	}
+	c1, c2 := net.Pipe()
+	go s.ServeConn(c1)
+	defer c2.Close()
+
+	rpc := func(tx *plan9.Fcall) *plan9.Fcall {
+		t.Helper()
+		if err := plan9.WriteFcall(c2, tx); err != nil {
+			t.Fatal(err)
+		}
+		rx, err := plan9.ReadFcall(c2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rx
+	}
+	rpc(&plan9.Fcall{Type: plan9.Tversion, Tag: plan9.NOTAG, Msize: 8192, Version: plan9.VERSION9P})
+	rpc(&plan9.Fcall{Type: plan9.Tattach, Tag: 1, Fid: 1, Afid: plan9.NOFID, Uname: "glenda"})
+	rpc(&plan9.Fcall{Type: plan9.Tauth, Tag: 1, Afid: 2, Uname: "glenda"})
+
+	for _, tt := range []struct {
+		tx   plan9.Fcall
+		want string
+	}{
+		{plan9.Fcall{Type: plan9.Tclunk, Fid: 9}, Eunknownfid.Error()},
+		{plan9.Fcall{Type: plan9.Tremove, Fid: 9}, Eunknownfid.Error()},
+		{plan9.Fcall{Type: plan9.Tattach, Fid: 1, Afid: plan9.NOFID, Uname: "glenda"}, Edupfid.Error()},
+		{plan9.Fcall{Type: plan9.Tattach, Fid: 2, Afid: plan9.NOFID, Uname: "glenda"}, Edupfid.Error()},
+		{plan9.Fcall{Type: plan9.Tauth, Afid: 1, Uname: "glenda"}, Edupfid.Error()},
+		{plan9.Fcall{Type: plan9.Tauth, Afid: 2, Uname: "glenda"}, Edupfid.Error()},
+	} {
+		tt.tx.Tag = 1
+		if rx := rpc(&tt.tx); rx.Type != plan9.Rerror || rx.Ename != tt.want {
+			t.Errorf("%v = %v, want error %q", &tt.tx, rx, tt.want)
+		}
+	}
+
+	// The fids in use survive the failed requests.
+	if rx := rpc(&plan9.Fcall{Type: plan9.Tstat, Tag: 1, Fid: 1}); rx.Type != plan9.Rstat {
+		t.Errorf("stat fid 1 = %v", rx)
+	}
+	if rx := rpc(&plan9.Fcall{Type: plan9.Tclunk, Tag: 1, Fid: 2}); rx.Type != plan9.Rclunk {
+		t.Errorf("clunk afid 2 = %v", rx)
+	}
+}
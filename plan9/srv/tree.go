@@ -0,0 +1,192 @@
+package srv
+
+import (
+	"sync"
+	"time"
+
+	"plramos.win/9fans/plan9"
+)
+
+// A Tree is a hierarchy of Files served by a Srv.
+type Tree struct {
+	Root *File
+
+	mu   sync.RWMutex
+	path uint64
+}
+
+// A File is a single file or directory in a Tree.
+// The embedded Dir is what Tstat returns. Once a file is in a tree
+// that is being served, change it only through Update.
+type File struct {
+	plan9.Dir
+	Aux interface{}
+
+	tree     *Tree
+	parent   *File
+	children []*File
+	removed  bool
+}
+
+// NewTree returns a tree whose root directory is owned by uid and gid
+// and has permission perm (DMDIR is implied).
+func NewTree(uid, gid string, perm plan9.Perm) *Tree {
+	t := new(Tree)
+	now := uint32(time.Now().Unix())
+	t.Root = &File{
+		Dir: plan9.Dir{
+			Qid:   plan9.Qid{Path: 0, Type: plan9.QTDIR},
+			Mode:  perm | plan9.DMDIR,
+			Atime: now,
+			Mtime: now,
+			Name:  "/",
+			Uid:   uid,
+			Gid:   gid,
+			Muid:  uid,
+		},
+		tree: t,
+	}
+	t.Root.parent = t.Root
+	return t
+}
+
+// Create creates a new file named name in the directory f.
+// The new file is owned by uid, has f's group and the given
+// permissions and auxiliary data.
+func (f *File) Create(name, uid string, perm plan9.Perm, aux interface{}) (*File, error) {
+	t := f.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f.removed {
+		return nil, Enotfound
+	}
+	if f.Mode&plan9.DMDIR == 0 {
+		return nil, Ecreatenondir
+	}
+	if name == "" || name == "." || name == ".." {
+		return nil, Eperm
+	}
+	for _, c := range f.children {
+		if c.Name == name {
+			return nil, Error("file already exists")
+		}
+	}
+	t.path++
+	now := uint32(time.Now().Unix())
+	nf := &File{
+		Dir: plan9.Dir{
+			Qid:   plan9.Qid{Path: t.path, Type: uint8(perm >> 24)},
+			Mode:  perm,
+			Atime: now,
+			Mtime: now,
+			Name:  name,
+			Uid:   uid,
+			Gid:   f.Gid,
+			Muid:  uid,
+		},
+		Aux:    aux,
+		tree:   t,
+		parent: f,
+	}
+	f.children = append(f.children, nf)
+	f.Qid.Vers++
+	f.Mtime = now
+	return nf, nil
+}
+
+// Remove removes f from its tree.
+// Non-empty directories cannot be removed.
+func (f *File) Remove() error {
+	t := f.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if f.removed || f.parent == f {
+		return Eperm
+	}
+	if len(f.children) > 0 {
+		return Error("directory not empty")
+	}
+	p := f.parent
+	for i, c := range p.children {
+		if c == f {
+			p.children = append(p.children[:i], p.children[i+1:]...)
+			break
+		}
+	}
+	p.Qid.Vers++
+	f.removed = true
+	return nil
+}
+
+// Walk returns the child of f with the given name, or nil.
+// The name ".." refers to f's parent.
+func (f *File) Walk(name string) *File {
+	t := f.tree
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if f.removed {
+		return nil
+	}
+	if name == ".." {
+		return f.parent
+	}
+	for _, c := range f.children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Parent returns f's parent directory. The root is its own parent.
+func (f *File) Parent() *File {
+	return f.parent
+}
+
+// Children returns a copy of the list of f's children.
+func (f *File) Children() []*File {
+	t := f.tree
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]*File(nil), f.children...)
+}
+
+// Stat returns a copy of f's directory entry.
+func (f *File) Stat() plan9.Dir {
+	t := f.tree
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return f.Dir
+}
+
+// Update calls fn with f's directory entry while holding the tree lock,
+// so that servers can change fields such as Length or Mtime safely.
+func (f *File) Update(fn func(d *plan9.Dir)) {
+	t := f.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(&f.Dir)
+}
+
+// hasPerm reports whether uid may access f with the given
+// AREAD/AWRITE/AEXEC bits.
+func hasPerm(f *File, uid string, p int) bool {
+	d := f.Stat()
+	m := int(d.Mode) & 7 // other
+	if (p & m) == p {
+		return true
+	}
+	if d.Uid == uid {
+		m |= int(d.Mode>>6) & 7
+		if (p & m) == p {
+			return true
+		}
+	}
+	if d.Gid == uid {
+		m |= int(d.Mode>>3) & 7
+		if (p & m) == p {
+			return true
+		}
+	}
+	return false
+}
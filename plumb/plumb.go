@@ -67,6 +67,14 @@ func (m *Message) Send(w io.Writer) error {
 	return err
 }
 
+// String returns the attribute list in the form used in plumb messages:
+// space-separated name=value pairs, with values quoted as needed.
+func (attr *Attribute) String() string {
+	var buf bytes.Buffer
+	attr.send(&buf)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
 func (attr *Attribute) send(w io.Writer) {
 	for a := attr; a != nil; a = a.Next {
 		if a != attr {
@@ -110,7 +118,11 @@ func (m *Message) Recv(r io.ByteReader) error {
 	if reader.err != nil {
 		return reader.err
 	}
-	n, err := strconv.Atoi(reader.readLine())
+	ndata := reader.readLine()
+	if reader.err != nil {
+		return reader.err
+	}
+	n, err := strconv.Atoi(ndata)
 	if err != nil {
 		return err
 	}
@@ -3,6 +3,7 @@ package plumb
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"reflect"
 	"testing"
 )
@@ -95,3 +96,23 @@ func TestMultipleAttributes(t *testing.T) {
 		t.Fatalf("difference:\n%+v\n%+v", message, m)
 	}
 }
+
+func TestRecvShort(t *testing.T) {
+	message := &Message{
+		Src:  "plumb",
+		Dst:  "edit",
+		Type: "text",
+		Attr: &Attribute{Name: "addr", Value: "/a b/"},
+		Data: []byte("/etc/passwd"),
+	}
+	var buf bytes.Buffer
+	message.Send(&buf)
+	b := buf.Bytes()
+	// Every prefix of a message is incomplete, not malformed.
+	for i := 0; i < len(b); i++ {
+		err := new(Message).Recv(bytes.NewReader(b[:i]))
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Errorf("Recv(%q) = %v, want EOF", b[:i], err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"plramos.win/9fans/plumb"
+)
+
+// ErrNoMatch is returned by Match when no rule set accepts a message.
+var ErrNoMatch = errors.New("no matching plumb rule")
+
+// An Exec describes what to do with a message that matched a rule set.
+type Exec struct {
+	Msg    *plumb.Message // the message, as rewritten by the rules
+	Set    *Ruleset       // the rule set that matched
+	Port   string         // destination port, if any
+	Start  string         // command to run for plumb start, if any
+	Client string         // command to run if no one has Port open, if any
+
+	// Vars holds $0 through $9, $file and $dir as set
+	// during the match.
+	Vars map[string]string
+}
+
+// Match applies the rule sets to m in order and returns the result
+// of the first one that matches. It does not modify m.
+//
+// A message with a destination port only matches rule sets for that
+// port. If no rule set matches such a message, Match returns an Exec
+// that delivers it unchanged to the port.
+func (r *Rules) Match(m *plumb.Message) (*Exec, error) {
+	for _, rs := range r.Sets {
+		if m.Dst != "" && rs.Port != "" && rs.Port != m.Dst {
+			continue
+		}
+		e, err := r.matchSet(rs, m)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			return e, nil
+		}
+	}
+	if m.Dst != "" {
+		return &Exec{Msg: copyMessage(m), Port: m.Dst, Vars: map[string]string{}}, nil
+	}
+	return nil, ErrNoMatch
+}
+
+func copyMessage(m *plumb.Message) *plumb.Message {
+	m1 := *m
+	m1.Data = append([]byte(nil), m.Data...)
+	var list **plumb.Attribute = &m1.Attr
+	for a := m.Attr; a != nil; a = a.Next {
+		*list = &plumb.Attribute{Name: a.Name, Value: a.Value}
+		list = &(*list).Next
+	}
+	return &m1
+}
+
+func (r *Rules) matchSet(rs *Ruleset, m *plumb.Message) (*Exec, error) {
+	e := &Exec{
+		Msg:  copyMessage(m),
+		Set:  rs,
+		Port: rs.Port,
+		Vars: make(map[string]string),
+	}
+	for _, rule := range rs.Rules {
+		ok, err := e.apply(rule, r.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", rs.File, rule.Line, err)
+		}
+		if !ok {
+			return nil, nil
+		}
+	}
+	var err error
+	if rs.Start != nil {
+		e.Start, err = e.expand(rs.Start.Arg, r.Vars)
+	}
+	if rs.Client != nil && err == nil {
+		e.Client, err = e.expand(rs.Client.Arg, r.Vars)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// expand expands s using the match variables and the message fields
+// ($src, $dst, $wdir, $type, $data, $attr) before the global variables.
+func (e *Exec) expand(s string, global map[string]string) (string, error) {
+	m := e.Msg
+	local := map[string]string{
+		"src":  m.Src,
+		"dst":  m.Dst,
+		"wdir": m.Dir,
+		"type": m.Type,
+		"data": string(m.Data),
+		"attr": m.Attr.String(),
+	}
+	for k, v := range e.Vars {
+		local[k] = v
+	}
+	for i := 0; i <= 9; i++ {
+		if _, ok := local[strconv.Itoa(i)]; !ok {
+			local[strconv.Itoa(i)] = ""
+		}
+	}
+	return expand(s, global, local)
+}
+
+// field returns a pointer to the message field named by obj.
+func (e *Exec) field(obj string) *string {
+	switch obj {
+	case Src:
+		return &e.Msg.Src
+	case Dst:
+		return &e.Msg.Dst
+	case Wdir:
+		return &e.Msg.Dir
+	case Type:
+		return &e.Msg.Type
+	}
+	return nil
+}
+
+func (e *Exec) text(obj string) string {
+	switch obj {
+	case Data:
+		return string(e.Msg.Data)
+	case Attr:
+		return e.Msg.Attr.String()
+	}
+	return *e.field(obj)
+}
+
+// apply evaluates a single rule, reporting whether it holds.
+func (e *Exec) apply(r *Rule, global map[string]string) (bool, error) {
+	if r.Obj == Plumb {
+		return true, nil
+	}
+	arg, err := e.expand(r.Arg, global)
+	if err != nil {
+		return false, err
+	}
+	switch r.Verb {
+	case Is:
+		return e.text(r.Obj) == arg, nil
+
+	case Matches:
+		re := r.re
+		if re == nil {
+			if re, err = compile(arg); err != nil {
+				return false, err
+			}
+		}
+		if r.Obj == Data {
+			if click := e.lookupAttr("click"); click != "" {
+				return e.matchClick(re, click), nil
+			}
+		}
+		return e.match(re, e.text(r.Obj)), nil
+
+	case Set:
+		if r.Obj == Data {
+			e.Msg.Data = []byte(arg)
+		} else {
+			*e.field(r.Obj) = arg
+		}
+		return true, nil
+
+	case Add:
+		i := strings.IndexByte(arg, '=')
+		if i <= 0 {
+			return false, fmt.Errorf("bad attribute %q", arg)
+		}
+		e.setAttr(arg[:i], arg[i+1:])
+		return true, nil
+
+	case Delete:
+		e.deleteAttr(arg)
+		return true, nil
+
+	case Isfile, Isdir:
+		name := arg
+		if !filepath.IsAbs(name) && e.Msg.Dir != "" {
+			name = filepath.Join(e.Msg.Dir, name)
+		}
+		fi, err := os.Stat(name)
+		if err != nil || fi.IsDir() != (r.Verb == Isdir) {
+			return false, nil
+		}
+		if r.Verb == Isdir {
+			e.Vars["dir"] = name
+		} else {
+			e.Vars["file"] = name
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown verb %q", r.Verb)
+}
+
+func (e *Exec) setVars(text string, loc []int) {
+	for i := 0; i <= 9; i++ {
+		v := ""
+		if 2*i+1 < len(loc) && loc[2*i] >= 0 {
+			v = text[loc[2*i]:loc[2*i+1]]
+		}
+		e.Vars[strconv.Itoa(i)] = v
+	}
+}
+
+func (e *Exec) match(re *regexp.Regexp, text string) bool {
+	loc := re.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return false
+	}
+	e.setVars(text, loc)
+	return true
+}
+
+// matchClick handles data matches for a message with a click
+// attribute: the data is the text around a mouse click at the
+// given offset, and the rule matches if the pattern matches some
+// substring containing the click. The leftmost such substring
+// becomes the message data and the click attribute is removed.
+func (e *Exec) matchClick(re *regexp.Regexp, click string) bool {
+	text := string(e.Msg.Data)
+	n, err := strconv.Atoi(click)
+	if err != nil || n < 0 || n > len(text) {
+		return false
+	}
+	for i := 0; i <= n; i++ {
+		for j := len(text); j >= n && j > i; j-- {
+			loc := re.FindStringSubmatchIndex(text[i:j])
+			if loc == nil {
+				continue
+			}
+			for k := range loc {
+				if loc[k] >= 0 {
+					loc[k] += i
+				}
+			}
+			e.setVars(text, loc)
+			e.Msg.Data = []byte(text[i:j])
+			e.deleteAttr("click")
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Exec) lookupAttr(name string) string {
+	return e.Msg.LookupAttr(name)
+}
+
+func (e *Exec) setAttr(name, value string) {
+	for a := e.Msg.Attr; a != nil; a = a.Next {
+		if a.Name == name {
+			a.Value = value
+			return
+		}
+	}
+	list := &e.Msg.Attr
+	for *list != nil {
+		list = &(*list).Next
+	}
+	*list = &plumb.Attribute{Name: name, Value: value}
+}
+
+func (e *Exec) deleteAttr(name string) {
+	for list := &e.Msg.Attr; *list != nil; {
+		if (*list).Name == name {
+			*list = (*list).Next
+			continue
+		}
+		list = &(*list).Next
+	}
+}
@@ -0,0 +1,382 @@
+// Package rules parses and evaluates plumbing rules, as described in plumb(7).
+//
+// A rules file is a sequence of rule sets separated by blank lines.
+// Each rule is a line of the form
+//
+//	object verb argument
+//
+// for instance
+//
+//	type is text
+//	data matches '([a-zA-Z0-9_\-./]+)\.go'
+//	arg isfile $0
+//	plumb to edit
+//
+// Lines outside rule sets may assign variables (name=value)
+// or include other rules files (include file).
+// Arguments are quoted as in rc(1): text between single quotes
+// is taken literally, and $name is replaced by the variable's value
+// everywhere else.
+package rules // import "plramos.win/9fans/plumb/rules"
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Objects.
+const (
+	Arg   = "arg"
+	Attr  = "attr"
+	Data  = "data"
+	Dst   = "dst"
+	Plumb = "plumb"
+	Src   = "src"
+	Type  = "type"
+	Wdir  = "wdir"
+)
+
+// Verbs.
+const (
+	Add     = "add"
+	Client  = "client"
+	Delete  = "delete"
+	Is      = "is"
+	Isdir   = "isdir"
+	Isfile  = "isfile"
+	Matches = "matches"
+	Set     = "set"
+	Start   = "start"
+	To      = "to"
+)
+
+// A Rule is a single line of a rule set.
+type Rule struct {
+	Obj  string
+	Verb string
+	Arg  string // the argument as written, with quotes
+	Line int
+
+	re *regexp.Regexp // for matches with no variables
+}
+
+// A Ruleset is a group of rules that apply together.
+type Ruleset struct {
+	Rules  []*Rule
+	Port   string // from plumb to; may be empty
+	Start  *Rule  // plumb start, if any
+	Client *Rule  // plumb client, if any
+	File   string
+	Line   int
+}
+
+// Rules is a parsed rules file.
+type Rules struct {
+	Sets []*Ruleset
+
+	// Vars holds the variables assigned at the top level of the file,
+	// which are visible to every rule.
+	Vars map[string]string
+
+	// Text is the text of the rules file, with includes unexpanded.
+	Text []byte
+}
+
+// A SyntaxError reports a problem in a rules file.
+type SyntaxError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// Ports returns the names of all ports mentioned in plumb to rules.
+func (r *Rules) Ports() []string {
+	var ports []string
+	seen := make(map[string]bool)
+	for _, rs := range r.Sets {
+		if rs.Port != "" && !seen[rs.Port] {
+			seen[rs.Port] = true
+			ports = append(ports, rs.Port)
+		}
+	}
+	return ports
+}
+
+// ParseFile parses the rules file with the given name.
+// Included files are looked up relative to the including file's
+// directory and then in $PLAN9/plumb.
+func ParseFile(file string) (*Rules, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(file, data, nil)
+}
+
+// Parse parses the rules in data, reporting errors as coming from
+// the named file. If include is non-nil, it is used to read included files;
+// otherwise they are read from the file system as by ParseFile.
+func Parse(file string, data []byte, include func(name string) ([]byte, error)) (*Rules, error) {
+	if include == nil {
+		include = func(name string) ([]byte, error) { return readInclude(file, name) }
+	}
+	p := &parser{
+		rules:   &Rules{Vars: make(map[string]string), Text: data},
+		include: include,
+	}
+	if err := p.parse(file, data, 0); err != nil {
+		return nil, err
+	}
+	return p.rules, nil
+}
+
+func readInclude(from, name string) ([]byte, error) {
+	if filepath.IsAbs(name) {
+		return os.ReadFile(name)
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(from), name))
+	if err == nil {
+		return data, nil
+	}
+	if p9 := os.Getenv("PLAN9"); p9 != "" {
+		if data, err1 := os.ReadFile(filepath.Join(p9, "plumb", name)); err1 == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+type parser struct {
+	rules   *Rules
+	include func(string) ([]byte, error)
+}
+
+var objects = map[string]bool{Arg: true, Attr: true, Data: true, Dst: true, Plumb: true, Src: true, Type: true, Wdir: true}
+
+// verbs lists the verbs permitted for each object.
+var verbs = map[string]map[string]bool{
+	Arg:   {Isdir: true, Isfile: true},
+	Attr:  {Add: true, Delete: true, Is: true, Matches: true},
+	Data:  {Is: true, Matches: true, Set: true},
+	Dst:   {Is: true, Matches: true, Set: true},
+	Plumb: {Client: true, Start: true, To: true},
+	Src:   {Is: true, Matches: true, Set: true},
+	Type:  {Is: true, Matches: true, Set: true},
+	Wdir:  {Is: true, Matches: true, Set: true},
+}
+
+func (p *parser) parse(file string, data []byte, depth int) error {
+	if depth > 10 {
+		return &SyntaxError{file, 0, "include nesting too deep"}
+	}
+	var rs *Ruleset
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		// Continuation lines.
+		for strings.HasSuffix(text, "\\") && sc.Scan() {
+			line++
+			text = strings.TrimSuffix(text, "\\") + " " + strings.TrimSpace(sc.Text())
+		}
+		errorf := func(format string, args ...interface{}) error {
+			return &SyntaxError{file, line, fmt.Sprintf(format, args...)}
+		}
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		if text == "" {
+			if rs != nil {
+				if err := p.finish(rs); err != nil {
+					return err
+				}
+			}
+			rs = nil
+			continue
+		}
+		if rs == nil {
+			if name, val, ok := assignment(text); ok {
+				v, err := expand(val, p.rules.Vars, nil)
+				if err != nil {
+					return errorf("%v", err)
+				}
+				p.rules.Vars[name] = v
+				continue
+			}
+			if f := strings.Fields(text); len(f) == 2 && f[0] == "include" {
+				name, err := expand(f[1], p.rules.Vars, nil)
+				if err != nil {
+					return errorf("%v", err)
+				}
+				data, err := p.include(name)
+				if err != nil {
+					return errorf("can't include %s: %v", name, err)
+				}
+				if err := p.parse(name, data, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		obj, rest := word(text)
+		verb, arg := word(rest)
+		if !objects[obj] {
+			return errorf("unknown object %q", obj)
+		}
+		if !verbs[obj][verb] {
+			return errorf("unknown verb %q for object %s", verb, obj)
+		}
+		if arg == "" && !(obj == Attr && verb == Delete) {
+			return errorf("missing argument for %s %s", obj, verb)
+		}
+		r := &Rule{Obj: obj, Verb: verb, Arg: arg, Line: line}
+		if verb == Matches && !strings.Contains(arg, "$") {
+			pat, err := expand(arg, nil, nil)
+			if err != nil {
+				return errorf("%v", err)
+			}
+			if r.re, err = compile(pat); err != nil {
+				return errorf("bad regexp %s: %v", arg, err)
+			}
+		}
+		if rs == nil {
+			rs = &Ruleset{File: file, Line: line}
+		}
+		rs.Rules = append(rs.Rules, r)
+	}
+	if rs != nil {
+		return p.finish(rs)
+	}
+	return sc.Err()
+}
+
+func (p *parser) finish(rs *Ruleset) error {
+	for _, r := range rs.Rules {
+		if r.Obj != Plumb {
+			continue
+		}
+		switch r.Verb {
+		case To:
+			if rs.Port != "" {
+				return &SyntaxError{rs.File, r.Line, "multiple plumb to rules"}
+			}
+			port, err := expand(r.Arg, p.rules.Vars, nil)
+			if err != nil {
+				return &SyntaxError{rs.File, r.Line, err.Error()}
+			}
+			rs.Port = port
+		case Start:
+			rs.Start = r
+		case Client:
+			rs.Client = r
+		}
+	}
+	if rs.Port == "" && rs.Start == nil {
+		return &SyntaxError{rs.File, rs.Line, "rule set has no plumb to or plumb start"}
+	}
+	p.rules.Sets = append(p.rules.Sets, rs)
+	return nil
+}
+
+// assignment reports whether text is a variable assignment name=value.
+func assignment(text string) (name, value string, ok bool) {
+	i := strings.IndexByte(text, '=')
+	if i <= 0 {
+		return "", "", false
+	}
+	for _, c := range text[:i] {
+		if !isVarChar(c) {
+			return "", "", false
+		}
+	}
+	return text[:i], strings.TrimSpace(text[i+1:]), true
+}
+
+// word splits off the first space-separated word of s.
+func word(s string) (w, rest string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+func isVarChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// compile compiles a rules regular expression, which must match
+// the entire text it is applied to.
+func compile(pat string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(`^(?:` + pat + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	re.Longest()
+	return re, nil
+}
+
+// expand removes rc-style quotes from s and replaces $name outside
+// quotes with the value of the variable, looked up first in local
+// and then in global. $0 through $9 and unknown variables
+// expand to the empty string.
+func expand(s string, global, local map[string]string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\'':
+			i++
+			for {
+				if i >= len(s) {
+					return "", fmt.Errorf("unterminated quoted string")
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+		case c == '$' && i+1 < len(s) && isVarChar(rune(s[i+1])):
+			j := i + 1
+			if '0' <= s[j] && s[j] <= '9' {
+				j++
+			} else {
+				for j < len(s) && isVarChar(rune(s[j])) {
+					j++
+				}
+			}
+			name := s[i+1 : j]
+			if v, ok := local[name]; ok {
+				b.WriteString(v)
+			} else if v, ok := global[name]; ok {
+				b.WriteString(v)
+			} else if v, ok := os.LookupEnv(name); ok && (name[0] < '0' || name[0] > '9') {
+				b.WriteString(v)
+			}
+			i = j
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), nil
+}
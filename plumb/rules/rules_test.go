@@ -0,0 +1,162 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"plramos.win/9fans/plumb"
+)
+
+const testRules = `
+# comment
+editor=acme
+addrelem='((#?[0-9]+)|(/[A-Za-z0-9_\^]+/?)|[.$])'
+addr=:($addrelem([,;+\-]$addrelem)*)
+
+# urls go to web browser
+type is text
+data matches 'https?://[^ ]+'
+plumb to web
+plumb start echo web $0
+
+# file:line
+type is text
+data matches '([.a-zA-Z0-9_/\-]+)'$addr
+arg isfile $1
+data set $file
+attr add addr=$2
+plumb to edit
+plumb client $editor
+
+# man pages
+type is text
+data matches '([a-zA-Z0-9_\-]+)\(([1-8])\)'
+plumb to man
+plumb start man $2 $1
+
+include extra
+`
+
+const extraRules = `
+type is image
+plumb to image
+`
+
+func parseTest(t *testing.T) *Rules {
+	r, err := Parse("test", []byte(testRules), func(name string) ([]byte, error) {
+		if name != "extra" {
+			t.Fatalf("include %q", name)
+		}
+		return []byte(extraRules), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestParse(t *testing.T) {
+	r := parseTest(t)
+	if len(r.Sets) != 4 {
+		t.Fatalf("got %d rule sets, want 4", len(r.Sets))
+	}
+	if got, want := strings.Join(r.Ports(), " "), "web edit man image"; got != want {
+		t.Errorf("Ports() = %q, want %q", got, want)
+	}
+	if r.Vars["editor"] != "acme" {
+		t.Errorf("editor = %q", r.Vars["editor"])
+	}
+	if !strings.HasPrefix(r.Vars["addr"], ":(((#?[0-9]+)") {
+		t.Errorf("addr = %q", r.Vars["addr"])
+	}
+}
+
+var badRules = []string{
+	"type is text\n",
+	"type frob text\nplumb to x\n",
+	"object is text\nplumb to x\n",
+	"data matches '(\nplumb to x\n",
+	"data matches 'abc\nplumb to x\n",
+	"plumb to a\nplumb to b\n",
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range badRules {
+		if _, err := Parse("bad", []byte(text), nil); err == nil {
+			t.Errorf("Parse(%q) succeeded", text)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "x.go"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	r := parseTest(t)
+
+	tests := []struct {
+		data, click, dst string
+		port, newdata    string
+		start, client    string
+		addr             string
+	}{
+		{data: "http://9fans.net/", port: "web", newdata: "http://9fans.net/", start: "echo web http://9fans.net/"},
+		{data: "x.go:12", port: "edit", newdata: filepath.Join(dir, "x.go"), client: "acme", addr: "12"},
+		{data: "x.go:/main/", port: "edit", newdata: filepath.Join(dir, "x.go"), client: "acme", addr: "/main/"},
+		{data: "cat(1)", port: "man", newdata: "cat(1)", start: "man 1 cat"},
+		{data: "see cat(1) too", click: "6", port: "man", newdata: "cat(1)", start: "man 1 cat"},
+		{data: "y.go:12", dst: "edit", port: "edit", newdata: "y.go:12"},
+		{data: "nothing here"},
+		{data: "y.go:12"},
+	}
+	for _, tt := range tests {
+		m := &plumb.Message{Src: "test", Dst: tt.dst, Dir: dir, Type: "text", Data: []byte(tt.data)}
+		if tt.click != "" {
+			m.Attr = &plumb.Attribute{Name: "click", Value: tt.click}
+		}
+		e, err := r.Match(m)
+		if tt.port == "" {
+			if err != ErrNoMatch {
+				t.Errorf("%q: Match = %v, %v, want ErrNoMatch", tt.data, e, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.data, err)
+			continue
+		}
+		if e.Port != tt.port || string(e.Msg.Data) != tt.newdata || e.Start != tt.start || e.Client != tt.client {
+			t.Errorf("%q: port %q data %q start %q client %q, want %q %q %q %q", tt.data,
+				e.Port, e.Msg.Data, e.Start, e.Client, tt.port, tt.newdata, tt.start, tt.client)
+		}
+		if got := e.Msg.LookupAttr("addr"); got != tt.addr {
+			t.Errorf("%q: addr = %q, want %q", tt.data, got, tt.addr)
+		}
+		if e.Msg.LookupAttr("click") != "" {
+			t.Errorf("%q: click attribute not removed", tt.data)
+		}
+		if string(m.Data) != tt.data {
+			t.Errorf("%q: Match modified message", tt.data)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"a": "A", "0": "zero"}
+	for _, tt := range []struct{ in, out string }{
+		{"$a", "A"},
+		{"'$a'", "$a"},
+		{"x$a'y'z", "xAyz"},
+		{"''''", "'"},
+		{"$0.go", "zero.go"},
+		{"$nosuchvariable", ""},
+	} {
+		out, err := expand(tt.in, nil, vars)
+		if err != nil || out != tt.out {
+			t.Errorf("expand(%q) = %q, %v, want %q", tt.in, out, err, tt.out)
+		}
+	}
+}